@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// unixAddressPrefix marks the monitor and engine addresses passed to programs
+// that are unix socket paths rather than TCP addresses.
+const unixAddressPrefix = "unix:"
+
+// normalizeAddress validates a gRPC address from the engine and puts it in
+// the form programs expect: host:port, with IPv6 hosts in brackets, or
+// "unix:" followed by the absolute path of a unix socket. A bare absolute
+// path is taken as a unix socket, and an IPv6 address without brackets as
+// ending in the port after its last colon. An empty address, for which there
+// is nothing to connect to, is left empty.
+func normalizeAddress(address string) (string, error) {
+	if address == "" {
+		return "", nil
+	}
+
+	if path, ok := strings.CutPrefix(address, unixAddressPrefix); ok || filepath.IsAbs(address) {
+		if !ok {
+			path = address
+		}
+		// Accept URLs such as unix:///run/pulumi.sock too.
+		path = strings.TrimPrefix(path, "//")
+		if !filepath.IsAbs(path) {
+			return "", fmt.Errorf("expected an absolute unix socket path, got %q", path)
+		}
+		return unixAddressPrefix + path, nil
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		i := strings.LastIndex(address, ":")
+		if i < 0 || net.ParseIP(address[:i]) == nil {
+			return "", fmt.Errorf("expected host:port or %s followed by a socket path", unixAddressPrefix)
+		}
+		host, port = address[:i], address[i+1:]
+	}
+	if host == "" {
+		return "", errors.New("missing host")
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
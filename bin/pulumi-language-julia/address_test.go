@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	for address, expected := range map[string]string{
+		"":                      "",
+		"127.0.0.1:52341":       "127.0.0.1:52341",
+		"localhost:52341":       "localhost:52341",
+		"[::1]:52341":           "[::1]:52341",
+		"::1:52341":             "[::1]:52341",
+		"fe80::1:8080":          "[fe80::1]:8080",
+		"unix:/run/pulumi.sock": "unix:/run/pulumi.sock",
+		"unix:///run/p.sock":    "unix:/run/p.sock",
+		"/run/pulumi.sock":      "unix:/run/pulumi.sock",
+	} {
+		actual, err := normalizeAddress(address)
+		if err != nil || actual != expected {
+			t.Errorf("%q: expected %q, got %q, %v", address, expected, actual, err)
+		}
+	}
+
+	for address, message := range map[string]string{
+		"localhost":            "host:port",
+		"::1":                  "port",
+		":52341":               "missing host",
+		"localhost:http":       "invalid port",
+		"localhost:65536":      "invalid port",
+		"unix:run/pulumi.sock": "absolute",
+	} {
+		if _, err := normalizeAddress(address); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%q: expected an error about %s, got %v", address, message, err)
+		}
+	}
+}
@@ -0,0 +1,619 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"golang.org/x/term"
+)
+
+// juliaExeEnvVar names the environment variable overriding the julia
+// executable used by the host.
+const juliaExeEnvVar = "PULUMI_JULIA_EXE"
+
+// sharedDepotEnvVar names the environment variable pointing every project
+// without a depot of its own at a package depot shared across stacks.
+const sharedDepotEnvVar = "PULUMI_JULIA_SHARED_DEPOT"
+
+// sdkPathEnvVar names the environment variable listing local checkouts of
+// Pulumi.jl, and of provider packages, that programs run against instead of
+// the released packages, for developing them.
+const sdkPathEnvVar = "PULUMI_JULIA_SDK_PATH"
+
+// juliaCommand is the julia executable, and the leading arguments shared by
+// every julia subprocess of a program.
+type juliaCommand struct {
+	// Path is the resolved julia executable.
+	Path string
+	// Args precede the arguments of each subprocess.
+	Args []string
+	// Channel is the juliaup channel julia is run from, if any.
+	Channel string
+	// Depot is the package depot julia uses first, if any: the project's
+	// depot or the shared depot.
+	Depot string
+	// Env is added to the environment of each subprocess.
+	Env []string
+}
+
+// juliaCommand resolves the julia executable configured for a program: the
+// PULUMI_JULIA_EXE environment variable, the `julia` runtime option, or the
+// julia on the PATH. Relative paths resolve against the project root.
+func (host *juliaLanguageHost) juliaCommand(info *pulumirpc.ProgramInfo, opts runtimeOptions) (juliaCommand, error) {
+	exe, source := "julia", "PATH"
+	if opts.Julia != "" {
+		exe, source = opts.Julia, "the julia runtime option"
+	}
+	if env := os.Getenv(juliaExeEnvVar); env != "" {
+		exe, source = env, juliaExeEnvVar
+	}
+
+	if strings.ContainsAny(exe, `/\`) && !filepath.IsAbs(exe) {
+		if root := orDefault(info.GetRootDirectory(), host.root); root != "" {
+			exe = filepath.Join(root, exe)
+		}
+	}
+	path, err := exec.LookPath(exe)
+	if err != nil {
+		return juliaCommand{}, &hostError{err: juliaNotFoundError(exe, source, err)}
+	}
+	logging.V(5).Infof("using julia executable %s from %s", path, source)
+	cmd := juliaCommand{Path: path}
+
+	if channel := opts.JuliaVersion; channel != "" {
+		if juliaup, ok := juliaupLauncher(path); !ok {
+			logging.Warningf("ignoring the juliaVersion runtime option: julia executable %s from %s "+
+				"is not juliaup's launcher", path, source)
+		} else if err := host.checkJuliaupChannel(juliaup, channel); err != nil {
+			return juliaCommand{}, &hostError{err: err}
+		} else {
+			cmd.Channel = channel
+			cmd.Args = append(cmd.Args, "+"+channel)
+		}
+	}
+	if depot := host.depot(info, opts); depot != "" {
+		if err := os.MkdirAll(depot, 0o755); err != nil {
+			return juliaCommand{}, hostErrorf("failed to create depot %s: %w", depot, err)
+		}
+		logging.V(5).Infof("using depot %s", depot)
+		cmd.Depot = depot
+		cmd.Env = append(cmd.Env, "JULIA_DEPOT_PATH="+prependSearchPath([]string{depot}, os.Getenv("JULIA_DEPOT_PATH")))
+	}
+	if loadPath := host.loadPath(info, opts); len(loadPath) > 0 {
+		logging.V(5).Infof("prepending %s to the load path", strings.Join(loadPath, ", "))
+		cmd.Env = append(cmd.Env, "JULIA_LOAD_PATH="+prependSearchPath(loadPath, os.Getenv("JULIA_LOAD_PATH")))
+	}
+	// A personal startup.jl slows down every run and may write to stdout, so
+	// it is skipped unless the project opts in.
+	if !opts.StartupFile {
+		cmd.Args = append(cmd.Args, "--startup-file=no")
+	}
+	return cmd, nil
+}
+
+// juliaNotFoundError explains that the julia executable exe, configured by
+// source, can't be run, and how to install or configure it.
+func juliaNotFoundError(exe, source string, err error) error {
+	const help = "Julia is required to run Pulumi programs written in Julia: install it with juliaup " +
+		"(https://github.com/JuliaLang/juliaup), or set the julia runtime option or " + juliaExeEnvVar +
+		" to the path of a julia executable"
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return fmt.Errorf("julia executable %q from %s is not executable. %s", exe, source, help)
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("julia executable %q from %s not found. %s", exe, source, help)
+	}
+	return fmt.Errorf("julia executable %q from %s can't be run: %w", exe, source, err)
+}
+
+// juliaupLauncher reports whether julia is the launcher juliaup installs,
+// which selects a channel from a leading +channel argument, and returns the
+// juliaup executable installed beside it.
+func juliaupLauncher(julia string) (string, bool) {
+	dirs := []string{filepath.Dir(julia)}
+	if resolved, err := filepath.EvalSymlinks(julia); err == nil {
+		dirs = append(dirs, filepath.Dir(resolved))
+	}
+	for _, dir := range dirs {
+		for _, name := range []string{"juliaup", "juliaup.exe"} {
+			juliaup := filepath.Join(dir, name)
+			if stat, err := os.Stat(juliaup); err == nil && stat.Mode().IsRegular() {
+				return juliaup, true
+			}
+		}
+	}
+	return "", false
+}
+
+// checkJuliaupChannel verifies that channel is installed with juliaup, so
+// that a missing channel is reported before julia is launched. Installed
+// channels are remembered for the host's lifetime, sparing every julia
+// command a `juliaup status`.
+func (host *juliaLanguageHost) checkJuliaupChannel(juliaup, channel string) error {
+	key := juliaup + "\x00" + channel
+	if _, ok := host.juliaupChannels.Load(key); ok {
+		return nil
+	}
+	installed, err := juliaupHasChannel(juliaup, channel)
+	if err != nil {
+		return err
+	}
+	if !installed {
+		return fmt.Errorf("julia channel %q is not installed; run `juliaup add %s`", channel, channel)
+	}
+	host.juliaupChannels.Store(key, true)
+	return nil
+}
+
+// juliaupHasChannel reports whether channel is installed with juliaup.
+func juliaupHasChannel(juliaup, channel string) (bool, error) {
+	output, err := exec.Command(juliaup, "status").Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to list juliaup channels: %w", err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		for _, field := range strings.Fields(line) {
+			if field == channel {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// juliaColor decides whether julia colors its output. NO_COLOR and
+// PULUMI_DISABLE_COLOR turn color off and FORCE_COLOR turns it on; otherwise
+// julia colors its output when the engine reports an interactive terminal.
+func juliaColor(isTerminal bool) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	if cmdutil.IsTruthy(os.Getenv("PULUMI_DISABLE_COLOR")) {
+		return false
+	}
+	if _, ok := os.LookupEnv("FORCE_COLOR"); ok {
+		return true
+	}
+	return isTerminal
+}
+
+// isTerminal reports whether r is a terminal.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// programStdin returns the stdin of a program run. It is empty, so that reads,
+// such as those of a dependency prompting for input, see the end of input at
+// once rather than wait forever, unless inherit is set and the host's stdin
+// is a terminal, for programs that are interactive.
+func programStdin(inherit bool) io.Reader {
+	if !inherit {
+		// A nil stdin reads from the null device.
+		return nil
+	}
+	if !isTerminal(os.Stdin) {
+		logging.V(5).Infof("not passing stdin to the program, as it isn't a terminal")
+		return nil
+	}
+	return os.Stdin
+}
+
+// withColor returns c with julia's color forced on or off, as julia
+// otherwise disables color when writing to a pipe. Forced color extends to
+// the subprocesses julia starts; turning it off is left to julia's command
+// line, so that tools the program runs keep deciding for themselves.
+func (c juliaCommand) withColor(color bool) juliaCommand {
+	c.Args = append([]string{}, c.Args...)
+	c.Env = append([]string{}, c.Env...)
+	if color {
+		c.Args = append(c.Args, "--color=yes")
+		c.Env = append(c.Env, "FORCE_COLOR=1")
+	} else {
+		c.Args = append(c.Args, "--color=no")
+	}
+	return c
+}
+
+// depot returns the absolute path of the depot runtime option, if set, or
+// else of the shared depot.
+func (host *juliaLanguageHost) depot(info *pulumirpc.ProgramInfo, opts runtimeOptions) string {
+	if opts.Depot == "" {
+		return sharedDepot()
+	}
+	return absPath(resolveAgainst(orDefault(info.GetRootDirectory(), host.root), opts.Depot))
+}
+
+// sharedDepot returns the absolute path of the depot from the
+// PULUMI_JULIA_SHARED_DEPOT environment variable, if set.
+func sharedDepot() string {
+	if depot := os.Getenv(sharedDepotEnvVar); depot != "" {
+		return absPath(depot)
+	}
+	return ""
+}
+
+// devPackage is a local checkout of a Julia package from PULUMI_JULIA_SDK_PATH.
+type devPackage struct {
+	Name string
+	Path string
+}
+
+// devPackages returns the package checkouts listed in PULUMI_JULIA_SDK_PATH,
+// separated like PATH, and the problems with the entries that don't look like
+// Julia packages, which are ignored.
+func devPackages() ([]devPackage, []string) {
+	var packages []devPackage
+	var problems []string
+	for _, dir := range filepath.SplitList(os.Getenv(sdkPathEnvVar)) {
+		if dir == "" {
+			continue
+		}
+		dir = absPath(dir)
+		project, err := readJuliaProject(dir)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("ignoring %s in %s: %v", dir, sdkPathEnvVar, err))
+		case project == nil || project.Name == "" || project.UUID == "":
+			problems = append(problems, fmt.Sprintf("ignoring %s in %s, which has no Project.toml "+
+				"naming a package", dir, sdkPathEnvVar))
+		default:
+			if _, err := os.Stat(filepath.Join(dir, "src", project.Name+".jl")); err != nil {
+				problems = append(problems, fmt.Sprintf("ignoring %s in %s, which has no src/%s.jl",
+					dir, sdkPathEnvVar, project.Name))
+				continue
+			}
+			packages = append(packages, devPackage{Name: project.Name, Path: dir})
+		}
+	}
+	return packages, problems
+}
+
+// pkgOffline reports whether dependencies are installed offline, from the
+// local depot only: with the offline runtime option, or a JULIA_PKG_OFFLINE
+// inherited from the environment set to true, as in air-gapped networks.
+func pkgOffline(opts runtimeOptions) bool {
+	offline, _ := strconv.ParseBool(os.Getenv("JULIA_PKG_OFFLINE"))
+	return opts.Offline || offline
+}
+
+// binaryPath returns the absolute path of the executable of the binary
+// runtime option, checking that it can be run.
+func (host *juliaLanguageHost) binaryPath(info *pulumirpc.ProgramInfo, opts runtimeOptions) (string, error) {
+	path := absPath(resolveAgainst(orDefault(info.GetRootDirectory(), host.root), opts.Binary))
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("binary %s from the binary runtime option not found: %w", path, err)
+	}
+	if stat.IsDir() || (runtime.GOOS != "windows" && stat.Mode()&0o111 == 0) {
+		return "", fmt.Errorf("binary %s from the binary runtime option is not an executable file", path)
+	}
+	return path, nil
+}
+
+// depots returns the package depots of a program: the depot runtime option
+// or shared depot, if set, followed by the depots julia searches.
+func (host *juliaLanguageHost) depots(info *pulumirpc.ProgramInfo, opts runtimeOptions) []string {
+	if depot := host.depot(info, opts); depot != "" {
+		return append([]string{depot}, juliaDepots()...)
+	}
+	return juliaDepots()
+}
+
+// loadPath returns the absolute directories of the loadPath runtime option,
+// warning about those that don't exist.
+func (host *juliaLanguageHost) loadPath(info *pulumirpc.ProgramInfo, opts runtimeOptions) []string {
+	var dirs []string
+	for _, dir := range opts.LoadPath {
+		dir = absPath(resolveAgainst(orDefault(info.GetRootDirectory(), host.root), dir))
+		if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
+			logging.Warningf("load path directory %s from the loadPath runtime option does not exist", dir)
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// prependSearchPath prepends entries to current, a JULIA_DEPOT_PATH or
+// JULIA_LOAD_PATH value. Without a current value the trailing separator makes
+// julia append its defaults, so that the standard library still resolves.
+func prependSearchPath(entries []string, current string) string {
+	sep := string(os.PathListSeparator)
+	return strings.Join(entries, sep) + sep + current
+}
+
+// command returns the command running julia with args, in the host's
+// environment extended with c.Env.
+func (c juliaCommand) command(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, c.Path, append(append([]string{}, c.Args...), args...)...)
+	cmd.Env = append(os.Environ(), c.Env...)
+	return cmd
+}
+
+// version returns the version of julia, as reported by `julia --version`.
+func (c juliaCommand) version(ctx context.Context) (semver.Version, error) {
+	output, err := c.command(ctx, "--version").Output()
+	if err != nil {
+		return semver.Version{}, fmt.Errorf("failed to get the julia version: %w", err)
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return semver.Version{}, fmt.Errorf("unexpected output from julia --version: %q", output)
+	}
+	return semver.ParseTolerant(fields[len(fields)-1])
+}
+
+// juliaVersionKey identifies the version of julia: its executable, as it was
+// when the version was probed, and its arguments, which may select a channel.
+func juliaVersionKey(julia juliaCommand) string {
+	var modTime int64
+	if stat, err := os.Stat(julia.Path); err == nil {
+		modTime = stat.ModTime().UnixNano()
+	}
+	return fmt.Sprintf("%s\x00%d\x00%s", julia.Path, modTime, strings.Join(julia.Args, "\x00"))
+}
+
+// juliaVersion returns the version of julia, probing each julia once.
+func (host *juliaLanguageHost) juliaVersion(ctx context.Context, julia juliaCommand) (semver.Version, error) {
+	key := juliaVersionKey(julia)
+	if version, ok := host.juliaVersions.Load(key); ok {
+		return version.(semver.Version), nil
+	}
+	version, err := julia.version(ctx)
+	if err != nil {
+		return semver.Version{}, err
+	}
+	host.juliaVersions.Store(key, version)
+	return version, nil
+}
+
+// checkJuliaCompat checks julia against the julia entry of the [compat]
+// section of the Project.toml in dir, if there is one, so that a julia the
+// project doesn't support is reported up front rather than by a failure deep
+// inside package resolution or loading. A compat entry or julia version that
+// can't be made sense of is left for julia to report.
+func (host *juliaLanguageHost) checkJuliaCompat(ctx context.Context, julia juliaCommand, dir string) error {
+	project, err := readJuliaProject(dir)
+	if err != nil || project == nil || project.Compat["julia"] == "" {
+		return nil
+	}
+	compat := project.Compat["julia"]
+	spec, err := parseJuliaVersionSpec(compat)
+	if err != nil {
+		logging.V(5).Infof("not checking julia against the compat entry of %s: %v", dir, err)
+		return nil
+	}
+	version, err := host.juliaVersion(ctx, julia)
+	if err != nil {
+		logging.V(5).Infof("not checking julia against the compat entry of %s: %v", dir, err)
+		return nil
+	}
+	if spec.contains(version) {
+		return nil
+	}
+	lower := spec.lowerBound()
+	channel := fmt.Sprintf("%d.%d", lower.Major, lower.Minor)
+	return fmt.Errorf("project requires Julia %s, found %s at %s: the julia entry of the [compat] section of %s "+
+		"doesn't allow it. Install a supported Julia with juliaup (`juliaup add %s`) and set the juliaVersion "+
+		"runtime option to %s, or run `pulumi install --use-language-version-tools` to have it installed",
+		compat, version, julia.Path, filepath.Join(dir, "Project.toml"), channel, channel)
+}
+
+// heapSizeHintVersion is the first julia version supporting --heap-size-hint.
+var heapSizeHintVersion = semver.Version{Major: 1, Minor: 9}
+
+// heapSizeHintSwitches returns the julia switches implementing the
+// heapSizeHint runtime option. Julia versions without --heap-size-hint run
+// without it, with a warning.
+func (host *juliaLanguageHost) heapSizeHintSwitches(
+	ctx context.Context, julia juliaCommand, opts runtimeOptions,
+) []string {
+	if opts.HeapSizeHint == "" {
+		return nil
+	}
+	version, err := host.juliaVersion(ctx, julia)
+	if err != nil {
+		logging.V(5).Infof("passing the heap size hint to a julia of unknown version: %v", err)
+	} else if version.LT(heapSizeHintVersion) {
+		logging.Warningf("ignoring the heapSizeHint runtime option, which requires julia %s or later, not %s",
+			heapSizeHintVersion, version)
+		return nil
+	}
+	return []string{"--heap-size-hint=" + opts.HeapSizeHint}
+}
+
+// juliaRunArgs returns the julia arguments running prog as a script, so that
+// PROGRAM_FILE, @__DIR__ and stack traces refer to the program file itself.
+// The switches follow the project. The program path is passed as its own
+// argument, after `--` so that it is never mistaken for a switch, and so
+// needs no escaping. Package-style programs call the main function of their
+// package instead. The program arguments follow, ending up in ARGS.
+func juliaRunArgs(prog juliaProgram, switches, programArgs []string) []string {
+	args := append([]string{"--project=" + prog.project()}, switches...)
+	if prog.Package != "" {
+		args = append(args, "-e", fmt.Sprintf("using %[1]s; %[1]s.main()", prog.Package))
+		if len(programArgs) == 0 {
+			return args
+		}
+		return append(append(args, "--"), programArgs...)
+	}
+	return append(append(args, "--", absPath(prog.EntryPoint)), programArgs...)
+}
+
+// minimalCompileSwitches make julia start faster by compiling and optimizing
+// as little as possible, which suits short programs such as most previews but
+// slows down long-running ones.
+var minimalCompileSwitches = []string{"--compile=min", "-O0", "--inline=no"}
+
+// juliaRunSwitches returns the julia switches implementing the runtime
+// options that apply to program runs.
+func juliaRunSwitches(opts runtimeOptions, dryRun bool) []string {
+	var switches []string
+	switch {
+	case opts.OptimizeStartup == optimizeStartupAlways,
+		opts.OptimizeStartup == optimizeStartupPreview && dryRun:
+		logging.V(5).Infof("optimizing for startup time with %s; programs start faster but run slower",
+			strings.Join(minimalCompileSwitches, " "))
+		switches = append(switches, minimalCompileSwitches...)
+	case opts.OptimizeStartup == optimizeStartupPreview:
+		logging.V(5).Infof("running with full compilation, as optimizeStartup only applies to previews")
+	}
+
+	threads, source := juliaThreads(opts)
+	logging.V(5).Infof("running with %s threads from %s", threads, source)
+	switches = append(switches, "--threads="+threads)
+
+	if opts.Coverage != "" {
+		// Julia writes the coverage of each source file next to it, as
+		// <file>.<pid>.cov, and the files are left for the user to process.
+		logging.V(5).Infof("collecting %s code coverage into .cov files next to the covered sources", opts.Coverage)
+		switches = append(switches, "--code-coverage="+opts.Coverage)
+	}
+	return append(switches, opts.JuliaArgs...)
+}
+
+// defaultThreads is the number of threads programs run with unless
+// configured otherwise.
+const defaultThreads = "1"
+
+// juliaThreads decides the number of threads programs run with, and where
+// the decision comes from: the threads runtime option, then an inherited
+// JULIA_NUM_THREADS, then defaultThreads. It is always passed to julia
+// explicitly, so that runs don't depend on the machine they happen on.
+func juliaThreads(opts runtimeOptions) (string, string) {
+	if opts.Threads != "" {
+		return opts.Threads, "the threads runtime option"
+	}
+	if env := os.Getenv("JULIA_NUM_THREADS"); env != "" {
+		// JULIA_NUM_THREADS may also set the interactive threadpool, as in
+		// "4,1", which --threads accepts too.
+		threads, _, _ := strings.Cut(env, ",")
+		if _, err := parseThreadsOption(threads); err == nil {
+			return env, "JULIA_NUM_THREADS"
+		}
+		logging.V(5).Infof("ignoring invalid JULIA_NUM_THREADS=%s", env)
+	}
+	return defaultThreads, "the default"
+}
+
+// precompileNote is shown once when a program run precompiles packages,
+// which can take long enough to look like a hang.
+const precompileNote = "note: Julia is precompiling packages as part of the program run, " +
+	"which may take several minutes; run `pulumi install` to precompile them ahead of time\n"
+
+// precompileWatcher passes output through to w, adding precompileNote after
+// the first write that shows julia precompiling packages.
+type precompileWatcher struct {
+	w        io.Writer
+	detected bool
+}
+
+func (p *precompileWatcher) Write(data []byte) (int, error) {
+	n, err := p.w.Write(data)
+	if err == nil && !p.detected && bytes.Contains(data, []byte("Precompiling")) {
+		p.detected = true
+		logging.V(5).Infof("detected precompilation during the program run")
+		_, err = io.WriteString(p.w, precompileNote)
+	}
+	return n, err
+}
+
+// juliaStringEscaper escapes the characters that are special inside a Julia
+// string literal: backslashes, quotes and the `$` of interpolation.
+var juliaStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+
+// juliaString quotes s as a Julia string literal, for the rare cases where a
+// value has to be spliced into code passed with `-e`. Prefer passing values
+// as arguments or through the environment.
+func juliaString(s string) string {
+	return `"` + juliaStringEscaper.Replace(s) + `"`
+}
+
+// absPath returns the absolute form of path, or path itself if that fails.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// sysimageSwitches returns the julia switches selecting the custom system
+// image of the sysimage runtime option, or the automatic sysimage of the
+// project in projectDir. A missing image falls back to the default one with
+// a warning, unless it is required.
+func (host *juliaLanguageHost) sysimageSwitches(
+	ctx context.Context, info *pulumirpc.ProgramInfo, opts runtimeOptions, julia juliaCommand, projectDir string,
+) ([]string, error) {
+	if opts.Sysimage == "" {
+		return nil, nil
+	}
+	if opts.Sysimage == autoSysimage {
+		return host.autoSysimageSwitches(ctx, info, opts, julia, projectDir)
+	}
+	path := absPath(resolveAgainst(orDefault(info.GetRootDirectory(), host.root), opts.Sysimage))
+	if _, err := os.Stat(path); err != nil {
+		if opts.SysimageRequired {
+			return nil, fmt.Errorf("sysimage %s not found: %w", path, err)
+		}
+		logging.Warningf("sysimage %s not found; using the default sysimage", path)
+		return nil, nil
+	}
+	logging.V(5).Infof("using sysimage %s", path)
+	return []string{"--sysimage=" + path}, nil
+}
+
+// autoSysimageSwitches returns the julia switches selecting the automatic
+// sysimage built for the current dependencies of the project in projectDir.
+// Programs run with the default sysimage until `pulumi install` builds it,
+// or if it is unusable, unless it is required.
+func (host *juliaLanguageHost) autoSysimageSwitches(
+	ctx context.Context, info *pulumirpc.ProgramInfo, opts runtimeOptions, julia juliaCommand, projectDir string,
+) ([]string, error) {
+	path, err := autoSysimagePath(ctx, julia, host.sysimageCacheDir(info), projectDir)
+	if err == nil {
+		err = checkSysimage(path)
+	}
+	switch {
+	case err == nil:
+		logging.V(5).Infof("using sysimage %s", path)
+		return []string{"--sysimage=" + path}, nil
+	case opts.SysimageRequired:
+		return nil, fmt.Errorf("no usable sysimage for the current dependencies: %w; "+
+			"run `pulumi install` to build it", err)
+	case !errors.Is(err, fs.ErrNotExist):
+		logging.Warningf("%v; using the default sysimage until `pulumi install` builds it again", err)
+	default:
+		logging.V(5).Infof("no sysimage built for the current dependencies, using the default one: %v", err)
+	}
+	return nil, nil
+}
+
+// sysimageCacheDir returns the absolute directory automatic sysimages are
+// kept in.
+func (host *juliaLanguageHost) sysimageCacheDir(info *pulumirpc.ProgramInfo) string {
+	return absPath(filepath.Join(orDefault(info.GetRootDirectory(), host.root), sysimageCacheDir))
+}
+
+// environmentDir returns the directory of the environment prog runs in.
+func (host *juliaLanguageHost) environmentDir(
+	info *pulumirpc.ProgramInfo, opts runtimeOptions, prog juliaProgram,
+) string {
+	if prog.Environment != "" {
+		return namedEnvironmentDir(prog.Environment, host.depots(info, opts))
+	}
+	return prog.ProjectDir
+}
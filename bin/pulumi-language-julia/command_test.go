@@ -0,0 +1,1521 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/grpc"
+)
+
+// fakeJulia puts a julia executable running the given shell script first on
+// the PATH.
+func fakeJulia(t *testing.T, script string) {
+	t.Helper()
+	bin := t.TempDir()
+	writeExecutable(t, filepath.Join(bin, "julia"), script)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	// Installs lock the first depot, so keep them out of the user's own.
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("JULIA_DEPOT_PATH", t.TempDir())
+	for _, name := range []string{"NO_COLOR", "FORCE_COLOR", "PULUMI_DISABLE_COLOR", "JULIA_NUM_THREADS"} {
+		unsetenv(t, name)
+	}
+}
+
+// unsetenv unsets the environment variable name for the duration of the test.
+func unsetenv(t *testing.T, name string) {
+	t.Helper()
+	t.Setenv(name, "")
+	os.Unsetenv(name)
+}
+
+func TestJuliaRunArgs(t *testing.T) {
+	root := t.TempDir()
+	args := juliaRunArgs(juliaProgram{
+		ProjectDir: root,
+		EntryPoint: filepath.Join(root, "infra", "prod.jl"),
+	}, []string{"--threads=auto"}, nil)
+	expected := []string{"--project=" + root, "--threads=auto", "--", filepath.Join(root, "infra", "prod.jl")}
+	if strings.Join(args, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+}
+
+func TestJuliaRunArgsSpecialPaths(t *testing.T) {
+	for _, name := range []string{"my project", `say "hi"`, "$(rm -rf)", "-e", "προϊόν"} {
+		t.Run(name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), name)
+			entryPoint := filepath.Join(dir, "main.jl")
+			args := juliaRunArgs(juliaProgram{ProjectDir: dir, EntryPoint: entryPoint}, nil, nil)
+			if args[len(args)-2] != "--" || args[len(args)-1] != entryPoint {
+				t.Errorf("expected the entry point verbatim after --, got %q", args)
+			}
+		})
+	}
+}
+
+func TestJuliaString(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"main.jl", `"main.jl"`},
+		{"my project/main.jl", `"my project/main.jl"`},
+		{`say "hi".jl`, `"say \"hi\".jl"`},
+		{"$(run(`rm -rf /`)).jl", "\"\\$(run(`rm -rf /`)).jl\""},
+		{"$HOME/main.jl", `"\$HOME/main.jl"`},
+		{`C:\Users\me\infra\main.jl`, `"C:\\Users\\me\\infra\\main.jl"`},
+		{"προϊόν/main.jl", `"προϊόν/main.jl"`},
+	}
+	for _, tt := range tests {
+		if actual := juliaString(tt.value); actual != tt.expected {
+			t.Errorf("juliaString(%q): expected %s, got %s", tt.value, tt.expected, actual)
+		}
+	}
+}
+
+func TestRunExecutesProgramFile(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	argv := filepath.Join(t.TempDir(), "argv")
+	fakeJulia(t, `printf '%s\n' "$@" > "`+argv+`"`)
+
+	host := newTestHost()
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if resp.GetError() != "" {
+		t.Fatalf("unexpected error: %s", resp.GetError())
+	}
+
+	data, err := os.ReadFile(argv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "--startup-file=no\n--color=no\n--project=" + root + "\n--threads=1\n--\n" + filepath.Join(root, "main.jl") + "\n"
+	if string(data) != expected {
+		t.Errorf("expected argv %q, got %q", expected, data)
+	}
+}
+
+func TestRunReportsProgramFailure(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	fakeJulia(t, "echo 'ERROR: boom' >&2\nexit 1\n")
+
+	host := newTestHost()
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{Program: root})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if resp.GetError() != "ERROR: boom" {
+		t.Errorf("expected the program's stderr, got %q", resp.GetError())
+	}
+}
+
+func TestRunUsesEnginePwd(t *testing.T) {
+	pwd := t.TempDir()
+	writeFile(t, filepath.Join(pwd, "infra", "main.jl"), "")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `{ pwd; printf '%s\n' "$@"; } > "`+out+`"`)
+
+	host := newTestHost()
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{Program: "infra", Pwd: pwd})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if resp.GetError() != "" {
+		t.Fatalf("unexpected error: %s", resp.GetError())
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		pwd,
+		"--startup-file=no",
+		"--color=no",
+		"--project=" + filepath.Join(pwd, "infra"),
+		"--threads=1",
+		"--",
+		filepath.Join(pwd, "infra", "main.jl"),
+	}, "\n") + "\n"
+	if string(data) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+}
+
+func TestRunResolvesSymlinkedProgram(t *testing.T) {
+	base := realPath(t.TempDir())
+	shared := filepath.Join(base, "shared", "infra")
+	writeFile(t, filepath.Join(shared, "Project.toml"), "[deps]\n")
+	writeFile(t, filepath.Join(shared, "program.jl"), "")
+	if err := os.Symlink(filepath.Join(shared, "program.jl"), filepath.Join(shared, "main.jl")); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(base, "service", "infra")
+	if err := os.MkdirAll(filepath.Dir(link), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(shared, link); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `{ pwd -P; printf '%s\n' "$@"; } > "`+out+`"`)
+
+	host := newTestHost()
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{
+		Pwd:  link,
+		Info: &pulumirpc.ProgramInfo{RootDirectory: link, ProgramDirectory: link, EntryPoint: "."},
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if resp.GetError() != "" {
+		t.Fatalf("unexpected error: %s", resp.GetError())
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		shared,
+		"--startup-file=no",
+		"--color=no",
+		"--project=" + shared,
+		"--threads=1",
+		"--",
+		filepath.Join(shared, "program.jl"),
+	}, "\n") + "\n"
+	if string(data) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+}
+
+// writeExecutable writes a shell script to path.
+func writeExecutable(t *testing.T, path, script string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake julia executables are shell scripts")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJuliaExecutableSelection(t *testing.T) {
+	root := t.TempDir()
+	writeExecutable(t, filepath.Join(root, "tools", "julia"), "echo 'julia version 1.10.4'\n")
+	writeExecutable(t, filepath.Join(root, "override"), "echo 'julia version 1.11.0'\n")
+	fakeJulia(t, "echo 'julia version 1.6.7'\n")
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	about := func(options map[string]interface{}) (*pulumirpc.AboutResponse, error) {
+		host := newTestHost()
+		return host.About(context.Background(), &pulumirpc.AboutRequest{
+			Info: &pulumirpc.ProgramInfo{RootDirectory: root, Options: mustStruct(t, options)},
+		})
+	}
+
+	resp, err := about(map[string]interface{}{})
+	if err != nil || resp.GetVersion() != "julia version 1.6.7" {
+		t.Errorf("expected the julia on the PATH, got %v, %v", resp, err)
+	}
+
+	resp, err = about(map[string]interface{}{"julia": "tools/julia"})
+	if err != nil || resp.GetExecutable() != filepath.Join(root, "tools", "julia") || resp.GetVersion() != "julia version 1.10.4" {
+		t.Errorf("expected the julia from the runtime option, got %v, %v", resp, err)
+	}
+
+	t.Setenv("PULUMI_JULIA_EXE", filepath.Join(root, "override"))
+	resp, err = about(map[string]interface{}{"julia": "tools/julia"})
+	if err != nil || resp.GetExecutable() != filepath.Join(root, "override") {
+		t.Errorf("expected PULUMI_JULIA_EXE to take precedence, got %v, %v", resp, err)
+	}
+}
+
+func TestRunMissingJuliaExecutable(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	host := newTestHost()
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory:    root,
+			ProgramDirectory: root,
+			EntryPoint:       ".",
+			Options:          mustStruct(t, map[string]interface{}{"julia": "/opt/julia-1.10/bin/julia"}),
+		},
+	})
+	msg := internalError(t, resp, err)
+	if !strings.Contains(msg, `julia executable "/opt/julia-1.10/bin/julia" from the julia runtime option not found`) {
+		t.Errorf("expected a missing executable error, got %q", msg)
+	}
+}
+
+func TestMissingJuliaExecutableHelp(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	writeFile(t, filepath.Join(root, "bin", "julia"), "")
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		message string
+	}{
+		{"missing path", map[string]interface{}{"julia": "./missing/julia"}, "from the julia runtime option not found"},
+		{"not on the PATH", nil, `julia executable "julia" from PATH not found`},
+		{"not executable", map[string]interface{}{"julia": "./bin/julia"}, "is not executable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "not executable" && runtime.GOOS == "windows" {
+				t.Skip("Windows has no executable bit")
+			}
+			t.Setenv("PATH", t.TempDir())
+			info := &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".", Options: mustStruct(t, tt.options),
+			}
+			expect := func(what, message string) {
+				t.Helper()
+				for _, part := range []string{tt.message, "Julia is required", "juliaup", "PULUMI_JULIA_EXE"} {
+					if !strings.Contains(message, part) {
+						t.Errorf("%s: expected an error containing %q, got %q", what, part, message)
+					}
+				}
+			}
+
+			host := newTestHost()
+			resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{Info: info})
+			expect("Run", internalError(t, resp, err))
+
+			err = host.InstallDependencies(&pulumirpc.InstallDependenciesRequest{Directory: root, Info: info},
+				&installDependenciesServer{})
+			if err == nil {
+				t.Fatal("expected InstallDependencies to fail")
+			}
+			expect("InstallDependencies", err.Error())
+
+			_, err = host.About(context.Background(), &pulumirpc.AboutRequest{Info: info})
+			if err == nil {
+				t.Fatal("expected About to fail")
+			}
+			expect("About", err.Error())
+		})
+	}
+}
+
+func TestJuliaupChannel(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	argv := filepath.Join(t.TempDir(), "argv")
+	fakeJulia(t, `printf '%s\n' "$@" > "`+argv+`"`+"\necho 'julia version 1.10.4'\n")
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	fake, err := exec.LookPath("julia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := t.TempDir()
+	statuses := filepath.Join(t.TempDir(), "statuses")
+	writeExecutable(t, filepath.Join(bin, "juliaup"), `echo >> "`+statuses+`"
+cat <<EOF
+ Default  Channel  Version                Update
+-----------------------------------------------
+       *  release  1.11.1+0.x64.linux.gnu
+          1.10     1.10.4+0.x64.linux.gnu
+EOF
+`)
+	if err := os.Symlink(fake, filepath.Join(bin, "julia")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	info := func(channel string) *pulumirpc.ProgramInfo {
+		return &pulumirpc.ProgramInfo{
+			RootDirectory:    root,
+			ProgramDirectory: root,
+			EntryPoint:       ".",
+			Options:          mustStruct(t, map[string]interface{}{"juliaVersion": channel}),
+		}
+	}
+
+	host := newTestHost()
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{Info: info("1.10")})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	data, err := os.ReadFile(argv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "+1.10\n--startup-file=no\n--color=no\n--project=") {
+		t.Errorf("expected the channel to be the first argument, got %q", data)
+	}
+
+	about, err := host.About(context.Background(), &pulumirpc.AboutRequest{Info: info("1.10")})
+	if err != nil || about.GetMetadata()["juliaupChannel"] != "1.10" {
+		t.Errorf("expected About to report the channel, got %v, %v", about, err)
+	}
+
+	if data, _ := os.ReadFile(statuses); strings.Count(string(data), "\n") != 1 {
+		t.Errorf("expected the channel to be checked once, got %d checks", strings.Count(string(data), "\n"))
+	}
+
+	resp, err = host.Run(context.Background(), &pulumirpc.RunRequest{Info: info("1.9")})
+	if msg := internalError(t, resp, err); !strings.Contains(msg, "juliaup add 1.9") {
+		t.Errorf("expected a missing channel error, got %q", msg)
+	}
+
+	// A julia that isn't juliaup's launcher is run without a channel.
+	t.Setenv("PULUMI_JULIA_EXE", fake)
+	resp, err = host.Run(context.Background(), &pulumirpc.RunRequest{Info: info("1.9")})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	if data, _ := os.ReadFile(argv); !strings.HasPrefix(string(data), "--startup-file=no\n") {
+		t.Errorf("expected no channel argument, got %q", data)
+	}
+}
+
+func TestRunChecksJuliaCompat(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n\n[compat]\njulia = \"1.10\"\n")
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeJulia(t, `echo "$*" >> "`+calls+`"
+case "$*" in *--version*) echo "julia version 1.6.7" ;; esac`)
+	info := &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."}
+
+	host := newTestHost()
+	for i := 0; i < 2; i++ {
+		resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{Info: info})
+		if err != nil || !strings.HasPrefix(resp.GetError(), "project requires Julia 1.10, found 1.6.7") ||
+			!strings.Contains(resp.GetError(), "juliaup add 1.10") {
+			t.Errorf("expected the run to fail on the julia version, got %v, %v", resp, err)
+		}
+	}
+	if data, _ := os.ReadFile(calls); string(data) != "--startup-file=no --color=no --version\n" {
+		t.Errorf("expected julia's version to be probed once and the program not to run, got %q", data)
+	}
+
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n\n[compat]\njulia = \"1.6\"\n")
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{Info: info})
+	if err != nil || resp.GetError() != "" {
+		t.Errorf("expected a supported julia to run the program, got %v, %v", resp, err)
+	}
+}
+
+func TestJuliaRunSwitchesThreads(t *testing.T) {
+	tests := []struct {
+		option   string
+		env      string
+		expected string
+	}{
+		{"auto", "16", "--threads=auto"},
+		{"", "16", "--threads=16"},
+		{"", "4,1", "--threads=4,1"},
+		{"", "", "--threads=1"},
+		{"", "many", "--threads=1"},
+	}
+	for _, tt := range tests {
+		t.Setenv("JULIA_NUM_THREADS", tt.env)
+		switches := juliaRunSwitches(runtimeOptions{Threads: tt.option}, false)
+		if actual := strings.Join(switches, " "); actual != tt.expected {
+			t.Errorf("option %q, JULIA_NUM_THREADS=%q: expected %q, got %q", tt.option, tt.env, tt.expected, actual)
+		}
+	}
+}
+
+func TestJuliaRunSwitchesJuliaArgs(t *testing.T) {
+	switches := juliaRunSwitches(runtimeOptions{
+		Threads: "4", JuliaArgs: []string{"--check-bounds=no", "--banner=no"},
+	}, false)
+	args := juliaRunArgs(juliaProgram{ProjectDir: "/infra", EntryPoint: "/infra/main.jl"}, switches, nil)
+	expected := []string{"--project=/infra", "--threads=4", "--check-bounds=no", "--banner=no", "--", "/infra/main.jl"}
+	if strings.Join(args, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+}
+
+func TestJuliaRunSwitchesOptimizeStartup(t *testing.T) {
+	tests := []struct {
+		option   optimizeStartup
+		dryRun   bool
+		expected string
+	}{
+		{optimizeStartupNever, true, "--threads=1"},
+		{optimizeStartupPreview, true, "--compile=min -O0 --inline=no --threads=1"},
+		{optimizeStartupPreview, false, "--threads=1"},
+		{optimizeStartupAlways, false, "--compile=min -O0 --inline=no --threads=1"},
+	}
+	unsetenv(t, "JULIA_NUM_THREADS")
+	for _, tt := range tests {
+		switches := juliaRunSwitches(runtimeOptions{OptimizeStartup: tt.option}, tt.dryRun)
+		if actual := strings.Join(switches, " "); actual != tt.expected {
+			t.Errorf("option %v, dry run %v: expected %q, got %q", tt.option, tt.dryRun, tt.expected, actual)
+		}
+	}
+}
+
+// runPluginServer collects the output streamed by RunPlugin.
+type runPluginServer struct {
+	grpc.ServerStream
+	stdout, stderr strings.Builder
+	exitCode       int32
+}
+
+func (s *runPluginServer) Send(resp *pulumirpc.RunPluginResponse) error {
+	s.stdout.Write(resp.GetStdout())
+	s.stderr.Write(resp.GetStderr())
+	if code, ok := resp.GetOutput().(*pulumirpc.RunPluginResponse_Exitcode); ok {
+		s.exitCode = code.Exitcode
+	}
+	return nil
+}
+
+func (s *runPluginServer) Context() context.Context {
+	return context.Background()
+}
+
+func TestSysimage(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	writeFile(t, filepath.Join(root, "build", "sys.so"), "")
+	argv := filepath.Join(t.TempDir(), "argv")
+	fakeJulia(t, `printf '%s\n' "$@" > "`+argv+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	info := func(options map[string]interface{}) *pulumirpc.ProgramInfo {
+		return &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".", Options: mustStruct(t, options),
+		}
+	}
+	args := func() string {
+		data, err := os.ReadFile(argv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	flag := "--sysimage=" + filepath.Join(root, "build", "sys.so") + "\n"
+
+	host := newTestHost()
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{
+		Info: info(map[string]interface{}{"sysimage": "build/sys.so"}),
+	})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	if !strings.Contains(args(), flag) {
+		t.Errorf("expected %q in the run arguments, got %q", flag, args())
+	}
+
+	server := &runPluginServer{}
+	err = host.RunPlugin(&pulumirpc.RunPluginRequest{
+		Pwd:     root,
+		Program: filepath.Join(root, "main.jl"),
+		Info:    info(map[string]interface{}{"sysimage": "build/sys.so"}),
+	}, server)
+	if err != nil {
+		t.Fatalf("RunPlugin: %v", err)
+	}
+	if !strings.Contains(args(), flag) {
+		t.Errorf("expected %q in the plugin arguments, got %q", flag, args())
+	}
+
+	// A missing sysimage falls back to the default one unless required.
+	resp, err = host.Run(context.Background(), &pulumirpc.RunRequest{
+		Info: info(map[string]interface{}{"sysimage": "missing.so"}),
+	})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	if strings.Contains(args(), "--sysimage") {
+		t.Errorf("expected no sysimage, got %q", args())
+	}
+
+	resp, err = host.Run(context.Background(), &pulumirpc.RunRequest{
+		Info: info(map[string]interface{}{"sysimage": "missing.so", "sysimageRequired": true}),
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !strings.Contains(resp.GetError(), "missing.so not found") {
+		t.Errorf("expected a missing sysimage error, got %q", resp.GetError())
+	}
+}
+
+func TestStartupFile(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	argv := filepath.Join(t.TempDir(), "argv")
+	fakeJulia(t, `printf '%s\n' "$@" > "`+argv+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	for _, startupFile := range []bool{false, true} {
+		resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+				Options: mustStruct(t, map[string]interface{}{"startupFile": startupFile}),
+			},
+		})
+		if err != nil || resp.GetError() != "" {
+			t.Fatalf("Run: %v, %v", resp, err)
+		}
+		data, err := os.ReadFile(argv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if skipped := strings.Contains(string(data), "--startup-file=no\n"); skipped == startupFile {
+			t.Errorf("startupFile %v: unexpected arguments %q", startupFile, data)
+		}
+	}
+}
+
+func TestDepot(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `echo "$JULIA_DEPOT_PATH" > "`+out+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	t.Setenv("JULIA_DEPOT_PATH", "")
+
+	info := &pulumirpc.ProgramInfo{
+		RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+		Options: mustStruct(t, map[string]interface{}{"depot": ".julia-depot"}),
+	}
+	depot := filepath.Join(root, ".julia-depot")
+	depotPath := func() string {
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(data))
+	}
+
+	host := newTestHost()
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{Info: info})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	if actual := depotPath(); actual != depot+string(os.PathListSeparator) {
+		t.Errorf("expected the depot to be prepended to the default depots, got %q", actual)
+	}
+	if stat, err := os.Stat(depot); err != nil || !stat.IsDir() {
+		t.Errorf("expected the depot to be created: %v", err)
+	}
+
+	t.Setenv("JULIA_DEPOT_PATH", "/opt/depot")
+	if err := host.InstallDependencies(&pulumirpc.InstallDependenciesRequest{Info: info},
+		&installDependenciesServer{}); err != nil {
+		t.Fatalf("InstallDependencies: %v", err)
+	}
+	if actual, expected := depotPath(), depot+string(os.PathListSeparator)+"/opt/depot"; actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+
+	about, err := host.About(context.Background(), &pulumirpc.AboutRequest{Info: info})
+	if err != nil || about.GetMetadata()["depot"] != depot {
+		t.Errorf("expected About to report the depot, got %v, %v", about.GetMetadata(), err)
+	}
+}
+
+func TestSharedDepot(t *testing.T) {
+	root, shared := t.TempDir(), filepath.Join(t.TempDir(), "depot")
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `echo "$JULIA_DEPOT_PATH" >> "`+out+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	t.Setenv("JULIA_DEPOT_PATH", "")
+	t.Setenv(sharedDepotEnvVar, shared)
+
+	info := &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."}
+	host := newTestHost()
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{Info: info})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	if err := host.InstallDependencies(&pulumirpc.InstallDependenciesRequest{Info: info},
+		&installDependenciesServer{}); err != nil {
+		t.Fatalf("InstallDependencies: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, actual := range strings.Fields(string(data)) {
+		if actual != shared+string(os.PathListSeparator) {
+			t.Errorf("expected every julia process to use the shared depot, got %q", data)
+		}
+	}
+	if stat, err := os.Stat(shared); err != nil || !stat.IsDir() {
+		t.Errorf("expected the shared depot to be created: %v", err)
+	}
+
+	about, err := host.About(context.Background(), &pulumirpc.AboutRequest{Info: info})
+	if err != nil || about.GetMetadata()["depot"] != shared || about.GetMetadata()["sharedDepot"] != shared {
+		t.Errorf("expected About to report the shared depot, got %v, %v", about.GetMetadata(), err)
+	}
+
+	// A project's own depot takes precedence.
+	info.Options = mustStruct(t, map[string]interface{}{"depot": ".julia-depot"})
+	about, err = host.About(context.Background(), &pulumirpc.AboutRequest{Info: info})
+	if err != nil || about.GetMetadata()["depot"] != filepath.Join(root, ".julia-depot") ||
+		about.GetMetadata()["sharedDepot"] != shared {
+		t.Errorf("expected the project's depot to be used, got %v, %v", about.GetMetadata(), err)
+	}
+}
+
+func TestHeapSizeHintSwitches(t *testing.T) {
+	opts := runtimeOptions{HeapSizeHint: "1G"}
+	for version, expected := range map[string]string{
+		"1.10.4": "--heap-size-hint=1G",
+		"1.9.0":  "--heap-size-hint=1G",
+		"1.8.5":  "",
+	} {
+		calls := filepath.Join(t.TempDir(), "calls")
+		fakeJulia(t, `echo >> "`+calls+`"; echo "julia version `+version+`"`)
+		t.Setenv("PULUMI_JULIA_EXE", "")
+		host := newTestHost()
+		julia, err := host.juliaCommand(nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			switches := host.heapSizeHintSwitches(context.Background(), julia, opts)
+			if strings.Join(switches, " ") != expected {
+				t.Errorf("julia %s: expected %q, got %q", version, expected, switches)
+			}
+		}
+		if data, err := os.ReadFile(calls); err != nil || strings.Count(string(data), "\n") != 1 {
+			t.Errorf("julia %s: expected the version to be probed once, got %q, %v", version, data, err)
+		}
+	}
+}
+
+func TestJuliaColor(t *testing.T) {
+	tests := []struct {
+		env        map[string]string
+		isTerminal bool
+		expected   bool
+	}{
+		{nil, false, false},
+		{nil, true, true},
+		{map[string]string{"NO_COLOR": ""}, true, false},
+		{map[string]string{"PULUMI_DISABLE_COLOR": "true"}, true, false},
+		{map[string]string{"PULUMI_DISABLE_COLOR": "false"}, true, true},
+		{map[string]string{"FORCE_COLOR": "1"}, false, true},
+		{map[string]string{"FORCE_COLOR": "1", "NO_COLOR": "1"}, true, false},
+	}
+	for _, tt := range tests {
+		for _, name := range []string{"NO_COLOR", "FORCE_COLOR", "PULUMI_DISABLE_COLOR"} {
+			unsetenv(t, name)
+		}
+		for name, value := range tt.env {
+			t.Setenv(name, value)
+		}
+		if actual := juliaColor(tt.isTerminal); actual != tt.expected {
+			t.Errorf("%v, terminal %v: expected %v, got %v", tt.env, tt.isTerminal, tt.expected, actual)
+		}
+	}
+}
+
+func TestInstallDependenciesColor(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `echo "$1 $2 FORCE_COLOR=$FORCE_COLOR" > "`+out+`"`)
+
+	err := newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+		IsTerminal: true,
+		Info:       &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	}, &installDependenciesServer{})
+	if err != nil {
+		t.Fatalf("InstallDependencies: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "--startup-file=no --color=yes FORCE_COLOR=1\n"; string(data) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+}
+
+func TestRunColor(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `echo "$* NO_COLOR=${NO_COLOR-unset}" > "`+out+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), " --color=no ") || !strings.HasSuffix(string(data), " NO_COLOR=unset\n") {
+		t.Errorf("expected color to be turned off for julia alone, got %q", data)
+	}
+}
+
+func TestAutoPrecompile(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `echo "$JULIA_PKG_PRECOMPILE_AUTO" > "`+out+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	for autoPrecompile, expected := range map[bool]string{false: "0", true: ""} {
+		resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+				Options: mustStruct(t, map[string]interface{}{"autoPrecompile": autoPrecompile}),
+			},
+		})
+		if err != nil || resp.GetError() != "" {
+			t.Fatalf("Run: %v, %v", resp, err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := strings.TrimSpace(string(data)); actual != expected {
+			t.Errorf("autoPrecompile %v: expected JULIA_PKG_PRECOMPILE_AUTO=%q, got %q", autoPrecompile, expected, actual)
+		}
+	}
+}
+
+func TestPrecompileWatcher(t *testing.T) {
+	var out strings.Builder
+	w := &precompileWatcher{w: &out}
+	for _, line := range []string{
+		"Loading\n",
+		"Precompiling PulumiAWS...\n",
+		"Precompiling Pulumi...\n",
+	} {
+		if _, err := io.WriteString(w, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := "Loading\nPrecompiling PulumiAWS...\n" + precompileNote + "Precompiling Pulumi...\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestRunExportsJuliaProject(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "env", "Project.toml"), "[deps]\n")
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `echo "$JULIA_PROJECT $2 $3" > "`+out+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	for project, expected := range map[string]string{
+		"env":     filepath.Join(root, "env"),
+		"@pulumi": "@pulumi",
+	} {
+		resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+				Options: mustStruct(t, map[string]interface{}{"project": project}),
+			},
+		})
+		if err != nil || resp.GetError() != "" {
+			t.Fatalf("Run: %v, %v", resp, err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// JULIA_PROJECT matches the --project switch following the
+		// startup and color switches.
+		if actual := strings.TrimSpace(string(data)); actual != expected+" --color=no --project="+expected {
+			t.Errorf("project %s: unexpected JULIA_PROJECT and arguments %q", project, actual)
+		}
+	}
+}
+
+func TestRunBinary(t *testing.T) {
+	root := t.TempDir()
+	pwd := t.TempDir()
+	out := filepath.Join(t.TempDir(), "out")
+	writeExecutable(t, filepath.Join(root, "build", "bin", "infra"),
+		`echo "$(pwd) $PULUMI_STACK $#" > "`+out+`"`)
+	writeFile(t, filepath.Join(root, "build", "bin", "data"), "")
+	// Binary mode needs neither julia nor an entry point.
+	t.Setenv("PATH", t.TempDir())
+
+	info := func(binary string) *pulumirpc.ProgramInfo {
+		return &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+			Options: mustStruct(t, map[string]interface{}{"binary": binary}),
+		}
+	}
+	host := newTestHost()
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{
+		Stack: "dev", Pwd: pwd, Info: info("./build/bin/infra"),
+	})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := pwd + " dev 0\n"; string(data) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+
+	about, err := host.About(context.Background(), &pulumirpc.AboutRequest{Info: info("./build/bin/infra")})
+	if err != nil || about.GetExecutable() != filepath.Join(root, "build", "bin", "infra") {
+		t.Errorf("expected About to report the binary, got %v, %v", about, err)
+	}
+
+	for binary, message := range map[string]string{
+		"build/bin/missing": "not found",
+		"build/bin/data":    "not an executable file",
+		"build/bin":         "not an executable file",
+	} {
+		resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{Info: info(binary)})
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		if !strings.Contains(resp.GetError(), message) {
+			t.Errorf("binary %s: expected an error containing %q, got %q", binary, message, resp.GetError())
+		}
+	}
+}
+
+func TestJuliaRunSwitchesCoverage(t *testing.T) {
+	unsetenv(t, "JULIA_NUM_THREADS")
+	switches := juliaRunSwitches(runtimeOptions{Coverage: "user", JuliaArgs: []string{"--check-bounds=yes"}}, false)
+	if expected := "--threads=1 --code-coverage=user --check-bounds=yes"; strings.Join(switches, " ") != expected {
+		t.Errorf("expected %q, got %q", expected, switches)
+	}
+}
+
+func TestRunPassesEnvironmentThrough(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `echo "$JULIA_DEBUG" > "`+out+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	t.Setenv("JULIA_DEBUG", "Pulumi")
+
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+			Options: mustStruct(t, map[string]interface{}{"coverage": "user"}),
+		},
+	})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != "Pulumi" {
+		t.Errorf("expected JULIA_DEBUG to reach the program, got %q", data)
+	}
+}
+
+func TestLoadPath(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	writeFile(t, filepath.Join(root, "vendor", "Acme", "src", "Acme.jl"), "")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `echo "$JULIA_LOAD_PATH" > "`+out+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	unsetenv(t, "JULIA_LOAD_PATH")
+
+	info := &pulumirpc.ProgramInfo{
+		RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+		Options: mustStruct(t, map[string]interface{}{"loadPath": []interface{}{"vendor", "missing"}}),
+	}
+	loadPath := func() string {
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(data))
+	}
+	sep := string(os.PathListSeparator)
+	vendored := filepath.Join(root, "vendor") + sep + filepath.Join(root, "missing") + sep
+
+	host := newTestHost()
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{Info: info})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	if actual := loadPath(); actual != vendored {
+		t.Errorf("expected %q, got %q", vendored, actual)
+	}
+
+	t.Setenv("JULIA_LOAD_PATH", "@"+sep+"@stdlib")
+	if err := host.InstallDependencies(&pulumirpc.InstallDependenciesRequest{Info: info},
+		&installDependenciesServer{}); err != nil {
+		t.Fatalf("InstallDependencies: %v", err)
+	}
+	if actual, expected := loadPath(), vendored+"@"+sep+"@stdlib"; actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
+
+func TestRunPassesLargeConfigInFile(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	out := t.TempDir()
+	fakeJulia(t, `echo "$PULUMI_CONFIG_FILE" > "`+out+`/path"
+echo "${PULUMI_CONFIG-unset}" > "`+out+`/env"
+ls -l "$PULUMI_CONFIG_FILE" > "`+out+`/mode"
+cp "$PULUMI_CONFIG_FILE" "`+out+`/config"
+exit 1`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	unsetenv(t, "PULUMI_CONFIG")
+
+	large := strings.Repeat("x", configFileThreshold)
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Config: map[string]string{"project:blob": large},
+		Info:   &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if resp.GetError() == "" {
+		t.Fatalf("expected the program failure to be reported")
+	}
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(data))
+	}
+	if config := read("config"); config != `{"version":2,"config":{"project:blob":"`+large+`"}}` {
+		t.Errorf("unexpected config file contents %.40q...", config)
+	}
+	if env := read("env"); env != "unset" {
+		t.Errorf("expected PULUMI_CONFIG to be unset, got %.40q...", env)
+	}
+	if mode := read("mode"); !strings.HasPrefix(mode, "-rw-------") {
+		t.Errorf("expected the config file to be private, got %s", mode)
+	}
+	// The file is removed once the program exits, even when it fails.
+	if _, err := os.Stat(read("path")); !os.IsNotExist(err) {
+		t.Errorf("expected the config file to be removed, got %v", err)
+	}
+}
+
+func TestDirectoryEnv(t *testing.T) {
+	root := realPath(t.TempDir())
+	program := filepath.Join(root, "infra")
+	writeFile(t, filepath.Join(program, "main.jl"), "")
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(root, link); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  *pulumirpc.RunRequest
+	}{
+		{"program info", &pulumirpc.RunRequest{Info: &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: program, EntryPoint: ".",
+		}}},
+		{"symlinked program info", &pulumirpc.RunRequest{Info: &pulumirpc.ProgramInfo{
+			RootDirectory: link, ProgramDirectory: filepath.Join(link, "infra"), EntryPoint: ".",
+		}}},
+		{"legacy program path", &pulumirpc.RunRequest{
+			Pwd: link, Program: filepath.Join("infra", "main.jl"), Info: &pulumirpc.ProgramInfo{RootDirectory: link},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected := []string{"PULUMI_ROOT_DIRECTORY=" + root, "PULUMI_PROGRAM_DIRECTORY=" + program}
+			if actual := newTestHost().directoryEnv(tt.req); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("expected %v, got %v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestRunExportsDirectories(t *testing.T) {
+	root := realPath(t.TempDir())
+	program := filepath.Join(root, "infra")
+	writeFile(t, filepath.Join(program, "main.jl"), "")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `echo "$PULUMI_ROOT_DIRECTORY:$PULUMI_PROGRAM_DIRECTORY" > "`+out+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: program, EntryPoint: "."},
+	})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := root + ":" + program; strings.TrimSpace(string(data)) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+}
+
+func TestRunEnv(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `echo "$AWS_PROFILE:$GREETING:$PULUMI_STACK" > "`+out+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	t.Setenv("AWS_PROFILE", "default")
+
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Stack: "dev",
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+			Options: mustStruct(t, map[string]interface{}{
+				"env": map[string]interface{}{"AWS_PROFILE": "prod", "GREETING": "$AWS_PROFILE"},
+			}),
+		},
+	})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "prod:$AWS_PROFILE:dev"; strings.TrimSpace(string(data)) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+}
+
+func TestRunForwardsArgs(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	out := filepath.Join(t.TempDir(), "out")
+	// Like julia, put everything after the program file in ARGS and echo it.
+	fakeJulia(t, `while [ "$1" != "--" ]; do shift; done; shift 2
+for arg in "$@"; do printf '%s\n' "$arg"; done > "`+out+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	args := []string{"--flag", "two words", "ünïcödé ✓", "$HOME", ""}
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Args: args,
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); !reflect.DeepEqual(actual, args) {
+		t.Errorf("expected ARGS %q, got %q", args, actual)
+	}
+}
+
+func TestJuliaRunArgsPackageProgramArgs(t *testing.T) {
+	prog := juliaProgram{ProjectDir: "/infra", Package: "Infra"}
+	expected := []string{"--project=/infra", "-e", "using Infra; Infra.main()", "--", "--flag", "a b"}
+	if args := juliaRunArgs(prog, nil, []string{"--flag", "a b"}); !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+}
+
+func TestProgramEnv(t *testing.T) {
+	root := realPath(t.TempDir())
+	info := &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."}
+	host := newJuliaLanguageHost("127.0.0.1:4321", "", "", defaultMaxSourceScanBytes)
+
+	tests := []struct {
+		name      string
+		req       *pulumirpc.RunRequest
+		configEnv []string
+		expected  []string
+	}{
+		{
+			name: "update",
+			req: &pulumirpc.RunRequest{
+				Project: "proj", Stack: "dev", Parallel: 8, MonitorAddress: "127.0.0.1:1234", Info: info,
+			},
+			configEnv: []string{"PULUMI_CONFIG={}", "PULUMI_CONFIG_SECRET_KEYS=[]"},
+			expected: []string{
+				"PULUMI_PROJECT=proj", "PULUMI_STACK=dev", "PULUMI_DRY_RUN=false", "PULUMI_QUERY_MODE=false",
+				"PULUMI_PARALLEL=8", "PULUMI_MONITOR=127.0.0.1:1234", "PULUMI_ENGINE=127.0.0.1:4321",
+				"PULUMI_ROOT_DIRECTORY=" + root, "PULUMI_PROGRAM_DIRECTORY=" + root,
+				"PULUMI_CONFIG={}", "PULUMI_CONFIG_SECRET_KEYS=[]",
+			},
+		},
+		{
+			name: "query",
+			req: &pulumirpc.RunRequest{
+				Project: "proj", Stack: "dev", Organization: "acme", DryRun: true, QueryMode: true, Info: info,
+			},
+			configEnv: []string{"PULUMI_CONFIG_FILE=/tmp/config.json", "PULUMI_CONFIG_SECRET_KEYS=[]"},
+			expected: []string{
+				"PULUMI_PROJECT=proj", "PULUMI_STACK=dev", "PULUMI_DRY_RUN=true", "PULUMI_QUERY_MODE=true",
+				"PULUMI_PARALLEL=2147483647", "PULUMI_MONITOR=", "PULUMI_ENGINE=127.0.0.1:4321",
+				"PULUMI_ROOT_DIRECTORY=" + root, "PULUMI_PROGRAM_DIRECTORY=" + root,
+				"PULUMI_CONFIG_FILE=/tmp/config.json", "PULUMI_CONFIG_SECRET_KEYS=[]", "PULUMI_ORGANIZATION=acme",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := host.programEnv(tt.req, tt.configEnv)
+			if err != nil {
+				t.Fatalf("programEnv: %v", err)
+			}
+			if !reflect.DeepEqual(env, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, env)
+			}
+			// The env runtime option must not be able to override any of them.
+			for _, entry := range env {
+				if name, _, _ := strings.Cut(entry, "="); !slices.Contains(reservedEnvNames, name) {
+					t.Errorf("%s is not reserved", name)
+				}
+			}
+		})
+	}
+}
+
+func TestRunInvalidAddress(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `echo "$PULUMI_MONITOR $PULUMI_ENGINE" > "`+out+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	info := &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."}
+
+	host := newJuliaLanguageHost("::1:52341", "", "", defaultMaxSourceScanBytes)
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{
+		MonitorAddress: "/run/pulumi/monitor.sock", Info: info,
+	})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "unix:/run/pulumi/monitor.sock [::1]:52341"; strings.TrimSpace(string(data)) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+
+	if err := os.Remove(out); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = host.Run(context.Background(), &pulumirpc.RunRequest{MonitorAddress: "localhost", Info: info})
+	if msg := internalError(t, resp, err); !strings.Contains(msg, `invalid monitor address "localhost"`) {
+		t.Errorf("expected an invalid monitor address error, got %q", msg)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("expected the program not to run, got %v", err)
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	env := mergeEnv(
+		[]string{"HOME=/home/me", "PULUMI_STACK=old", "PATH=/bin", "PULUMI_STACK=older"},
+		[]string{"AWS_PROFILE=prod", "PATH=/usr/bin"},
+		[]string{"PULUMI_STACK=dev", "PULUMI_CONFIG={}"},
+	)
+	expected := []string{"HOME=/home/me", "PULUMI_STACK=dev", "PATH=/usr/bin", "AWS_PROFILE=prod", "PULUMI_CONFIG={}"}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("expected %q, got %q", expected, env)
+	}
+}
+
+func TestRunReplacesInheritedPulumiEnv(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `env > "`+out+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	// As left by a parent Pulumi process, e.g. automation API in CI.
+	t.Setenv("PULUMI_PROJECT", "parent-project")
+	t.Setenv("PULUMI_STACK", "parent-stack")
+	t.Setenv("PULUMI_DRY_RUN", "true")
+	t.Setenv("PULUMI_CONFIG_SECRETS_FILE", "/tmp/parent-secrets.json")
+	t.Setenv("PULUMI_ORGANIZATION", "parent-org")
+	t.Setenv("PULUMI_ACCESS_TOKEN", "token")
+
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Project: "proj", Stack: "dev",
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string][]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if name, value, ok := strings.Cut(line, "="); ok && strings.HasPrefix(name, "PULUMI_") {
+			values[name] = append(values[name], value)
+		}
+	}
+	for name, expected := range map[string][]string{
+		"PULUMI_PROJECT":             {"proj"},
+		"PULUMI_STACK":               {"dev"},
+		"PULUMI_DRY_RUN":             {"false"},
+		"PULUMI_CONFIG_SECRETS_FILE": nil,
+		"PULUMI_ORGANIZATION":        nil,
+		"PULUMI_ACCESS_TOKEN":        {"token"},
+	} {
+		if !reflect.DeepEqual(values[name], expected) {
+			t.Errorf("%s: expected %q, got %q", name, expected, values[name])
+		}
+	}
+}
+
+func TestEffectiveParallel(t *testing.T) {
+	for parallel, expected := range map[int32]int32{
+		0:             math.MaxInt32,
+		-1:            math.MaxInt32,
+		1:             1,
+		16:            16,
+		math.MaxInt32: math.MaxInt32,
+	} {
+		if actual := effectiveParallel(parallel); actual != expected {
+			t.Errorf("parallel %d: expected %d, got %d", parallel, expected, actual)
+		}
+	}
+}
+
+// runUntilCancelled runs the program of a fake julia running script, which
+// touches the ready file once it has started, cancels the run and returns
+// how long the program took to exit.
+func runUntilCancelled(
+	t *testing.T, script string, options map[string]interface{},
+) (time.Duration, *pulumirpc.RunResponse, error) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("programs can't be interrupted on Windows")
+	}
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	ready := filepath.Join(t.TempDir(), "ready")
+	fakeJulia(t, `touch "`+ready+`"`+"\n"+script)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	var resp *pulumirpc.RunResponse
+	var err error
+	go func() {
+		defer close(done)
+		resp, err = newTestHost().Run(ctx, &pulumirpc.RunRequest{Info: &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".", Options: mustStruct(t, options),
+		}})
+	}()
+
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(ready); err == nil {
+			break
+		} else if time.Since(start) > 10*time.Second {
+			t.Fatal("the program didn't start")
+		}
+	}
+	cancelled := time.Now()
+	cancel()
+	select {
+	case <-done:
+		return time.Since(cancelled), resp, err
+	case <-time.After(10 * time.Second):
+		t.Fatal("the program wasn't stopped")
+		return 0, nil, nil
+	}
+}
+
+func TestRunInterruptsOnCancel(t *testing.T) {
+	// Like a program catching InterruptException to clean up in a finally
+	// block.
+	out := filepath.Join(t.TempDir(), "out")
+	_, resp, err := runUntilCancelled(t, `trap 'echo interrupted > "`+out+`"; exit 130' INT
+while :; do sleep 0.05; done`, nil)
+	if err != nil || resp.GetError() != "" {
+		t.Errorf("expected the cancelled run not to fail, got %v, %v", resp, err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil || strings.TrimSpace(string(data)) != "interrupted" {
+		t.Errorf("expected the program to be interrupted, got %q, %v", data, err)
+	}
+}
+
+func TestRunCancellationIsNotAFailure(t *testing.T) {
+	for name, script := range map[string]string{
+		"stopped by the interrupt":   "while :; do sleep 0.05; done",
+		"terminated after interrupt": "trap 'kill -TERM $$' INT; while :; do sleep 0.05; done",
+		"exits 143":                  "trap 'exit 143' INT; while :; do sleep 0.05; done",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, resp, err := runUntilCancelled(t, script, nil)
+			if err != nil || resp.GetError() != "" || resp.GetBail() {
+				t.Errorf("expected the cancelled run not to fail, got %v, %v", resp, err)
+			}
+		})
+	}
+
+	// Programs failing for other reasons as they are cancelled still fail.
+	_, resp, err := runUntilCancelled(t, `trap 'echo "ERROR: cleanup failed" >&2; exit 1' INT
+while :; do sleep 0.05; done`, nil)
+	if err != nil || resp.GetError() != "ERROR: cleanup failed" {
+		t.Errorf("expected the run to fail, got %v, %v", resp, err)
+	}
+}
+
+func TestRunInterruptExitWithoutCancellation(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	fakeJulia(t, "exit 130")
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	})
+	if err != nil || resp.GetError() != "Julia program exited with code 130" {
+		t.Errorf("expected the run to fail, got %v, %v", resp, err)
+	}
+}
+
+func TestRunKillsAfterCancelGracePeriod(t *testing.T) {
+	elapsed, resp, err := runUntilCancelled(t, `trap '' INT
+while :; do sleep 0.05; done`, map[string]interface{}{"cancelGracePeriod": "200ms"})
+	if elapsed > 5*time.Second {
+		t.Errorf("expected the program to be killed after the grace period, took %s", elapsed)
+	}
+	if err != nil || resp.GetError() != "" {
+		t.Errorf("expected the cancelled run not to fail, got %v, %v", resp, err)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	opts := runtimeOptions{Timeout: time.Hour, PreviewTimeout: time.Minute}
+	for dryRun, expected := range map[bool]struct {
+		timeout time.Duration
+		option  string
+	}{
+		true:  {time.Minute, "previewTimeout"},
+		false: {time.Hour, "timeout"},
+	} {
+		if timeout, option := runTimeout(opts, dryRun); timeout != expected.timeout || option != expected.option {
+			t.Errorf("dryRun %t: expected %s from %s, got %s from %s",
+				dryRun, expected.timeout, expected.option, timeout, option)
+		}
+	}
+	if timeout, _ := runTimeout(runtimeOptions{PreviewTimeout: time.Minute}, false); timeout != 0 {
+		t.Errorf("expected no timeout for updates, got %s", timeout)
+	}
+}
+
+func TestRunTimesOut(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a shell")
+	}
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	fakeJulia(t, `trap 'exit 130' INT
+while :; do sleep 0.05; done`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	start := time.Now()
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		DryRun: true,
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+			Options: mustStruct(t, map[string]interface{}{"timeout": "1h", "previewTimeout": "200ms"}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !strings.Contains(resp.GetError(), "timed out after 200ms") ||
+		!strings.Contains(resp.GetError(), "previewTimeout runtime option") {
+		t.Errorf("expected a timeout error naming previewTimeout, got %q", resp.GetError())
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the program to be stopped at the timeout, took %s", elapsed)
+	}
+}
+
+func TestRunClosesStdin(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	fakeJulia(t, "if read line; then echo \"read: $line\" >&2; else echo 'end of input' >&2; fi\nexit 1")
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	// Give the host a stdin with input pending that never ends, which the
+	// program would wait on forever if it read it.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if _, err := w.WriteString("typed\n"); err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	// A stdin that isn't a terminal isn't inherited even when asked for.
+	for _, inherit := range []bool{false, true} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		resp, err := newTestHost().Run(ctx, &pulumirpc.RunRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+				Options: mustStruct(t, map[string]interface{}{"inheritStdin": inherit}),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		if resp.GetError() != "end of input" {
+			t.Errorf("inheritStdin %v: expected an empty stdin, got %q", inherit, resp.GetError())
+		}
+	}
+}
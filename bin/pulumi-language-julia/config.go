@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/sig"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+// configFormatVersion marks the config documents passed to programs, so that
+// the SDK can tell them from the flat map of strings passed by older hosts:
+//
+//	{"version": 2, "config": {"project:name": "web", "project:data": {"nested": [1, true]}},
+//	 "aliases": {"name": "project:name", "data": "project:data"}}
+//
+// Config values keep their types: objects, arrays, numbers and booleans set
+// with `pulumi config set --path` reach the program as such. The aliases map
+// the keys of the project's own config, without their namespace, to the full
+// keys.
+const configFormatVersion = 2
+
+// configDocument is the JSON document config is passed to programs in.
+type configDocument struct {
+	Version int                    `json:"version"`
+	Config  map[string]interface{} `json:"config"`
+	Aliases map[string]string      `json:"aliases,omitempty"`
+}
+
+func marshalConfig(values map[string]interface{}, aliases map[string]string) (string, error) {
+	data, err := json.Marshal(configDocument{Version: configFormatVersion, Config: values, Aliases: aliases})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// configAliases maps the keys of project's config among keys to themselves
+// without the "project:" namespace, e.g. "name" to "project:name". An alias
+// that is a key of its own, such as "aws:region" for "project:aws:region",
+// is left out, as the explicit key takes precedence.
+func configAliases(project string, keys []string) map[string]string {
+	if project == "" {
+		return nil
+	}
+	explicit := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		explicit[key] = true
+	}
+	aliases := map[string]string{}
+	for _, key := range keys {
+		alias, ok := strings.CutPrefix(key, project+":")
+		if !ok || alias == "" {
+			continue
+		}
+		if explicit[alias] {
+			logging.V(5).Infof("not aliasing config key %s as %s, which is a key of its own", key, alias)
+			continue
+		}
+		aliases[alias] = key
+	}
+	return aliases
+}
+
+// splitConfig returns the config of req, separating the secret values from
+// the others. Values are taken from the typed property map sent by newer
+// engines, with secret values unwrapped; for older engines, values holding
+// JSON objects or arrays are parsed. A key is secret if the engine says so or
+// if its value holds a secret.
+func splitConfig(req *pulumirpc.RunRequest) (plain, secrets map[string]interface{}) {
+	secretKeys := map[string]bool{}
+	for _, key := range req.GetConfigSecretKeys() {
+		secretKeys[key] = true
+	}
+
+	values := map[string]interface{}{}
+	if propertyMap := req.GetConfigPropertyMap(); propertyMap != nil {
+		for key, value := range propertyMap.AsMap() {
+			value, secret := unwrapSecrets(value)
+			values[key] = value
+			if secret {
+				secretKeys[key] = true
+			}
+		}
+	} else {
+		for key, value := range req.GetConfig() {
+			values[key] = parseConfigValue(value)
+		}
+	}
+
+	plain, secrets = map[string]interface{}{}, map[string]interface{}{}
+	for key, value := range values {
+		if secretKeys[key] {
+			secrets[key] = value
+		} else {
+			plain[key] = value
+		}
+	}
+	return plain, secrets
+}
+
+// parseConfigValue returns the structured value of a config value holding a
+// JSON object or array, and any other value as is. Scalars stay strings, as
+// a string such as "true" can't be told from a boolean.
+func parseConfigValue(value string) interface{} {
+	trimmed := strings.TrimSpace(value)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return value
+	}
+	var structured interface{}
+	if err := json.Unmarshal([]byte(trimmed), &structured); err != nil {
+		return value
+	}
+	return structured
+}
+
+// unwrapSecrets replaces the secrets in a value of the config property map,
+// marked with the secret signature, by their plain values, reporting whether
+// there were any.
+func unwrapSecrets(value interface{}) (interface{}, bool) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if value[sig.Key] == sig.Secret {
+			unwrapped, _ := unwrapSecrets(value["value"])
+			return unwrapped, true
+		}
+		secret := false
+		unwrapped := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			v, s := unwrapSecrets(v)
+			unwrapped[k] = v
+			secret = secret || s
+		}
+		return unwrapped, secret
+	case []interface{}:
+		secret := false
+		unwrapped := make([]interface{}, len(value))
+		for i, v := range value {
+			v, s := unwrapSecrets(v)
+			unwrapped[i] = v
+			secret = secret || s
+		}
+		return unwrapped, secret
+	default:
+		return value, false
+	}
+}
+
+// Config that can't be passed to programs in the environment is written to
+// temporary files, named after these patterns, holding a config document:
+//
+//   - PULUMI_CONFIG_FILE names a file holding the config that isn't secret,
+//     in place of PULUMI_CONFIG, when it is too large for the environment.
+//   - PULUMI_CONFIG_SECRETS_FILE names a file holding the secret config,
+//     which is never put in the environment, where child processes, crash
+//     dumps and /proc/<pid>/environ would expose it.
+//
+// The files can only be read by the current user, and are removed once the
+// program exits.
+const (
+	configFilePattern        = "pulumi-julia-config-*.json"
+	configSecretsFilePattern = "pulumi-julia-secrets-*.json"
+)
+
+// configFileThreshold is the size of the serialized config above which it is
+// passed to programs in a file rather than in PULUMI_CONFIG, well below the
+// 128KiB Linux allows for a single environment variable.
+const configFileThreshold = 32 * 1024
+
+// staleConfigFileAge is the age after which the config files of a host that
+// is no longer running are assumed to have been left behind by it.
+const staleConfigFileAge = 24 * time.Hour
+
+// writeConfigFile writes config to a temporary file named after pattern that
+// only the current user can read, and returns its path. The name starts with
+// the host's PID, so that sweeps tell whose file it is. The caller removes
+// the file.
+func writeConfigFile(pattern, config string) (string, error) {
+	file, err := os.CreateTemp("", strings.Replace(pattern, "*", strconv.Itoa(os.Getpid())+"-*", 1))
+	if err != nil {
+		return "", fmt.Errorf("failed to create config file: %w", err)
+	}
+	if _, err := file.WriteString(config); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write config file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write config file: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// sweepConfigFiles removes the config files older than maxAge whose host is
+// no longer running. The files of a running host, which may be in the middle
+// of a long update, are kept however old they are.
+func sweepConfigFiles(maxAge time.Duration) {
+	for _, pattern := range []string{configFilePattern, configSecretsFilePattern} {
+		paths, err := filepath.Glob(filepath.Join(os.TempDir(), pattern))
+		if err != nil {
+			continue
+		}
+		for _, path := range paths {
+			stat, err := os.Stat(path)
+			if err != nil || time.Since(stat.ModTime()) < maxAge {
+				continue
+			}
+			if pid, ok := configFilePID(filepath.Base(path), pattern); ok && processAlive(pid) {
+				logging.V(5).Infof("keeping config file %s of running host %d", path, pid)
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				logging.V(5).Infof("failed to remove stale config file %s: %v", path, err)
+			} else {
+				logging.V(5).Infof("removed stale config file %s", path)
+			}
+		}
+	}
+}
+
+// configFilePID returns the PID of the host that wrote the config file name,
+// named after pattern, if the name records it.
+func configFilePID(name, pattern string) (int, bool) {
+	prefix, _, _ := strings.Cut(pattern, "*")
+	pid, _, ok := strings.Cut(strings.TrimPrefix(name, prefix), "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(pid)
+	return n, err == nil && n > 0
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/sig"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+func TestRunKeepsSecretsOutOfEnvironment(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	out := t.TempDir()
+	fakeJulia(t, `echo "$PULUMI_CONFIG_SECRETS_FILE" > "`+out+`/path"
+env > "`+out+`/env"
+ls -l "$PULUMI_CONFIG_SECRETS_FILE" > "`+out+`/mode"
+cp "$PULUMI_CONFIG_SECRETS_FILE" "`+out+`/secrets"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Config:           map[string]string{"project:name": "web", "project:password": "hunter2"},
+		ConfigSecretKeys: []string{"project:password"},
+		Info:             &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(data))
+	}
+	env := read("env")
+	if strings.Contains(env, "hunter2") {
+		t.Errorf("expected the secret to be kept out of the environment")
+	}
+	if !strings.Contains(env, `PULUMI_CONFIG={"version":2,"config":{"project:name":"web"}}`) {
+		t.Errorf("expected the plain config in the environment")
+	}
+	if !strings.Contains(env, `PULUMI_CONFIG_SECRET_KEYS=["project:password"]`) {
+		t.Errorf("expected the secret keys in the environment")
+	}
+	if secrets := read("secrets"); secrets != `{"version":2,"config":{"project:password":"hunter2"}}` {
+		t.Errorf("unexpected secrets file contents %q", secrets)
+	}
+	if mode := read("mode"); !strings.HasPrefix(mode, "-rw-------") {
+		t.Errorf("expected the secrets file to be private, got %s", mode)
+	}
+	if _, err := os.Stat(read("path")); !os.IsNotExist(err) {
+		t.Errorf("expected the secrets file to be removed, got %v", err)
+	}
+}
+
+func TestRunWithoutSecrets(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `echo "${PULUMI_CONFIG_SECRETS_FILE-unset}" > "`+out+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Config: map[string]string{"project:name": "web"},
+		Info:   &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != "unset" {
+		t.Errorf("expected no secrets file, got %q", data)
+	}
+}
+
+func TestSweepConfigFiles(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	running, err := writeConfigFile(configSecretsFilePattern, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * staleConfigFileAge)
+	if err := os.Chtimes(running, old, old); err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := writeConfigFile(configFilePattern, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unrelated := filepath.Join(os.TempDir(), "other.json")
+	writeFile(t, unrelated, "{}")
+	if err := os.Chtimes(unrelated, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the old files of hosts that are gone are stale; running was
+	// written by this host, which is still running.
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	orphaned := filepath.Join(os.TempDir(), fmt.Sprintf("pulumi-julia-config-%d-123.json", exited.Process.Pid))
+	legacy := filepath.Join(os.TempDir(), "pulumi-julia-secrets-456.json")
+	for _, path := range []string{orphaned, legacy} {
+		writeFile(t, path, "{}")
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sweepConfigFiles(staleConfigFileAge)
+	for _, path := range []string{orphaned, legacy} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", path, err)
+		}
+	}
+	for _, path := range []string{running, fresh, unrelated} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s to be kept: %v", path, err)
+		}
+	}
+}
+
+func TestSplitConfigPropertyMap(t *testing.T) {
+	secret := func(value interface{}) map[string]interface{} {
+		return map[string]interface{}{sig.Key: sig.Secret, "value": value}
+	}
+	req := &pulumirpc.RunRequest{
+		ConfigSecretKeys: []string{"project:password"},
+		ConfigPropertyMap: mustStruct(t, map[string]interface{}{
+			"project:name":     "web",
+			"project:enabled":  true,
+			"project:password": secret("hunter2"),
+			"project:data": map[string]interface{}{
+				"ports": []interface{}{80.0, 443.0},
+				"tls":   map[string]interface{}{"key": secret("private")},
+			},
+		}),
+	}
+
+	config, err := newTestHost().constructConfig(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"version":2,"config":{"project:enabled":true,"project:name":"web"}}`; config != expected {
+		t.Errorf("expected %s, got %s", expected, config)
+	}
+
+	secrets, err := newTestHost().constructConfigSecrets(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"version":2,"config":{"project:data":{"ports":[80,443],"tls":{"key":"private"}},` +
+		`"project:password":"hunter2"}}`
+	if secrets != expected {
+		t.Errorf("expected %s, got %s", expected, secrets)
+	}
+
+	// A value holding a secret is secret, even if the engine didn't say so.
+	keys, err := newTestHost().constructConfigSecretKeys(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `["project:data","project:password"]`; keys != expected {
+		t.Errorf("expected %s, got %s", expected, keys)
+	}
+}
+
+func TestSplitConfigStrings(t *testing.T) {
+	config, err := newTestHost().constructConfig(&pulumirpc.RunRequest{
+		Config: map[string]string{
+			"project:flag":  "true",
+			"project:count": "3",
+			"project:data":  `{"nested": {"list": [1, "two"]}}`,
+			"project:brace": "{not json",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"version":2,"config":{"project:brace":"{not json","project:count":"3",` +
+		`"project:data":{"nested":{"list":[1,"two"]}},"project:flag":"true"}}`
+	if config != expected {
+		t.Errorf("expected %s, got %s", expected, config)
+	}
+}
+
+func TestConstructConfigAliases(t *testing.T) {
+	config, err := newTestHost().constructConfig(&pulumirpc.RunRequest{
+		Project: "project",
+		Config: map[string]string{
+			"project:name":       "web",
+			"project:password":   "hunter2",
+			"project:aws:region": "eu-west-1",
+			"aws:region":         "us-west-2",
+			"other:name":         "api",
+		},
+		ConfigSecretKeys: []string{"project:password"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The explicit aws:region key wins over the alias of project:aws:region,
+	// and secret keys are aliased without their values being exposed.
+	expected := `{"version":2,"config":{"aws:region":"us-west-2","other:name":"api",` +
+		`"project:aws:region":"eu-west-1","project:name":"web"},` +
+		`"aliases":{"name":"project:name","password":"project:password"}}`
+	if config != expected {
+		t.Errorf("expected %s, got %s", expected, config)
+	}
+}
+
+func TestConfigAliases(t *testing.T) {
+	for _, tt := range []struct {
+		project  string
+		keys     []string
+		expected map[string]string
+	}{
+		{"proj", []string{"proj:a", "proj:b:c", "other:a"}, map[string]string{"a": "proj:a", "b:c": "proj:b:c"}},
+		{"proj", []string{"proj:aws:region", "aws:region"}, map[string]string{}},
+		{"proj", []string{"proj:", "projx:a"}, map[string]string{}},
+		{"", []string{"proj:a"}, nil},
+	} {
+		if actual := configAliases(tt.project, tt.keys); !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("%s %v: expected %v, got %v", tt.project, tt.keys, tt.expected, actual)
+		}
+	}
+}
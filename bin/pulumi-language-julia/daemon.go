@@ -0,0 +1,615 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+const (
+	// daemonScript is the julia code daemons run, serving the program runs
+	// requested on their stdin.
+	daemonScript = "using Pulumi; Pulumi.serve_runs()"
+
+	// reviseDaemonScript is daemonScript for daemons that have Revise.jl
+	// apply changes to the code they loaded before each run. Revise is loaded
+	// first, so that it tracks the packages loaded after it.
+	reviseDaemonScript = "using Revise; using Pulumi; Pulumi.serve_runs(revise=() -> Revise.revise(throw=true))"
+
+	// daemonMessagePrefix marks the protocol messages daemons write to their
+	// stdout, among the output of packages as they are loaded.
+	daemonMessagePrefix = "pulumi-julia-daemon: "
+
+	// daemonOutputDrain bounds how long the output of a run is waited for
+	// once it is over, as processes the program left running can hold the
+	// output connections open.
+	daemonOutputDrain = time.Second
+
+	// daemonStopTimeout is how long a daemon has to exit once asked to before
+	// it is killed.
+	daemonStopTimeout = 5 * time.Second
+)
+
+// daemonRequest asks a daemon to run a program, as julia would run it given
+// the program and its arguments after `--`.
+type daemonRequest struct {
+	Program string   `json:"program"`
+	Args    []string `json:"args"`
+	Dir     string   `json:"dir"`
+	// Env holds the PULUMI_* environment variables of the run, which are all
+	// that changes from one run to the next.
+	Env map[string]string `json:"env"`
+	// Output is the address the daemon sends the program's output to, over a
+	// connection for each of stdout and stderr, starting with Token.
+	Output string `json:"output"`
+	Token  string `json:"token"`
+}
+
+// daemonMessage is a message from a daemon: that it is ready to run
+// programs, the exit code of the program it ran, or that it needs restarting
+// to run it, as Revise couldn't apply the changes to the code it loaded.
+type daemonMessage struct {
+	Ready    bool `json:"ready"`
+	ExitCode int  `json:"exitCode"`
+	Restart  bool `json:"restart"`
+}
+
+// errReviseFailed is returned for programs that a daemon didn't run, as
+// Revise couldn't apply the changes to the code it loaded.
+var errReviseFailed = errors.New("Revise failed to apply code changes")
+
+// reviseFailures are the errors showing that a program ran into code that
+// Revise couldn't update in place, which a new daemon loads afresh.
+var reviseFailures = [][]byte{[]byte("invalid redefinition of constant"), []byte("cannot redefine struct")}
+
+// programExitError is the failure of a program run by a daemon, with the exit
+// code julia would have exited with.
+type programExitError struct {
+	code int
+}
+
+func (e *programExitError) Error() string { return fmt.Sprintf("exit status %d", e.code) }
+
+// ExitCode returns the exit code of the run, as exec.ExitError does.
+func (e *programExitError) ExitCode() int { return e.code }
+
+// daemonPool holds the daemon that programs run in with the daemon runtime
+// option: a warm julia process, with Pulumi.jl and the packages of the
+// program's environment loaded, that runs programs one after another, so that
+// the preview and the update of `pulumi up` pay for julia's startup once.
+type daemonPool struct {
+	mu     sync.Mutex
+	daemon *juliaDaemon
+	// busy is set while a program runs in a daemon, and retiring if the
+	// daemon is to be stopped once it is over.
+	busy, retiring bool
+	// failed identifies the daemon that last failed to start, which isn't
+	// started again until its command or files change.
+	failed string
+}
+
+// run runs cmd, which runs a program as `julia switches... -- program args...`,
+// in the daemon for its switches and environment, starting one unless it is
+// running already with the files it loaded unchanged, and reports whether it
+// did, with the timing of the run. Programs that can't run in a daemon,
+// because another program is running in it or it fails to start, are left to
+// run as processes of their own.
+//
+// With revise, the daemon loads Revise.jl, which applies changes to the code
+// it loaded before each run, so that it only has to be restarted when the
+// environment changes or Revise can't apply a change.
+func (p *daemonPool) run(
+	ctx context.Context, cmd *exec.Cmd, programDir string, revise bool, gracePeriod time.Duration,
+) (bool, processTiming, error) {
+	script := daemonScript
+	if revise {
+		script = reviseDaemonScript
+		// Revise tracks the sources, which are only read again by a new
+		// daemon otherwise.
+		programDir = ""
+	}
+	args, req, ok := daemonRequestFor(cmd, script)
+	if !ok {
+		return false, processTiming{}, nil
+	}
+	key := daemonKey(cmd.Path, args, cmd.Env)
+	fingerprint := daemonFingerprint(projectDirArg(args), programDir)
+
+	p.mu.Lock()
+	if p.busy || p.failed == key+fingerprint {
+		p.mu.Unlock()
+		logging.V(5).Infof("running the program in a process of its own, as the julia daemon is unavailable")
+		return false, processTiming{}, nil
+	}
+	d := p.daemon
+	p.daemon, p.busy = nil, true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.busy = false
+		retiring := p.retiring
+		p.retiring = false
+		switch {
+		case d == nil || d.hasExited():
+		case d.stale, retiring:
+			go d.close()
+		default:
+			p.daemon = d
+		}
+	}()
+
+	if d != nil && (d.key != key || d.fingerprint != fingerprint || d.hasExited()) {
+		logging.V(5).Infof("replacing the julia daemon, as the program or its environment changed")
+		go d.close()
+		d = nil
+	}
+	for restarted := false; ; restarted = true {
+		if d == nil {
+			logging.V(5).Infof("starting a julia daemon: %s %s", cmd.Path, strings.Join(args, " "))
+			started, err := startDaemon(ctx, cmd.Path, args, daemonEnv(cmd.Env), req.Dir)
+			if err != nil {
+				if ctx.Err() != nil {
+					return true, processTiming{ExitCode: -1}, ctx.Err()
+				}
+				if revise {
+					logging.Warningf("running the program in a process of its own, as the julia daemon "+
+						"failed to start; check that Revise.jl is installed: %v", err)
+				} else {
+					logging.V(5).Infof("running the program in a process of its own, "+
+						"as the julia daemon failed to start: %v", err)
+				}
+				p.mu.Lock()
+				p.failed = key + fingerprint
+				p.mu.Unlock()
+				return false, processTiming{}, nil
+			}
+			d = started
+			d.key, d.fingerprint, d.revise = key, fingerprint, revise
+		}
+		timing, err := d.run(ctx, req, cmd.Stdout, cmd.Stderr, gracePeriod)
+		if !errors.Is(err, errReviseFailed) || restarted {
+			return true, timing, err
+		}
+		// The program didn't run, so it runs in a new daemon instead.
+		logging.V(5).Infof("restarting the julia daemon, as Revise can't apply the changes to the code it loaded")
+		go d.close()
+		d = nil
+	}
+}
+
+// retire stops the daemon, or, while a program runs in it, has it stopped
+// once the program is over, so that no more programs run in it.
+func (p *daemonPool) retire() {
+	p.mu.Lock()
+	d := p.daemon
+	p.daemon = nil
+	p.retiring = p.busy
+	p.mu.Unlock()
+	if d != nil {
+		d.close()
+	}
+}
+
+// stop stops the daemon, as the host shuts down.
+func (p *daemonPool) stop() {
+	p.mu.Lock()
+	d := p.daemon
+	p.daemon = nil
+	p.mu.Unlock()
+	if d != nil {
+		d.close()
+	}
+}
+
+// daemonRequestFor splits cmd, which runs a program as `julia switches... --
+// program args...`, into the arguments starting a daemon running script with
+// the same switches and the request running the program in it. Package
+// programs, run as `julia switches... -e "using X; X.main()" -- args...`,
+// have no program file to send and run in a process of their own.
+func daemonRequestFor(cmd *exec.Cmd, script string) ([]string, daemonRequest, bool) {
+	i := slices.Index(cmd.Args, "--")
+	if i < 1 || i+1 >= len(cmd.Args) || slices.Contains(cmd.Args[1:i], "-e") {
+		return nil, daemonRequest{}, false
+	}
+	args := append(slices.Clone(cmd.Args[1:i]), "-e", script)
+
+	req := daemonRequest{Program: cmd.Args[i+1], Args: cmd.Args[i+2:], Dir: cmd.Dir, Env: map[string]string{}}
+	if req.Args == nil {
+		req.Args = []string{}
+	}
+	if req.Dir == "" {
+		req.Dir, _ = os.Getwd()
+	}
+	for _, v := range cmd.Env {
+		if name, value, ok := strings.Cut(v, "="); ok && strings.HasPrefix(name, "PULUMI_") {
+			req.Env[name] = value
+		}
+	}
+	return args, req, true
+}
+
+// daemonEnv returns the environment of a daemon running programs with env,
+// without the PULUMI_* variables, which are set for each run.
+func daemonEnv(env []string) []string {
+	return slices.DeleteFunc(slices.Clone(env), func(v string) bool {
+		return strings.HasPrefix(v, "PULUMI_")
+	})
+}
+
+// daemonKey identifies the daemon a program runs in: julia at path, run with
+// args and env, which all programs it runs share.
+func daemonKey(path string, args, env []string) string {
+	env = daemonEnv(env)
+	slices.Sort(env)
+	return strings.Join(append(append([]string{path}, args...), env...), "\x00")
+}
+
+// projectDirArg returns the directory of the environment that julia, run
+// with args, loads packages from.
+func projectDirArg(args []string) string {
+	for _, arg := range args {
+		if project, ok := strings.CutPrefix(arg, "--project="); ok {
+			if strings.HasSuffix(project, ".toml") {
+				return filepath.Dir(project)
+			}
+			return project
+		}
+	}
+	return ""
+}
+
+// daemonFingerprint summarizes the modification times and sizes of the files
+// that programs running in a daemon load: the Project.toml and Manifest.toml
+// of their environment in projectDir and the Julia sources under programDir,
+// if set.
+func daemonFingerprint(projectDir, programDir string) string {
+	var b strings.Builder
+	if projectDir != "" {
+		for _, name := range []string{"Project.toml", "Manifest.toml", "JuliaProject.toml", "JuliaManifest.toml"} {
+			writeFileFingerprint(&b, filepath.Join(projectDir, name))
+		}
+	}
+	if programDir == "" {
+		return b.String()
+	}
+	_ = filepath.WalkDir(programDir, func(path string, entry fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return nil
+		case entry.IsDir() && path != programDir && strings.HasPrefix(entry.Name(), "."):
+			return filepath.SkipDir
+		case !entry.IsDir() && strings.HasSuffix(entry.Name(), ".jl"):
+			writeFileFingerprint(&b, path)
+		}
+		return nil
+	})
+	return b.String()
+}
+
+// juliaDaemon is a julia process serving program runs.
+type juliaDaemon struct {
+	// key identifies the julia command and environment the daemon runs, and
+	// fingerprint the files it loaded, with which programs may run in it.
+	key, fingerprint string
+	// revise is set for daemons running Revise, and stale once a program ran
+	// into code Revise couldn't update.
+	revise, stale bool
+
+	cmd      *exec.Cmd
+	group    *processGroup
+	requests io.WriteCloser
+	messages chan daemonMessage
+	// listener accepts the output connections of runs.
+	listener *net.TCPListener
+
+	// exited is closed once the daemon exited, with err the error it exited
+	// with.
+	exited chan struct{}
+	err    error
+}
+
+// startDaemon starts julia at path as a daemon, with args, env and dir, and
+// waits for it to be ready to run programs.
+func startDaemon(ctx context.Context, path string, args, env []string, dir string) (*juliaDaemon, error) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for program output: %w", err)
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Env, cmd.Dir, cmd.Stderr = env, dir, daemonLog{}
+	requests, err := cmd.StdinPipe()
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	group := newProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := group.started(); err != nil {
+		logging.V(5).Infof("failed to set up the process group of the julia daemon: %v", err)
+	}
+
+	d := &juliaDaemon{
+		cmd:      cmd,
+		group:    group,
+		requests: requests,
+		messages: make(chan daemonMessage, 1),
+		listener: listener,
+		exited:   make(chan struct{}),
+	}
+	go d.readMessages(stdout)
+
+	select {
+	case msg := <-d.messages:
+		if !msg.Ready {
+			d.stop(0)
+			return nil, fmt.Errorf("unexpected message from the julia daemon: %+v", msg)
+		}
+		return d, nil
+	case <-d.exited:
+		return nil, fmt.Errorf("julia daemon exited before it was ready: %v", d.err)
+	case <-ctx.Done():
+		d.stop(0)
+		return nil, ctx.Err()
+	}
+}
+
+// readMessages reads the messages the daemon writes to stdout until it
+// exits, logging the rest of its output.
+func (d *juliaDaemon) readMessages(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		data, ok := strings.CutPrefix(line, daemonMessagePrefix)
+		var msg daemonMessage
+		if !ok || json.Unmarshal([]byte(data), &msg) != nil {
+			logging.V(5).Infof("julia daemon: %s", line)
+			continue
+		}
+		select {
+		case d.messages <- msg:
+		default:
+			logging.V(5).Infof("unexpected message from the julia daemon: %s", data)
+		}
+	}
+	// Output the scanner gave up on still has to be read for the daemon to
+	// make progress.
+	_, _ = io.Copy(io.Discard, stdout)
+
+	// The listener stays open for the run in progress to accept the output
+	// connections the daemon made before exiting; close closes it.
+	d.err = d.cmd.Wait()
+	d.group.release()
+	logging.V(5).Infof("julia daemon exited: %v", d.err)
+	close(d.exited)
+}
+
+// run runs the program of req, copying its output to stdout and stderr, and
+// returns its timing, as that of a process. A program that exits julia as it
+// runs takes the daemon with it.
+func (d *juliaDaemon) run(
+	ctx context.Context, req daemonRequest, stdout, stderr io.Writer, gracePeriod time.Duration,
+) (processTiming, error) {
+	timer := newProcessTimer()
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return timer.stop("Julia program", -1), err
+	}
+	req.Output, req.Token = d.listener.Addr().String(), hex.EncodeToString(token)
+	line, err := json.Marshal(req)
+	if err != nil {
+		return timer.stop("Julia program", -1), err
+	}
+	stdout, stderr = timer.wrap(stdout), timer.wrap(stderr)
+	var watcher *reviseWatcher
+	if d.revise {
+		watcher = &reviseWatcher{w: stderr}
+		stderr = watcher
+	}
+	output := d.acceptOutput(req.Token, map[string]io.Writer{"stdout": stdout, "stderr": stderr})
+	defer func() {
+		output.wait(daemonOutputDrain)
+		if d.hasExited() {
+			d.listener.Close()
+		}
+		if watcher != nil && watcher.failed() {
+			logging.V(5).Infof("retiring the julia daemon, as the program ran into code Revise couldn't update")
+			d.stale = true
+		}
+	}()
+
+	if _, err := d.requests.Write(append(line, '\n')); err != nil {
+		logging.V(5).Infof("failed to send the run to the julia daemon: %v", err)
+	}
+	select {
+	case msg := <-d.messages:
+		if msg.Restart {
+			return timer.stop("Julia program", -1), errReviseFailed
+		}
+		timing := timer.stop("Julia program", msg.ExitCode)
+		if msg.ExitCode != 0 {
+			return timing, &programExitError{code: msg.ExitCode}
+		}
+		return timing, nil
+	case <-d.exited:
+		return timer.stop("Julia program", -1), d.err
+	case <-ctx.Done():
+		d.stop(gracePeriod)
+		return timer.stop("Julia program", -1), ctx.Err()
+	}
+}
+
+// reviseWatcher passes the stderr of a run through to w, noting whether it
+// shows the program running into code Revise couldn't update.
+type reviseWatcher struct {
+	w io.Writer
+
+	mu    sync.Mutex
+	found bool
+}
+
+func (r *reviseWatcher) Write(data []byte) (int, error) {
+	r.mu.Lock()
+	for _, failure := range reviseFailures {
+		r.found = r.found || bytes.Contains(data, failure)
+	}
+	r.mu.Unlock()
+	return r.w.Write(data)
+}
+
+func (r *reviseWatcher) failed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.found
+}
+
+// daemonOutput is the output of a run, copied from the connections the
+// daemon makes for it.
+type daemonOutput struct {
+	listener *net.TCPListener
+	// accepting is closed once no more connections are accepted, and copied
+	// once all of the output was copied.
+	accepting, copied chan struct{}
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+// acceptOutput accepts the connections of the run identified by token, one
+// for each of sinks, copying what is sent over them to the sink they name.
+// Connections from anything else are closed.
+func (d *juliaDaemon) acceptOutput(token string, sinks map[string]io.Writer) *daemonOutput {
+	o := &daemonOutput{listener: d.listener, accepting: make(chan struct{}), copied: make(chan struct{})}
+	var copies sync.WaitGroup
+	go func() {
+		defer func() {
+			close(o.accepting)
+			copies.Wait()
+			close(o.copied)
+		}()
+		for len(sinks) > 0 {
+			conn, err := d.listener.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			_ = conn.SetReadDeadline(time.Now().Add(daemonStopTimeout))
+			header, err := r.ReadString('\n')
+			_ = conn.SetReadDeadline(time.Time{})
+			name, ok := strings.CutPrefix(strings.TrimSpace(header), token+" ")
+			sink, found := sinks[name]
+			if err != nil || !ok || !found {
+				conn.Close()
+				continue
+			}
+			delete(sinks, name)
+			o.mu.Lock()
+			o.conns = append(o.conns, conn)
+			o.mu.Unlock()
+
+			copies.Add(1)
+			go func() {
+				defer copies.Done()
+				defer conn.Close()
+				if _, err := io.Copy(sink, r); err != nil {
+					logging.V(5).Infof("failed to copy the %s of the program: %v", name, err)
+				}
+			}()
+		}
+	}()
+	return o
+}
+
+// wait waits for the output to be copied, for at most drain, and stops
+// accepting connections for it.
+func (o *daemonOutput) wait(drain time.Duration) {
+	timer := time.NewTimer(drain)
+	defer timer.Stop()
+	select {
+	case <-o.copied:
+		return
+	case <-timer.C:
+	}
+	_ = o.listener.SetDeadline(time.Now())
+	<-o.accepting
+	_ = o.listener.SetDeadline(time.Time{})
+	o.mu.Lock()
+	for _, conn := range o.conns {
+		conn.Close()
+	}
+	o.mu.Unlock()
+	<-o.copied
+}
+
+// hasExited reports whether the daemon exited.
+func (d *juliaDaemon) hasExited() bool {
+	select {
+	case <-d.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop stops the daemon, interrupting it first if gracePeriod allows, as a
+// run is cancelled, and then killing it.
+func (d *juliaDaemon) stop(gracePeriod time.Duration) {
+	if gracePeriod > 0 && d.group.interrupt() == nil {
+		select {
+		case <-d.exited:
+			return
+		case <-time.After(gracePeriod):
+		}
+	}
+	if err := d.group.kill(); err != nil {
+		logging.V(5).Infof("failed to kill the julia daemon: %v", err)
+	}
+	<-d.exited
+}
+
+// close asks the daemon to exit, by closing its stdin, and kills it if it
+// doesn't in time.
+func (d *juliaDaemon) close() {
+	d.requests.Close()
+	select {
+	case <-d.exited:
+	case <-time.After(daemonStopTimeout):
+		d.stop(0)
+	}
+	d.listener.Close()
+}
+
+// daemonLog logs the error output of daemons, which programs write elsewhere.
+type daemonLog struct{}
+
+func (daemonLog) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		logging.V(5).Infof("julia daemon: %s", line)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+// TestFakeJulia is not a test of its own but the julia the daemon tests run,
+// as a process of the test binary. It runs programs made of lines such as
+// `stderr boom` and `exit 1`, either as a process of their own or, when run
+// with a daemon script, as a daemon serving the runs it is sent.
+func TestFakeJulia(t *testing.T) {
+	if os.Getenv("FAKE_JULIA") == "" {
+		t.Skip("only runs as the julia of the daemon tests")
+	}
+	args := flag.Args()
+	if i := slices.Index(args, "-e"); i >= 0 && (args[i+1] == daemonScript || args[i+1] == reviseDaemonScript) {
+		if os.Getenv("FAKE_JULIA_DAEMON_FAILS") != "" {
+			fmt.Fprintln(os.Stderr, "ERROR: ArgumentError: Package Pulumi not found in current path.")
+			os.Exit(1)
+		}
+		fmt.Println("Precompiling Pulumi...")
+		fmt.Printf("%s{\"ready\": true}\n", daemonMessagePrefix)
+		requests := bufio.NewScanner(os.Stdin)
+		for served := 0; requests.Scan(); served++ {
+			var req daemonRequest
+			if err := json.Unmarshal(requests.Bytes(), &req); err != nil {
+				panic(err)
+			}
+			// Revise fails to apply changes to the code a daemon loaded for
+			// earlier runs while there is a revise-fails file.
+			_, err := os.Stat(filepath.Join(filepath.Dir(req.Program), "revise-fails"))
+			if args[i+1] == reviseDaemonScript && served > 0 && err == nil {
+				fmt.Printf("%s{\"restart\": true}\n", daemonMessagePrefix)
+				continue
+			}
+			stdout, stderr := dialOutput(req, "stdout"), dialOutput(req, "stderr")
+			code := runFakeProgram(req.Program, req.Env["PULUMI_DRY_RUN"], stdout, stderr)
+			stdout.Close()
+			stderr.Close()
+			fmt.Printf("%s{\"exitCode\": %d}\n", daemonMessagePrefix, code)
+		}
+		os.Exit(0)
+	}
+	program := args[slices.Index(args, "--")+1]
+	os.Exit(runFakeProgram(program, os.Getenv("PULUMI_DRY_RUN"), os.Stdout, os.Stderr))
+}
+
+func dialOutput(req daemonRequest, name string) net.Conn {
+	conn, err := net.Dial("tcp", req.Output)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(conn, "%s %s\n", req.Token, name)
+	return conn
+}
+
+// runFakeProgram runs the fake program at path, recording the process it ran
+// in and whether it was a preview in runs.log next to it.
+func runFakeProgram(path, dryRun string, stdout, stderr io.Writer) int {
+	log, err := os.OpenFile(filepath.Join(filepath.Dir(path), "runs.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(log, "%d %s\n", os.Getpid(), dryRun)
+	log.Close()
+	program, err := os.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	for _, line := range strings.Split(string(program), "\n") {
+		op, arg, _ := strings.Cut(line, " ")
+		switch op {
+		case "stdout":
+			fmt.Fprintln(stdout, arg)
+		case "stderr":
+			fmt.Fprintln(stderr, arg)
+		case "exit":
+			code, _ := strconv.Atoi(arg)
+			return code
+		case "die":
+			code, _ := strconv.Atoi(arg)
+			os.Exit(code)
+		}
+	}
+	return 0
+}
+
+// fakeDaemonJulia puts the fake julia of TestFakeJulia on PATH.
+func fakeDaemonJulia(t *testing.T, env string) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeJulia(t, fmt.Sprintf("FAKE_JULIA=1 %s exec '%s' -test.run='^TestFakeJulia$' -- \"$@\"", env, exe))
+}
+
+// runs returns the processes the fake programs in root ran in, in order.
+func runs(t *testing.T, root string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, "runs.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func runPid(run string) string {
+	pid, _, _ := strings.Cut(run, " ")
+	return pid
+}
+
+func TestRunInDaemon(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	writeFile(t, filepath.Join(root, "main.jl"), "stdout hello\nstderr warming up")
+	fakeDaemonJulia(t, "")
+	host := newTestHost()
+	t.Cleanup(host.daemons.stop)
+	run := func(dryRun bool) *pulumirpc.RunResponse {
+		t.Helper()
+		resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{
+			DryRun: dryRun,
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+				Options: mustStruct(t, map[string]interface{}{"daemon": true}),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		return resp
+	}
+
+	if resp := run(true); resp.GetError() != "" || resp.GetBail() {
+		t.Fatalf("expected the preview to succeed, got %+v", resp)
+	}
+	if resp := run(false); resp.GetError() != "" || resp.GetBail() {
+		t.Fatalf("expected the update to succeed, got %+v", resp)
+	}
+	got := runs(t, root)
+	if len(got) != 2 || runPid(got[0]) != runPid(got[1]) || runPid(got[0]) == strconv.Itoa(os.Getpid()) {
+		t.Fatalf("expected the preview and update to run in the same daemon, got runs %q", got)
+	}
+	if got[0] != runPid(got[0])+" true" || got[1] != runPid(got[1])+" false" {
+		t.Errorf("expected each run to get its own settings, got runs %q", got)
+	}
+
+	// Changing the program starts a new daemon.
+	writeFile(t, filepath.Join(root, "main.jl"), "stderr ERROR: bucket name is taken\nexit 1")
+	if resp := run(false); resp.GetError() != "ERROR: bucket name is taken" {
+		t.Errorf("expected the run to fail with its stderr, got %+v", resp)
+	}
+	writeFile(t, filepath.Join(root, "main.jl"), "stderr ERROR: reported already\nexit 32")
+	if resp := run(false); !resp.GetBail() || resp.GetError() != "" {
+		t.Errorf("expected the run to bail, got %+v", resp)
+	}
+	got = runs(t, root)
+	if runPid(got[2]) == runPid(got[1]) {
+		t.Errorf("expected a changed program to run in a new daemon, got runs %q", got)
+	}
+
+	// Changing the project starts a new daemon too.
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\nPulumi = \"00000000-0000-0000-0000-000000000000\"\n")
+	run(false)
+	got = runs(t, root)
+	if runPid(got[4]) == runPid(got[3]) {
+		t.Errorf("expected a changed project to run in a new daemon, got runs %q", got)
+	}
+
+	// A program exiting julia takes the daemon down with it.
+	writeFile(t, filepath.Join(root, "main.jl"), "stderr ERROR: exiting\ndie 3")
+	if resp := run(false); !strings.Contains(resp.GetError(), "exiting") {
+		t.Errorf("expected the run to fail with its stderr, got %+v", resp)
+	}
+	if host.daemons.daemon != nil {
+		t.Errorf("expected the daemon to have exited with the program")
+	}
+
+	writeFile(t, filepath.Join(root, "main.jl"), "stdout hello")
+	run(false)
+	d := host.daemons.daemon
+	host.daemons.stop()
+	if !d.hasExited() {
+		t.Errorf("expected stopping the pool to stop its daemon")
+	}
+}
+
+func TestRunFallsBackWhenDaemonFailsToStart(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "stdout hello")
+	fakeDaemonJulia(t, "FAKE_JULIA_DAEMON_FAILS=1")
+	host := newTestHost()
+	t.Cleanup(host.daemons.stop)
+	for i := 0; i < 2; i++ {
+		resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+				Options: mustStruct(t, map[string]interface{}{"daemon": true}),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		if resp.GetError() != "" {
+			t.Errorf("expected the run to fall back to a process of its own, got %q", resp.GetError())
+		}
+	}
+	if got := runs(t, root); len(got) != 2 || runPid(got[0]) == runPid(got[1]) {
+		t.Errorf("expected each run in a process of its own, got runs %q", got)
+	}
+}
+
+func TestRunPackageProgramOutsideDaemon(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"),
+		"name = \"Infra\"\nuuid = \"6e5a8f0c-1f3a-4c55-9a55-2b8d1a7c9e10\"\n")
+	writeFile(t, filepath.Join(root, "src", "Infra.jl"), "module Infra\nmain() = nothing\nend\n")
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeJulia(t, `echo "$*" >> "`+calls+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	host := newTestHost()
+	t.Cleanup(host.daemons.stop)
+
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{
+		Args: []string{"a", "b"},
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+			Options: mustStruct(t, map[string]interface{}{"daemon": true}),
+		},
+	})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 ||
+		!strings.HasSuffix(lines[0], " -e using Infra; Infra.main() -- a b") {
+		t.Errorf("expected the package program to run in a process of its own, got %q", data)
+	}
+	if host.daemons.daemon != nil {
+		t.Errorf("expected no daemon to be started")
+	}
+}
+
+func TestRunInReviseDaemon(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	writeFile(t, filepath.Join(root, "main.jl"), "stdout hello")
+	fakeDaemonJulia(t, "")
+	host := newTestHost()
+	t.Cleanup(host.daemons.stop)
+	run := func() *pulumirpc.RunResponse {
+		t.Helper()
+		resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+				Options: mustStruct(t, map[string]interface{}{"daemon": "revise"}),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		return resp
+	}
+
+	// Revise picks up changes to the program.
+	run()
+	writeFile(t, filepath.Join(root, "main.jl"), "stdout hello again")
+	run()
+	got := runs(t, root)
+	if len(got) != 2 || runPid(got[0]) != runPid(got[1]) {
+		t.Fatalf("expected a changed program to run in the same daemon, got runs %q", got)
+	}
+
+	// Changes Revise can't apply get the program run in a new daemon.
+	writeFile(t, filepath.Join(root, "revise-fails"), "")
+	if resp := run(); resp.GetError() != "" {
+		t.Errorf("expected the program to run in a new daemon, got %+v", resp)
+	}
+	os.Remove(filepath.Join(root, "revise-fails"))
+	got = runs(t, root)
+	if len(got) != 3 || runPid(got[2]) == runPid(got[1]) {
+		t.Fatalf("expected the program to run once, in a new daemon, got runs %q", got)
+	}
+
+	// So do programs that ran into code Revise couldn't update.
+	writeFile(t, filepath.Join(root, "main.jl"),
+		"stderr ERROR: LoadError: invalid redefinition of constant Main.Infra.Bucket\nexit 1")
+	if resp := run(); !strings.Contains(resp.GetError(), "invalid redefinition") {
+		t.Errorf("expected the run to fail, got %+v", resp)
+	}
+	writeFile(t, filepath.Join(root, "main.jl"), "stdout hello")
+	run()
+	got = runs(t, root)
+	if runPid(got[3]) != runPid(got[2]) || runPid(got[4]) == runPid(got[3]) {
+		t.Errorf("expected the run after the failure to start a new daemon, got runs %q", got)
+	}
+}
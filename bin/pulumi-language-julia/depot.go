@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// juliaDepots returns the package depots searched by Julia, honoring
+// JULIA_DEPOT_PATH. Empty entries in JULIA_DEPOT_PATH stand for the default
+// user depot.
+func juliaDepots() []string {
+	defaultDepot := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		defaultDepot = filepath.Join(home, ".julia")
+	}
+
+	value, ok := os.LookupEnv("JULIA_DEPOT_PATH")
+	if !ok || value == "" {
+		if defaultDepot == "" {
+			return nil
+		}
+		return []string{defaultDepot}
+	}
+
+	var depots []string
+	for _, depot := range filepath.SplitList(value) {
+		if depot == "" {
+			depot = defaultDepot
+		}
+		if depot != "" {
+			depots = append(depots, depot)
+		}
+	}
+	return depots
+}
+
+// namedEnvironmentDir returns the directory of the shared environment
+// `@name`: the first of depots holding it, or where Pkg creates it, in the
+// first depot.
+func namedEnvironmentDir(environment string, depots []string) string {
+	name := strings.TrimPrefix(environment, "@")
+	for _, depot := range depots {
+		dir := filepath.Join(depot, "environments", name)
+		if _, err := os.Stat(filepath.Join(dir, "Project.toml")); err == nil {
+			return dir
+		}
+	}
+	if len(depots) == 0 {
+		return ""
+	}
+	return filepath.Join(depots[0], "environments", name)
+}
+
+// slugChars is the alphabet Julia uses for package directory slugs.
+const slugChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// versionSlug computes the directory name Julia uses for a given version of a
+// package under `<depot>/packages/<name>/`, mirroring Base.version_slug.
+func versionSlug(uuid, treeHash string) (string, bool) {
+	uuidBytes, err := hex.DecodeString(strings.ReplaceAll(uuid, "-", ""))
+	if err != nil || len(uuidBytes) != 16 {
+		return "", false
+	}
+	treeBytes, err := hex.DecodeString(treeHash)
+	if err != nil || len(treeBytes) != 20 {
+		return "", false
+	}
+
+	// Julia hashes the UUID as a native (little-endian) UInt128.
+	var value [16]byte
+	binary.LittleEndian.PutUint64(value[:8], binary.BigEndian.Uint64(uuidBytes[8:]))
+	binary.LittleEndian.PutUint64(value[8:], binary.BigEndian.Uint64(uuidBytes[:8]))
+
+	table := crc32.MakeTable(crc32.Castagnoli)
+	crc := crc32.Update(0, table, value[:])
+	crc = crc32.Update(crc, table, treeBytes)
+
+	slug := make([]byte, 5)
+	for i := range slug {
+		slug[i] = slugChars[crc%uint32(len(slugChars))]
+		crc /= uint32(len(slugChars))
+	}
+	return string(slug), true
+}
+
+// packageDirectory locates the source directory of an installed package from
+// its manifest entry. Dev'd packages resolve relative to the manifest in
+// projectDir; registry packages are looked up in depots. It returns "" if the
+// package cannot be found.
+func packageDirectory(projectDir, name string, entry manifestEntry, depots []string) string {
+	if entry.Path != "" {
+		path := entry.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(projectDir, path)
+		}
+		return path
+	}
+
+	slug, ok := versionSlug(entry.UUID, entry.GitTreeSha1)
+	if !ok {
+		return ""
+	}
+	for _, depot := range depots {
+		dir := filepath.Join(depot, "packages", name, slug)
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+	return ""
+}
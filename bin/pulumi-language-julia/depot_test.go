@@ -0,0 +1,63 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVersionSlug(t *testing.T) {
+	// JSON.jl v0.21.4 is installed under ~/.julia/packages/JSON/93Ea8.
+	slug, ok := versionSlug("682c06a0-de6a-54ab-a142-c8b1cf79cde6", "31e996f0a15c7b280ba9f76636b3ff9e2ae58c9a")
+	if !ok || slug != "93Ea8" {
+		t.Fatalf("expected slug 93Ea8, got %q (ok=%v)", slug, ok)
+	}
+
+	if _, ok := versionSlug("not-a-uuid", "31e996f0a15c7b280ba9f76636b3ff9e2ae58c9a"); ok {
+		t.Errorf("expected an invalid UUID to be rejected")
+	}
+	if _, ok := versionSlug("682c06a0-de6a-54ab-a142-c8b1cf79cde6", ""); ok {
+		t.Errorf("expected a missing tree hash to be rejected")
+	}
+}
+
+func TestJuliaDepots(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	defaultDepot := filepath.Join(home, ".julia")
+
+	t.Setenv("JULIA_DEPOT_PATH", "")
+	if depots := juliaDepots(); !reflect.DeepEqual(depots, []string{defaultDepot}) {
+		t.Errorf("expected the default depot, got %v", depots)
+	}
+
+	custom := filepath.Join(home, "depot")
+	t.Setenv("JULIA_DEPOT_PATH", custom+string(filepath.ListSeparator))
+	if depots := juliaDepots(); !reflect.DeepEqual(depots, []string{custom, defaultDepot}) {
+		t.Errorf("expected the custom depot followed by the default, got %v", depots)
+	}
+}
+
+func TestPackageDirectory(t *testing.T) {
+	depot := t.TempDir()
+	t.Setenv("JULIA_DEPOT_PATH", depot)
+
+	entry := manifestEntry{
+		UUID:        "682c06a0-de6a-54ab-a142-c8b1cf79cde6",
+		GitTreeSha1: "31e996f0a15c7b280ba9f76636b3ff9e2ae58c9a",
+	}
+	if dir := packageDirectory("/project", "JSON", entry, juliaDepots()); dir != "" {
+		t.Errorf("expected an uninstalled package not to be found, got %s", dir)
+	}
+
+	installed := filepath.Join(depot, "packages", "JSON", "93Ea8")
+	writeFile(t, filepath.Join(installed, "Project.toml"), "name = \"JSON\"\n")
+	if dir := packageDirectory("/project", "JSON", entry, juliaDepots()); dir != installed {
+		t.Errorf("expected %s, got %s", installed, dir)
+	}
+
+	dev := manifestEntry{Path: "../dev/JSON"}
+	if dir := packageDirectory("/project", "JSON", dev, juliaDepots()); dir != filepath.Join("/dev", "JSON") {
+		t.Errorf("expected dev'd package to resolve relative to the project, got %s", dir)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+// defaultDepotLockTimeout is how long a process waits for the lock of a
+// depot by default: long enough for another stack to install and precompile
+// large dependencies.
+const defaultDepotLockTimeout = 30 * time.Minute
+
+// depotLockPollInterval is how often a process waiting for the lock of a
+// depot tries to take it again.
+var depotLockPollInterval = 500 * time.Millisecond
+
+// lockDepot takes the lock serializing the hosts installing and precompiling
+// packages into depot, so that stacks sharing a depot don't write to it at
+// once. It waits for the lock until ctx is done or, if timeout is positive,
+// for at most timeout, telling w what it is waiting for, and returns a
+// function releasing it.
+func lockDepot(ctx context.Context, depot string, w io.Writer, timeout time.Duration) (func(), error) {
+	path := filepath.Join(depot, "pulumi", "depot.lock")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to lock depot %s: %w", depot, err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock depot %s: %w", depot, err)
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	ticker := time.NewTicker(depotLockPollInterval)
+	defer ticker.Stop()
+	for waiting := false; ; waiting = true {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock depot %s: %w", depot, err)
+		}
+		if locked {
+			logging.V(5).Infof("locked depot %s", depot)
+			return func() {
+				if err := unlockFile(f); err != nil {
+					logging.V(5).Infof("failed to unlock depot %s: %v", depot, err)
+				}
+				f.Close()
+			}, nil
+		}
+		if !waiting {
+			logging.V(3).Infof("waiting for the lock of depot %s", depot)
+			fmt.Fprintf(w, "Waiting for another stack using the depot %s to finish\n", depot)
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-expired:
+			f.Close()
+			return nil, fmt.Errorf("gave up after %s waiting for another stack using the depot %s to finish; "+
+				"set the depotLockTimeout runtime option to wait longer", timeout, depot)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockDepot(t *testing.T) {
+	depot := t.TempDir()
+	unlock, err := lockDepot(context.Background(), depot, &bytes.Buffer{}, 0)
+	if err != nil {
+		t.Fatalf("lockDepot: %v", err)
+	}
+
+	var out bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := lockDepot(ctx, depot, &out, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a locked depot to be waited for, got %v", err)
+	}
+	if !strings.Contains(out.String(), "Waiting for another stack using the depot "+depot) {
+		t.Errorf("expected the wait to be reported, got %q", out.String())
+	}
+	_, err = lockDepot(context.Background(), depot, &bytes.Buffer{}, 200*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "gave up after 200ms") ||
+		!strings.Contains(err.Error(), "depotLockTimeout") {
+		t.Errorf("expected the wait to time out, got %v", err)
+	}
+
+	locked := make(chan func())
+	go func() {
+		unlock, err := lockDepot(context.Background(), depot, &bytes.Buffer{}, 0)
+		if err != nil {
+			t.Errorf("lockDepot: %v", err)
+		}
+		locked <- unlock
+	}()
+	time.Sleep(100 * time.Millisecond)
+	unlock()
+	select {
+	case unlock := <-locked:
+		if unlock != nil {
+			unlock()
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected the depot to be locked once released")
+	}
+}
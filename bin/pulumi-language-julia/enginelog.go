@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/rpcutil"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// maxLogLineBytes bounds the partial line an engineLogWriter buffers, so that
+// output without newlines is still forwarded, in pieces.
+const maxLogLineBytes = 64 << 10
+
+// dialEngine connects to the engine at address.
+func dialEngine(address string) (pulumirpc.EngineClient, io.Closer, error) {
+	target, err := normalizeAddress(address)
+	if err != nil {
+		return nil, nil, hostErrorf("invalid engine address %q: %w", address, err)
+	}
+	conn, err := grpc.NewClient(target,
+		grpc.WithTransportCredentials(insecure.NewCredentials()), rpcutil.GrpcChannelOptions())
+	if err != nil {
+		return nil, nil, hostErrorf("failed to connect to the engine at %s: %w", address, err)
+	}
+	return pulumirpc.NewEngineClient(conn), conn, nil
+}
+
+// logTiming sends the timing of the process name to the engine as an info
+// message, for the CLI to show.
+func (host *juliaLanguageHost) logTiming(ctx context.Context, name string, timing processTiming) {
+	engine, conn, err := dialEngine(host.engineAddress)
+	if err != nil {
+		logging.V(5).Infof("failed to report the timing of %s: %v", name, err)
+		return
+	}
+	defer conn.Close()
+	// The timing of a cancelled run is reported too.
+	_, err = engine.Log(context.WithoutCancel(ctx), &pulumirpc.LogRequest{
+		Severity: pulumirpc.LogSeverity_INFO,
+		Message:  fmt.Sprintf("%s: %s", name, timing),
+	})
+	if err != nil {
+		logging.V(5).Infof("failed to report the timing of %s: %v", name, err)
+	}
+}
+
+// engineLogWriter forwards a program's stderr to the engine, sending each
+// line as a log message, so that it is shown in order with the CLI's own
+// output and included in its JSON output. Lines it fails to send are written
+// to fallback instead.
+type engineLogWriter struct {
+	ctx      context.Context
+	engine   pulumirpc.EngineClient
+	fallback io.Writer
+
+	partial []byte
+	// block is the severity of the multiline log message being written, if
+	// inBlock is set.
+	block   pulumirpc.LogSeverity
+	inBlock bool
+	// failed is set once the program reports an uncaught exception.
+	failed bool
+}
+
+func (w *engineLogWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.log(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	if len(w.partial) >= maxLogLineBytes {
+		w.log(string(w.partial))
+		w.partial = nil
+	}
+	// Keep only the partial line, rather than the buffer of all output.
+	w.partial = bytes.Clone(w.partial)
+	return len(p), nil
+}
+
+// Flush forwards the last line of output, if it isn't terminated.
+func (w *engineLogWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.log(string(w.partial))
+		w.partial = nil
+	}
+}
+
+// log sends line to the engine with the severity it appears to have.
+func (w *engineLogWriter) log(line string) {
+	line = strings.TrimSuffix(line, "\r")
+	severity := w.lineSeverity(line)
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	_, err := w.engine.Log(w.ctx, &pulumirpc.LogRequest{Severity: severity, Message: validUTF8(line)})
+	if err != nil {
+		logging.V(5).Infof("failed to forward program output to the engine: %v", err)
+		fmt.Fprintln(w.fallback, line)
+	}
+}
+
+// lineSeverity guesses the severity of line from the prefix julia's logging
+// macros give messages, such as `[ Info:` or, for multiline messages,
+// `┌ Warning:`. The continuation lines of multiline messages keep their
+// severity, and everything from an uncaught exception on, which is its stack
+// trace, is an error.
+func (w *engineLogWriter) lineSeverity(line string) pulumirpc.LogSeverity {
+	line = ansiEscape.ReplaceAllString(line, "")
+	switch {
+	case w.failed:
+		return pulumirpc.LogSeverity_ERROR
+	case strings.HasPrefix(line, "ERROR:"):
+		w.failed = true
+		return pulumirpc.LogSeverity_ERROR
+	case w.inBlock && (strings.HasPrefix(line, "│") || strings.HasPrefix(line, "└")):
+		w.inBlock = !strings.HasPrefix(line, "└")
+		return w.block
+	}
+
+	w.block, w.inBlock = pulumirpc.LogSeverity_INFO, strings.HasPrefix(line, "┌ ")
+	level := strings.TrimPrefix(strings.TrimPrefix(line, "┌ "), "[ ")
+	switch {
+	case strings.HasPrefix(level, "Error:"):
+		w.block = pulumirpc.LogSeverity_ERROR
+	case strings.HasPrefix(level, "Warning:"):
+		w.block = pulumirpc.LogSeverity_WARNING
+	case strings.HasPrefix(level, "Debug:"):
+		w.block = pulumirpc.LogSeverity_DEBUG
+	}
+	return w.block
+}
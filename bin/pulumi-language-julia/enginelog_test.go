@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/grpc"
+	pbempty "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// recordingEngine records the log messages sent to it, as
+// "SEVERITY message", failing to send those containing "unsendable".
+type recordingEngine struct {
+	pulumirpc.UnimplementedEngineServer
+
+	mu   sync.Mutex
+	logs []string
+}
+
+func (e *recordingEngine) record(req *pulumirpc.LogRequest) error {
+	if strings.Contains(req.GetMessage(), "unsendable") {
+		return errors.New("connection refused")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.logs = append(e.logs, req.GetSeverity().String()+" "+req.GetMessage())
+	return nil
+}
+
+func (e *recordingEngine) Log(ctx context.Context, req *pulumirpc.LogRequest) (*pbempty.Empty, error) {
+	return &pbempty.Empty{}, e.record(req)
+}
+
+// recordingEngineClient calls a recordingEngine directly.
+type recordingEngineClient struct {
+	pulumirpc.EngineClient
+	engine *recordingEngine
+}
+
+func (c recordingEngineClient) Log(
+	ctx context.Context, req *pulumirpc.LogRequest, opts ...grpc.CallOption,
+) (*pbempty.Empty, error) {
+	return c.engine.Log(ctx, req)
+}
+
+func TestEngineLogWriter(t *testing.T) {
+	engine := &recordingEngine{}
+	var fallback bytes.Buffer
+	w := &engineLogWriter{ctx: context.Background(), engine: recordingEngineClient{engine: engine}, fallback: &fallback}
+
+	for _, chunk := range []string{
+		"plain output\r\n[ Info: creat", "ing bucket\n",
+		"┌ Warning: deprecated\n│   option = acl\n└ @ Main main.jl:3\n",
+		"\x1b[33m\x1b[1m[ \x1b[22m\x1b[39m\x1b[33m\x1b[1mWarning: \x1b[22m\x1b[39mcolored\n",
+		"[ Debug: details\n\n", "unsendable line\n",
+		"┌ Error: failed\n└ @ Main main.jl:5\n",
+		"ERROR: LoadError: caf\xe9\nStacktrace:\n [1] top-level scope\n", "no newline",
+	} {
+		if n, err := w.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("Write: %d, %v", n, err)
+		}
+	}
+	w.Flush()
+
+	expected := []string{
+		"INFO plain output",
+		"INFO [ Info: creating bucket",
+		"WARNING ┌ Warning: deprecated",
+		"WARNING │   option = acl",
+		"WARNING └ @ Main main.jl:3",
+		"WARNING \x1b[33m\x1b[1m[ \x1b[22m\x1b[39m\x1b[33m\x1b[1mWarning: \x1b[22m\x1b[39mcolored",
+		"DEBUG [ Debug: details",
+		"ERROR ┌ Error: failed",
+		"ERROR └ @ Main main.jl:5",
+		"ERROR ERROR: LoadError: caf\ufffd",
+		"ERROR Stacktrace:",
+		"ERROR  [1] top-level scope",
+		"ERROR no newline",
+	}
+	if !reflect.DeepEqual(engine.logs, expected) {
+		t.Errorf("expected logs\n%q\ngot\n%q", expected, engine.logs)
+	}
+	if fallback.String() != "unsendable line\n" {
+		t.Errorf("expected unsent lines to be written to stderr, got %q", fallback.String())
+	}
+}
+
+func TestEngineLogWriterLongLines(t *testing.T) {
+	engine := &recordingEngine{}
+	w := &engineLogWriter{ctx: context.Background(), engine: recordingEngineClient{engine: engine}}
+	for i := 0; i < 3; i++ {
+		fmt.Fprint(w, strings.Repeat("x", maxLogLineBytes/2))
+	}
+	if len(engine.logs) != 1 || len(engine.logs[0]) != len("INFO ")+maxLogLineBytes {
+		t.Errorf("expected a full line to be forwarded, got %d messages", len(engine.logs))
+	}
+	if len(w.partial) != maxLogLineBytes/2 {
+		t.Errorf("expected the rest to be buffered, got %d bytes", len(w.partial))
+	}
+}
+
+func TestRunLogsToEngine(t *testing.T) {
+	engine := &recordingEngine{}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	pulumirpc.RegisterEngineServer(srv, engine)
+	go srv.Serve(lis) //nolint:errcheck
+	t.Cleanup(srv.Stop)
+
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	fakeJulia(t, `printf '[ Info: starting\n┌ Warning: slow\n└ @ Main main.jl:2\n' >&2
+echo 'to stdout'
+printf 'last' >&2`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	host := newJuliaLanguageHost(lis.Addr().String(), "", root, defaultMaxSourceScanBytes)
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+			Options: mustStruct(t, map[string]interface{}{"logToEngine": true}),
+		},
+	})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	expected := []string{"INFO [ Info: starting", "WARNING ┌ Warning: slow", "WARNING └ @ Main main.jl:2", "INFO last"}
+	if !reflect.DeepEqual(engine.logs, expected) {
+		t.Errorf("expected logs %q, got %q", expected, engine.logs)
+	}
+}
+
+func TestRunLogsTimingsToEngine(t *testing.T) {
+	engine := &recordingEngine{}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	pulumirpc.RegisterEngineServer(srv, engine)
+	go srv.Serve(lis) //nolint:errcheck
+	t.Cleanup(srv.Stop)
+
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	fakeJulia(t, "echo 'to stdout'")
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	host := newJuliaLanguageHost(lis.Addr().String(), "", root, defaultMaxSourceScanBytes)
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+			Options: mustStruct(t, map[string]interface{}{"logTimings": true}),
+		},
+	})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v, %v", resp, err)
+	}
+	if len(engine.logs) != 1 || !strings.HasPrefix(engine.logs[0], "INFO Julia program: first output after ") ||
+		!strings.Contains(engine.logs[0], ", exited with code 0 after ") {
+		t.Errorf("expected the timing of the run to be logged, got %q", engine.logs)
+	}
+}
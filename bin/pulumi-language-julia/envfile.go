@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+// envNamePattern matches the environment variable names a .env file may set.
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envFileEnv returns the environment variables of the envFile runtime option,
+// relative to the project root. Nothing is loaded unless it is set, so that a
+// .env written for other tools, such as docker-compose, doesn't leak into
+// programs.
+func (host *juliaLanguageHost) envFileEnv(info *pulumirpc.ProgramInfo, opts runtimeOptions) ([]string, error) {
+	if opts.EnvFile == "" {
+		return nil, nil
+	}
+	path := resolveAgainst(orDefault(info.GetRootDirectory(), host.root), opts.EnvFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+	env, err := parseEnvFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid env file %s: %w", path, err)
+	}
+	return env, nil
+}
+
+// parseEnvFile parses the KEY=value lines of a .env file into environment
+// entries, in order. Blank lines, comments and `export` prefixes are
+// ignored. Values may be single-quoted, taken literally, or double-quoted,
+// with backslash escapes; unquoted values end at a ` #` comment. Nothing is
+// interpolated.
+func parseEnvFile(data []byte) ([]string, error) {
+	var env []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(strings.TrimSuffix(scanner.Text(), "\r"))
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !envNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("line %d: expected NAME=value", n)
+		}
+		if slices.Contains(reservedEnvNames, name) {
+			return nil, fmt.Errorf("line %d: %s is set by the language host and can't be overridden", n, name)
+		}
+		value, err := parseEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		env = append(env, name+"="+value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// parseEnvValue unquotes the value of a .env line.
+func parseEnvValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "'"):
+		end := strings.Index(value[1:], "'")
+		if end < 0 {
+			return "", errors.New("unterminated single-quoted value")
+		}
+		return value[1 : end+1], trailingComment(value[end+2:])
+	case strings.HasPrefix(value, `"`):
+		var b strings.Builder
+		for i := 1; i < len(value); i++ {
+			switch c := value[i]; {
+			case c == '"':
+				return b.String(), trailingComment(value[i+1:])
+			case c == '\\' && i+1 < len(value):
+				i++
+				switch value[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				case 'r':
+					b.WriteByte('\r')
+				default:
+					b.WriteByte(value[i])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", errors.New("unterminated double-quoted value")
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value), nil
+}
+
+// trailingComment checks that only a comment follows a quoted value.
+func trailingComment(rest string) error {
+	if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("unexpected %q after quoted value", rest)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	data := "\ufeff# credentials\r\n" +
+		"AWS_PROFILE=prod\r\n" +
+		"\r\n" +
+		"export REGION = us-west-2 # inline comment\n" +
+		"EMPTY=\n" +
+		"EMPTY_QUOTED=\"\"\n" +
+		"SINGLE='literal \\n $HOME # not a comment'\n" +
+		"DOUBLE=\"line\\none \\\"quoted\\\"\" # comment\n" +
+		"URL=https://example.com/#anchor\n" +
+		"EQUALS=a=b\n" +
+		"   # indented comment\n" +
+		"AWS_PROFILE=override"
+	env, err := parseEnvFile([]byte(data))
+	if err != nil {
+		t.Fatalf("parseEnvFile: %v", err)
+	}
+	expected := []string{
+		"AWS_PROFILE=prod",
+		"REGION=us-west-2",
+		"EMPTY=",
+		"EMPTY_QUOTED=",
+		`SINGLE=literal \n $HOME # not a comment`,
+		"DOUBLE=line\none \"quoted\"",
+		"URL=https://example.com/#anchor",
+		"EQUALS=a=b",
+		"AWS_PROFILE=override",
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("expected %q, got %q", expected, env)
+	}
+}
+
+func TestParseEnvFileErrors(t *testing.T) {
+	for data, message := range map[string]string{
+		"A=1\nJUST_A_NAME\n":    "line 2: expected NAME=value",
+		"1ABC=1":                "expected NAME=value",
+		"MY-VAR=1":              "expected NAME=value",
+		"A='open":               "unterminated single-quoted value",
+		"A=\"open\r\n":          "unterminated double-quoted value",
+		"A=\"quoted\" trailing": "unexpected",
+		"PULUMI_STACK=prod":     "set by the language host",
+	} {
+		if _, err := parseEnvFile([]byte(data)); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%q: expected an error containing %q, got %v", data, message, err)
+		}
+	}
+}
+
+func TestRunEnvFile(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `echo "$FROM_FILE:$INHERITED:$OPTION" > "`+out+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	t.Setenv("INHERITED", "environment")
+	unsetenv(t, "FROM_FILE")
+	unsetenv(t, "OPTION")
+
+	run := func(options map[string]interface{}) (string, string) {
+		if err := os.RemoveAll(out); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+				Options: mustStruct(t, options),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		data, _ := os.ReadFile(out)
+		return strings.TrimSpace(string(data)), resp.GetError()
+	}
+
+	// Without the envFile option, the project's .env is left alone.
+	writeFile(t, filepath.Join(root, ".env"), "FROM_FILE=file\nINHERITED=file\nOPTION=file\n")
+	if actual, errMsg := run(nil); actual != ":environment:" || errMsg != "" {
+		t.Errorf("expected no env file values, got %q, %q", actual, errMsg)
+	}
+
+	// The envFile has lower precedence than inherited variables and the env
+	// option.
+	actual, errMsg := run(map[string]interface{}{
+		"envFile": ".env", "env": map[string]interface{}{"OPTION": "option"},
+	})
+	if expected := "file:environment:option"; actual != expected || errMsg != "" {
+		t.Errorf("expected %q, got %q, %q", expected, actual, errMsg)
+	}
+
+	writeFile(t, filepath.Join(root, "config", "dev.env"), "FROM_FILE=dev\n")
+	if actual, errMsg := run(map[string]interface{}{"envFile": "config/dev.env"}); actual != "dev:environment:" ||
+		errMsg != "" {
+		t.Errorf("expected the envFile option to be loaded, got %q, %q", actual, errMsg)
+	}
+
+	if _, errMsg := run(map[string]interface{}{"envFile": "missing.env"}); !strings.Contains(errMsg, "missing.env") {
+		t.Errorf("expected a missing env file error, got %q", errMsg)
+	}
+	writeFile(t, filepath.Join(root, ".env"), "FROM_FILE=file\nCOMPOSE_PROFILES\n")
+	if _, errMsg := run(map[string]interface{}{"envFile": ".env"}); !strings.Contains(errMsg, "invalid env file") {
+		t.Errorf("expected an invalid env file error, got %q", errMsg)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Run fails in one of two ways. Errors of the program or of its project, such
+// as a missing entry point, a runtime option set wrong or a program exiting
+// with an error, are reported as the program's error in the RunResponse.
+// Errors of the language host or of the machine it runs on, such as a missing
+// julia or a failure to start it, fail the Run call itself with an internal
+// gRPC error, which the CLI reports as such.
+
+// hostError marks an error as one of the language host rather than of the
+// program it runs.
+type hostError struct {
+	err error
+}
+
+func (e *hostError) Error() string { return e.err.Error() }
+
+func (e *hostError) Unwrap() error { return e.err }
+
+// hostErrorf formats a hostError like fmt.Errorf.
+func hostErrorf(format string, args ...interface{}) error {
+	return &hostError{err: fmt.Errorf(format, args...)}
+}
+
+// isHostError reports whether err is, or wraps, a hostError.
+func isHostError(err error) bool {
+	var host *hostError
+	return errors.As(err, &host)
+}
+
+// runFailure returns the outcome of a Run failing with err: an internal gRPC
+// error for host errors, or else a RunResponse reporting err as the program's
+// error.
+func runFailure(err error) (*pulumirpc.RunResponse, error) {
+	if isHostError(err) {
+		return nil, status.Error(codes.Internal, validUTF8(err.Error()))
+	}
+	return &pulumirpc.RunResponse{Error: validUTF8(err.Error())}, nil
+}
+
+// stoppedError returns the gRPC error of a call whose work, described by what,
+// stopped because ctx ended: Canceled or DeadlineExceeded, as the context's
+// error, rather than the Unknown status of a failure.
+func stoppedError(ctx context.Context, what string) error {
+	return status.FromContextError(fmt.Errorf("%s stopped: %w", what, ctx.Err())).Err()
+}
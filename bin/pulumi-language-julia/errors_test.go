@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// internalError returns the message of err, failing the test unless a Run
+// returning resp and err failed with an internal gRPC error.
+func internalError(t *testing.T, resp *pulumirpc.RunResponse, err error) string {
+	t.Helper()
+	if status.Code(err) != codes.Internal || resp != nil {
+		t.Fatalf("expected an internal error, got %v, %v", resp, err)
+	}
+	return status.Convert(err).Message()
+}
+
+func TestRunFailure(t *testing.T) {
+	resp, err := runFailure(errors.New("main.jl not found"))
+	if err != nil || resp.GetError() != "main.jl not found" {
+		t.Errorf("expected the program's error, got %v, %v", resp, err)
+	}
+
+	wrapped := fmt.Errorf("starting julia: %w", hostErrorf("failed to create depot %s: %w", "/depot", errors.New("read-only")))
+	resp, err = runFailure(wrapped)
+	if msg := internalError(t, resp, err); msg != "starting julia: failed to create depot /depot: read-only" {
+		t.Errorf("expected the host error's message, got %q", msg)
+	}
+}
+
+func TestRunErrorChannels(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	fakeJulia(t, "echo 'ERROR: boom' >&2\nexit 1")
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	run := func(options map[string]interface{}, entryPoint string) (*pulumirpc.RunResponse, error) {
+		return newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: entryPoint,
+				Options: mustStruct(t, options),
+			},
+		})
+	}
+
+	// The program's errors are reported in the response.
+	for name, test := range map[string]struct {
+		options    map[string]interface{}
+		entryPoint string
+		message    string
+	}{
+		"program exit":        {nil, ".", "ERROR: boom"},
+		"missing entry point": {nil, "missing.jl", "missing.jl"},
+		"invalid option":      {map[string]interface{}{"threads": "many"}, ".", "invalid runtime option threads"},
+	} {
+		resp, err := run(test.options, test.entryPoint)
+		if err != nil || !strings.Contains(resp.GetError(), test.message) {
+			t.Errorf("%s: expected an error containing %q in the response, got %v, %v", name, test.message, resp, err)
+		}
+	}
+
+	// The host's errors fail the call.
+	resp, err := run(map[string]interface{}{"julia": "./missing/julia"}, ".")
+	if msg := internalError(t, resp, err); !strings.Contains(msg, "Julia is required") {
+		t.Errorf("expected a missing julia error, got %q", msg)
+	}
+}
@@ -0,0 +1,664 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// installDependenciesServer collects the output streamed by
+// InstallDependencies.
+type installDependenciesServer struct {
+	grpc.ServerStream
+	ctx            context.Context
+	stdout, stderr strings.Builder
+}
+
+func (s *installDependenciesServer) Send(resp *pulumirpc.InstallDependenciesResponse) error {
+	s.stdout.Write(resp.GetStdout())
+	s.stderr.Write(resp.GetStderr())
+	return nil
+}
+
+func (s *installDependenciesServer) Context() context.Context {
+	if s.ctx != nil {
+		return s.ctx
+	}
+	return context.Background()
+}
+
+func TestInstallDependenciesPrefersProgramInfo(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `pwd > "`+out+`"`)
+
+	host := newTestHost()
+	server := &installDependenciesServer{}
+	err := host.InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+		Directory: t.TempDir(),
+		Info:      &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	}, server)
+	if err != nil {
+		t.Fatalf("InstallDependencies: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != root {
+		t.Errorf("expected Pkg to run in %s, got %s", root, data)
+	}
+}
+
+func TestInstallDependenciesUsesProjectOption(t *testing.T) {
+	repo := t.TempDir()
+	writeFile(t, filepath.Join(repo, "env", "Project.toml"), "[deps]\n")
+	stack := filepath.Join(repo, "stacks", "dev")
+	writeFile(t, filepath.Join(stack, "main.jl"), "")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `pwd > "`+out+`"`)
+
+	host := newJuliaLanguageHost("127.0.0.1:0", "", repo, defaultMaxSourceScanBytes)
+	err := host.InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+		Directory: stack,
+		Info: &pulumirpc.ProgramInfo{
+			ProgramDirectory: stack,
+			EntryPoint:       ".",
+			Options:          mustStruct(t, map[string]interface{}{"project": "env"}),
+		},
+	}, &installDependenciesServer{})
+	if err != nil {
+		t.Fatalf("InstallDependencies: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != filepath.Join(repo, "env") {
+		t.Errorf("expected Pkg to run in the shared environment, got %s", data)
+	}
+}
+
+func TestInstallDependenciesInstallArgs(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	argv := filepath.Join(t.TempDir(), "argv")
+	fakeJulia(t, `printf '%s\n' "$@" >> "`+argv+`"`)
+
+	err := newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+			Options: mustStruct(t, map[string]interface{}{
+				"installArgs": []interface{}{"--pkgimages=no"},
+				"juliaArgs":   []interface{}{"--check-bounds=no"},
+			}),
+		},
+	}, &installDependenciesServer{})
+	if err != nil {
+		t.Fatalf("InstallDependencies: %v", err)
+	}
+
+	data, err := os.ReadFile(argv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "--startup-file=no\n--color=no\n--project=.\n--pkgimages=no\n-e\nusing Pkg; Pkg.instantiate()\n" +
+		"--startup-file=no\n--color=no\n--project=.\n--pkgimages=no\n-e\nusing Pkg; Pkg.precompile()\n"
+	if string(data) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+}
+
+func TestInstallDependenciesNamedEnvironment(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	argv := filepath.Join(t.TempDir(), "argv")
+	fakeJulia(t, `printf '%s\n' "$@" >> "`+argv+`"`)
+	t.Setenv("JULIA_DEPOT_PATH", t.TempDir())
+
+	err := newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+			Options: mustStruct(t, map[string]interface{}{"project": "@pulumi"}),
+		},
+	}, &installDependenciesServer{})
+	if err != nil {
+		t.Fatalf("InstallDependencies: %v", err)
+	}
+
+	data, err := os.ReadFile(argv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "--startup-file=no\n--color=no\n-e\n" +
+		`using Pkg; Pkg.activate("pulumi"; shared=true); Pkg.instantiate()` + "\n" +
+		"--startup-file=no\n--color=no\n-e\n" +
+		`using Pkg; Pkg.activate("pulumi"; shared=true); Pkg.precompile()` + "\n"
+	if string(data) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+}
+
+func TestInstallDependenciesCreatesProject(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "using Pulumi\n")
+	argv := filepath.Join(t.TempDir(), "argv")
+	fakeJulia(t, `printf '%s\n' "$@" >> "`+argv+`"
+echo '[deps]' > Project.toml`)
+
+	server := &installDependenciesServer{}
+	err := newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	}, server)
+	if err != nil {
+		t.Fatalf("InstallDependencies: %v", err)
+	}
+	data, err := os.ReadFile(argv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "--startup-file=no\n--color=no\n--project=.\n-e\n" +
+		`using Pkg; Pkg.activate("."); Pkg.add("Pulumi")` + "\n" +
+		"--startup-file=no\n--color=no\n--project=.\n-e\nusing Pkg; Pkg.precompile()\n"
+	if string(data) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+	if !strings.Contains(server.stdout.String(), "Created "+filepath.Join(root, "Project.toml")) {
+		t.Errorf("expected to be told that the project was created, got %q", server.stdout.String())
+	}
+
+	// Opting out leaves programs without a project alone.
+	os.Remove(argv)
+	os.Remove(filepath.Join(root, "Project.toml"))
+	err = newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+			Options: mustStruct(t, map[string]interface{}{"autoProject": false}),
+		},
+	}, &installDependenciesServer{})
+	if err != nil {
+		t.Fatalf("InstallDependencies: %v", err)
+	}
+	if _, err := os.Stat(argv); !os.IsNotExist(err) {
+		t.Errorf("expected julia not to run with autoProject false: %v", err)
+	}
+}
+
+func TestInstallDependenciesPrecompiles(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeJulia(t, `case "$*" in
+*instantiate*) echo "instantiate JULIA_PKG_PRECOMPILE_AUTO=$JULIA_PKG_PRECOMPILE_AUTO" >> "`+calls+`" ;;
+*precompile*)
+	echo precompile >> "`+calls+`"
+	echo 'ERROR: The following 1 direct dependency failed to precompile:' >&2
+	exit 1 ;;
+esac`)
+
+	server := &installDependenciesServer{}
+	err := newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	}, server)
+	if err != nil {
+		t.Fatalf("expected packages failing to precompile not to fail the install, got %v", err)
+	}
+	if data, _ := os.ReadFile(calls); string(data) != "instantiate JULIA_PKG_PRECOMPILE_AUTO=0\nprecompile\n" {
+		t.Errorf("expected instantiation without precompilation, then precompilation, got %q", data)
+	}
+	if !strings.Contains(server.stderr.String(), "failed to precompile:\n") ||
+		!strings.Contains(server.stderr.String(), "Warning: some packages failed to precompile") {
+		t.Errorf("expected Pkg's error to be streamed with a warning, got %q", server.stderr.String())
+	}
+
+	os.Remove(calls)
+	err = newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+			Options: mustStruct(t, map[string]interface{}{"installPrecompile": false}),
+		},
+	}, &installDependenciesServer{})
+	if err != nil {
+		t.Fatalf("InstallDependencies: %v", err)
+	}
+	if data, _ := os.ReadFile(calls); string(data) != "instantiate JULIA_PKG_PRECOMPILE_AUTO=\n" {
+		t.Errorf("expected installPrecompile false to leave precompilation to Pkg.instantiate, got %q", data)
+	}
+}
+
+func TestManifestOutOfDate(t *testing.T) {
+	for stderr, expected := range map[string]bool{
+		"ERROR: expected package `AWS [fbe9abb3]` to exist in the manifest (use `resolve` to populate the manifest)": true,
+		"ERROR: `AWS` is a direct dependency, but does not appear in the manifest. " +
+			"If you intend `AWS` to be a direct dependency, run `Pkg.resolve()` to populate the manifest.": true,
+		"\x1b[91mERROR:\x1b[0m expected manifest to match project":               true,
+		"ERROR: Unsatisfiable requirements detected for package AWS [fbe9abb3]:": false,
+		"ERROR: could not download https://pkg.julialang.org/registries":         false,
+	} {
+		if actual := manifestOutOfDate(stderr); actual != expected {
+			t.Errorf("manifestOutOfDate(%q): expected %v, got %v", stderr, expected, actual)
+		}
+	}
+}
+
+func TestInstallDependenciesResolvesOutdatedManifest(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	argv := filepath.Join(t.TempDir(), "argv")
+	fakeJulia(t, `echo "$*" >> "`+argv+`"
+case "$*" in
+*resolve*) ;;
+*instantiate*)
+	echo 'ERROR: expected package AWS [fbe9abb3] to exist in the manifest (use resolve to populate the manifest)' >&2
+	exit 1 ;;
+esac`)
+	install := func(options map[string]interface{}) (*installDependenciesServer, []string, error) {
+		t.Helper()
+		os.Remove(argv)
+		server := &installDependenciesServer{}
+		err := newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+				Options: mustStruct(t, options),
+			},
+		}, server)
+		data, _ := os.ReadFile(argv)
+		return server, strings.Split(strings.TrimSpace(string(data)), "\n"), err
+	}
+
+	server, calls, err := install(map[string]interface{}{"installPrecompile": false})
+	if err != nil {
+		t.Fatalf("expected the manifest to be resolved again, got %v", err)
+	}
+	if len(calls) != 2 || !strings.HasSuffix(calls[1], "using Pkg; Pkg.resolve(); Pkg.instantiate()") {
+		t.Errorf("expected instantiation to be retried after resolving, got %q", calls)
+	}
+	if !strings.Contains(server.stderr.String(), "The manifest doesn't match the project; updating it") {
+		t.Errorf("expected to be told that the manifest was resolved again, got %q", server.stderr.String())
+	}
+
+	_, calls, err = install(map[string]interface{}{"installPrecompile": false, "strictManifest": true})
+	if err == nil || !strings.Contains(err.Error(), "to exist in the manifest") ||
+		!strings.Contains(err.Error(), "julia --project -e 'using Pkg; Pkg.resolve()'") {
+		t.Errorf("expected a strict manifest to fail with advice, got %v", err)
+	}
+	if len(calls) != 1 {
+		t.Errorf("expected a strict manifest not to be resolved, got %q", calls)
+	}
+}
+
+func TestInstallDependenciesOffline(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	argv := filepath.Join(t.TempDir(), "argv")
+	fakeJulia(t, `case "$*" in
+*version*) echo "julia version 1.10.4" ;;
+*)
+	echo "JULIA_PKG_OFFLINE=$JULIA_PKG_OFFLINE $*" >> "`+argv+`"
+	echo 'ERROR: cannot download AWS [fbe9abb3] in offline mode' >&2
+	exit 1 ;;
+esac`)
+	unsetenv(t, "JULIA_PKG_OFFLINE")
+	info := &pulumirpc.ProgramInfo{
+		RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+		Options: mustStruct(t, map[string]interface{}{"offline": true}),
+	}
+
+	err := newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{Info: info},
+		&installDependenciesServer{})
+	if err == nil || !strings.HasPrefix(err.Error(), "Julia packages were installed offline, and the depot lacks") {
+		t.Errorf("expected the error to say that packages are missing offline, got %v", err)
+	}
+	data, _ := os.ReadFile(argv)
+	if !strings.HasPrefix(string(data), "JULIA_PKG_OFFLINE=true ") ||
+		!strings.Contains(string(data), "using Pkg; Pkg.offline(true); Pkg.instantiate()") {
+		t.Errorf("expected Pkg to install offline, got %q", data)
+	}
+	about, err := newTestHost().About(context.Background(), &pulumirpc.AboutRequest{Info: info})
+	if err != nil || about.GetMetadata()["offline"] != "true" {
+		t.Errorf("expected About to report offline mode, got %v, %v", about.GetMetadata(), err)
+	}
+
+	// An inherited JULIA_PKG_OFFLINE turns offline mode on too.
+	os.Remove(argv)
+	t.Setenv("JULIA_PKG_OFFLINE", "true")
+	info.Options = nil
+	err = newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{Info: info},
+		&installDependenciesServer{})
+	if data, _ := os.ReadFile(argv); !strings.Contains(string(data), "Pkg.offline(true)") {
+		t.Errorf("expected an inherited JULIA_PKG_OFFLINE to install offline, got %q, %v", data, err)
+	}
+	about, err = newTestHost().About(context.Background(), &pulumirpc.AboutRequest{Info: info})
+	if err != nil || about.GetMetadata()["offline"] != "true" {
+		t.Errorf("expected About to report offline mode, got %v, %v", about.GetMetadata(), err)
+	}
+}
+
+func TestInstallDependenciesRegistries(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	argv := filepath.Join(t.TempDir(), "argv")
+	fakeJulia(t, `echo "JULIA_PKG_SERVER=$JULIA_PKG_SERVER $*" >> "`+argv+`"`)
+	unsetenv(t, "JULIA_PKG_OFFLINE")
+	install := func(options map[string]interface{}) []string {
+		t.Helper()
+		os.Remove(argv)
+		err := newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+				Options: mustStruct(t, options),
+			},
+		}, &installDependenciesServer{})
+		if err != nil {
+			t.Fatalf("InstallDependencies: %v", err)
+		}
+		data, _ := os.ReadFile(argv)
+		return strings.Split(strings.TrimSpace(string(data)), "\nJULIA_PKG_SERVER=")
+	}
+
+	calls := install(map[string]interface{}{
+		"pkgServer":  "https://pkg.example.com",
+		"registries": []interface{}{"https://github.com/example/Registry.git", "https://github.com/JuliaRegistries/General"},
+	})
+	if len(calls) != 2 || !strings.HasPrefix(calls[0], "JULIA_PKG_SERVER=https://pkg.example.com ") ||
+		!strings.HasPrefix(calls[1], "https://pkg.example.com ") {
+		t.Errorf("expected every Pkg step to use the Pkg server, got %q", calls)
+	}
+	script := calls[0]
+	if !strings.Contains(script, `for url in ["https://github.com/example/Registry.git", `+
+		`"https://github.com/JuliaRegistries/General"]`) ||
+		!strings.Contains(script, "key(url) in known || Pkg.Registry.add(Pkg.RegistrySpec(url=url))") ||
+		strings.Index(script, "Pkg.Registry.add") > strings.Index(script, "Pkg.instantiate()") {
+		t.Errorf("expected the missing registries to be added before instantiating, got %q", script)
+	}
+	if strings.Contains(calls[1], "Pkg.Registry") {
+		t.Errorf("expected registries to be added once, got %q", calls[1])
+	}
+
+	// Offline, registries can't be added.
+	calls = install(map[string]interface{}{
+		"offline": true, "registries": []interface{}{"https://github.com/example/Registry.git"},
+	})
+	if strings.Contains(calls[0], "Pkg.Registry") {
+		t.Errorf("expected registries not to be added offline, got %q", calls[0])
+	}
+}
+
+func TestInstallDependenciesDevelopsSDK(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	sdk, provider, notPackage := t.TempDir(), t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(sdk, "Project.toml"), "name = \"Pulumi\"\nuuid = \"8c5fd2a4-4b2f-4c3b-9e6c-1b3f0a9d6e21\"\n")
+	writeFile(t, filepath.Join(sdk, "src", "Pulumi.jl"), "module Pulumi end\n")
+	writeFile(t, filepath.Join(provider, "Project.toml"), "name = \"PulumiAWS\"\nuuid = \"3d1e5b0c-7a5e-4f4e-8d2b-6a3f1c9e0b7d\"\n")
+	writeFile(t, filepath.Join(provider, "src", "PulumiAWS.jl"), "module PulumiAWS end\n")
+	writeFile(t, filepath.Join(notPackage, "main.jl"), "")
+	t.Setenv("PULUMI_JULIA_SDK_PATH", strings.Join([]string{sdk, notPackage, provider}, string(filepath.ListSeparator)))
+	argv := filepath.Join(t.TempDir(), "argv")
+	fakeJulia(t, `echo "$*" >> "`+argv+`"`)
+
+	server := &installDependenciesServer{}
+	err := newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+			Options: mustStruct(t, map[string]interface{}{"installPrecompile": false}),
+		},
+	}, server)
+	if err != nil {
+		t.Fatalf("InstallDependencies: %v", err)
+	}
+	data, _ := os.ReadFile(argv)
+	calls := strings.Split(strings.TrimSpace(string(data)), "\n")
+	expected := fmt.Sprintf("using Pkg; Pkg.develop([Pkg.PackageSpec(path=%s), Pkg.PackageSpec(path=%s)])",
+		juliaString(sdk), juliaString(provider))
+	if len(calls) != 2 || !strings.HasSuffix(calls[0], "Pkg.instantiate()") || !strings.HasSuffix(calls[1], expected) {
+		t.Errorf("expected the checkouts to be developed after instantiating, got %q", calls)
+	}
+	if !strings.Contains(server.stderr.String(), "Warning: ignoring "+notPackage+" in PULUMI_JULIA_SDK_PATH") {
+		t.Errorf("expected a warning about the directory that isn't a package, got %q", server.stderr.String())
+	}
+	if !strings.Contains(server.stdout.String(), "Developing Pulumi at "+sdk+", PulumiAWS at "+provider) {
+		t.Errorf("expected to be told what is developed, got %q", server.stdout.String())
+	}
+}
+
+func TestInstallDependenciesInstallsJuliaVersion(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n\n[compat]\njulia = \"~1.10\"\n")
+	argv := filepath.Join(t.TempDir(), "argv")
+	fakeJulia(t, `case "$*" in
+*--version*) case "$1" in +*) echo "julia version ${1#+}.5" ;; *) echo "julia version 1.11.1" ;; esac ;;
+*) echo "$*" >> "`+argv+`" ;;
+esac`)
+	unsetenv(t, "PULUMI_JULIA_EXE")
+	withoutJuliaup := os.Getenv("PATH")
+	bin := t.TempDir()
+	channels := filepath.Join(bin, "channels")
+	writeExecutable(t, filepath.Join(bin, "juliaup"), `case "$1" in
+status) echo " Default  Channel  Version"; cat "`+channels+`" 2>/dev/null || true ;;
+add) echo "Installing Julia $2"; echo "          $2  $2.5+0.x64.linux.gnu" >> "`+channels+`" ;;
+esac`)
+	fake, err := exec.LookPath("julia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(fake, filepath.Join(bin, "julia")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+withoutJuliaup)
+
+	install := func(useTools bool, options map[string]interface{}) (*installDependenciesServer, string, error) {
+		t.Helper()
+		os.Remove(argv)
+		server := &installDependenciesServer{}
+		err := newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+				Options: mustStruct(t, options),
+			},
+			UseLanguageVersionTools: useTools,
+		}, server)
+		data, _ := os.ReadFile(argv)
+		return server, string(data), err
+	}
+	noPrecompile := map[string]interface{}{"installPrecompile": false}
+
+	// Without language version tools, julia on the PATH fails the compat check.
+	_, args, err := install(false, noPrecompile)
+	if err == nil || !strings.HasPrefix(err.Error(), "project requires Julia ~1.10, found 1.11.1") || args != "" {
+		t.Errorf("expected julia on the PATH to be rejected, got %q, %v", args, err)
+	}
+	if _, err := os.Stat(channels); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be installed with juliaup: %v", err)
+	}
+
+	// The julia version the compat entry requires is installed and used.
+	server, args, err := install(true, noPrecompile)
+	if err != nil {
+		t.Fatalf("InstallDependencies: %v", err)
+	}
+	if !strings.HasPrefix(args, "+1.10 ") {
+		t.Errorf("expected the dependencies to be installed with julia 1.10, got %q", args)
+	}
+	if !strings.Contains(server.stdout.String(), `Installing Julia 1.10, as required by julia = "~1.10" in the [compat] `+
+		"section of Project.toml, with juliaup\nInstalling Julia 1.10\n") ||
+		!strings.Contains(server.stdout.String(), "set the juliaVersion runtime option to 1.10") {
+		t.Errorf("expected juliaup's output to be streamed, got %q", server.stdout.String())
+	}
+
+	// So is the channel of the juliaVersion runtime option.
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	_, args, err = install(true, map[string]interface{}{"juliaVersion": "1.9", "installPrecompile": false})
+	if err != nil || !strings.HasPrefix(args, "+1.9 ") {
+		t.Errorf("expected the dependencies to be installed with julia 1.9, got %q, %v", args, err)
+	}
+	if data, _ := os.ReadFile(channels); strings.Count(string(data), "\n") != 2 {
+		t.Errorf("expected each version to be installed once, got %q", data)
+	}
+
+	// A julia on the PATH satisfying the compat entry needs nothing installed.
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n\n[compat]\njulia = \"1.11\"\n")
+	if _, args, err = install(true, noPrecompile); err != nil || strings.HasPrefix(args, "+") {
+		t.Errorf("expected julia on the PATH to install, got %q, %v", args, err)
+	}
+
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n\n[compat]\njulia = \"~1.12\"\n")
+	t.Setenv("PATH", withoutJuliaup)
+	_, _, err = install(true, noPrecompile)
+	if err == nil || !strings.Contains(err.Error(), "Julia 1.12, as required by julia = \"~1.12\"") ||
+		!strings.Contains(err.Error(), "juliaup, which would install it, was not found") {
+		t.Errorf("expected an error naming the missing version, got %v", err)
+	}
+}
+
+func TestInstallDependenciesStreamsFailure(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	fakeJulia(t, "echo '   Resolving package versions...'\necho 'ERROR: Unsatisfiable requirements detected' >&2\nexit 1")
+
+	server := &installDependenciesServer{}
+	err := newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	}, server)
+	if err == nil || !strings.Contains(err.Error(), "Julia package installation failed") {
+		t.Errorf("expected the installation to fail, got %v", err)
+	}
+	if server.stdout.String() != "   Resolving package versions...\n" ||
+		server.stderr.String() != "ERROR: Unsatisfiable requirements detected\n" {
+		t.Errorf("expected the output up to the failure to be streamed, got %q and %q",
+			server.stdout.String(), server.stderr.String())
+	}
+}
+
+func TestInstallDependenciesQuotesStderr(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	fakeJulia(t, `i=0
+while [ $i -lt 100 ]; do echo "   Updating registry line $i" >&2; i=$((i+1)); done
+printf '\033[91mERROR:\033[0m Unsatisfiable requirements detected for package AWS [fbe9abb3]:\n' >&2
+echo ' AWS [fbe9abb3] log:' >&2
+echo ' ├─restricted to versions 2 by project, leaving only versions: [empty set]' >&2
+exit 1`)
+
+	err := newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	}, &installDependenciesServer{})
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Fatalf("expected the installation to fail with its exit status, got %v", err)
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		"Julia package installation failed: exit status 1\n",
+		"--project=. -e using Pkg; Pkg.instantiate()",
+		"\nin " + root + "\n",
+		"ERROR: Unsatisfiable requirements detected for package AWS [fbe9abb3]:\n",
+		"leaving only versions: [empty set]",
+		"registry line 99\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("expected the error to contain %q, got %q", want, msg)
+		}
+	}
+	if strings.Contains(msg, "registry line 1\n") || strings.Contains(msg, "\x1b[") {
+		t.Errorf("expected only the uncolored tail of stderr to be quoted, got %q", msg)
+	}
+}
+
+// failingInstallServer fails to send any output.
+type failingInstallServer struct {
+	installDependenciesServer
+}
+
+func (s *failingInstallServer) Send(*pulumirpc.InstallDependenciesResponse) error {
+	return errors.New("transport is closing")
+}
+
+func TestInstallDependenciesReportsSendErrors(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	fakeJulia(t, "echo '   Resolving package versions...'")
+
+	err := newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	}, &failingInstallServer{})
+	if err == nil || !strings.Contains(err.Error(), "transport is closing") {
+		t.Errorf("expected the send error to be reported, got %v", err)
+	}
+}
+
+func TestInstallDependenciesStopsOnCancellation(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	pids := filepath.Join(t.TempDir(), "pids")
+	// Pkg downloads and precompiles in processes of its own.
+	fakeJulia(t, `sleep 60 &
+echo $$ $! > "`+pids+`.tmp" && mv "`+pids+`.tmp" "`+pids+`"
+wait`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+				Options: mustStruct(t, map[string]interface{}{"cancelGracePeriod": "1s"}),
+			},
+		}, &installDependenciesServer{ctx: ctx})
+	}()
+	var data []byte
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		var err error
+		if data, err = os.ReadFile(pids); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("julia didn't start")
+		}
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if status.Code(err) != codes.Canceled {
+			t.Errorf("expected a cancelled status, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("InstallDependencies didn't return on cancellation")
+	}
+	for _, pid := range strings.Fields(string(data)) {
+		if !processGone(t, pid) {
+			t.Errorf("expected process %s to be stopped with the install", pid)
+		}
+	}
+}
+
+// processGone reports whether the process pid has exited, waiting a little
+// for it to; an exited process left for its new parent to reap counts.
+func processGone(t *testing.T, pid string) bool {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if err := exec.Command("kill", "-0", pid).Run(); err != nil {
+			return true
+		}
+		if stat, err := os.ReadFile("/proc/" + pid + "/stat"); err == nil && strings.Contains(string(stat), ") Z ") {
+			return true
+		}
+	}
+	return false
+}
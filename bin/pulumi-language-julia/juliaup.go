@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+// installJuliaVersion installs the julia version the project pins with
+// juliaup, if no julia matching it is installed, streaming juliaup's output
+// to stdout and stderr. The version is pinned by the juliaVersion runtime
+// option or, without it, by the julia entry of the `[compat]` section of the
+// program's Project.toml, which a julia on the PATH may satisfy. It returns
+// the juliaup channel the dependencies are to be installed with, if julia on
+// the PATH won't do.
+func (host *juliaLanguageHost) installJuliaVersion(
+	ctx context.Context, info *pulumirpc.ProgramInfo, opts runtimeOptions, prog juliaProgram,
+	stdout, stderr io.Writer, gracePeriod time.Duration,
+) (string, error) {
+	channel, source := opts.JuliaVersion, "the juliaVersion runtime option"
+	if channel == "" {
+		project, err := readJuliaProject(prog.ProjectDir)
+		if err != nil || project == nil || project.Compat["julia"] == "" {
+			return "", nil
+		}
+		compat := project.Compat["julia"]
+		spec, err := parseJuliaVersionSpec(compat)
+		if err != nil {
+			logging.V(5).Infof("ignoring the julia compat entry of %s: %v", prog.ProjectDir, err)
+			return "", nil
+		}
+		if julia, err := host.juliaCommand(info, opts); err == nil {
+			if version, err := host.juliaVersion(ctx, julia); err == nil && spec.contains(version) {
+				logging.V(5).Infof("julia %s satisfies the julia compat entry %q", version, compat)
+				return "", nil
+			}
+		}
+		lower := spec.lowerBound()
+		channel = fmt.Sprintf("%d.%d", lower.Major, lower.Minor)
+		source = fmt.Sprintf("julia = %q in the [compat] section of Project.toml", compat)
+	}
+
+	juliaup, err := exec.LookPath("juliaup")
+	if err != nil {
+		return "", fmt.Errorf("Julia %s, as required by %s, is not installed, and juliaup, which would install it, "+
+			"was not found; install juliaup from https://github.com/JuliaLang/juliaup, or Julia %s yourself",
+			channel, source, channel)
+	}
+	installed, err := juliaupHasChannel(juliaup, channel)
+	if err != nil {
+		return "", err
+	}
+	if !installed {
+		logging.V(5).Infof("installing julia %s, as required by %s, with juliaup", channel, source)
+		fmt.Fprintf(stdout, "Installing Julia %s, as required by %s, with juliaup\n", channel, source)
+		cmd := exec.CommandContext(ctx, juliaup, "add", channel)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		if _, err := runProcess("juliaup", cmd, gracePeriod); err != nil {
+			if ctx.Err() != nil {
+				return "", stoppedError(ctx, "Julia installation")
+			}
+			return "", fmt.Errorf("failed to install Julia %s, as required by %s, with `juliaup add %s`: %w",
+				channel, source, channel, err)
+		}
+	}
+	if opts.JuliaVersion == "" {
+		fmt.Fprintf(stdout, "Installing dependencies with Julia %s; set the juliaVersion runtime option to %s "+
+			"to run programs with it too\n", channel, channel)
+	}
+	return channel, nil
+}
@@ -5,16 +5,21 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	pbempty "google.golang.org/protobuf/types/known/emptypb"
 
@@ -32,13 +37,40 @@ type juliaLanguageHost struct {
 
 	engineAddress string
 	tracing       string
+	// root is the project root passed by the engine. Relative program paths
+	// are resolved against it when a request carries no better reference.
+	root string
+
+	// maxSourceScanBytes bounds the size of Julia source files scanned for
+	// provider imports during plugin detection; zero disables scanning.
+	maxSourceScanBytes int64
+
+	// pluginMetadata caches pulumi-plugin.json lookups across requests.
+	pluginMetadata *pluginMetadataCache
+	// pluginCache caches plugin detection results across requests.
+	pluginCache *pluginDetectionCache
+	// daemons holds the warm julia process programs run in with the daemon
+	// runtime option.
+	daemons *daemonPool
+	// outputMu serializes the lines that programs, several of which may run
+	// at once, write to the host's stdout and stderr.
+	outputMu sync.Mutex
+	// juliaVersions caches the versions of the julia commands checked against
+	// the [compat] of projects, by juliaVersionKey.
+	juliaVersions sync.Map
+	// juliaupChannels records the juliaup channels found to be installed, by
+	// juliaup executable and channel.
+	juliaupChannels sync.Map
 }
 
 func main() {
 	var tracing string
 	var root string
+	var maxSourceScanBytes int64
 	flag.StringVar(&tracing, "tracing", "", "Emit tracing to a Zipkin-compatible tracing endpoint")
 	flag.StringVar(&root, "root", "", "Project root path")
+	flag.Int64Var(&maxSourceScanBytes, "max-source-scan-bytes", defaultMaxSourceScanBytes,
+		"Skip Julia source files larger than this when detecting plugins (0 disables source scanning)")
 	flag.Parse()
 
 	args := flag.Args()
@@ -48,10 +80,13 @@ func main() {
 	}
 	engineAddress := args[0]
 
+	// Clean up after hosts that were killed before removing their files.
+	sweepConfigFiles(staleConfigFileAge)
+
 	// Fire up a gRPC server, letting the kernel choose a free port.
+	host := newJuliaLanguageHost(engineAddress, tracing, root, maxSourceScanBytes)
 	port, done, err := rpcutil.Serve(0, nil, []func(*grpc.Server) error{
 		func(srv *grpc.Server) error {
-			host := newJuliaLanguageHost(engineAddress, tracing)
 			pulumirpc.RegisterLanguageRuntimeServer(srv, host)
 			return nil
 		},
@@ -65,15 +100,22 @@ func main() {
 	fmt.Printf("%d\n", port)
 
 	// And finally wait for the server to stop serving.
-	if err := <-done; err != nil {
+	err = <-done
+	host.daemons.stop()
+	if err != nil {
 		cmdutil.Exit(fmt.Errorf("language host RPC server stopped with error: %w", err))
 	}
 }
 
-func newJuliaLanguageHost(engineAddress, tracing string) *juliaLanguageHost {
+func newJuliaLanguageHost(engineAddress, tracing, root string, maxSourceScanBytes int64) *juliaLanguageHost {
 	return &juliaLanguageHost{
-		engineAddress: engineAddress,
-		tracing:       tracing,
+		engineAddress:      engineAddress,
+		tracing:            tracing,
+		root:               root,
+		maxSourceScanBytes: maxSourceScanBytes,
+		pluginMetadata:     newPluginMetadataCache(),
+		pluginCache:        newPluginDetectionCache(),
+		daemons:            &daemonPool{},
 	}
 }
 
@@ -84,121 +126,578 @@ func (host *juliaLanguageHost) GetRequiredPlugins(
 ) (*pulumirpc.GetRequiredPluginsResponse, error) {
 	logging.V(5).Infof("GetRequiredPlugins: program=%s", req.GetProgram())
 
-	// For now, we don't analyze the Julia program to extract required plugins.
-	// Users should ensure required providers are installed.
-	// In the future, we could parse Project.toml or main.jl for provider references.
+	packages, err := host.requiredPackages(req.GetInfo(), req.GetProgram(), req.GetPwd())
+	if err != nil {
+		return nil, err
+	}
+
 	return &pulumirpc.GetRequiredPluginsResponse{
-		Plugins: []*pulumirpc.PluginDependency{},
+		Plugins: pluginDependencies(packages),
 	}, nil
 }
 
+// GetRequiredPackages computes the complete set of anticipated packages required by a program.
+func (host *juliaLanguageHost) GetRequiredPackages(
+	ctx context.Context,
+	req *pulumirpc.GetRequiredPackagesRequest,
+) (*pulumirpc.GetRequiredPackagesResponse, error) {
+	logging.V(5).Infof("GetRequiredPackages: program=%s", req.GetInfo().GetProgramDirectory())
+
+	packages, err := host.requiredPackages(req.GetInfo(), "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &pulumirpc.GetRequiredPackagesResponse{
+		Packages: packages,
+	}, nil
+}
+
+// requiredPackages detects the packages required by the program and merges
+// them with the plugins declared in the runtime options.
+func (host *juliaLanguageHost) requiredPackages(
+	info *pulumirpc.ProgramInfo, program, pwd string,
+) ([]*pulumirpc.PackageDependency, error) {
+	opts, err := parseRuntimeOptions(info.GetOptions())
+	if err != nil {
+		return nil, err
+	}
+	prog, err := host.resolveProgram(info, program, pwd, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.PluginDetection == pluginDetectionOff {
+		logging.V(5).Infof("GetRequiredPlugins: plugin detection is off")
+		return withExplicitPlugins(opts.Plugins, nil), nil
+	}
+	detector := &pluginDetector{
+		maxSourceBytes: host.maxSourceScanBytes,
+		entryPoint:     prog.EntryPoint,
+		metadata:       host.pluginMetadata,
+		depots:         host.depots(info, opts),
+		scanTimeout:    opts.PluginDetectionTimeout,
+	}
+	if opts.PluginDetection == pluginDetectionProjectOnly {
+		detector.maxSourceBytes = 0
+	}
+	packages, err := host.pluginCache.detect(detector, prog.ProjectDir)
+	if err != nil {
+		return nil, err
+	}
+	return withExplicitPlugins(opts.Plugins, packages), nil
+}
+
 // Run executes a Julia program and returns the result.
 func (host *juliaLanguageHost) Run(
 	ctx context.Context,
 	req *pulumirpc.RunRequest,
-) (*pulumirpc.RunResponse, error) {
-	logging.V(5).Infof("Run: program=%s, pwd=%s", req.GetProgram(), req.GetPwd())
+) (resp *pulumirpc.RunResponse, err error) {
+	logging.V(5).Infof("Run: program=%s, pwd=%s, programDirectory=%s, entryPoint=%s",
+		req.GetProgram(), req.GetPwd(), req.GetInfo().GetProgramDirectory(), req.GetInfo().GetEntryPoint())
 
 	config, err := host.constructConfig(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to construct config: %w", err)
+		return runFailure(hostErrorf("failed to construct config: %w", err))
 	}
 
 	configSecretKeys, err := host.constructConfigSecretKeys(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to construct config secret keys: %w", err)
+		return runFailure(hostErrorf("failed to construct config secret keys: %w", err))
 	}
 
-	// Determine the program to run
-	program := req.GetProgram()
-	if program == "" {
-		program = "."
+	configSecrets, err := host.constructConfigSecrets(req)
+	if err != nil {
+		return runFailure(hostErrorf("failed to construct config secrets: %w", err))
 	}
 
-	// Find the main.jl file
-	var mainFile string
-	if info, err := os.Stat(program); err == nil && info.IsDir() {
-		mainFile = filepath.Join(program, "main.jl")
-	} else if strings.HasSuffix(program, ".jl") {
-		mainFile = program
-	} else {
-		mainFile = filepath.Join(program, "main.jl")
+	opts, err := parseRuntimeOptions(req.GetInfo().GetOptions())
+	if err != nil {
+		return runFailure(err)
 	}
+	// However much output the error quotes, the response has to get through.
+	defer func() {
+		if resp != nil {
+			resp.Error = capError(resp.Error, opts.MaxErrorSize)
+		}
+	}()
 
-	// Check if main.jl exists
-	if _, err := os.Stat(mainFile); os.IsNotExist(err) {
-		return &pulumirpc.RunResponse{
-			Error: fmt.Sprintf("could not find Julia program: %s", mainFile),
-		}, nil
+	var configEnv []string
+	if len(config) > configFileThreshold {
+		// Large config would exceed the limits on the size of the
+		// environment, so it is passed in a file instead.
+		path, err := writeConfigFile(configFilePattern, config)
+		if err != nil {
+			return runFailure(&hostError{err: err})
+		}
+		defer os.Remove(path)
+		logging.V(5).Infof("passing %d bytes of config in %s", len(config), path)
+		configEnv = append(configEnv, fmt.Sprintf("PULUMI_CONFIG_FILE=%s", path))
+	} else {
+		configEnv = append(configEnv, fmt.Sprintf("PULUMI_CONFIG=%s", config))
+	}
+	configEnv = append(configEnv, fmt.Sprintf("PULUMI_CONFIG_SECRET_KEYS=%s", configSecretKeys))
+	if configSecrets != "" {
+		path, err := writeConfigFile(configSecretsFilePattern, configSecrets)
+		if err != nil {
+			return runFailure(&hostError{err: err})
+		}
+		defer os.Remove(path)
+		configEnv = append(configEnv, fmt.Sprintf("PULUMI_CONFIG_SECRETS_FILE=%s", path))
 	}
 
-	// Build the Julia command
-	args := []string{
-		"--project=.",
-		"-e",
-		fmt.Sprintf(`include("%s")`, filepath.Base(mainFile)),
+	// The timeout covers precompilation and typechecking too, which are part
+	// of the run.
+	timeout, timeoutOption := runTimeout(opts, req.GetDryRun())
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
-	cmd := exec.CommandContext(ctx, "julia", args...)
-	cmd.Dir = filepath.Dir(mainFile)
+	// Validate the environment before anything slow, such as typechecking.
+	env, err := host.programEnv(req, configEnv)
+	if err != nil {
+		return runFailure(err)
+	}
+	fileEnv, err := host.envFileEnv(req.GetInfo(), opts)
+	if err != nil {
+		return runFailure(err)
+	}
 
-	// Set up environment
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PULUMI_PROJECT=%s", req.GetProject()))
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PULUMI_STACK=%s", req.GetStack()))
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PULUMI_DRY_RUN=%t", req.GetDryRun()))
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PULUMI_PARALLEL=%d", req.GetParallel()))
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PULUMI_MONITOR=%s", req.GetMonitorAddress()))
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PULUMI_ENGINE=%s", host.engineAddress))
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PULUMI_CONFIG=%s", config))
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PULUMI_CONFIG_SECRET_KEYS=%s", configSecretKeys))
+	cmd, err := host.programCommand(ctx, req, opts)
+	if err != nil {
+		// Precompiling packages may take long enough to be timed out or
+		// cancelled before the program starts.
+		switch {
+		case timeout > 0 && errors.Is(err, context.DeadlineExceeded):
+			return &pulumirpc.RunResponse{Error: timeoutFailure(timeout, timeoutOption)}, nil
+		case errors.Is(err, context.Canceled):
+			logging.V(5).Infof("Julia program stopped on cancellation before it started")
+			return &pulumirpc.RunResponse{}, nil
+		}
+		return runFailure(err)
+	}
+	logging.V(5).Infof("running %s", strings.Join(cmd.Args, " "))
 
-	if req.GetOrganization() != "" {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("PULUMI_ORGANIZATION=%s", req.GetOrganization()))
+	// Set up environment. The env file only fills in variables that aren't
+	// inherited, which the env runtime option overrides in turn, but not the
+	// PULUMI_* variables that follow it. Those inherited from a parent Pulumi
+	// process are dropped, so that none of them is left stale.
+	cmd.Env = mergeEnv(fileEnv, withoutEnv(cmd.Env, reservedEnvNames), envList(opts.Env), env)
+	// Leave precompilation to InstallDependencies, whose output is streamed,
+	// rather than stalling the run.
+	if !opts.AutoPrecompile {
+		cmd.Env = mergeEnv(cmd.Env, []string{"JULIA_PKG_PRECOMPILE_AUTO=0"})
 	}
 
-	// Capture output
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = io.MultiWriter(os.Stdout, &stdout)
-	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	cmd.Stdin = programStdin(opts.InheritStdin)
 
-	// Run the program
-	if err := cmd.Run(); err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			// Return the error message from stderr if available
-			errMsg := strings.TrimSpace(stderr.String())
-			if errMsg == "" {
-				errMsg = fmt.Sprintf("Julia program exited with code %d", exitErr.ExitCode())
+	// Stream output, keeping only its tail to report if the program fails.
+	var errSink io.Writer = os.Stderr
+	if opts.LogToEngine {
+		engine, conn, err := dialEngine(host.engineAddress)
+		if err != nil {
+			return runFailure(err)
+		}
+		defer conn.Close()
+		// Logs still get through as the run is cancelled.
+		logWriter := &engineLogWriter{ctx: context.WithoutCancel(ctx), engine: engine, fallback: os.Stderr}
+		defer logWriter.Flush()
+		errSink = logWriter
+	}
+	// A program run against checkouts of the SDK says so, lest their changes
+	// be mistaken for those of a release.
+	devs, problems := devPackages()
+	for _, problem := range problems {
+		fmt.Fprintf(errSink, "warning: %s\n", problem)
+	}
+	if len(devs) > 0 {
+		fmt.Fprintf(errSink, "note: running against the checkouts of %s, from %s, rather than released packages\n",
+			devPackageList(devs), sdkPathEnvVar)
+	}
+	// Output is forwarded through queues, so that the program doesn't stall
+	// while the CLI falls behind reading it.
+	watcher := &precompileWatcher{w: errSink}
+	var stdout, stderr *ringBuffer
+	var stdoutLines, stderrLines *lineWriter
+	var stdoutQueue, stderrQueue *outputQueue
+	capture := func(cmd *exec.Cmd) {
+		stdout, stderr = newRingBuffer(opts.OutputBufferSize), newRingBuffer(opts.OutputBufferSize)
+		stdoutLines, stderrLines = newLineWriter(&host.outputMu, os.Stdout), newLineWriter(&host.outputMu, watcher)
+		stdoutQueue, stderrQueue = newOutputQueue(stdoutLines, outputQueueSize), newOutputQueue(stderrLines, outputQueueSize)
+		cmd.Stdout = io.MultiWriter(stdout, stdoutQueue)
+		cmd.Stderr = io.MultiWriter(stderr, stderrQueue)
+	}
+	// Programs that can't run in a daemon, such as compiled binaries and
+	// programs reading the terminal, run in a process of their own, as do
+	// package programs, which the daemon pool turns down.
+	useDaemon := opts.Daemon != daemonOff && opts.Binary == "" && !isTerminal(cmd.Stdin)
+	run := func(cmd *exec.Cmd) error {
+		ran, timing, err := false, processTiming{}, error(nil)
+		if useDaemon {
+			ran, timing, err = host.daemons.run(ctx, cmd, host.runProgramDirectory(req),
+				opts.Daemon == daemonRevise, opts.CancelGracePeriod)
+		}
+		if !ran {
+			timing, err = runProcess("Julia program", cmd, opts.CancelGracePeriod)
+		}
+		stdoutQueue.Close()
+		stderrQueue.Close()
+		stdoutLines.Flush()
+		stderrLines.Flush()
+		if dropped := stdoutQueue.Dropped() + stderrQueue.Dropped(); dropped > 0 {
+			fmt.Fprintf(errSink, "warning: dropped %d bytes of program output, as it was written faster than "+
+				"the Pulumi CLI read it\n", dropped)
+		}
+		if opts.LogTimings {
+			host.logTiming(ctx, "Julia program", timing)
+		}
+		return err
+	}
+	capture(cmd)
+
+	// Run the program, noting whether the out-of-memory killer strikes.
+	oomKills, oomKnown := oomKillCount()
+	err = run(cmd)
+	var exit interface{ ExitCode() int }
+	if errors.As(err, &exit) && ctx.Err() == nil && opts.RetryCorruptCache {
+		// Cache files left corrupted by an interrupted run fail every run
+		// until they are removed, so the program gets one more try without
+		// them.
+		if removed := removeCacheFiles(corruptCacheFiles(stderr.String())); len(removed) > 0 {
+			logging.V(5).Infof("removed corrupted cache files %s", strings.Join(removed, ", "))
+			fmt.Fprintf(errSink, "note: removed Julia's corrupted precompile cache files %s; running the program again\n",
+				strings.Join(removed, ", "))
+			// Packages loaded from them stay loaded in a daemon, so the
+			// program runs in a process of its own.
+			if useDaemon {
+				host.daemons.retire()
+				useDaemon = false
+			}
+			cmd = rerunCommand(ctx, cmd)
+			capture(cmd)
+			err = run(cmd)
+		}
+	}
+	if err != nil {
+		if timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return &pulumirpc.RunResponse{Error: timeoutFailure(timeout, timeoutOption)}, nil
+		}
+		// The engine accounts for cancellations itself, so a program stopped by
+		// one hasn't failed.
+		if errors.Is(ctx.Err(), context.Canceled) && (interruptedExit(err) || errors.Is(err, context.Canceled)) {
+			logging.V(5).Infof("Julia program stopped on cancellation: %v", err)
+			return &pulumirpc.RunResponse{}, nil
+		}
+		// Programs run in a daemon fail with the exit code of their run rather
+		// than of a process.
+		if errors.As(err, &exit) {
+			if exit.ExitCode() == bailExitCode {
+				logging.V(5).Infof("Julia program bailed out after reporting its error")
+				return &pulumirpc.RunResponse{Bail: true}, nil
+			}
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				if killedExit(exitErr) {
+					kills, ok := oomKillCount()
+					return &pulumirpc.RunResponse{Error: killedFailure(oomKnown && ok && kills > oomKills)}, nil
+				}
+				if crash, mayDumpCore := exitCrash(exitErr); crash != "" {
+					return &pulumirpc.RunResponse{Error: crashFailure(crash, mayDumpCore, cmd.Dir, stderr.String())}, nil
+				}
+			}
+			if startupFailed(stderr.String()) {
+				return &pulumirpc.RunResponse{Error: startupFailure(cmd.Args, stderr.String())}, nil
+			}
+			// The whole stack trace has been streamed already, so the error
+			// only repeats its gist.
+			errOutput, location := stderr.String(), ""
+			if juliaErr := parseJuliaError(errOutput, host.runProgramDirectory(req)); juliaErr != nil {
+				location = juliaErr.location
+				if !opts.VerboseErrors {
+					errOutput = juliaErr.summary()
+				}
 			}
 			return &pulumirpc.RunResponse{
-				Error: errMsg,
+				Error: programFailure(exit.ExitCode(), location, stdout.String(), errOutput),
 			}, nil
 		}
-		return nil, fmt.Errorf("failed to run Julia program: %w", err)
+		return runFailure(hostErrorf("failed to run Julia program: %w", err))
 	}
 
 	return &pulumirpc.RunResponse{}, nil
 }
 
-// constructConfig creates a JSON string of configuration values.
-func (host *juliaLanguageHost) constructConfig(req *pulumirpc.RunRequest) (string, error) {
-	configMap := make(map[string]string)
-	for k, v := range req.GetConfig() {
-		configMap[k] = v
+// runTimeout returns how long a program may run, or 0 for no limit, along
+// with the runtime option setting it.
+func runTimeout(opts runtimeOptions, dryRun bool) (time.Duration, string) {
+	switch {
+	case dryRun && opts.PreviewTimeout > 0:
+		return opts.PreviewTimeout, "previewTimeout"
+	case !dryRun && opts.UpdateTimeout > 0:
+		return opts.UpdateTimeout, "updateTimeout"
 	}
-	configJSON, err := json.Marshal(configMap)
+	return opts.Timeout, "timeout"
+}
+
+// timeoutFailure is the error of a run timed out after timeout, set by the
+// runtime option timeoutOption.
+func timeoutFailure(timeout time.Duration, timeoutOption string) string {
+	return fmt.Sprintf("Julia program timed out after %s; raise the %s runtime option "+
+		"in Pulumi.yaml to give it longer", timeout, timeoutOption)
+}
+
+// programCommand returns the command running the program of req: julia
+// running the program's entry point or, in binary mode, the compiled
+// program itself.
+func (host *juliaLanguageHost) programCommand(
+	ctx context.Context, req *pulumirpc.RunRequest, opts runtimeOptions,
+) (*exec.Cmd, error) {
+	if opts.Binary != "" {
+		return host.binaryCommand(ctx, req, opts)
+	}
+
+	// Determine the program to run
+	prog, err := host.resolveProgram(req.GetInfo(), req.GetProgram(), req.GetPwd(), opts)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	mainFile := prog.EntryPoint
+
+	// Check if the entry point exists
+	if _, err := os.Stat(mainFile); os.IsNotExist(err) {
+		pwd := req.GetPwd()
+		if pwd == "" {
+			pwd, _ = os.Getwd()
+		}
+		return nil, errors.New(missingProgramError(prog, pwd))
+	}
+
+	julia, err := host.juliaCommand(req.GetInfo(), opts)
+	if err != nil {
+		return nil, err
+	}
+	// The engine doesn't tell programs whether they run in a terminal, so their
+	// output is only colored on request.
+	julia = julia.withColor(juliaColor(false))
+
+	envDir := host.environmentDir(req.GetInfo(), opts, prog)
+	if err := host.checkJuliaCompat(ctx, julia, envDir); err != nil {
+		return nil, err
+	}
+	sysimage, err := host.sysimageSwitches(ctx, req.GetInfo(), opts, julia, envDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Precompile {
+		depots := host.depots(req.GetInfo(), opts)
+		err := precompileProject(ctx, julia, prog, envDir, depots, os.Stderr, opts.CancelGracePeriod,
+			opts.DepotLockTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := typecheck(ctx, julia, prog, opts); err != nil {
+		return nil, err
+	}
+
+	// Build the Julia command
+	switches := append(sysimage, host.heapSizeHintSwitches(ctx, julia, opts)...)
+	if opts.TraceCompile {
+		switches = append(switches, traceCompileSwitches(ctx, julia, host.sysimageCacheDir(req.GetInfo()), envDir)...)
+	}
+	args := juliaRunArgs(prog, append(switches, juliaRunSwitches(opts, req.GetDryRun())...), req.GetArgs())
+
+	cmd := julia.command(ctx, args...)
+	// Run the program in the engine's working directory, so that relative
+	// paths in the program behave as they do when it is run by hand.
+	cmd.Dir = prog.ProjectDir
+	if prog.Environment != "" {
+		cmd.Dir = filepath.Dir(prog.EntryPoint)
 	}
-	return string(configJSON), nil
+	if pwd := req.GetPwd(); pwd != "" {
+		cmd.Dir = realPath(pwd)
+	}
+
+	// Export the environment too, so that julia processes started by the
+	// program, such as Distributed workers, load the same packages.
+	cmd.Env = append(cmd.Env, "JULIA_PROJECT="+prog.project())
+	return cmd, nil
+}
+
+// programEnv returns the PULUMI_* environment variables through which the
+// program learns about its stack, the engine and, from configEnv, its
+// configuration. The monitor and engine addresses are normalized, so that
+// programs don't have to guess at their format.
+func (host *juliaLanguageHost) programEnv(req *pulumirpc.RunRequest, configEnv []string) ([]string, error) {
+	monitor, err := normalizeAddress(req.GetMonitorAddress())
+	if err != nil {
+		return nil, hostErrorf("invalid monitor address %q: %w", req.GetMonitorAddress(), err)
+	}
+	engine, err := normalizeAddress(host.engineAddress)
+	if err != nil {
+		return nil, hostErrorf("invalid engine address %q: %w", host.engineAddress, err)
+	}
+
+	env := []string{
+		fmt.Sprintf("PULUMI_PROJECT=%s", req.GetProject()),
+		fmt.Sprintf("PULUMI_STACK=%s", req.GetStack()),
+		fmt.Sprintf("PULUMI_DRY_RUN=%t", req.GetDryRun()),
+		fmt.Sprintf("PULUMI_QUERY_MODE=%t", req.GetQueryMode()),
+		fmt.Sprintf("PULUMI_PARALLEL=%d", effectiveParallel(req.GetParallel())),
+		fmt.Sprintf("PULUMI_MONITOR=%s", monitor),
+		fmt.Sprintf("PULUMI_ENGINE=%s", engine),
+	}
+	env = append(env, host.directoryEnv(req)...)
+	env = append(env, configEnv...)
+	if req.GetOrganization() != "" {
+		env = append(env, fmt.Sprintf("PULUMI_ORGANIZATION=%s", req.GetOrganization()))
+	}
+	return env, nil
+}
+
+// envList returns env as NAME=value entries, sorted by name.
+func envList(env map[string]string) []string {
+	list := make([]string, 0, len(env))
+	for name, value := range env {
+		list = append(list, name+"="+value)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// unboundedParallel is the engine's default for --parallel, meaning no limit
+// on the resource operations run at once.
+const unboundedParallel = math.MaxInt32
+
+// effectiveParallel returns the number of resource operations programs may
+// run at once. Engines send 0 to mean the default rather than no
+// parallelism, which programs would otherwise take literally.
+func effectiveParallel(parallel int32) int32 {
+	if parallel > 0 {
+		return parallel
+	}
+	logging.V(5).Infof("parallel %d from the engine means its default; using %d", parallel, unboundedParallel)
+	return unboundedParallel
+}
+
+// mergeEnv returns base with the entries of each of overrides set in turn.
+// An entry replaces any earlier one of the same name in place rather than
+// being appended, as which of two duplicates a program sees depends on its
+// libc.
+func mergeEnv(base []string, overrides ...[]string) []string {
+	env := make([]string, 0, len(base))
+	index := make(map[string]int, len(base))
+	set := func(entry string) {
+		name, _, _ := strings.Cut(entry, "=")
+		if i, ok := index[name]; ok {
+			env[i] = entry
+			return
+		}
+		index[name] = len(env)
+		env = append(env, entry)
+	}
+	for _, entry := range base {
+		set(entry)
+	}
+	for _, entries := range overrides {
+		for _, entry := range entries {
+			set(entry)
+		}
+	}
+	return env
+}
+
+// withoutEnv returns env without the entries of the given names.
+func withoutEnv(env []string, names []string) []string {
+	var kept []string
+	for _, entry := range env {
+		if name, _, _ := strings.Cut(entry, "="); !slices.Contains(names, name) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// directoryEnv returns the environment variables telling the program where
+// the project root and the program directory are, so that it can find files
+// relative to them wherever it runs. Both are absolute, with symlinks
+// resolved like the program itself.
+func (host *juliaLanguageHost) directoryEnv(req *pulumirpc.RunRequest) []string {
+	programDir := host.runProgramDirectory(req)
+	root := programDir
+	if dir := orDefault(req.GetInfo().GetRootDirectory(), host.root); dir != "" {
+		root = realPath(absPath(dir))
+	}
+	return []string{
+		"PULUMI_ROOT_DIRECTORY=" + root,
+		"PULUMI_PROGRAM_DIRECTORY=" + programDir,
+	}
+}
+
+// runProgramDirectory returns the absolute program directory of req, with
+// symlinks resolved.
+func (host *juliaLanguageHost) runProgramDirectory(req *pulumirpc.RunRequest) string {
+	programDir := req.GetInfo().GetProgramDirectory()
+	if programDir == "" {
+		programDir = resolveAgainst(orDefault(req.GetPwd(), host.root), programDirectory(req.GetProgram()))
+	}
+	return realPath(absPath(programDir))
+}
+
+// binaryCommand returns the command running the executable of the binary
+// runtime option, such as an app built with PackageCompiler, in place of
+// julia. It runs in the engine's working directory, or the project root.
+func (host *juliaLanguageHost) binaryCommand(
+	ctx context.Context, req *pulumirpc.RunRequest, opts runtimeOptions,
+) (*exec.Cmd, error) {
+	binary, err := host.binaryPath(req.GetInfo(), opts)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, binary)
+	cmd.Env = os.Environ()
+	cmd.Dir = orDefault(req.GetInfo().GetRootDirectory(), host.root)
+	if pwd := req.GetPwd(); pwd != "" {
+		cmd.Dir = realPath(pwd)
+	}
+	return cmd, nil
+}
+
+// constructConfig creates a JSON document of the configuration values that
+// aren't secret, along with the aliases of all the project's keys.
+func (host *juliaLanguageHost) constructConfig(req *pulumirpc.RunRequest) (string, error) {
+	plain, secrets := splitConfig(req)
+	keys := make([]string, 0, len(plain)+len(secrets))
+	for key := range plain {
+		keys = append(keys, key)
+	}
+	for key := range secrets {
+		keys = append(keys, key)
+	}
+	return marshalConfig(plain, configAliases(req.GetProject(), keys))
+}
+
+// constructConfigSecrets creates a JSON document of the secret configuration
+// values, which are kept out of the program's environment, or "" if there
+// are none.
+func (host *juliaLanguageHost) constructConfigSecrets(req *pulumirpc.RunRequest) (string, error) {
+	_, secrets := splitConfig(req)
+	if len(secrets) == 0 {
+		return "", nil
+	}
+	return marshalConfig(secrets, nil)
 }
 
 // constructConfigSecretKeys creates a JSON array of secret key names.
 func (host *juliaLanguageHost) constructConfigSecretKeys(req *pulumirpc.RunRequest) (string, error) {
-	secretKeys := req.GetConfigSecretKeys()
-	if secretKeys == nil {
-		secretKeys = []string{}
+	_, secrets := splitConfig(req)
+	secretKeys := []string{}
+	for key := range secrets {
+		secretKeys = append(secretKeys, key)
 	}
+	sort.Strings(secretKeys)
 	keysJSON, err := json.Marshal(secretKeys)
 	if err != nil {
 		return "", err
@@ -221,76 +720,265 @@ func (host *juliaLanguageHost) InstallDependencies(
 	req *pulumirpc.InstallDependenciesRequest,
 	server pulumirpc.LanguageRuntime_InstallDependenciesServer,
 ) error {
-	logging.V(5).Infof("InstallDependencies: directory=%s", req.GetDirectory())
+	logging.V(5).Infof("InstallDependencies: directory=%s, programDirectory=%s",
+		req.GetDirectory(), req.GetInfo().GetProgramDirectory())
 
-	directory := req.GetDirectory()
-	if directory == "" {
-		directory = "."
+	opts, err := parseRuntimeOptions(req.GetInfo().GetOptions())
+	if err != nil {
+		return err
 	}
 
-	// Check for Project.toml
-	projectToml := filepath.Join(directory, "Project.toml")
-	if _, err := os.Stat(projectToml); os.IsNotExist(err) {
-		// No Project.toml, nothing to install
-		return nil
+	// Install into the environment the program runs in, preferring the
+	// program info sent by newer engines over the legacy directory.
+	prog, err := host.resolveProgram(req.GetInfo(), req.GetDirectory(), "", opts)
+	if err != nil {
+		return err
 	}
+	directory := prog.ProjectDir
 
-	// Run Julia's Pkg.instantiate() to install dependencies
-	cmd := exec.Command("julia", "--project=.", "-e", "using Pkg; Pkg.instantiate()")
-	cmd.Dir = directory
+	// Stream output to the server
+	stream := newOutputStream(func(p []byte) error {
+		return server.Send(&pulumirpc.InstallDependenciesResponse{Stdout: p})
+	}, func(p []byte) error {
+		return server.Send(&pulumirpc.InstallDependenciesResponse{Stderr: p})
+	})
+	stdout, stderr := stream.stdout(), stream.stderr()
 
-	// Stream stdout
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("failed to get stdout pipe: %w", err)
+	// The engine asks for the julia version the project pins to be installed,
+	// as for `pulumi install` on a fresh machine.
+	if req.GetUseLanguageVersionTools() {
+		channel, err := host.installJuliaVersion(server.Context(), req.GetInfo(), opts, prog, stdout, stderr,
+			opts.CancelGracePeriod)
+		if err != nil {
+			return err
+		}
+		if err := stream.Err(); err != nil {
+			return fmt.Errorf("failed to stream the output of juliaup: %w", err)
+		}
+		opts.JuliaVersion = channel
 	}
-	stderr, err := cmd.StderrPipe()
+
+	julia, err := host.juliaCommand(req.GetInfo(), opts)
 	if err != nil {
-		return fmt.Errorf("failed to get stderr pipe: %w", err)
+		return err
 	}
-
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start Julia: %w", err)
+	// A julia the project doesn't support would only fail deep inside
+	// package resolution.
+	if err := host.checkJuliaCompat(server.Context(), julia, host.environmentDir(req.GetInfo(), opts, prog)); err != nil {
+		return err
 	}
+	julia = julia.withColor(juliaColor(req.GetIsTerminal()))
 
-	// Stream output to the server
-	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := stdout.Read(buf)
-			if n > 0 {
-				server.Send(&pulumirpc.InstallDependenciesResponse{
-					Stdout: buf[:n],
-				})
-			}
-			if err != nil {
-				break
+	// Run Julia's Pkg.instantiate() to install dependencies. pkgArgs returns
+	// the arguments running a Pkg script in the program's environment.
+	var pkgArgs func(script string) []string
+	script := "Pkg.instantiate()"
+	var created string
+	if prog.Environment != "" {
+		// Shared environments live in the depot and are activated by name.
+		name := strings.TrimPrefix(prog.Environment, "@")
+		directory = filepath.Dir(prog.EntryPoint)
+		pkgArgs = func(script string) []string {
+			return append(append([]string{}, opts.InstallArgs...), "-e",
+				fmt.Sprintf("using Pkg; Pkg.activate(%s; shared=true); %s", juliaString(name), script))
+		}
+	} else {
+		// Check for Project.toml
+		projectToml := filepath.Join(directory, "Project.toml")
+		if _, err := os.Stat(projectToml); os.IsNotExist(err) {
+			if !opts.AutoProject {
+				logging.V(5).Infof("no Project.toml in %s, nothing to install", directory)
+				return nil
 			}
+			// A bare template, or a program just written, gets an environment
+			// with the SDK, without which it can't run.
+			logging.V(5).Infof("no Project.toml in %s, creating one with the Pulumi package", directory)
+			script, created = `Pkg.activate("."); Pkg.add("Pulumi")`, projectToml
 		}
-	}()
+		pkgArgs = func(script string) []string {
+			return append(append([]string{"--project=."}, opts.InstallArgs...), "-e", "using Pkg; "+script)
+		}
+	}
 
-	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := stderr.Read(buf)
-			if n > 0 {
-				server.Send(&pulumirpc.InstallDependenciesResponse{
-					Stderr: buf[:n],
-				})
-			}
-			if err != nil {
-				break
-			}
+	// Registries mirroring packages internally are added to the depot first.
+	offline := pkgOffline(opts)
+	if len(opts.Registries) > 0 {
+		if offline {
+			logging.V(5).Infof("not adding registries %s offline", strings.Join(opts.Registries, ", "))
+		} else {
+			script = addRegistriesScript(opts.Registries) + script
 		}
-	}()
+	}
+	var pkgEnv []string
+	if opts.PkgServer != "" {
+		pkgEnv = append(pkgEnv, "JULIA_PKG_SERVER="+opts.PkgServer)
+	}
+	// Offline, Pkg neither updates registries nor downloads anything,
+	// installing from the depot only.
+	if offline {
+		logging.V(5).Infof("installing the dependencies of %s offline", directory)
+		pkgEnv = append(pkgEnv, "JULIA_PKG_OFFLINE=true")
+		script = "Pkg.offline(true); " + script
+	}
+	// Precompilation gets a step of its own.
+	installEnv := pkgEnv
+	if opts.InstallPrecompile {
+		installEnv = append(slices.Clip(pkgEnv), "JULIA_PKG_PRECOMPILE_AUTO=0")
+	}
 
-	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("Julia package installation failed: %w", err)
+	// runPkg runs a Pkg script in the program's environment as the step name,
+	// streaming its output and keeping the tail of its stderr, where Pkg
+	// reports what went wrong. Besides the command run and its error, it
+	// returns the error ending the install however the step went, as stop:
+	// a cancelled install is stopped, with the processes it started, rather
+	// than failed, and output that didn't get through explains a failure
+	// better than the exit code of a process that lost its output.
+	runPkg := func(name, script string, env []string) (cmd *exec.Cmd, errTail *ringBuffer, err, stop error) {
+		cmd = julia.command(server.Context(), pkgArgs(script)...)
+		cmd.Dir = directory
+		cmd.Env = mergeEnv(cmd.Env, env)
+		errTail = newRingBuffer(installStderrBufferSize)
+		cmd.Stdout = stdout
+		cmd.Stderr = io.MultiWriter(stderr, errTail)
+		timing, err := runProcess(name, cmd, opts.CancelGracePeriod)
+		if opts.LogTimings {
+			host.logTiming(server.Context(), name, timing)
+		}
+		if server.Context().Err() != nil {
+			return cmd, errTail, err, stoppedError(server.Context(), name)
+		}
+		if streamErr := stream.Err(); streamErr != nil {
+			return cmd, errTail, err, fmt.Errorf("failed to stream the output of %s: %w", name, streamErr)
+		}
+		return cmd, errTail, err, nil
+	}
+
+	// Stacks sharing a depot, be it the default one, install into it one at a
+	// time: Pkg races on registries and artifacts otherwise.
+	if depots := host.depots(req.GetInfo(), opts); len(depots) > 0 {
+		unlock, err := lockDepot(server.Context(), depots[0], stderr, opts.DepotLockTimeout)
+		if server.Context().Err() != nil {
+			return stoppedError(server.Context(), "Julia package installation")
+		}
+		if err != nil {
+			return fmt.Errorf("Julia package installation failed: %w", err)
+		}
+		defer unlock()
+	}
+
+	cmd, errTail, err, stop := runPkg("Julia package installation", script, installEnv)
+	if stop != nil {
+		return stop
+	}
+	// A manifest left behind by a change to the project, as when a teammate
+	// bumps a compat bound without committing the manifest Pkg resolved, is
+	// resolved again, unless it is meant to be kept as it is.
+	if err != nil && created == "" && manifestOutOfDate(errTail.String()) {
+		if opts.StrictManifest {
+			return fmt.Errorf("%w\n%s", installFailure(err, cmd.Args, cmd.Dir, errTail.String()), strictManifestAdvice)
+		}
+		logging.V(3).Infof("the manifest of %s doesn't match its project, resolving it again", directory)
+		fmt.Fprintf(stderr, "The manifest doesn't match the project; updating it with Pkg.resolve() and installing again. "+
+			"Commit the updated Manifest.toml, or set the strictManifest runtime option to fail instead\n")
+		resolve := "Pkg.resolve(); Pkg.instantiate()"
+		if offline {
+			resolve = "Pkg.offline(true); " + resolve
+		}
+		cmd, errTail, err, stop = runPkg("Julia package resolution", resolve, installEnv)
+		if stop != nil {
+			return stop
+		}
+	}
+	if err != nil {
+		if offline && missingOffline(errTail.String()) {
+			return fmt.Errorf("%s\n%w", offlineAdvice, installFailure(err, cmd.Args, cmd.Dir, errTail.String()))
+		}
+		return installFailure(err, cmd.Args, cmd.Dir, errTail.String())
+	}
+	if created != "" {
+		fmt.Fprintf(stdout, "Created %s, with the Pulumi package as a dependency, for the program to run in; "+
+			"set the autoProject runtime option to false not to create one\n", created)
+	}
+
+	// Contributors run programs against their checkouts of the SDK.
+	devs, problems := devPackages()
+	for _, problem := range problems {
+		logging.V(5).Infof("%s", problem)
+		fmt.Fprintf(stderr, "Warning: %s\n", problem)
+	}
+	if len(devs) > 0 {
+		specs := make([]string, len(devs))
+		for i, dev := range devs {
+			specs[i] = fmt.Sprintf("Pkg.PackageSpec(path=%s)", juliaString(dev.Path))
+		}
+		cmd, errTail, err, stop := runPkg("Julia package development",
+			fmt.Sprintf("Pkg.develop([%s])", strings.Join(specs, ", ")), installEnv)
+		if stop != nil {
+			return stop
+		}
+		if err != nil {
+			return installFailure(err, cmd.Args, cmd.Dir, errTail.String())
+		}
+		fmt.Fprintf(stdout, "Developing %s from %s\n", devPackageList(devs), sdkPathEnvVar)
+	}
+
+	// Precompile the packages where their progress is seen, rather than
+	// silently in the middle of the first run. Julia precompiles those that
+	// fail to when programs load them, so that doesn't fail the install.
+	if opts.InstallPrecompile {
+		_, _, err, stop := runPkg("Julia package precompilation", "Pkg.precompile()", pkgEnv)
+		if stop != nil {
+			return stop
+		}
+		if err != nil {
+			logging.V(5).Infof("precompiling the packages of %s failed: %v", directory, err)
+			fmt.Fprintf(stderr, "Warning: some packages failed to precompile (%v), see the output above; "+
+				"Julia will try again when the program loads them\n", err)
+		}
+	}
+
+	// Build the sysimage programs run with while the dependencies are fresh,
+	// rather than stalling a run.
+	if opts.Sysimage == autoSysimage {
+		err := buildAutoSysimage(server.Context(), julia, host.sysimageCacheDir(req.GetInfo()), prog,
+			host.environmentDir(req.GetInfo(), opts, prog), stdout, stderr, opts.CancelGracePeriod)
+		if server.Context().Err() != nil {
+			return stoppedError(server.Context(), "the sysimage build")
+		}
+		if streamErr := stream.Err(); streamErr != nil {
+			return fmt.Errorf("failed to stream the output of the sysimage build: %w", streamErr)
+		}
+		return err
 	}
 
 	return nil
 }
 
+// devPackageList describes the package checkouts devs for messages.
+func devPackageList(devs []devPackage) string {
+	list := make([]string, len(devs))
+	for i, dev := range devs {
+		list[i] = fmt.Sprintf("%s at %s", dev.Name, dev.Path)
+	}
+	return validUTF8(strings.Join(list, ", "))
+}
+
+// addRegistriesScript returns Pkg code adding the registries at urls to the
+// depot, skipping those it has already, whatever the form of their URL.
+func addRegistriesScript(urls []string) string {
+	quoted := make([]string, len(urls))
+	for i, url := range urls {
+		quoted[i] = juliaString(url)
+	}
+	return fmt.Sprintf(`let
+    key(url) = lowercase(rstrip(replace(url, r"\.git/?$" => ""), '/'))
+    known = Set(key(r.repo) for r in Pkg.Registry.reachable_registries() if r.repo !== nothing)
+    for url in [%s]
+        key(url) in known || Pkg.Registry.add(Pkg.RegistrySpec(url=url))
+    end
+end; `, strings.Join(quoted, ", "))
+}
+
 // RuntimeOptionsPrompts returns a list of additional prompts to ask during `pulumi new`.
 func (host *juliaLanguageHost) RuntimeOptionsPrompts(
 	ctx context.Context,
@@ -306,17 +994,54 @@ func (host *juliaLanguageHost) About(
 	ctx context.Context,
 	req *pulumirpc.AboutRequest,
 ) (*pulumirpc.AboutResponse, error) {
+	opts, err := parseRuntimeOptions(req.GetInfo().GetOptions())
+	if err != nil {
+		return nil, err
+	}
+	// Programs compiled into a binary run without julia.
+	if opts.Binary != "" {
+		binary, err := host.binaryPath(req.GetInfo(), opts)
+		if err != nil {
+			return nil, err
+		}
+		return &pulumirpc.AboutResponse{
+			Executable: validUTF8(binary),
+			Version:    "unknown",
+			Metadata:   map[string]string{"binary": validUTF8(binary)},
+		}, nil
+	}
+	julia, err := host.juliaCommand(req.GetInfo(), opts)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get Julia version
-	cmd := exec.Command("julia", "--version")
+	cmd := julia.command(ctx, "--version")
 	output, err := cmd.Output()
 	juliaVersion := "unknown"
 	if err == nil {
 		juliaVersion = strings.TrimSpace(string(output))
 	}
 
+	metadata := map[string]string{}
+	if julia.Channel != "" {
+		metadata["juliaupChannel"] = julia.Channel
+	}
+	if julia.Depot != "" {
+		metadata["depot"] = validUTF8(julia.Depot)
+	}
+	if depot := sharedDepot(); depot != "" {
+		metadata["sharedDepot"] = validUTF8(depot)
+	}
+	if pkgOffline(opts) {
+		metadata["offline"] = "true"
+	}
+
+	// Paths needn't be UTF-8.
 	return &pulumirpc.AboutResponse{
-		Executable: "julia",
-		Version:    juliaVersion,
+		Executable: validUTF8(julia.Path),
+		Version:    validUTF8(juliaVersion),
+		Metadata:   metadata,
 	}, nil
 }
 
@@ -327,10 +1052,21 @@ func (host *juliaLanguageHost) GetProgramDependencies(
 ) (*pulumirpc.GetProgramDependenciesResponse, error) {
 	logging.V(5).Infof("GetProgramDependencies: program=%s", req.GetProgram())
 
-	// For now, return empty. In the future, we could parse Project.toml
-	// to return Julia package dependencies.
+	opts, err := parseRuntimeOptions(req.GetInfo().GetOptions())
+	if err != nil {
+		return nil, err
+	}
+	prog, err := host.resolveProgram(req.GetInfo(), req.GetProgram(), req.GetPwd(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	dependencies, err := programDependencies(prog.ProjectDir, req.GetTransitiveDependencies())
+	if err != nil {
+		return nil, err
+	}
 	return &pulumirpc.GetProgramDependenciesResponse{
-		Dependencies: []*pulumirpc.DependencyInfo{},
+		Dependencies: dependencies,
 	}, nil
 }
 
@@ -341,72 +1077,56 @@ func (host *juliaLanguageHost) RunPlugin(
 ) error {
 	logging.V(5).Infof("RunPlugin: program=%s", req.GetProgram())
 
-	// Build command
-	args := []string{"--project=.", req.GetProgram()}
-	args = append(args, req.GetArgs()...)
-
-	cmd := exec.Command("julia", args...)
-	cmd.Dir = req.GetPwd()
-	cmd.Env = append(os.Environ(), req.GetEnv()...)
-
-	stdout, err := cmd.StdoutPipe()
+	opts, err := parseRuntimeOptions(req.GetInfo().GetOptions())
 	if err != nil {
 		return err
 	}
-	stderr, err := cmd.StderrPipe()
+	julia, err := host.juliaCommand(req.GetInfo(), opts)
 	if err != nil {
 		return err
 	}
+	julia = julia.withColor(juliaColor(false))
 
-	if err := cmd.Start(); err != nil {
+	sysimage, err := host.sysimageSwitches(server.Context(), req.GetInfo(), opts, julia, req.GetPwd())
+	if err != nil {
 		return err
 	}
 
-	// Stream output
-	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := stdout.Read(buf)
-			if n > 0 {
-				server.Send(&pulumirpc.RunPluginResponse{
-					Output: &pulumirpc.RunPluginResponse_Stdout{Stdout: buf[:n]},
-				})
-			}
-			if err != nil {
-				break
-			}
-		}
-	}()
+	// Build command
+	args := append([]string{"--project=."}, sysimage...)
+	args = append(args, req.GetProgram())
+	args = append(args, req.GetArgs()...)
 
-	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := stderr.Read(buf)
-			if n > 0 {
-				server.Send(&pulumirpc.RunPluginResponse{
-					Output: &pulumirpc.RunPluginResponse_Stderr{Stderr: buf[:n]},
-				})
-			}
-			if err != nil {
-				break
-			}
-		}
-	}()
+	cmd := julia.command(server.Context(), args...)
+	cmd.Dir = req.GetPwd()
+	cmd.Env = append(cmd.Env, req.GetEnv()...)
 
-	if err := cmd.Wait(); err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			server.Send(&pulumirpc.RunPluginResponse{
-				Output: &pulumirpc.RunPluginResponse_Exitcode{Exitcode: int32(exitErr.ExitCode())},
-			})
-			return nil
+	// Stream output
+	stream := newOutputStream(func(p []byte) error {
+		return server.Send(&pulumirpc.RunPluginResponse{Output: &pulumirpc.RunPluginResponse_Stdout{Stdout: p}})
+	}, func(p []byte) error {
+		return server.Send(&pulumirpc.RunPluginResponse{Output: &pulumirpc.RunPluginResponse_Stderr{Stderr: p}})
+	})
+	cmd.Stdout, cmd.Stderr = stream.stdout(), stream.stderr()
+
+	timing, err := runProcess("Julia plugin "+req.GetProgram(), cmd, opts.CancelGracePeriod)
+	if opts.LogTimings {
+		host.logTiming(server.Context(), "Julia plugin "+req.GetProgram(), timing)
+	}
+	if err := stream.Err(); err != nil {
+		return fmt.Errorf("failed to stream the output of plugin %s: %w", req.GetProgram(), err)
+	}
+	exitCode := 0
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return err
 		}
-		return err
+		exitCode = exitErr.ExitCode()
 	}
-
-	server.Send(&pulumirpc.RunPluginResponse{
-		Output: &pulumirpc.RunPluginResponse_Exitcode{Exitcode: 0},
+	return server.Send(&pulumirpc.RunPluginResponse{
+		Output: &pulumirpc.RunPluginResponse_Exitcode{Exitcode: int32(exitCode)},
 	})
-	return nil
 }
 
 // GenerateProgram generates a Julia program from PCL (Pulumi Configuration Language).
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup filesystems are mounted.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// oomKillCount returns how many processes the kernel's out-of-memory killer
+// has killed in the host's memory cgroup, which the programs it runs belong
+// to, and whether that is known. Both cgroup v2's memory.events and v1's
+// memory.oom_control count them.
+func oomKillCount() (int, bool) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		// Lines are hierarchy-ID:controllers:path, with no controllers for
+		// the v2 hierarchy.
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		var files []string
+		switch {
+		case fields[0] == "0" && fields[1] == "":
+			files = []string{
+				filepath.Join(cgroupRoot, fields[2], "memory.events"),
+				filepath.Join(cgroupRoot, "unified", fields[2], "memory.events"),
+			}
+		case strings.Contains(","+fields[1]+",", ",memory,"):
+			files = []string{filepath.Join(cgroupRoot, "memory", fields[2], "memory.oom_control")}
+		}
+		for _, file := range files {
+			if count, ok := readOOMKills(file); ok {
+				return count, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// readOOMKills reads the oom_kill count of a cgroup's memory.events or
+// memory.oom_control file.
+func readOOMKills(file string) (int, bool) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "oom_kill "); ok {
+			count, err := strconv.Atoi(strings.TrimSpace(value))
+			return count, err == nil
+		}
+	}
+	return 0, false
+}
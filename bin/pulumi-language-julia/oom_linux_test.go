@@ -0,0 +1,27 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReadOOMKills(t *testing.T) {
+	dir := t.TempDir()
+	v2 := filepath.Join(dir, "memory.events")
+	writeFile(t, v2, "low 0\nhigh 0\nmax 3\noom 2\noom_kill 2\noom_group_kill 0\n")
+	v1 := filepath.Join(dir, "memory.oom_control")
+	writeFile(t, v1, "oom_kill_disable 0\nunder_oom 0\noom_kill 5\n")
+	old := filepath.Join(dir, "old.oom_control")
+	writeFile(t, old, "oom_kill_disable 0\nunder_oom 0\n")
+
+	for file, expected := range map[string]int{v2: 2, v1: 5} {
+		if count, ok := readOOMKills(file); !ok || count != expected {
+			t.Errorf("%s: expected %d kills, got %d, %t", filepath.Base(file), expected, count, ok)
+		}
+	}
+	for _, file := range []string{old, filepath.Join(dir, "missing")} {
+		if _, ok := readOOMKills(file); ok {
+			t.Errorf("%s: expected the count to be unknown", filepath.Base(file))
+		}
+	}
+}
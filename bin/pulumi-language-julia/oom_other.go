@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// oomKillCount returns how many processes the out-of-memory killer has
+// killed, which is only known on Linux.
+func oomKillCount() (int, bool) {
+	return 0, false
+}
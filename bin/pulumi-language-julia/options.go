@@ -0,0 +1,654 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// runtimeOptions are the options set in the `runtime.options` block of the
+// project's Pulumi.yaml:
+//
+//	runtime:
+//	  name: julia
+//	  options:
+//	    entrypoint: infra/stacks/prod.jl
+//	    project: .
+//	    julia: /opt/julia-1.10/bin/julia
+//	    juliaVersion: "1.10"
+//	    threads: auto
+//	    heapSizeHint: 1G
+//	    coverage: user
+//	    optimizeStartup: true
+//	    sysimage: build/sys.so
+//	    depot: .julia-depot
+//	    loadPath: [vendor]
+//	    binary: build/bin/infra
+//	    typechecker: jet
+//	    typecheckerLevel: warn
+//	    startupFile: true
+//	    autoPrecompile: true
+//	    autoProject: false
+//	    precompile: true
+//	    traceCompile: true
+//	    juliaArgs: ["--check-bounds=no"]
+//	    installArgs: ["--pkgimages=no"]
+//	    installPrecompile: false
+//	    strictManifest: true
+//	    offline: true
+//	    pkgServer: https://pkg.example.com
+//	    registries: ["https://github.com/example/Registry.git"]
+//	    env:
+//	      AWS_PROFILE: prod
+//	    envFile: .env
+//	    cancelGracePeriod: 30s
+//	    depotLockTimeout: 1h
+//	    timeout: 30m
+//	    previewTimeout: 5m
+//	    outputBufferSize: 1M
+//	    maxErrorSize: 64K
+//	    verboseErrors: true
+//	    logToEngine: true
+//	    logTimings: true
+//	    retryCorruptCache: false
+//	    inheritStdin: true
+//	    daemon: true
+//	    pluginDetection: project-only
+//	    pluginDetectionTimeout: 5s
+//	    plugins:
+//	      - name: aws
+//	        version: 6.40.0
+type runtimeOptions struct {
+	// EntryPoint is the Julia file to run, relative to the project root. It
+	// overrides Pulumi.yaml's `main`.
+	EntryPoint string
+	// Project is the directory of the Julia environment to run the program
+	// in, relative to the project root. By default the nearest Project.toml
+	// above the program is used.
+	Project string
+	// Julia is the julia executable, as a path or a name looked up on the
+	// PATH. The PULUMI_JULIA_EXE environment variable takes precedence.
+	Julia string
+	// JuliaVersion is the juliaup channel to run julia from, e.g. "1.10".
+	JuliaVersion string
+	// Threads is the number of threads programs run with: a positive number
+	// or "auto".
+	Threads string
+	// HeapSizeHint is the memory size, such as "1G", above which programs
+	// collect garbage more aggressively.
+	HeapSizeHint string
+	// Coverage selects the code programs collect coverage data for: "none",
+	// "user", "all" or "@path" for the code under path.
+	Coverage string
+	// Sysimage is a custom system image, relative to the project root, that
+	// programs and plugins run with, or "auto" for one of the project's
+	// dependencies built as they are installed.
+	Sysimage string
+	// SysimageRequired makes a missing Sysimage an error rather than falling
+	// back to the default system image.
+	SysimageRequired bool
+	// OptimizeStartup trades run time for startup time by minimizing
+	// compilation, for previews or for every run.
+	OptimizeStartup optimizeStartup
+	// Binary is an executable, relative to the project root, that programs
+	// run as instead of running their entry point with julia.
+	Binary string
+	// Depot is a package depot, relative to the project root, that is
+	// prepended to JULIA_DEPOT_PATH.
+	Depot string
+	// Typechecker is the static analyzer programs are checked with before
+	// they run. "jet" is the only one supported.
+	Typechecker string
+	// TypecheckerWarnOnly reports the problems found by the typechecker
+	// without failing the run.
+	TypecheckerWarnOnly bool
+	// LoadPath are directories, relative to the project root, prepended to
+	// JULIA_LOAD_PATH, e.g. to load vendored packages.
+	LoadPath []string
+	// StartupFile loads the user's startup.jl, which julia is otherwise run
+	// without.
+	StartupFile bool
+	// AutoPrecompile lets Pkg precompile stale packages when programs load
+	// them, rather than leaving precompilation to dependency installs.
+	AutoPrecompile bool
+	// AutoProject has dependency installs create the Project.toml of
+	// programs without one, adding the Pulumi package to it.
+	AutoProject bool
+	// Precompile precompiles the project's packages, streaming Pkg's
+	// progress, before programs run whenever the project or its manifest
+	// changed since the last time.
+	Precompile bool
+	// TraceCompile records the methods the first run with the project's
+	// current dependencies compiles, and precompiles them before later runs.
+	TraceCompile bool
+	// JuliaArgs are extra julia switches for program runs, passed through
+	// uninterpreted after the host's own switches.
+	JuliaArgs []string
+	// InstallArgs are extra julia switches for installing dependencies.
+	InstallArgs []string
+	// StrictManifest fails dependency installs on a manifest out of date
+	// with its project, rather than resolving it again.
+	StrictManifest bool
+	// Offline installs dependencies from the local depot only, without
+	// reaching package servers or updating registries.
+	Offline bool
+	// PkgServer is the Pkg server dependencies are installed from, exported
+	// as JULIA_PKG_SERVER.
+	PkgServer string
+	// Registries are the URLs of registries added to the depot, if missing,
+	// before dependencies are installed.
+	Registries []string
+	// InstallPrecompile precompiles the project's packages, in a step of its
+	// own, after installing them.
+	InstallPrecompile bool
+	// Env are environment variables set for programs, overriding inherited
+	// ones. Values are taken literally.
+	Env map[string]string
+	// EnvFile is a file of KEY=value lines, relative to the project root,
+	// setting environment variables for programs that aren't set already.
+	// Unset, no file is loaded.
+	EnvFile string
+	// CancelGracePeriod is how long cancelled programs get to exit after
+	// being interrupted, before they are killed.
+	CancelGracePeriod time.Duration
+	// DepotLockTimeout bounds how long dependency installs and precompilation
+	// wait for other stacks using the same depot to finish.
+	DepotLockTimeout time.Duration
+	// Timeout bounds how long programs run. PreviewTimeout and UpdateTimeout
+	// take precedence for previews and updates respectively.
+	Timeout        time.Duration
+	PreviewTimeout time.Duration
+	UpdateTimeout  time.Duration
+	// OutputBufferSize is how many bytes of each of a program's stdout and
+	// stderr are kept to report when it fails.
+	OutputBufferSize int
+	// MaxErrorSize is how many bytes of error a failed run reports at most.
+	MaxErrorSize int
+	// VerboseErrors reports the whole stack trace of programs that throw,
+	// rather than a summary of it, as the error of their run.
+	VerboseErrors bool
+	// LogToEngine forwards the stderr of programs to the engine as log
+	// messages instead of writing it to the host's stderr.
+	LogToEngine bool
+	// LogTimings reports where the time of program runs, dependency installs
+	// and plugin runs went as engine info messages, besides the host's log.
+	LogTimings bool
+	// RetryCorruptCache runs programs that fail on corrupted precompile
+	// cache files again, once, after removing them.
+	RetryCorruptCache bool
+	// InheritStdin passes the host's stdin to programs when it is a terminal,
+	// rather than running them with an empty one.
+	InheritStdin bool
+	// Daemon runs programs in a warm julia process that the host keeps
+	// between runs, rather than in a process of their own, optionally with
+	// Revise.jl applying code changes to it.
+	Daemon daemonMode
+	// PluginDetection says where GetRequiredPlugins looks for the provider
+	// packages a program uses.
+	PluginDetection pluginDetectionMode
+	// PluginDetectionTimeout bounds how long scanning sources for provider
+	// packages takes.
+	PluginDetectionTimeout time.Duration
+
+	// Plugins are reported by GetRequiredPlugins in addition to, and in
+	// preference to, the detected plugins.
+	Plugins []pluginOption
+}
+
+// optimizeStartup says when programs run with minimal compilation.
+type optimizeStartup int
+
+const (
+	optimizeStartupNever optimizeStartup = iota
+	// optimizeStartupPreview minimizes compilation for previews only.
+	optimizeStartupPreview
+	// optimizeStartupAlways minimizes compilation for updates too.
+	optimizeStartupAlways
+)
+
+// daemonMode says whether programs run in a daemon, and whether it runs
+// Revise.
+type daemonMode int
+
+const (
+	daemonOff daemonMode = iota
+	daemonOn
+	// daemonRevise has Revise.jl apply code changes to the daemon between
+	// runs, rather than starting a new one.
+	daemonRevise
+)
+
+// pluginDetectionMode says where provider packages are looked for.
+type pluginDetectionMode int
+
+const (
+	// pluginDetectionSources looks in the project's dependencies and in the
+	// sources the entry point includes.
+	pluginDetectionSources pluginDetectionMode = iota
+	// pluginDetectionProjectOnly looks in the project's dependencies only.
+	pluginDetectionProjectOnly
+	// pluginDetectionOff detects nothing, leaving plugins to the plugins
+	// runtime option.
+	pluginDetectionOff
+)
+
+// pluginOption is an entry of the `plugins` runtime option.
+type pluginOption struct {
+	Name              string
+	Version           string
+	PluginDownloadURL string
+}
+
+// parseRuntimeOptions validates the runtime options sent by the engine.
+func parseRuntimeOptions(options *structpb.Struct) (runtimeOptions, error) {
+	opts := runtimeOptions{
+		CancelGracePeriod: defaultCancelGracePeriod,
+		DepotLockTimeout:  defaultDepotLockTimeout,
+		OutputBufferSize:  defaultOutputBufferSize,
+		MaxErrorSize:      defaultMaxErrorSize,
+		RetryCorruptCache: true,
+		AutoProject:       true,
+		InstallPrecompile: true,
+
+		PluginDetectionTimeout: defaultPluginDetectionTimeout,
+	}
+	if options == nil {
+		return opts, nil
+	}
+	values := options.AsMap()
+
+	for name, dst := range map[string]*string{
+		"entrypoint":   &opts.EntryPoint,
+		"project":      &opts.Project,
+		"julia":        &opts.Julia,
+		"juliaVersion": &opts.JuliaVersion,
+		"sysimage":     &opts.Sysimage,
+		"depot":        &opts.Depot,
+		"binary":       &opts.Binary,
+		"heapSizeHint": &opts.HeapSizeHint,
+		"coverage":     &opts.Coverage,
+		"envFile":      &opts.EnvFile,
+		"pkgServer":    &opts.PkgServer,
+	} {
+		if err := parseStringOption(values, name, dst); err != nil {
+			return opts, err
+		}
+	}
+
+	if opts.HeapSizeHint != "" && !heapSizeHintPattern.MatchString(opts.HeapSizeHint) {
+		return opts, fmt.Errorf("invalid runtime option heapSizeHint: "+
+			"expected a size such as \"512M\" or \"2G\", got %q", opts.HeapSizeHint)
+	}
+
+	switch {
+	case opts.Coverage == "", opts.Coverage == "none", opts.Coverage == "user", opts.Coverage == "all",
+		strings.HasPrefix(opts.Coverage, "@"):
+	default:
+		return opts, fmt.Errorf("invalid runtime option coverage: "+
+			"expected \"none\", \"user\", \"all\" or \"@path\", got %q", opts.Coverage)
+	}
+
+	for name, dst := range map[string]*[]string{
+		"juliaArgs":   &opts.JuliaArgs,
+		"installArgs": &opts.InstallArgs,
+		"loadPath":    &opts.LoadPath,
+		"registries":  &opts.Registries,
+	} {
+		if err := parseStringListOption(values, name, dst); err != nil {
+			return opts, err
+		}
+	}
+	for i, url := range opts.Registries {
+		if url == "" {
+			return opts, fmt.Errorf("invalid runtime option registries: entry %d: expected a URL, got \"\"", i)
+		}
+	}
+
+	if value, ok := values["env"]; ok {
+		env, err := parseEnvOption(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid runtime option env: %w", err)
+		}
+		opts.Env = env
+	}
+
+	if err := parseDurationOption(values, "cancelGracePeriod", &opts.CancelGracePeriod); err != nil {
+		return opts, err
+	}
+	for name, dst := range map[string]*time.Duration{
+		"timeout":        &opts.Timeout,
+		"previewTimeout": &opts.PreviewTimeout,
+		"updateTimeout":  &opts.UpdateTimeout,
+
+		"pluginDetectionTimeout": &opts.PluginDetectionTimeout,
+		"depotLockTimeout":       &opts.DepotLockTimeout,
+	} {
+		if err := parseDurationOption(values, name, dst); err != nil {
+			return opts, err
+		}
+		if _, ok := values[name]; ok && *dst == 0 {
+			return opts, fmt.Errorf("invalid runtime option %s: expected a positive duration, got %v", name, values[name])
+		}
+	}
+
+	for name, dst := range map[string]*int{
+		"outputBufferSize": &opts.OutputBufferSize,
+		"maxErrorSize":     &opts.MaxErrorSize,
+	} {
+		if value, ok := values[name]; ok {
+			size, err := parseSize(value)
+			if err != nil {
+				return opts, fmt.Errorf("invalid runtime option %s: %w", name, err)
+			}
+			*dst = size
+		}
+	}
+
+	if value, ok := values["threads"]; ok {
+		threads, err := parseThreadsOption(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid runtime option threads: %w", err)
+		}
+		opts.Threads = threads
+	}
+
+	for name, dst := range map[string]*bool{
+		"sysimageRequired":  &opts.SysimageRequired,
+		"startupFile":       &opts.StartupFile,
+		"autoPrecompile":    &opts.AutoPrecompile,
+		"autoProject":       &opts.AutoProject,
+		"precompile":        &opts.Precompile,
+		"traceCompile":      &opts.TraceCompile,
+		"installPrecompile": &opts.InstallPrecompile,
+		"strictManifest":    &opts.StrictManifest,
+		"offline":           &opts.Offline,
+		"verboseErrors":     &opts.VerboseErrors,
+		"logToEngine":       &opts.LogToEngine,
+		"logTimings":        &opts.LogTimings,
+		"retryCorruptCache": &opts.RetryCorruptCache,
+		"inheritStdin":      &opts.InheritStdin,
+	} {
+		if err := parseBoolOption(values, name, dst); err != nil {
+			return opts, err
+		}
+	}
+
+	if value, ok := values["typechecker"]; ok {
+		if value != "jet" {
+			return opts, fmt.Errorf("invalid runtime option typechecker: expected \"jet\", got %v", value)
+		}
+		opts.Typechecker = "jet"
+	}
+
+	if value, ok := values["typecheckerLevel"]; ok {
+		switch value {
+		case "error":
+			opts.TypecheckerWarnOnly = false
+		case "warn":
+			opts.TypecheckerWarnOnly = true
+		default:
+			return opts, fmt.Errorf("invalid runtime option typecheckerLevel: "+
+				"expected \"error\" or \"warn\", got %v", value)
+		}
+	}
+
+	if value, ok := values["optimizeStartup"]; ok {
+		switch value {
+		case false:
+			opts.OptimizeStartup = optimizeStartupNever
+		case true, "preview":
+			opts.OptimizeStartup = optimizeStartupPreview
+		case "always":
+			opts.OptimizeStartup = optimizeStartupAlways
+		default:
+			return opts, fmt.Errorf("invalid runtime option optimizeStartup: "+
+				"expected true, false, \"preview\" or \"always\", got %v", value)
+		}
+	}
+
+	if value, ok := values["daemon"]; ok {
+		switch value {
+		case false:
+			opts.Daemon = daemonOff
+		case true:
+			opts.Daemon = daemonOn
+		case "revise":
+			opts.Daemon = daemonRevise
+		default:
+			return opts, fmt.Errorf("invalid runtime option daemon: "+
+				"expected true, false or \"revise\", got %v", value)
+		}
+	}
+
+	if value, ok := values["pluginDetection"]; ok {
+		switch value {
+		case "sources":
+			opts.PluginDetection = pluginDetectionSources
+		case "project-only":
+			opts.PluginDetection = pluginDetectionProjectOnly
+		case "off":
+			opts.PluginDetection = pluginDetectionOff
+		default:
+			return opts, fmt.Errorf("invalid runtime option pluginDetection: "+
+				"expected \"sources\", \"project-only\" or \"off\", got %v", value)
+		}
+	}
+
+	if value, ok := values["plugins"]; ok {
+		plugins, err := parsePluginOptions(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid runtime option plugins: %w", err)
+		}
+		opts.Plugins = plugins
+	}
+
+	return opts, nil
+}
+
+// heapSizeHintPattern matches the sizes accepted by julia's --heap-size-hint:
+// a number with a K, M, G or T unit.
+var heapSizeHintPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[KMGTkmgt]$`)
+
+// parseStringOption sets dst to the value of the named option, if set, which
+// must be a non-empty string.
+func parseStringOption(values map[string]interface{}, name string, dst *string) error {
+	value, ok := values[name]
+	if !ok {
+		return nil
+	}
+	s, ok := value.(string)
+	if !ok || s == "" {
+		return fmt.Errorf("invalid runtime option %s: expected a non-empty string, got %v", name, value)
+	}
+	*dst = s
+	return nil
+}
+
+// parseBoolOption sets dst to the value of the named option, if set, which
+// must be a boolean.
+func parseBoolOption(values map[string]interface{}, name string, dst *bool) error {
+	value, ok := values[name]
+	if !ok {
+		return nil
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return fmt.Errorf("invalid runtime option %s: expected a boolean, got %v", name, value)
+	}
+	*dst = b
+	return nil
+}
+
+// parseDurationOption sets dst to the value of the named option, if set,
+// which must be a non-negative duration in Go syntax, such as "90s" or "1h30m".
+func parseDurationOption(values map[string]interface{}, name string, dst *time.Duration) error {
+	value, ok := values[name]
+	if !ok {
+		return nil
+	}
+	s, _ := value.(string)
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return fmt.Errorf("invalid runtime option %s: expected a duration such as \"15s\" or \"30m\", got %v",
+			name, value)
+	}
+	*dst = d
+	return nil
+}
+
+// parseStringListOption sets dst to the value of the named option, if set,
+// which must be a list of strings.
+func parseStringListOption(values map[string]interface{}, name string, dst *[]string) error {
+	value, ok := values[name]
+	if !ok {
+		return nil
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("invalid runtime option %s: expected a list of strings, got %v", name, value)
+	}
+	strs := make([]string, len(list))
+	for i, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return fmt.Errorf("invalid runtime option %s: entry %d: expected a string, got %v", name, i, item)
+		}
+		strs[i] = s
+	}
+	*dst = strs
+	return nil
+}
+
+// reservedEnvNames are the environment variables through which Run passes
+// the program its stack and configuration, which the env runtime option must
+// not override.
+var reservedEnvNames = []string{
+	"PULUMI_PROJECT", "PULUMI_STACK", "PULUMI_ORGANIZATION", "PULUMI_DRY_RUN", "PULUMI_QUERY_MODE",
+	"PULUMI_PARALLEL", "PULUMI_MONITOR", "PULUMI_ENGINE", "PULUMI_CONFIG", "PULUMI_CONFIG_FILE",
+	"PULUMI_CONFIG_SECRET_KEYS", "PULUMI_CONFIG_SECRETS_FILE", "PULUMI_ROOT_DIRECTORY", "PULUMI_PROGRAM_DIRECTORY",
+}
+
+// parseEnvOption validates a map of environment variable names to string
+// values, none of which may be reserved.
+func parseEnvOption(value interface{}) (map[string]string, error) {
+	entries, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a map of names to strings, got %v", value)
+	}
+	env := make(map[string]string, len(entries))
+	for name, value := range entries {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return nil, fmt.Errorf("invalid environment variable name %q", name)
+		}
+		if slices.Contains(reservedEnvNames, name) {
+			return nil, fmt.Errorf("%s is set by the language host and can't be overridden", name)
+		}
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected a string, got %v", name, value)
+		}
+		env[name] = s
+	}
+	return env, nil
+}
+
+// sizePattern matches a size in bytes, optionally with a K, M or G unit.
+var sizePattern = regexp.MustCompile(`^([0-9]+)([KMGkmg]?)$`)
+
+// parseSize validates a positive size: a number of bytes or a string such as
+// "512K" or "1M".
+func parseSize(value interface{}) (int, error) {
+	var size int64
+	switch value := value.(type) {
+	case float64:
+		if value == math.Trunc(value) && value <= math.MaxInt32 {
+			size = int64(value)
+		}
+	case string:
+		if m := sizePattern.FindStringSubmatch(value); m != nil {
+			n, _ := strconv.ParseInt(m[1], 10, 32)
+			switch strings.ToUpper(m[2]) {
+			case "K":
+				n <<= 10
+			case "M":
+				n <<= 20
+			case "G":
+				n <<= 30
+			}
+			size = n
+		}
+	}
+	if size < 1 || size > math.MaxInt32 {
+		return 0, fmt.Errorf("expected a positive size such as 262144, \"256K\" or \"1M\", got %v", value)
+	}
+	return int(size), nil
+}
+
+// parseThreadsOption validates a thread count: a positive integer or "auto".
+func parseThreadsOption(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case float64:
+		if value < 1 || value != math.Trunc(value) {
+			return "", fmt.Errorf("expected a positive integer or \"auto\", got %v", value)
+		}
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case string:
+		if value == "auto" {
+			return value, nil
+		}
+		if n, err := strconv.Atoi(value); err == nil && n >= 1 {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("expected a positive integer or \"auto\", got %v", value)
+}
+
+func parsePluginOptions(value interface{}) ([]pluginOption, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list, got %v", value)
+	}
+
+	plugins := make([]pluginOption, 0, len(list))
+	for i, item := range list {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("entry %d: expected an object, got %v", i, item)
+		}
+
+		var plugin pluginOption
+		for key, value := range entry {
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("entry %d: %q must be a string", i, key)
+			}
+			switch key {
+			case "name":
+				plugin.Name = s
+			case "version":
+				plugin.Version = s
+			case "pluginDownloadURL":
+				plugin.PluginDownloadURL = s
+			default:
+				return nil, fmt.Errorf("entry %d: unknown key %q (expected name, version or pluginDownloadURL)", i, key)
+			}
+		}
+		if plugin.Name == "" {
+			return nil, fmt.Errorf("entry %d: missing plugin name", i)
+		}
+		if plugin.Version != "" && !isVersionString(strings.TrimPrefix(plugin.Version, "v")) {
+			return nil, fmt.Errorf("entry %d: version %q is not a valid semantic version", i, plugin.Version)
+		}
+		if err := validatePluginDownloadURL(plugin.PluginDownloadURL); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		plugins = append(plugins, plugin)
+	}
+	return plugins, nil
+}
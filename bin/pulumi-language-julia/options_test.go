@@ -0,0 +1,355 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func mustStruct(t *testing.T, values map[string]interface{}) *structpb.Struct {
+	t.Helper()
+	s, err := structpb.NewStruct(values)
+	if err != nil {
+		t.Fatalf("invalid runtime options: %v", err)
+	}
+	return s
+}
+
+func TestParseRuntimeOptionsPlugins(t *testing.T) {
+	opts, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{
+		"plugins": []interface{}{
+			map[string]interface{}{"name": "aws", "version": "6.40.0"},
+			map[string]interface{}{"name": "acme", "pluginDownloadURL": "github://api.github.com/acme"},
+		},
+	}))
+	if err != nil {
+		t.Fatalf("parseRuntimeOptions: %v", err)
+	}
+	expected := []pluginOption{
+		{Name: "aws", Version: "6.40.0"},
+		{Name: "acme", PluginDownloadURL: "github://api.github.com/acme"},
+	}
+	if len(opts.Plugins) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, opts.Plugins)
+	}
+	for i := range expected {
+		if opts.Plugins[i] != expected[i] {
+			t.Errorf("plugin %d: expected %v, got %v", i, expected[i], opts.Plugins[i])
+		}
+	}
+}
+
+func TestParseRuntimeOptionsInvalidPlugins(t *testing.T) {
+	tests := []struct {
+		name    string
+		plugins interface{}
+		message string
+	}{
+		{"not a list", "aws", "expected a list"},
+		{"not an object", []interface{}{"aws"}, "expected an object"},
+		{"missing name", []interface{}{map[string]interface{}{"version": "1.0.0"}}, "missing plugin name"},
+		{"numeric version", []interface{}{map[string]interface{}{"name": "aws", "version": 6.4}}, `"version" must be a string`},
+		{"bad version", []interface{}{map[string]interface{}{"name": "aws", "version": "six"}}, "not a valid semantic version"},
+		{"unknown key", []interface{}{map[string]interface{}{"name": "aws", "kind": "resource"}}, `unknown key "kind"`},
+		{"bad url", []interface{}{map[string]interface{}{"name": "aws", "pluginDownloadURL": "s3://bucket"}}, "unsupported plugin download URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{"plugins": tt.plugins}))
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Fatalf("expected error containing %q, got %v", tt.message, err)
+			}
+		})
+	}
+}
+
+func TestParseRuntimeOptionsNil(t *testing.T) {
+	opts, err := parseRuntimeOptions(nil)
+	if err != nil {
+		t.Fatalf("parseRuntimeOptions: %v", err)
+	}
+	if len(opts.Plugins) != 0 {
+		t.Errorf("expected no plugins, got %v", opts.Plugins)
+	}
+}
+
+func TestParseRuntimeOptionsPaths(t *testing.T) {
+	opts, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{
+		"entrypoint": "infra/stacks/prod.jl",
+		"project":    "infra",
+	}))
+	if err != nil {
+		t.Fatalf("parseRuntimeOptions: %v", err)
+	}
+	if opts.EntryPoint != "infra/stacks/prod.jl" || opts.Project != "infra" {
+		t.Errorf("unexpected options %+v", opts)
+	}
+
+	for _, option := range []string{"entrypoint", "project", "julia", "sysimage"} {
+		for _, value := range []interface{}{"", 42.0} {
+			_, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{option: value}))
+			if err == nil || !strings.Contains(err.Error(), option) {
+				t.Errorf("expected a %s error for %v, got %v", option, value, err)
+			}
+		}
+	}
+}
+
+func TestParseRuntimeOptionsThreads(t *testing.T) {
+	for value, expected := range map[interface{}]string{4.0: "4", "auto": "auto", "8": "8"} {
+		opts, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{"threads": value}))
+		if err != nil || opts.Threads != expected {
+			t.Errorf("threads %v: expected %q, got %q, %v", value, expected, opts.Threads, err)
+		}
+	}
+	for _, value := range []interface{}{0.0, -2.0, 1.5, "0", "many", true} {
+		_, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{"threads": value}))
+		if err == nil || !strings.Contains(err.Error(), `positive integer or "auto"`) {
+			t.Errorf("threads %v: expected a validation error, got %v", value, err)
+		}
+	}
+}
+
+func TestParseRuntimeOptionsOptimizeStartup(t *testing.T) {
+	for value, expected := range map[interface{}]optimizeStartup{
+		false: optimizeStartupNever, true: optimizeStartupPreview,
+		"preview": optimizeStartupPreview, "always": optimizeStartupAlways,
+	} {
+		opts, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{"optimizeStartup": value}))
+		if err != nil || opts.OptimizeStartup != expected {
+			t.Errorf("optimizeStartup %v: expected %v, got %v, %v", value, expected, opts.OptimizeStartup, err)
+		}
+	}
+	if _, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{"optimizeStartup": "update"})); err == nil {
+		t.Errorf("expected an error for an unknown value")
+	}
+}
+
+func TestParseRuntimeOptionsDaemon(t *testing.T) {
+	for value, expected := range map[interface{}]daemonMode{
+		false: daemonOff, true: daemonOn, "revise": daemonRevise,
+	} {
+		opts, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{"daemon": value}))
+		if err != nil || opts.Daemon != expected {
+			t.Errorf("daemon %v: expected %v, got %v, %v", value, expected, opts.Daemon, err)
+		}
+	}
+	if _, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{"daemon": "warm"})); err == nil {
+		t.Errorf("expected an error for an unknown value")
+	}
+}
+
+func TestParseRuntimeOptionsPluginDetection(t *testing.T) {
+	opts, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{}))
+	if err != nil || opts.PluginDetection != pluginDetectionSources ||
+		opts.PluginDetectionTimeout != defaultPluginDetectionTimeout {
+		t.Errorf("expected sources to be scanned by default, got %+v, %v", opts, err)
+	}
+	for value, expected := range map[string]pluginDetectionMode{
+		"sources": pluginDetectionSources, "project-only": pluginDetectionProjectOnly, "off": pluginDetectionOff,
+	} {
+		opts, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{"pluginDetection": value}))
+		if err != nil || opts.PluginDetection != expected {
+			t.Errorf("pluginDetection %v: expected %v, got %v, %v", value, expected, opts.PluginDetection, err)
+		}
+	}
+	for name, value := range map[string]interface{}{"pluginDetection": "manifest", "pluginDetectionTimeout": "0s"} {
+		if _, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{name: value})); err == nil {
+			t.Errorf("expected an error for %s %v", name, value)
+		}
+	}
+}
+
+func TestParseRuntimeOptionsSysimage(t *testing.T) {
+	opts, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{
+		"sysimage": "build/sys.so", "sysimageRequired": true,
+	}))
+	if err != nil || opts.Sysimage != "build/sys.so" || !opts.SysimageRequired {
+		t.Errorf("unexpected options %+v, %v", opts, err)
+	}
+	if _, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{"sysimageRequired": "yes"})); err == nil {
+		t.Errorf("expected an error for a non-boolean sysimageRequired")
+	}
+}
+
+func TestParseRuntimeOptionsJuliaArgs(t *testing.T) {
+	opts, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{
+		"juliaArgs":   []interface{}{"--check-bounds=no", "--banner=no"},
+		"installArgs": []interface{}{},
+	}))
+	if err != nil {
+		t.Fatalf("parseRuntimeOptions: %v", err)
+	}
+	if strings.Join(opts.JuliaArgs, " ") != "--check-bounds=no --banner=no" || len(opts.InstallArgs) != 0 {
+		t.Errorf("unexpected options %+v", opts)
+	}
+
+	for _, option := range []string{"juliaArgs", "installArgs", "loadPath", "registries"} {
+		for _, value := range []interface{}{"--banner=no", []interface{}{"--banner=no", 42.0}} {
+			_, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{option: value}))
+			if err == nil || !strings.Contains(err.Error(), option) {
+				t.Errorf("expected a %s error for %v, got %v", option, value, err)
+			}
+		}
+	}
+}
+
+func TestParseRuntimeOptionsRegistries(t *testing.T) {
+	opts, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{
+		"pkgServer":  "https://pkg.example.com",
+		"registries": []interface{}{"https://github.com/example/Registry.git"},
+	}))
+	if err != nil || opts.PkgServer != "https://pkg.example.com" ||
+		strings.Join(opts.Registries, " ") != "https://github.com/example/Registry.git" {
+		t.Errorf("unexpected options %+v, %v", opts, err)
+	}
+	for name, value := range map[string]interface{}{"pkgServer": "", "registries": []interface{}{""}} {
+		_, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{name: value}))
+		if err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("expected a %s error for %v, got %v", name, value, err)
+		}
+	}
+}
+
+func TestParseRuntimeOptionsHeapSizeHint(t *testing.T) {
+	for _, value := range []string{"1G", "512M", "1.5G", "2t"} {
+		opts, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{"heapSizeHint": value}))
+		if err != nil || opts.HeapSizeHint != value {
+			t.Errorf("heapSizeHint %q: unexpected %+v, %v", value, opts, err)
+		}
+	}
+	for _, value := range []interface{}{"1", "G", "1GB", "-1G", 1024.0} {
+		_, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{"heapSizeHint": value}))
+		if err == nil || !strings.Contains(err.Error(), "heapSizeHint") {
+			t.Errorf("expected a heapSizeHint error for %v, got %v", value, err)
+		}
+	}
+}
+
+func TestParseRuntimeOptionsTypechecker(t *testing.T) {
+	opts, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{
+		"typechecker": "jet", "typecheckerLevel": "warn",
+	}))
+	if err != nil || opts.Typechecker != "jet" || !opts.TypecheckerWarnOnly {
+		t.Errorf("unexpected options %+v, %v", opts, err)
+	}
+	for name, value := range map[string]interface{}{"typechecker": "mypy", "typecheckerLevel": "fatal"} {
+		_, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{name: value}))
+		if err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("expected a %s error, got %v", name, err)
+		}
+	}
+}
+
+func TestParseRuntimeOptionsCoverage(t *testing.T) {
+	for _, value := range []string{"none", "user", "all", "@src"} {
+		opts, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{"coverage": value}))
+		if err != nil || opts.Coverage != value {
+			t.Errorf("coverage %q: unexpected %+v, %v", value, opts, err)
+		}
+	}
+	if _, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{"coverage": "tracefile"})); err == nil {
+		t.Errorf("expected an error for an unknown coverage mode")
+	}
+}
+
+func TestParseRuntimeOptionsEnv(t *testing.T) {
+	opts, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{
+		"env": map[string]interface{}{"AWS_PROFILE": "prod", "GREETING": "${HOME} $USER"},
+	}))
+	if err != nil {
+		t.Fatalf("parseRuntimeOptions: %v", err)
+	}
+	if len(opts.Env) != 2 || opts.Env["AWS_PROFILE"] != "prod" || opts.Env["GREETING"] != "${HOME} $USER" {
+		t.Errorf("unexpected env %v", opts.Env)
+	}
+
+	for _, value := range []interface{}{
+		"AWS_PROFILE=prod",
+		map[string]interface{}{"DEBUG": true},
+		map[string]interface{}{"A=B": "c"},
+		map[string]interface{}{"PULUMI_STACK": "prod"},
+		map[string]interface{}{"PULUMI_CONFIG": "{}"},
+	} {
+		_, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{"env": value}))
+		if err == nil || !strings.Contains(err.Error(), "env") {
+			t.Errorf("expected an env error for %v, got %v", value, err)
+		}
+	}
+}
+
+func TestParseRuntimeOptionsCancelGracePeriod(t *testing.T) {
+	opts, err := parseRuntimeOptions(nil)
+	if err != nil || opts.CancelGracePeriod != defaultCancelGracePeriod {
+		t.Errorf("expected the default grace period, got %v, %v", opts.CancelGracePeriod, err)
+	}
+	opts, err = parseRuntimeOptions(mustStruct(t, map[string]interface{}{"cancelGracePeriod": "1m30s"}))
+	if err != nil || opts.CancelGracePeriod != 90*time.Second {
+		t.Errorf("expected 1m30s, got %v, %v", opts.CancelGracePeriod, err)
+	}
+	for _, value := range []interface{}{"15", "-1s", "soon", 15.0} {
+		_, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{"cancelGracePeriod": value}))
+		if err == nil || !strings.Contains(err.Error(), "cancelGracePeriod") {
+			t.Errorf("expected a cancelGracePeriod error for %v, got %v", value, err)
+		}
+	}
+}
+
+func TestParseRuntimeOptionsDepotLockTimeout(t *testing.T) {
+	opts, err := parseRuntimeOptions(nil)
+	if err != nil || opts.DepotLockTimeout != defaultDepotLockTimeout {
+		t.Errorf("expected the default depot lock timeout, got %v, %v", opts.DepotLockTimeout, err)
+	}
+	opts, err = parseRuntimeOptions(mustStruct(t, map[string]interface{}{"depotLockTimeout": "2h"}))
+	if err != nil || opts.DepotLockTimeout != 2*time.Hour {
+		t.Errorf("expected 2h, got %v, %v", opts.DepotLockTimeout, err)
+	}
+	for _, value := range []interface{}{"0s", "later", 60.0} {
+		_, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{"depotLockTimeout": value}))
+		if err == nil || !strings.Contains(err.Error(), "depotLockTimeout") {
+			t.Errorf("expected a depotLockTimeout error for %v, got %v", value, err)
+		}
+	}
+}
+
+func TestParseRuntimeOptionsTimeouts(t *testing.T) {
+	opts, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{
+		"timeout": "30m", "previewTimeout": "5m", "updateTimeout": "1h30m",
+	}))
+	if err != nil || opts.Timeout != 30*time.Minute || opts.PreviewTimeout != 5*time.Minute ||
+		opts.UpdateTimeout != 90*time.Minute {
+		t.Errorf("unexpected options %+v, %v", opts, err)
+	}
+	for _, name := range []string{"timeout", "previewTimeout", "updateTimeout"} {
+		for _, value := range []interface{}{"0s", "-5m", "30", "half an hour", 1800.0} {
+			_, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{name: value}))
+			if err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("expected a %s error for %v, got %v", name, value, err)
+			}
+		}
+	}
+}
+
+func TestParseRuntimeOptionsSizes(t *testing.T) {
+	opts, err := parseRuntimeOptions(nil)
+	if err != nil || opts.OutputBufferSize != defaultOutputBufferSize || opts.MaxErrorSize != defaultMaxErrorSize {
+		t.Errorf("expected the default sizes, got %d and %d, %v", opts.OutputBufferSize, opts.MaxErrorSize, err)
+	}
+	for _, name := range []string{"outputBufferSize", "maxErrorSize"} {
+		for value, want := range map[interface{}]int{4096.0: 4096, "4096": 4096, "64K": 64 << 10, "1m": 1 << 20, "1G": 1 << 30} {
+			opts, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{name: value}))
+			got := map[string]int{"outputBufferSize": opts.OutputBufferSize, "maxErrorSize": opts.MaxErrorSize}[name]
+			if err != nil || got != want {
+				t.Errorf("%s %v: expected %d, got %d, %v", name, value, want, got, err)
+			}
+		}
+		for _, value := range []interface{}{0.0, -1.0, 1.5, "0K", "1.5M", "2G", "1T", "1 MB", true} {
+			_, err := parseRuntimeOptions(mustStruct(t, map[string]interface{}{name: value}))
+			if err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("expected a %s error for %v, got %v", name, value, err)
+			}
+		}
+	}
+}
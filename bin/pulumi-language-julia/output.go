@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// stdoutTailLines is how many trailing lines of stdout are reported when a
+	// program fails without writing anything to stderr.
+	stdoutTailLines = 50
+
+	// defaultMaxErrorSize bounds the error of a RunResponse, which can quote a
+	// lot of program output, keeping it well clear of gRPC message limits.
+	defaultMaxErrorSize = 16 << 10
+
+	// defaultOutputBufferSize is how much of each of a program's stdout and
+	// stderr is kept for error reporting.
+	defaultOutputBufferSize = 256 << 10
+
+	// bailExitCode is the exit code of programs that reported their error to
+	// the engine already, with Pulumi.run. Other Pulumi language SDKs bail out
+	// with the same code.
+	bailExitCode = 32
+
+	// maxOutputLineBytes bounds the partial line a lineWriter buffers.
+	maxOutputLineBytes = 64 << 10
+
+	// outputQueueSize is how much output an outputQueue holds while its
+	// writer falls behind.
+	outputQueueSize = 4 << 20
+
+	// installStderrBufferSize is how much of the stderr of package
+	// installation is kept to report if it fails.
+	installStderrBufferSize = 64 << 10
+
+	// installTailLines is how many trailing lines of stderr are reported for
+	// package installation that failed, enough for Pkg's resolver errors.
+	installTailLines = 40
+)
+
+// lineWriter passes output through to w a whole line at a time, so that the
+// lines a program writes to stdout and stderr, which are copied concurrently,
+// don't interleave mid-line: writers sharing mu never write at once. Lines
+// longer than maxOutputLineBytes are broken up.
+type lineWriter struct {
+	mu      *sync.Mutex
+	w       io.Writer
+	partial []byte
+}
+
+func newLineWriter(mu *sync.Mutex, w io.Writer) *lineWriter {
+	return &lineWriter{mu: mu, w: w}
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.partial = append(l.partial, p...)
+	end := bytes.LastIndexByte(l.partial, '\n') + 1
+	if end == 0 && len(l.partial) >= maxOutputLineBytes {
+		end = len(l.partial)
+	}
+	if end == 0 {
+		return len(p), nil
+	}
+	var err error
+	if end == len(l.partial) && l.partial[end-1] != '\n' {
+		err = l.write(append(l.partial, '\n'))
+	} else {
+		err = l.write(l.partial[:end])
+	}
+	// Keep only the partial line, in the same buffer, so that output streams
+	// through without allocating.
+	l.partial = l.partial[:copy(l.partial, l.partial[end:])]
+	return len(p), err
+}
+
+// Flush writes the last line of output, if it isn't terminated, as the
+// program exits.
+func (l *lineWriter) Flush() error {
+	if len(l.partial) == 0 {
+		return nil
+	}
+	err := l.write(l.partial)
+	l.partial = nil
+	return err
+}
+
+func (l *lineWriter) write(p []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.w.Write(p)
+	return err
+}
+
+// outputQueue passes output through to w from a goroutine of its own, so
+// that a program never waits for a slow w, such as the CLI reading the host's
+// stdout, or the engine its log messages. Up to size bytes are queued,
+// bounding the host's memory however much a program writes; once that much
+// is waiting, the oldest queued output is dropped to make room, and counted.
+type outputQueue struct {
+	w    io.Writer
+	size int
+
+	mu sync.Mutex
+	// changed is signalled whenever chunks are queued or written, or the
+	// queue is closed.
+	changed *sync.Cond
+	chunks  [][]byte
+	// queued counts the bytes of chunks, and of the chunk being written.
+	queued  int
+	dropped int
+	closed  bool
+	// free are buffers of written chunks, reused for new ones so that
+	// streaming output doesn't allocate.
+	free [][]byte
+	// err is the first error writing to w, after which output is discarded.
+	err  error
+	done chan struct{}
+}
+
+func newOutputQueue(w io.Writer, size int) *outputQueue {
+	q := &outputQueue{w: w, size: size, done: make(chan struct{})}
+	q.changed = sync.NewCond(&q.mu)
+	go q.forward()
+	return q
+}
+
+func (q *outputQueue) Write(p []byte) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return 0, q.err
+	}
+	// A write larger than the whole queue is queued alone, rather than split.
+	for len(q.chunks) > 0 && q.queued+len(p) > q.size {
+		oldest := q.chunks[0]
+		q.chunks[0] = nil
+		q.chunks = q.chunks[1:]
+		q.queued -= len(oldest)
+		q.dropped += len(oldest)
+		q.free = append(q.free, oldest)
+	}
+	var chunk []byte
+	if n := len(q.free); n > 0 {
+		chunk, q.free = q.free[n-1][:0], q.free[:n-1]
+	}
+	q.chunks = append(q.chunks, append(chunk, p...))
+	q.queued += len(p)
+	q.changed.Broadcast()
+	return len(p), nil
+}
+
+// forward writes the queued chunks to w, in order, until the queue is closed
+// and empty.
+func (q *outputQueue) forward() {
+	defer close(q.done)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		for len(q.chunks) == 0 && !q.closed {
+			q.changed.Wait()
+		}
+		if len(q.chunks) == 0 {
+			return
+		}
+		chunk := q.chunks[0]
+		q.chunks[0] = nil
+		q.chunks = q.chunks[1:]
+		if q.err == nil {
+			q.mu.Unlock()
+			_, err := q.w.Write(chunk)
+			q.mu.Lock()
+			if err != nil {
+				q.err = err
+			}
+		}
+		q.queued -= len(chunk)
+		q.free = append(q.free, chunk)
+		q.changed.Broadcast()
+	}
+}
+
+// Close waits for the queued output to be written, returning the first error
+// writing it.
+func (q *outputQueue) Close() error {
+	q.mu.Lock()
+	q.closed = true
+	q.changed.Broadcast()
+	q.mu.Unlock()
+	<-q.done
+	return q.err
+}
+
+// Dropped returns how many bytes of output were dropped to make room in the
+// queue.
+func (q *outputQueue) Dropped() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// outputStream streams the stdout and stderr of a julia process over a
+// streaming RPC, sending each write with sendStdout or sendStderr. Set as
+// the output of an exec.Cmd, it is written until the process closes its
+// output, which Wait waits for, so that the last lines a process writes as it
+// exits, often the errors that made it fail, are sent too.
+type outputStream struct {
+	// mu serializes sends, which servers don't support concurrently.
+	mu                     sync.Mutex
+	sendStdout, sendStderr func([]byte) error
+	// err is the first error sending output, after which output is
+	// discarded.
+	err error
+}
+
+func newOutputStream(sendStdout, sendStderr func([]byte) error) *outputStream {
+	return &outputStream{sendStdout: sendStdout, sendStderr: sendStderr}
+}
+
+// stdout and stderr return the writers of the process's stdout and stderr.
+func (s *outputStream) stdout() io.Writer { return &streamWriter{s, s.sendStdout} }
+func (s *outputStream) stderr() io.Writer { return &streamWriter{s, s.sendStderr} }
+
+// Err returns the first error sending output.
+func (s *outputStream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// streamWriter is one of the outputs of an outputStream.
+type streamWriter struct {
+	stream *outputStream
+	send   func([]byte) error
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.stream.mu.Lock()
+	defer w.stream.mu.Unlock()
+	if w.stream.err != nil {
+		return 0, w.stream.err
+	}
+	if err := w.send(p); err != nil {
+		w.stream.err = err
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ringBuffer keeps the most recent output written to it, up to a fixed size,
+// so that the output of long-running programs is captured in bounded memory.
+type ringBuffer struct {
+	data []byte
+	pos  int
+	full bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{data: make([]byte, size)}
+}
+
+func (b *ringBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if n >= len(b.data) {
+		copy(b.data, p[n-len(b.data):])
+		b.pos, b.full = 0, true
+		return n, nil
+	}
+	k := copy(b.data[b.pos:], p)
+	if k < n {
+		b.pos = copy(b.data, p[k:])
+		b.full = true
+	} else if b.pos += k; b.pos == len(b.data) {
+		b.pos, b.full = 0, true
+	}
+	return n, nil
+}
+
+// String returns the output kept, starting at a line boundary when earlier
+// output was dropped.
+func (b *ringBuffer) String() string {
+	if !b.full {
+		return string(b.data[:b.pos])
+	}
+	return fromLineStart(string(b.data[b.pos:]) + string(b.data[:b.pos]))
+}
+
+// validUTF8 replaces the invalid UTF-8 in s, such as latin-1 output of tools
+// programs run, which gRPC would fail to marshal, with U+FFFD.
+func validUTF8(s string) string {
+	return strings.ToValidUTF8(s, string(utf8.RuneError))
+}
+
+// memoryAdvice suggests how to stop a program running out of memory.
+const memoryAdvice = "give it more memory with a larger runner or a higher container memory limit, " +
+	"or set the heapSizeHint runtime option so that Julia collects garbage before memory runs out"
+
+// killedFailure describes a program killed by SIGKILL, which, unless the run
+// was cancelled, is most often the out-of-memory killer's doing. oomKilled
+// says whether the out-of-memory killer is known to have killed it.
+func killedFailure(oomKilled bool) string {
+	if oomKilled {
+		return "Julia program was killed by the out-of-memory killer, having run out of memory; " + memoryAdvice
+	}
+	return "Julia program was killed by signal KILL, which is most often sent by the out-of-memory killer " +
+		"when memory runs out; if so, " + memoryAdvice
+}
+
+// crashTailLines is how many trailing lines of stderr are reported for a
+// program that crashed.
+const crashTailLines = 20
+
+// crashFailure describes a program killed by crash, such as a signal, which
+// usually means that a native library crashed, quoting the last lines of its
+// stderr. Where it may have dumped core, the message points at the working
+// directory, dir, where core dumps are typically written.
+func crashFailure(crash string, mayDumpCore bool, dir, stderr string) string {
+	msg := fmt.Sprintf("Julia program was killed by %s, which usually means that a native library it uses crashed", crash)
+	if mayDumpCore {
+		msg += fmt.Sprintf("; if core dumps are enabled, look for one in its working directory, %s", dir)
+	}
+	if tail := tailLines(stderr, crashTailLines); tail != "" {
+		msg += fmt.Sprintf("\nThe last lines of its stderr were:\n%s", tail)
+	}
+	return validUTF8(msg)
+}
+
+// startupErrors match the messages julia itself fails with before it runs any
+// code, such as for an unknown or invalid switch or a missing system image.
+var startupErrors = regexp.MustCompile(`^(?:ERROR: )?(?:julia: |unknown option |` +
+	`System image file .* not found|could not load library |fatal: error thrown and no exception handler available)`)
+
+// startupFailed reports whether stderr shows julia failing to start rather
+// than the program failing: julia reports such failures before any output.
+func startupFailed(stderr string) bool {
+	first, _, _ := strings.Cut(strings.TrimSpace(ansiEscape.ReplaceAllString(stderr, "")), "\n")
+	return startupErrors.MatchString(first)
+}
+
+// startupFailure describes julia failing to start, when run as args, quoting
+// its stderr.
+func startupFailure(args []string, stderr string) string {
+	return validUTF8(fmt.Sprintf("Julia failed to start, before running the program: %s\nThe command run was: %s",
+		strings.TrimSpace(stderr), strings.Join(args, " ")))
+}
+
+// installFailure describes package installation, run as args in dir, that
+// failed with err, quoting the last lines of its stderr, which hold Pkg's
+// error, such as a registry that failed to update or unsatisfiable compat
+// bounds, in case the streamed output never made it to the user.
+func installFailure(err error, args []string, dir, stderr string) error {
+	msg := fmt.Sprintf("The command run was: %s\nin %s", strings.Join(args, " "), dir)
+	if tail := tailLines(ansiEscape.ReplaceAllString(stderr, ""), installTailLines); tail != "" {
+		msg += fmt.Sprintf("\nThe last lines of its stderr were:\n%s", tail)
+	}
+	return fmt.Errorf("Julia package installation failed: %w\n%s", err, validUTF8(msg))
+}
+
+// manifestMismatch matches the errors Pkg.instantiate() fails with when the
+// manifest wasn't resolved again after the project changed.
+var manifestMismatch = regexp.MustCompile(`(?i)expected (?:package .* to exist in the manifest|` +
+	`manifest to match (?:the )?project)|does not appear in the manifest|` +
+	`to populate the manifest|manifest .*(?:does not|doesn't) match`)
+
+// manifestOutOfDate reports whether stderr shows Pkg.instantiate() failing
+// on a manifest out of date with its project.
+func manifestOutOfDate(stderr string) bool {
+	return manifestMismatch.MatchString(ansiEscape.ReplaceAllString(stderr, ""))
+}
+
+// strictManifestAdvice tells users what to do about a manifest out of date
+// with its project that the strictManifest runtime option keeps as it is.
+const strictManifestAdvice = "Manifest.toml doesn't match Project.toml. Run " +
+	"`julia --project -e 'using Pkg; Pkg.resolve()'` locally and commit the updated Manifest.toml"
+
+// offlineMissing matches the errors Pkg fails with offline for packages, or
+// registries, that aren't in the depot and would have to be downloaded.
+var offlineMissing = regexp.MustCompile(`(?i)offline|not installed|` +
+	`(?:could not|cannot|unable to|failed to) (?:download|find|clone|fetch)|no known versions|to be registered|` +
+	`not (?:found )?in any (?:of the )?registr|timed? ?out|could not resolve host`)
+
+// missingOffline reports whether stderr shows an offline install failing for
+// want of something that isn't in the depot.
+func missingOffline(stderr string) bool {
+	return offlineMissing.MatchString(ansiEscape.ReplaceAllString(stderr, ""))
+}
+
+// offlineAdvice explains an offline install failing for want of packages.
+const offlineAdvice = "Julia packages were installed offline, and the depot lacks packages or registry entries " +
+	"the project needs, which can't be downloaded offline; install them into the depot where the package server " +
+	"can be reached, or vendor them with their registry, then install again"
+
+// programFailure describes a program that exited with exitCode, quoting its
+// stderr, or the tail of its stdout when stderr is empty, since programs that
+// report errors with @error or redirect their logging write them to stdout.
+// The location in the program that the error was thrown from, if known, leads
+// the message, so that it is the first thing seen in the CLI's output.
+func programFailure(exitCode int, location, stdout, stderr string) string {
+	if msg := strings.TrimSpace(stderr); msg != "" {
+		if location != "" {
+			msg = location + ": " + msg
+		}
+		return validUTF8(msg)
+	}
+	msg := fmt.Sprintf("Julia program exited with code %d", exitCode)
+	if tail := tailLines(stdout, stdoutTailLines); tail != "" {
+		msg += fmt.Sprintf(" without writing to stderr; the last lines of its stdout were:\n%s",
+			validUTF8(tail))
+	}
+	return msg
+}
+
+// tailLines returns at most the last n lines of output, without surrounding
+// whitespace.
+func tailLines(output string, n int) string {
+	output = strings.TrimSpace(output)
+	for i, end := 0, len(output); i < n; i++ {
+		nl := strings.LastIndexByte(output[:end], '\n')
+		if nl < 0 {
+			return output
+		}
+		if i == n-1 {
+			return output[nl+1:]
+		}
+		end = nl
+	}
+	return output
+}
+
+// capError bounds msg to about maxBytes, keeping its start, which says what
+// went wrong, and its end, where program output ends with the error, and
+// marking what was dropped in between. Both are cut at character boundaries.
+func capError(msg string, maxBytes int) string {
+	if len(msg) <= maxBytes {
+		return msg
+	}
+	head := maxBytes / 4
+	for head > 0 && !utf8.RuneStart(msg[head]) {
+		head--
+	}
+	tail := len(msg) - (maxBytes - head)
+	for tail < len(msg) && !utf8.RuneStart(msg[tail]) {
+		tail++
+	}
+	return fmt.Sprintf("%s\n[%d bytes truncated]\n%s", msg[:head], tail-head, msg[tail:])
+}
+
+// fromLineStart drops the partial line at the start of output that was cut
+// short, or, without a line to start at, the partial character.
+func fromLineStart(s string) string {
+	if nl := strings.IndexByte(s, '\n'); nl >= 0 {
+		return s[nl+1:]
+	}
+	for len(s) > 0 && !utf8.RuneStart(s[0]) {
+		s = s[1:]
+	}
+	return s
+}
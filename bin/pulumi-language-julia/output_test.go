@@ -0,0 +1,698 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestProgramFailure(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 60; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	stdout := strings.Join(lines, "\n") + "\n"
+
+	if got := programFailure(1, "", stdout, "  ERROR: boom\n"); got != "ERROR: boom" {
+		t.Errorf("expected stderr to be reported, got %q", got)
+	}
+	if got := programFailure(1, "lib/buckets.jl:12", "", "ERROR: boom\n"); got != "lib/buckets.jl:12: ERROR: boom" {
+		t.Errorf("expected the location to lead the error, got %q", got)
+	}
+	if got := programFailure(2, "", "", ""); got != "Julia program exited with code 2" {
+		t.Errorf("expected the exit code alone, got %q", got)
+	}
+
+	got := programFailure(1, "", stdout, "")
+	want := "Julia program exited with code 1 without writing to stderr; the last lines of its stdout were:\n" +
+		strings.Join(lines[10:], "\n")
+	if got != want {
+		t.Errorf("expected the last 50 lines of stdout, got %q", got)
+	}
+}
+
+func TestCapError(t *testing.T) {
+	if got := capError("ERROR: boom", 100); got != "ERROR: boom" {
+		t.Errorf("expected a short error to be kept, got %q", got)
+	}
+
+	long := "ERROR: " + strings.Repeat("é", defaultMaxErrorSize) + " the end"
+	got := capError(long, defaultMaxErrorSize)
+	if len(got) > defaultMaxErrorSize+100 {
+		t.Errorf("expected the error to be bounded, got %d bytes", len(got))
+	}
+	if !strings.HasPrefix(got, "ERROR: éé") || !strings.HasSuffix(got, "éé the end") {
+		t.Errorf("expected the start and end of the error to be kept, got %q...", got[:100])
+	}
+	if !utf8.ValidString(got) {
+		t.Errorf("expected the error to be cut between characters")
+	}
+	head, rest, _ := strings.Cut(got, "\n[")
+	dropped, tail, _ := strings.Cut(rest, " bytes truncated]\n")
+	if n, err := strconv.Atoi(dropped); err != nil || len(head)+n+len(tail) != len(long) {
+		t.Errorf("expected the bytes dropped to be counted, got %q", dropped)
+	}
+}
+
+func TestRingBuffer(t *testing.T) {
+	b := newRingBuffer(16)
+	fmt.Fprint(b, "one\ntwo\n")
+	if got := b.String(); got != "one\ntwo\n" {
+		t.Errorf("expected all output before the buffer fills, got %q", got)
+	}
+	fmt.Fprint(b, "three\nfour\n") // drops "one"
+	if got := b.String(); got != "two\nthree\nfour\n" {
+		t.Errorf("expected the partial first line to be dropped, got %q", got)
+	}
+	fmt.Fprint(b, strings.Repeat("x", 40)+"\nfive\n")
+	if got := b.String(); got != "five\n" {
+		t.Errorf("expected the tail of a write larger than the buffer, got %q", got)
+	}
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(b, "line %d\n", i)
+	}
+	if got := b.String(); got != "line 99\n" || len(b.data) != 16 {
+		t.Errorf("expected the buffer to keep its size and the last line, got %q in %d bytes", got, len(b.data))
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	var mu sync.Mutex
+	var out bytes.Buffer
+	w := newLineWriter(&mu, &out)
+	for _, chunk := range []string{"one", "\ntw", "o\nthree\nfo", "ur"} {
+		fmt.Fprint(w, chunk)
+	}
+	if out.String() != "one\ntwo\nthree\n" {
+		t.Errorf("expected whole lines only, got %q", out.String())
+	}
+	w.Flush()
+	if out.String() != "one\ntwo\nthree\nfour" {
+		t.Errorf("expected the partial line to be flushed, got %q", out.String())
+	}
+
+	out.Reset()
+	fmt.Fprint(w, strings.Repeat("x", maxOutputLineBytes+10))
+	if out.String() != strings.Repeat("x", maxOutputLineBytes+10)+"\n" {
+		t.Errorf("expected a long line to be broken up, got %d bytes", out.Len())
+	}
+}
+
+func TestLineWriterConcurrentWriters(t *testing.T) {
+	var mu sync.Mutex
+	var out bytes.Buffer
+	const lines, lineBytes = 200, 10000
+
+	var wg sync.WaitGroup
+	for _, c := range "ab" {
+		w := newLineWriter(&mu, &out)
+		line := strings.Repeat(string(c), lineBytes) + "\n"
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Write in chunks that split lines unevenly, as pipes do.
+			data := strings.Repeat(line, lines)
+			for i, size := 0, 1; i < len(data); size = size*7%4093 + 1 {
+				end := min(i+size, len(data))
+				w.Write([]byte(data[i:end]))
+				i = end
+			}
+			w.Flush()
+		}()
+	}
+	wg.Wait()
+
+	got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(got) != 2*lines {
+		t.Fatalf("expected %d lines, got %d", 2*lines, len(got))
+	}
+	for i, line := range got {
+		if len(line) != lineBytes || strings.Trim(line, line[:1]) != "" {
+			t.Fatalf("line %d is interleaved: %q...", i, line[:min(len(line), 40)])
+		}
+	}
+}
+
+// blockingWriter collects what is written to it, each write announcing
+// itself on writing and then waiting until it is released.
+type blockingWriter struct {
+	writing, release chan struct{}
+	bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.writing <- struct{}{}
+	<-w.release
+	return w.Buffer.Write(p)
+}
+
+func TestOutputQueue(t *testing.T) {
+	w := &blockingWriter{writing: make(chan struct{}, 4), release: make(chan struct{})}
+	q := newOutputQueue(w, 8)
+	q.Write([]byte("one\n"))
+	<-w.writing
+
+	// However slow the writer, writes never wait: once the queue is full,
+	// the oldest queued output makes room for new output.
+	written := make(chan struct{})
+	go func() {
+		for _, p := range []string{"two\n", "three\n", "four\n"} {
+			q.Write([]byte(p))
+		}
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected writes not to wait for the writer")
+	}
+
+	close(w.release)
+	if err := q.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := w.String(); got != "one\nfour\n" {
+		t.Errorf("expected the oldest output to be dropped, got %q", got)
+	}
+	if dropped := q.Dropped(); dropped != len("two\nthree\n") {
+		t.Errorf("expected %d bytes to be dropped, got %d", len("two\nthree\n"), dropped)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("broken pipe") }
+
+func TestOutputQueueError(t *testing.T) {
+	q := newOutputQueue(failingWriter{}, 1<<10)
+	q.Write([]byte("lost\n"))
+	if err := q.Close(); err == nil || err.Error() != "broken pipe" {
+		t.Errorf("expected the error writing the output, got %v", err)
+	}
+	if _, err := q.Write([]byte("more\n")); err == nil {
+		t.Errorf("expected writes to fail once the writer has")
+	}
+}
+
+// sentOutput collects what an outputStream sends.
+type sentOutput struct {
+	stdout, stderr bytes.Buffer
+}
+
+func (o *sentOutput) stream() *outputStream {
+	return newOutputStream(func(p []byte) error {
+		o.stdout.Write(p)
+		return nil
+	}, func(p []byte) error {
+		o.stderr.Write(p)
+		return nil
+	})
+}
+
+func TestOutputStreamSendsLastLines(t *testing.T) {
+	fakeJulia(t, `i=0; while [ $i -lt 1000 ]; do echo "Installing package $i"; i=$((i+1)); done
+echo 'ERROR: Unsatisfiable requirements detected for package AWS' >&2
+exit 1`)
+	for i := 0; i < 20; i++ {
+		var sent sentOutput
+		stream := sent.stream()
+		cmd := exec.CommandContext(context.Background(), "julia")
+		cmd.Stdout, cmd.Stderr = stream.stdout(), stream.stderr()
+		if _, err := runProcess("julia", cmd, 0); err == nil {
+			t.Fatalf("expected julia to fail")
+		}
+		if !strings.HasSuffix(sent.stdout.String(), "Installing package 999\n") ||
+			sent.stderr.String() != "ERROR: Unsatisfiable requirements detected for package AWS\n" {
+			t.Fatalf("expected all the output to be sent, got stdout ending %q and stderr %q",
+				sent.stdout.String()[max(0, sent.stdout.Len()-40):], sent.stderr.String())
+		}
+	}
+}
+
+func TestOutputStreamSendError(t *testing.T) {
+	sends := 0
+	stream := newOutputStream(func(p []byte) error {
+		sends++
+		return errors.New("transport is closing")
+	}, func(p []byte) error {
+		sends++
+		return nil
+	})
+	if _, err := stream.stdout().Write([]byte("one\n")); err == nil {
+		t.Errorf("expected the send error to be returned")
+	}
+	if _, err := stream.stderr().Write([]byte("two\n")); err == nil {
+		t.Errorf("expected output to be discarded once sending failed")
+	}
+	if err := stream.Err(); err == nil || err.Error() != "transport is closing" || sends != 1 {
+		t.Errorf("expected the first send error after 1 send, got %v after %d", err, sends)
+	}
+}
+
+func TestStartupFailed(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   bool
+	}{
+		{"ERROR: julia: unknown option `--bogus`\n", true},
+		{"julia: -t,--threads=<n>[,auto|<m>]; n must be an integer >= 1\n", true},
+		{"ERROR: System image file \"/tmp/missing.so\" not found.\n", true},
+		{"fatal: error thrown and no exception handler available.\nReadOnlyMemoryError()\n", true},
+		{"ERROR: LoadError: could not load library \"libfoo\"\nStacktrace:\n", false},
+		{"[ Info: starting\nERROR: julia: unknown option\n", false},
+		{"ERROR: boom\n", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := startupFailed(tt.stderr); got != tt.want {
+			t.Errorf("startupFailed(%q) = %v, want %v", tt.stderr, got, tt.want)
+		}
+	}
+}
+
+func TestRunReportsStartupFailure(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	fakeJulia(t, "echo 'ERROR: julia: unknown option `--bogus`' >&2\nexit 1")
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	msg := resp.GetError()
+	for _, part := range []string{
+		"Julia failed to start, before running the program: ERROR: julia: unknown option `--bogus`",
+		"\nThe command run was: ", "--project=", filepath.Join(realPath(root), "main.jl"),
+	} {
+		if !strings.Contains(msg, part) {
+			t.Errorf("expected %q in %q", part, msg)
+		}
+	}
+}
+
+func TestTailLines(t *testing.T) {
+	tests := []struct {
+		output string
+		n      int
+		want   string
+	}{
+		{"", 3, ""},
+		{"one", 3, "one"},
+		{"one\ntwo\nthree\n\n", 2, "two\nthree"},
+		{"one\ntwo\nthree", 3, "one\ntwo\nthree"},
+		{"one\ntwo\nthree", 1, "three"},
+	}
+	for _, tt := range tests {
+		if got := tailLines(tt.output, tt.n); got != tt.want {
+			t.Errorf("tailLines(%q, %d) = %q, want %q", tt.output, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestRunBoundsCapturedOutput(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	// Write 1MB of stdout, far more than is kept, before the error.
+	fakeJulia(t, `i=0; while [ $i -lt 16384 ]; do echo "$i: padding to make a line of 64 bytes ......................"; i=$((i+1)); done
+echo 'Error: the real problem'
+exit 1`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+			Options: mustStruct(t, map[string]interface{}{"outputBufferSize": "4K"}),
+		},
+	})
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !strings.HasSuffix(resp.GetError(), "16383: padding to make a line of 64 bytes ......................\n"+
+		"Error: the real problem") {
+		t.Errorf("expected the tail of stdout to be reported, got %q", resp.GetError())
+	}
+	if lines := strings.Count(resp.GetError(), "\n"); lines != stdoutTailLines {
+		t.Errorf("expected %d lines of stdout, got %d", stdoutTailLines, lines)
+	}
+	// Output is streamed through the host in small writes, so an unbounded
+	// capture would grow the heap by the full megabyte.
+	if grown := int64(after.HeapAlloc) - int64(before.HeapAlloc); grown > 512<<10 {
+		t.Errorf("expected the captured output to stay bounded, the heap grew by %d bytes", grown)
+	}
+}
+
+func TestRunFloodingSlowStdout(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	// Write 64MB of stdout, to a CLI reading it slowly.
+	fakeJulia(t, `yes "flooding the output with lines of 64 bytes ..................." | head -c 67108864`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	t.Cleanup(func() { os.Stdout = stdout })
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = errW
+	t.Cleanup(func() { os.Stderr = stderr })
+	warnings := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(errR)
+		warnings <- string(data)
+	}()
+	read := make(chan int64)
+	go func() {
+		var n int64
+		buf := make([]byte, 64<<10)
+		for {
+			k, err := r.Read(buf)
+			n += int64(k)
+			if err != nil {
+				read <- n
+				return
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+
+	var before runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	var peak uint64
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			peak = max(peak, stats.HeapInuse)
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	})
+	close(done)
+	<-sampled
+	w.Close()
+	errW.Close()
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v %v", err, resp.GetError())
+	}
+	// What the CLI couldn't keep up with is dropped rather than waited for,
+	// and the run says so.
+	n, warning := <-read, <-warnings
+	if n == 0 || n > 64<<20 {
+		t.Errorf("expected the output to be forwarded, got %d bytes", n)
+	}
+	if n < 64<<20 && !strings.Contains(warning, fmt.Sprintf("warning: dropped %d bytes of program output", 64<<20-n)) {
+		t.Errorf("expected a warning about the %d bytes dropped, got %q", 64<<20-n, warning)
+	}
+	// The host holds no more than its queues and output buffers, however
+	// much the program writes.
+	if grown := int64(peak) - int64(before.HeapInuse); grown > 32<<20 {
+		t.Errorf("expected the host's memory to stay flat, the heap grew by %d bytes", grown)
+	}
+}
+
+// captureOutput redirects the host's stdout and stderr to pipes until the
+// returned function is called, which returns what was written to each.
+func captureOutput(t *testing.T) func() (string, string) {
+	t.Helper()
+	var outputs [2]chan string
+	var writers [2]*os.File
+	for i, std := range []**os.File{&os.Stdout, &os.Stderr} {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved := *std
+		*std, writers[i] = w, w
+		t.Cleanup(func() { *std = saved })
+		outputs[i] = make(chan string)
+		go func(output chan string) {
+			data, _ := io.ReadAll(r)
+			output <- string(data)
+		}(outputs[i])
+	}
+	return func() (string, string) {
+		writers[0].Close()
+		writers[1].Close()
+		return <-outputs[0], <-outputs[1]
+	}
+}
+
+func TestConcurrentRuns(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	// Each program checks that it got the config of its stack, then writes
+	// lines longer than a pipe writes atomically to both stdout and stderr.
+	fakeJulia(t, `config=${PULUMI_CONFIG:-$(cat "$PULUMI_CONFIG_FILE")}
+case "$config" in *"\"$PULUMI_STACK\""*) ok=yes ;; *) ok=no ;; esac
+pad=$(printf '%05000d' 0)
+i=0; while [ $i -lt 100 ]; do echo "$PULUMI_STACK $ok $pad"; echo "$PULUMI_STACK $ok $pad" >&2; i=$((i+1)); done`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	output := captureOutput(t)
+
+	const stacks = 8
+	host := newTestHost()
+	var wg sync.WaitGroup
+	for i := 0; i < stacks; i++ {
+		wg.Add(1)
+		go func(stack string, large bool) {
+			defer wg.Done()
+			config := map[string]string{"project:name": stack}
+			if large {
+				// Large config is passed in a file of the run's own.
+				config["project:blob"] = strings.Repeat("x", configFileThreshold)
+			}
+			resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{
+				Stack: stack, Config: config,
+				Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+			})
+			if err != nil || resp.GetError() != "" {
+				t.Errorf("Run %s: %v %v", stack, err, resp.GetError())
+			}
+		}(fmt.Sprintf("stack-%d", i), i%2 == 1)
+	}
+	wg.Wait()
+
+	stdout, stderr := output()
+	pad := strings.Repeat("0", 5000)
+	for name, out := range map[string]string{"stdout": stdout, "stderr": stderr} {
+		counts := map[string]int{}
+		for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+			stack, rest, _ := strings.Cut(line, " ")
+			if rest != "yes "+pad {
+				t.Fatalf("expected whole lines of runs with their own config on %s, got %.100q", name, line)
+			}
+			counts[stack]++
+		}
+		for i := 0; i < stacks; i++ {
+			if stack := fmt.Sprintf("stack-%d", i); counts[stack] != 100 {
+				t.Errorf("expected 100 lines of %s on %s, got %d", stack, name, counts[stack])
+			}
+		}
+	}
+}
+
+func TestRunReportsStdoutWithoutStderr(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	fakeJulia(t, "echo 'starting'\necho 'Error: bucket name is taken'\nexit 3")
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	want := "Julia program exited with code 3 without writing to stderr; the last lines of its stdout were:\n" +
+		"starting\nError: bucket name is taken"
+	if resp.GetError() != want {
+		t.Errorf("expected %q, got %q", want, resp.GetError())
+	}
+}
+
+func TestRunBailsOnBailExitCode(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	info := &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."}
+
+	fakeJulia(t, "echo 'ERROR: reported already' >&2\nexit 32")
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{Info: info})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !resp.GetBail() || resp.GetError() != "" {
+		t.Errorf("expected the run to bail without an error, got %+v", resp)
+	}
+
+	fakeJulia(t, "echo 'ERROR: not reported' >&2\nexit 31")
+	resp, err = newTestHost().Run(context.Background(), &pulumirpc.RunRequest{Info: info})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if resp.GetBail() || resp.GetError() != "ERROR: not reported" {
+		t.Errorf("expected the run to fail with an error, got %+v", resp)
+	}
+}
+
+func TestRunReportsInvalidUTF8(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	// "café" and "naïve" encoded as latin-1.
+	fakeJulia(t, `printf 'ERROR: provider failed: caf\351 na\357ve\n' >&2; exit 1`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if want := "ERROR: provider failed: caf� na�ve"; resp.GetError() != want {
+		t.Errorf("expected %q, got %q", want, resp.GetError())
+	}
+	if _, err := proto.Marshal(resp); err != nil {
+		t.Errorf("expected the response to marshal, got %v", err)
+	}
+
+	resp, err = runFailure(hostErrorf("failed to run \xe9"))
+	if msg := internalError(t, resp, err); msg != "failed to run �" {
+		t.Errorf("expected the host error to be valid UTF-8, got %q", msg)
+	}
+}
+
+func TestRunCapsOversizedError(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	// A 12MB line of stderr, three times gRPC's default message limit.
+	fakeJulia(t, `printf 'ERROR: {"dump": "' >&2; head -c 12000000 /dev/zero | tr '\0' x >&2; printf '"}' >&2; exit 1`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	stderr := os.Stderr
+	os.Stderr = devNull
+	defer func() { os.Stderr = stderr }()
+
+	for _, maxErrorSize := range []int{defaultMaxErrorSize, 1 << 10} {
+		options := map[string]interface{}{"outputBufferSize": "16M"}
+		if maxErrorSize != defaultMaxErrorSize {
+			options["maxErrorSize"] = maxErrorSize
+		}
+		resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+				Options: mustStruct(t, options),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		data, err := proto.Marshal(resp)
+		if err != nil {
+			t.Fatalf("expected the response to marshal, got %v", err)
+		}
+		var got pulumirpc.RunResponse
+		if err := proto.Unmarshal(data, &got); err != nil {
+			t.Fatalf("expected the response to unmarshal, got %v", err)
+		}
+		msg := got.GetError()
+		if len(msg) > maxErrorSize+100 {
+			t.Errorf("maxErrorSize %d: expected the error to be bounded, got %d bytes", maxErrorSize, len(msg))
+		}
+		if !strings.HasPrefix(msg, `ERROR: {"dump": "xxx`) || !strings.HasSuffix(msg, `xxx"}`) ||
+			!strings.Contains(msg, " bytes truncated]") {
+			t.Errorf("maxErrorSize %d: expected the start and end of stderr, got %q...", maxErrorSize, msg[:100])
+		}
+	}
+}
+
+func TestRunReportsKilledProgram(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no SIGKILL")
+	}
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	for _, script := range []string{"echo 'allocating' >&2; kill -KILL $$", "exit 137"} {
+		fakeJulia(t, script)
+		resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+			Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+		})
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		// The out-of-memory killer didn't strike, so it is only suggested as
+		// the likeliest cause.
+		if want := killedFailure(false); resp.GetError() != want {
+			t.Errorf("%s: expected %q, got %q", script, want, resp.GetError())
+		}
+	}
+	if msg := killedFailure(true); !strings.Contains(msg, "out-of-memory killer, having run out of memory") ||
+		!strings.Contains(msg, "heapSizeHint") {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
+
+func TestRunNotesDevelopedSDK(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	sdk := t.TempDir()
+	writeFile(t, filepath.Join(sdk, "Project.toml"), "name = \"Pulumi\"\nuuid = \"8c5fd2a4-4b2f-4c3b-9e6c-1b3f0a9d6e21\"\n")
+	writeFile(t, filepath.Join(sdk, "src", "Pulumi.jl"), "module Pulumi end\n")
+	t.Setenv("PULUMI_JULIA_SDK_PATH", sdk)
+	fakeJulia(t, "true")
+
+	output := captureOutput(t)
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	})
+	_, stderr := output()
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v %v", err, resp.GetError())
+	}
+	if !strings.Contains(stderr, "note: running against the checkouts of Pulumi at "+sdk+", from PULUMI_JULIA_SDK_PATH") {
+		t.Errorf("expected the run to note the developed SDK, got %q", stderr)
+	}
+}
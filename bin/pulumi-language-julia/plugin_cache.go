@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+// pluginDetectionInputs are the files in the program directory whose contents
+// determine the outcome of plugin detection.
+var pluginDetectionInputs = append([]string{"Project.toml", "Manifest.toml", "main.jl"}, pluginMappingFiles...)
+
+// pluginDetectionCache memoizes plugin detection per program directory. The
+// engine asks for the required plugins several times during a single
+// `pulumi up`, and detection may scan sources and the package depots.
+type pluginDetectionCache struct {
+	mu      sync.Mutex
+	entries map[string]pluginDetectionResult
+}
+
+// pluginDetectionResult is a cached detection result, valid for as long as the
+// fingerprint of its inputs is unchanged.
+type pluginDetectionResult struct {
+	// sources are the included source files examined during detection, in
+	// addition to the fixed pluginDetectionInputs.
+	sources     []string
+	fingerprint string
+	plugins     []*pulumirpc.PackageDependency
+}
+
+func newPluginDetectionCache() *pluginDetectionCache {
+	return &pluginDetectionCache{entries: map[string]pluginDetectionResult{}}
+}
+
+// detect returns the plugins required by the program in dir, reusing the
+// previous result if none of the detection inputs have changed since.
+func (c *pluginDetectionCache) detect(
+	detector *pluginDetector, dir string,
+) ([]*pulumirpc.PackageDependency, error) {
+	key, err := filepath.Abs(dir)
+	if err != nil {
+		key = dir
+	}
+	if detector.entryPoint != "" {
+		key += string(os.PathListSeparator) + detector.entryPoint
+	}
+	for _, depot := range detector.depots {
+		key += string(os.PathListSeparator) + depot
+	}
+	if detector.maxSourceBytes <= 0 {
+		key += string(os.PathListSeparator) + "project-only"
+	}
+
+	c.mu.Lock()
+	cached, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && detectionFingerprint(dir, cached.sources) == cached.fingerprint {
+		logging.V(5).Infof("GetRequiredPlugins: using cached plugins for %s", key)
+		return cached.plugins, nil
+	}
+
+	plugins, err := detector.detect(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Results missing the sources there was no time to scan are not kept,
+	// so that the next request gets another chance to scan them.
+	if detector.truncated {
+		return plugins, nil
+	}
+
+	c.mu.Lock()
+	c.entries[key] = pluginDetectionResult{
+		sources:     detector.sources,
+		fingerprint: detectionFingerprint(dir, detector.sources),
+		plugins:     plugins,
+	}
+	c.mu.Unlock()
+	return plugins, nil
+}
+
+// detectionFingerprint summarizes the modification times and sizes of the
+// plugin detection inputs in dir and of the given source files.
+func detectionFingerprint(dir string, sources []string) string {
+	paths := make([]string, 0, len(pluginDetectionInputs)+len(sources))
+	for _, name := range pluginDetectionInputs {
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	paths = append(paths, sources...)
+
+	var b strings.Builder
+	for _, path := range paths {
+		writeFileFingerprint(&b, path)
+	}
+	return b.String()
+}
+
+// writeFileFingerprint writes the modification time and size of the file at
+// path, or that it is missing, to b.
+func writeFileFingerprint(b *strings.Builder, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		fmt.Fprintf(b, "%s:-;", path)
+		return
+	}
+	fmt.Fprintf(b, "%s:%d:%d;", path, info.ModTime().UnixNano(), info.Size())
+}
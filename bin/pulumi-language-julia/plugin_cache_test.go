@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPluginDetectionCache(t *testing.T) {
+	dir := t.TempDir()
+	projectToml := filepath.Join(dir, "Project.toml")
+	writeFile(t, projectToml, "[deps]\nPulumiAWS = \"5f3c2e7a-0b1d-4a6e-8d2c-1e4f9b7a3c21\"\n")
+
+	cache := newPluginDetectionCache()
+	detector := &pluginDetector{maxSourceBytes: defaultMaxSourceScanBytes}
+
+	first, err := cache.detect(detector, dir)
+	if err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+	if names := pluginNames(first); len(names) != 1 || names[0] != "aws" {
+		t.Fatalf("expected the aws plugin, got %v", names)
+	}
+
+	second, err := cache.detect(detector, dir)
+	if err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+	if &second[0] != &first[0] {
+		t.Errorf("expected the second detection to be served from the cache")
+	}
+
+	// Changing any input invalidates the cached result.
+	mainJl := filepath.Join(dir, "main.jl")
+	writeFile(t, mainJl, "using PulumiRandom\n")
+	third, err := cache.detect(detector, dir)
+	if err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+	if names := pluginNames(third); len(names) != 2 || names[1] != "random" {
+		t.Fatalf("expected aws and random plugins after adding main.jl, got %v", names)
+	}
+
+	writeFile(t, projectToml, "[deps]\n")
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(projectToml, future, future); err != nil {
+		t.Fatal(err)
+	}
+	fourth, err := cache.detect(detector, dir)
+	if err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+	if names := pluginNames(fourth); len(names) != 1 || names[0] != "random" {
+		t.Fatalf("expected only the random plugin after editing Project.toml, got %v", names)
+	}
+}
+
+func TestPluginDetectionCacheTracksIncludedFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "main.jl"), `include("network.jl")`)
+	network := filepath.Join(dir, "network.jl")
+	writeFile(t, network, "using PulumiAWS\n")
+
+	cache := newPluginDetectionCache()
+	detector := &pluginDetector{maxSourceBytes: defaultMaxSourceScanBytes}
+	if _, err := cache.detect(detector, dir); err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+
+	writeFile(t, network, "using PulumiAWS, PulumiRandom\n")
+	plugins, err := cache.detect(detector, dir)
+	if err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+	if names := pluginNames(plugins); len(names) != 2 {
+		t.Fatalf("expected editing an included file to invalidate the cache, got %v", names)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+// pluginMappingFiles are the names of the optional project-local files mapping
+// Julia package names to plugins. At most one of them may exist.
+var pluginMappingFiles = []string{"pulumi-plugins.json", "pulumi-plugins.toml"}
+
+// pluginMapping is a project-local override of the plugin required by a Julia
+// package. It takes precedence over every other source of plugin information.
+type pluginMapping struct {
+	// Suppress disables plugin detection for the package entirely.
+	Suppress    bool
+	Name        string
+	Version     string
+	DownloadURL string
+	// Parameterization describes a parameterized package provided by the
+	// plugin, e.g. a dynamically bridged Terraform provider.
+	Parameterization *pulumirpc.PackageParameterization
+}
+
+// readPluginMappings loads the project-local plugin mapping file in dir, if
+// any. In the JSON form a package mapped to null is suppressed; TOML has no
+// null, so the TOML form uses false instead:
+//
+//	{
+//	  "AcmeInternal": {"name": "acme", "version": "1.2.0", "downloadURL": "https://example.com"},
+//	  "PulumiNetlify": {
+//	    "name": "terraform-provider", "version": "0.8.0",
+//	    "parameterization": {"name": "netlify", "version": "0.1.0", "value": "<base64>"}
+//	  },
+//	  "PulumiRandom": null
+//	}
+func readPluginMappings(dir string) (map[string]pluginMapping, error) {
+	var path string
+	for _, name := range pluginMappingFiles {
+		candidate := filepath.Join(dir, name)
+		if _, err := os.Stat(candidate); err == nil {
+			if path != "" {
+				return nil, fmt.Errorf("found both %s and %s; only one plugin mapping file is allowed",
+					filepath.Base(path), name)
+			}
+			path = candidate
+		}
+	}
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var raw map[string]interface{}
+	if strings.HasSuffix(path, ".toml") {
+		_, err = toml.Decode(string(data), &raw)
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	mappings := make(map[string]pluginMapping, len(raw))
+	for pkg, value := range raw {
+		mapping, err := parsePluginMapping(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry for %q in %s: %w", pkg, path, err)
+		}
+		mappings[pkg] = mapping
+	}
+	return mappings, nil
+}
+
+// parsePluginMapping validates a single entry of a plugin mapping file.
+func parsePluginMapping(value interface{}) (pluginMapping, error) {
+	switch value := value.(type) {
+	case nil:
+		return pluginMapping{Suppress: true}, nil
+	case bool:
+		if value {
+			return pluginMapping{}, fmt.Errorf("expected an object, null or false, got true")
+		}
+		return pluginMapping{Suppress: true}, nil
+	case map[string]interface{}:
+		var mapping pluginMapping
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if key == "parameterization" {
+				parameterization, err := parseParameterization(value[key])
+				if err != nil {
+					return pluginMapping{}, fmt.Errorf("invalid parameterization: %w", err)
+				}
+				mapping.Parameterization = parameterization
+				continue
+			}
+
+			s, ok := value[key].(string)
+			if !ok {
+				return pluginMapping{}, fmt.Errorf("%q must be a string", key)
+			}
+			switch key {
+			case "name":
+				mapping.Name = s
+			case "version":
+				mapping.Version = s
+			case "downloadURL":
+				mapping.DownloadURL = s
+			default:
+				return pluginMapping{}, fmt.Errorf(
+					"unknown key %q (expected name, version, downloadURL or parameterization)", key)
+			}
+		}
+		if mapping.Version != "" && !isVersionString(strings.TrimPrefix(mapping.Version, "v")) {
+			return pluginMapping{}, fmt.Errorf("version %q is not a valid semantic version", mapping.Version)
+		}
+		if err := validatePluginDownloadURL(mapping.DownloadURL); err != nil {
+			return pluginMapping{}, err
+		}
+		return mapping, nil
+	default:
+		return pluginMapping{}, fmt.Errorf("expected an object, null or false, got %v", value)
+	}
+}
+
+// parseParameterization validates the parameterization of a mapping entry. The
+// parameter value is base64 encoded, as in pulumi-plugin.json.
+func parseParameterization(value interface{}) (*pulumirpc.PackageParameterization, error) {
+	entry, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an object, got %v", value)
+	}
+
+	var parameterization pulumirpc.PackageParameterization
+	for key, value := range entry {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%q must be a string", key)
+		}
+		switch key {
+		case "name":
+			parameterization.Name = s
+		case "version":
+			parameterization.Version = s
+		case "value":
+			decoded, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("value is not valid base64: %w", err)
+			}
+			parameterization.Value = decoded
+		default:
+			return nil, fmt.Errorf("unknown key %q (expected name, version or value)", key)
+		}
+	}
+	if parameterization.Name == "" {
+		return nil, fmt.Errorf("missing name")
+	}
+	if !isVersionString(strings.TrimPrefix(parameterization.Version, "v")) {
+		return nil, fmt.Errorf("version %q is not a valid semantic version", parameterization.Version)
+	}
+	return &parameterization, nil
+}
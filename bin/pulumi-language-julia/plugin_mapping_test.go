@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+const mappingProjectToml = `[deps]
+AcmeInternal = "c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f"
+PulumiAWS = "5f3c2e7a-0b1d-4a6e-8d2c-1e4f9b7a3c21"
+PulumiRandom = "b2a4c6d8-1e3f-4a5b-9c7d-2f4e6a8b0c13"
+`
+
+func TestPluginMappingFile(t *testing.T) {
+	tests := []struct {
+		file     string
+		contents string
+	}{
+		{"pulumi-plugins.json", `{
+  "AcmeInternal": {"name": "acme", "version": "1.2.0", "downloadURL": "https://artifacts.example.com/pulumi"},
+  "PulumiAWS": {"version": "6.50.0"},
+  "PulumiRandom": null
+}`},
+		{"pulumi-plugins.toml", `PulumiRandom = false
+
+[AcmeInternal]
+name = "acme"
+version = "1.2.0"
+downloadURL = "https://artifacts.example.com/pulumi"
+
+[PulumiAWS]
+version = "6.50.0"
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, filepath.Join(dir, "Project.toml"), mappingProjectToml)
+			writeFile(t, filepath.Join(dir, tt.file), tt.contents)
+
+			detector := &pluginDetector{}
+			plugins, err := detector.detect(dir)
+			if err != nil {
+				t.Fatalf("detect: %v", err)
+			}
+			if len(plugins) != 2 {
+				t.Fatalf("expected acme and aws plugins, got %v", pluginNames(plugins))
+			}
+			acme, aws := plugins[0], plugins[1]
+			if acme.GetName() != "acme" || acme.GetVersion() != "v1.2.0" ||
+				acme.GetServer() != "https://artifacts.example.com/pulumi" {
+				t.Errorf("unexpected acme plugin: %v", acme)
+			}
+			if aws.GetName() != "aws" || aws.GetVersion() != "v6.50.0" {
+				t.Errorf("unexpected aws plugin: %v", aws)
+			}
+		})
+	}
+}
+
+func TestMalformedPluginMappingFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+		message  string
+	}{
+		{"syntax", "pulumi-plugins.json", `{"PulumiAWS": {`, "failed to parse"},
+		{"not an object", "pulumi-plugins.json", `{"PulumiAWS": "aws"}`, `invalid entry for "PulumiAWS"`},
+		{"unknown key", "pulumi-plugins.json", `{"PulumiAWS": {"server": "x"}}`, `unknown key "server"`},
+		{"wrong type", "pulumi-plugins.json", `{"PulumiAWS": {"version": 6}}`, `"version" must be a string`},
+		{"bad version", "pulumi-plugins.json", `{"PulumiAWS": {"version": "latest"}}`, "not a valid semantic version"},
+		{"true", "pulumi-plugins.toml", "PulumiAWS = true\n", "got true"},
+		{"bad parameter value", "pulumi-plugins.json",
+			`{"PulumiAWS": {"parameterization": {"name": "x", "version": "1.0.0", "value": "%%%"}}}`, "not valid base64"},
+		{"unnamed parameterization", "pulumi-plugins.json",
+			`{"PulumiAWS": {"parameterization": {"version": "1.0.0"}}}`, "missing name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, filepath.Join(dir, "Project.toml"), mappingProjectToml)
+			writeFile(t, filepath.Join(dir, tt.file), tt.contents)
+
+			detector := &pluginDetector{}
+			_, err := detector.detect(dir)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.message) || !strings.Contains(err.Error(), tt.file) {
+				t.Errorf("expected error naming %s and containing %q, got %v", tt.file, tt.message, err)
+			}
+		})
+	}
+}
+
+func TestConflictingPluginMappingFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "pulumi-plugins.json"), "{}")
+	writeFile(t, filepath.Join(dir, "pulumi-plugins.toml"), "")
+
+	if _, err := readPluginMappings(dir); err == nil || !strings.Contains(err.Error(), "only one") {
+		t.Fatalf("expected an error about multiple mapping files, got %v", err)
+	}
+}
+
+func TestParameterizedPluginMapping(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "Project.toml"), mappingProjectToml)
+	writeFile(t, filepath.Join(dir, "pulumi-plugins.json"), `{
+  "AcmeInternal": {
+    "name": "terraform-provider", "version": "0.8.0",
+    "parameterization": {"name": "acme", "version": "1.2.0", "value": "eyJyZW1vdGUiOiJhY21lIn0="}
+  },
+  "PulumiAWS": {
+    "name": "terraform-provider", "version": "0.8.0",
+    "parameterization": {"name": "netlify", "version": "0.1.0", "value": ""}
+  },
+  "PulumiRandom": null
+}`)
+
+	host := newTestHost()
+	resp, err := host.GetRequiredPackages(context.Background(), &pulumirpc.GetRequiredPackagesRequest{
+		Info: &pulumirpc.ProgramInfo{ProgramDirectory: dir},
+	})
+	if err != nil {
+		t.Fatalf("GetRequiredPackages: %v", err)
+	}
+	packages := resp.GetPackages()
+	if len(packages) != 2 {
+		t.Fatalf("expected two parameterized packages, got %v", pluginNames(packages))
+	}
+	acme := packages[0]
+	if acme.GetName() != "terraform-provider" || acme.GetVersion() != "v0.8.0" {
+		t.Errorf("expected the base plugin terraform-provider v0.8.0, got %v", acme)
+	}
+	param := acme.GetParameterization()
+	if param.GetName() != "acme" || param.GetVersion() != "v1.2.0" || string(param.GetValue()) != `{"remote":"acme"}` {
+		t.Errorf("unexpected parameterization: %v", param)
+	}
+
+	// Engines without GetRequiredPackages only install the shared base plugin.
+	plugins, err := host.GetRequiredPlugins(context.Background(), &pulumirpc.GetRequiredPluginsRequest{
+		Program: dir,
+	})
+	if err != nil {
+		t.Fatalf("GetRequiredPlugins: %v", err)
+	}
+	if names := pluginNames(plugins.GetPlugins()); len(names) != 1 || names[0] != "terraform-provider" {
+		t.Errorf("expected only the base plugin, got %v", names)
+	}
+}
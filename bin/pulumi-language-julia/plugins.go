@@ -0,0 +1,636 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/plugin"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// knownProviderPlugins maps Julia provider package names to the name of the
+// resource plugin they require.
+var knownProviderPlugins = map[string]string{
+	"PulumiAWS":          "aws",
+	"PulumiAzure":        "azure",
+	"PulumiAzureNative":  "azure-native",
+	"PulumiCloudflare":   "cloudflare",
+	"PulumiCommand":      "command",
+	"PulumiDigitalOcean": "digitalocean",
+	"PulumiDocker":       "docker",
+	"PulumiGCP":          "gcp",
+	"PulumiGitHub":       "github",
+	"PulumiKubernetes":   "kubernetes",
+	"PulumiRandom":       "random",
+	"PulumiTLS":          "tls",
+}
+
+// pluginVersion translates the version of a Julia provider package into the
+// version of the plugin it requires. Provider packages track the version of
+// the upstream provider, optionally recording it as build metadata when the
+// Julia package has been re-released (e.g. "6.40.1+6.40.0").
+func pluginVersion(packageVersion string) string {
+	version := strings.TrimPrefix(packageVersion, "v")
+	if i := strings.Index(version, "+"); i >= 0 {
+		if build := version[i+1:]; isVersionString(build) {
+			version = build
+		} else {
+			version = version[:i]
+		}
+	}
+	if !isVersionString(version) {
+		return ""
+	}
+	return "v" + version
+}
+
+// isVersionString reports whether s looks like a MAJOR.MINOR.PATCH version,
+// optionally followed by a pre-release suffix.
+func isVersionString(s string) bool {
+	if i := strings.Index(s, "-"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" || strings.Trim(part, "0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// defaultMaxSourceScanBytes is the default size limit for source files scanned
+// for provider imports.
+const defaultMaxSourceScanBytes = 1 << 20
+
+// defaultPluginDetectionTimeout is how long scanning sources for provider
+// imports takes at most by default.
+const defaultPluginDetectionTimeout = 10 * time.Second
+
+// pluginDetector computes the resource plugins required by a Julia program.
+type pluginDetector struct {
+	// maxSourceBytes bounds the size of source files scanned for `using` and
+	// `import` statements. Larger files are skipped, and zero disables source
+	// scanning entirely.
+	maxSourceBytes int64
+
+	// entryPoint is the program's entry file, scanned along with the files it
+	// includes. It defaults to main.jl in the program directory.
+	entryPoint string
+
+	// metadata caches the pulumi-plugin.json files of installed packages.
+	metadata *pluginMetadataCache
+
+	// depots are searched for installed packages. They default to the
+	// depots julia searches.
+	depots []string
+
+	// scanTimeout bounds how long source scanning takes, if set.
+	scanTimeout time.Duration
+
+	// sources records the source files examined by the last detection, so
+	// cached results can be invalidated when any of them changes.
+	sources []string
+	// truncated is set if the last detection ran out of time before
+	// scanning every source file.
+	truncated bool
+}
+
+// detect computes the resource packages required by the Julia program in dir.
+// Provider packages are taken from the Project.toml dependencies and from the
+// packages loaded by main.jl and the files it includes, and may be remapped by
+// a pulumi-plugins.json or pulumi-plugins.toml. When a Manifest.toml is
+// present, plugins are pinned to the resolved versions and the installed
+// packages are checked for a pulumi-plugin.json describing their plugin.
+func (d *pluginDetector) detect(dir string) ([]*pulumirpc.PackageDependency, error) {
+	d.sources = nil
+	d.truncated = false
+
+	project, err := readJuliaProject(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings, err := readPluginMappings(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	devVersions, err := devPluginVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := readJuliaManifest(dir)
+	if err != nil {
+		// An unreadable manifest only costs us version pinning.
+		logging.V(5).Infof("GetRequiredPlugins: ignoring manifest: %v", err)
+		manifest = nil
+	}
+
+	packages := map[string]bool{}
+	if project != nil {
+		for pkg := range project.Deps {
+			packages[pkg] = true
+		}
+	}
+	entryPoint := d.entryPoint
+	if entryPoint == "" {
+		entryPoint = filepath.Join(dir, defaultEntryPoint)
+	}
+	for _, pkg := range d.scanSource(entryPoint) {
+		packages[pkg] = true
+	}
+
+	// Sort package names so the response is deterministic.
+	names := make([]string, 0, len(packages))
+	for pkg := range packages {
+		names = append(names, pkg)
+	}
+	sort.Strings(names)
+
+	plugins := []*pulumirpc.PackageDependency{}
+	for _, pkg := range names {
+		mapping, ok := mappings[pkg]
+		if ok && mapping.Suppress {
+			logging.V(5).Infof("GetRequiredPlugins: plugin detection suppressed for %s", pkg)
+			continue
+		}
+		if dep := d.packagePlugin(dir, pkg, project, manifest, mapping, devVersions); dep != nil {
+			plugins = append(plugins, dep)
+		}
+	}
+	return mergePackageDependencies(plugins), nil
+}
+
+// packagePlugin returns the plugin required by the Julia package pkg, or nil
+// if it doesn't require one. The project's plugin mapping file takes
+// precedence over a pulumi-plugin.json shipped with the installed package,
+// which in turn takes precedence over the built-in name mapping.
+func (d *pluginDetector) packagePlugin(
+	dir, pkg string, project *juliaProject, manifest juliaManifest, mapping pluginMapping,
+	devVersions map[string]string,
+) *pulumirpc.PackageDependency {
+	name := knownProviderPlugins[pkg]
+	version := resolvedPluginVersion(pkg, project, manifest)
+	var server string
+	var parameterization *pulumirpc.PackageParameterization
+
+	if entry, ok := manifest[pkg]; ok {
+		if info := d.metadata.lookup(dir, pkg, entry, d.depots); info != nil {
+			if !info.Resource {
+				return nil
+			}
+			if info.Name != "" {
+				name = info.Name
+			}
+			if info.Version != "" {
+				version = "v" + strings.TrimPrefix(info.Version, "v")
+			}
+			if info.Server != "" {
+				if err := validatePluginDownloadURL(info.Server); err != nil {
+					logging.V(5).Infof("GetRequiredPlugins: ignoring server of %s: %v", pkg, err)
+				} else {
+					server = info.Server
+				}
+			}
+			if p := info.Parameterization; p != nil {
+				parameterization = &pulumirpc.PackageParameterization{
+					Name:    p.Name,
+					Version: "v" + strings.TrimPrefix(p.Version, "v"),
+					Value:   p.Value,
+				}
+			}
+		}
+
+		if entry.Path != "" && name != "" {
+			// A package developed from a local checkout doesn't correspond to
+			// a published release, so whatever version it claims may not exist
+			// upstream.
+			if dev, ok := devVersions[name]; ok {
+				logging.V(5).Infof("GetRequiredPlugins: %s is developed from %s; using %s version %s from %s",
+					pkg, entry.Path, name, dev, devPluginsEnvVar)
+				version = dev
+			} else {
+				logging.V(5).Infof("GetRequiredPlugins: %s is developed from %s; omitting the %s plugin version "+
+					"so the engine uses a locally installed plugin", pkg, entry.Path, name)
+				version = ""
+			}
+		}
+	}
+
+	if mapping.Name != "" {
+		name = mapping.Name
+	}
+	if mapping.Version != "" {
+		version = "v" + strings.TrimPrefix(mapping.Version, "v")
+	}
+	if mapping.DownloadURL != "" {
+		server = mapping.DownloadURL
+	}
+	if p := mapping.Parameterization; p != nil {
+		parameterization = &pulumirpc.PackageParameterization{
+			Name:    p.Name,
+			Version: "v" + strings.TrimPrefix(p.Version, "v"),
+			Value:   p.Value,
+		}
+	}
+
+	if name == "" {
+		return nil
+	}
+	if parameterization != nil && parameterization.Name == "" {
+		logging.V(5).Infof("GetRequiredPlugins: ignoring parameterization of %s without a name", pkg)
+		parameterization = nil
+	}
+	return &pulumirpc.PackageDependency{
+		Name:             name,
+		Kind:             "resource",
+		Version:          version,
+		Server:           server,
+		Parameterization: parameterization,
+	}
+}
+
+// pluginMetadataCache memoizes the pulumi-plugin.json files of installed Julia
+// packages so repeated plugin detection doesn't hit the depot again.
+type pluginMetadataCache struct {
+	mu      sync.Mutex
+	entries map[string]*plugin.PulumiPluginJSON
+}
+
+func newPluginMetadataCache() *pluginMetadataCache {
+	return &pluginMetadataCache{entries: map[string]*plugin.PulumiPluginJSON{}}
+}
+
+// lookup returns the pulumi-plugin.json of the package described by entry, or
+// nil if the package can't be located in depots or doesn't ship one.
+func (c *pluginMetadataCache) lookup(dir, pkg string, entry manifestEntry, depots []string) *plugin.PulumiPluginJSON {
+	if depots == nil {
+		depots = juliaDepots()
+	}
+	// Registry packages are immutable once installed, so they can be cached by
+	// their tree hash. Dev'd packages can change at any time and are always
+	// read afresh.
+	key := ""
+	if c != nil && entry.Path == "" && entry.GitTreeSha1 != "" {
+		key = pkg + "@" + entry.UUID + "/" + entry.GitTreeSha1 +
+			string(os.PathListSeparator) + strings.Join(depots, string(os.PathListSeparator))
+		c.mu.Lock()
+		info, ok := c.entries[key]
+		c.mu.Unlock()
+		if ok {
+			return info
+		}
+	}
+
+	var info *plugin.PulumiPluginJSON
+	if pkgDir := packageDirectory(dir, pkg, entry, depots); pkgDir != "" {
+		path := filepath.Join(pkgDir, "pulumi-plugin.json")
+		loaded, err := plugin.LoadPulumiPluginJSON(path)
+		switch {
+		case err == nil:
+			info = loaded
+		case !os.IsNotExist(err):
+			logging.V(5).Infof("GetRequiredPlugins: ignoring %s: %v", path, err)
+		}
+	}
+
+	if key != "" {
+		c.mu.Lock()
+		c.entries[key] = info
+		c.mu.Unlock()
+	}
+	return info
+}
+
+// maxIncludeDepth bounds how deeply nested include() calls are followed when
+// scanning sources.
+const maxIncludeDepth = 16
+
+// sourceScanWorkers bounds how many source files are scanned at once.
+var sourceScanWorkers = min(runtime.NumCPU(), 8)
+
+// scanSource returns the packages loaded by the Julia source file at path and
+// by the files it includes, level by level. Missing, unreadable or oversized
+// files yield no packages. Scanning stops once the scan timeout elapses,
+// returning the packages found so far.
+func (d *pluginDetector) scanSource(path string) []string {
+	if d.maxSourceBytes <= 0 {
+		return nil
+	}
+	ctx := context.Background()
+	if d.scanTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.scanTimeout)
+		defer cancel()
+	}
+
+	var packages []string
+	visited := map[string]bool{}
+	level := []string{path}
+	for depth := 0; len(level) > 0; depth++ {
+		var files []string
+		for _, path := range level {
+			if abs, err := filepath.Abs(path); err == nil {
+				path = abs
+			}
+			if visited[path] {
+				continue
+			}
+			visited[path] = true
+			// Record missing files too, so creating one invalidates cached
+			// results.
+			d.sources = append(d.sources, path)
+			files = append(files, path)
+		}
+
+		scans := d.scanFiles(ctx, files)
+		if ctx.Err() != nil {
+			d.truncated = true
+			logging.V(3).Infof("GetRequiredPlugins: stopped scanning sources after %s, "+
+				"plugins loaded by files not scanned yet are not detected", d.scanTimeout)
+		}
+		level = nil
+		for i, source := range scans {
+			packages = append(packages, source.Imports...)
+			if len(source.Includes) == 0 || d.truncated {
+				continue
+			}
+			if depth >= maxIncludeDepth {
+				logging.V(5).Infof("GetRequiredPlugins: not following includes of %s beyond depth %d", files[i], depth)
+				continue
+			}
+			for _, include := range source.Includes {
+				// include() resolves relative paths against the including
+				// file.
+				if !filepath.IsAbs(include) {
+					include = filepath.Join(filepath.Dir(files[i]), include)
+				}
+				level = append(level, include)
+			}
+		}
+	}
+	return packages
+}
+
+// scanFiles scans the source files at paths with a bounded number of
+// workers until ctx is done, returning what was found in each.
+func (d *pluginDetector) scanFiles(ctx context.Context, paths []string) []juliaSourceInfo {
+	scans := make([]juliaSourceInfo, len(paths))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(sourceScanWorkers, len(paths)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				scans[i] = d.scanFile(paths[i])
+			}
+		}()
+	}
+	for i := range paths {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case next <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(next)
+	wg.Wait()
+	return scans
+}
+
+// scanFile returns what the Julia source file at path loads and includes.
+func (d *pluginDetector) scanFile(path string) juliaSourceInfo {
+	info, err := os.Stat(path)
+	if err != nil {
+		return juliaSourceInfo{}
+	}
+	if info.Size() > d.maxSourceBytes {
+		logging.V(5).Infof("GetRequiredPlugins: not scanning %s (%d bytes exceeds limit of %d)",
+			path, info.Size(), d.maxSourceBytes)
+		return juliaSourceInfo{}
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		logging.V(5).Infof("GetRequiredPlugins: failed to read %s: %v", path, err)
+		return juliaSourceInfo{}
+	}
+
+	source := scanJuliaSource(src)
+	if source.DynamicIncludes > 0 {
+		logging.V(5).Infof("GetRequiredPlugins: skipping %d non-literal include() calls in %s",
+			source.DynamicIncludes, path)
+	}
+	return source
+}
+
+// devPluginsEnvVar names the environment variable pinning the plugin versions
+// of provider packages developed from a local checkout, as a comma separated
+// list of plugin=version pairs.
+const devPluginsEnvVar = "PULUMI_DEV_PLUGINS"
+
+// devPluginVersions parses the plugin versions set by devPluginsEnvVar.
+func devPluginVersions() (map[string]string, error) {
+	versions := map[string]string{}
+	for _, item := range strings.Split(os.Getenv(devPluginsEnvVar), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, version, ok := strings.Cut(item, "=")
+		name, version = strings.TrimSpace(name), strings.TrimPrefix(strings.TrimSpace(version), "v")
+		if !ok || name == "" || !isVersionString(version) {
+			return nil, fmt.Errorf("invalid %s entry %q: expected plugin=version", devPluginsEnvVar, item)
+		}
+		versions[name] = "v" + version
+	}
+	return versions, nil
+}
+
+// resolvedPluginVersion returns the plugin version for pkg implied by the
+// project environment: the version resolved in the manifest if there is one,
+// and otherwise the lower bound of the package's [compat] entry.
+func resolvedPluginVersion(pkg string, project *juliaProject, manifest juliaManifest) string {
+	var spec juliaVersionSpec
+	if project != nil {
+		if compat, ok := project.Compat[pkg]; ok {
+			parsed, err := parseJuliaVersionSpec(compat)
+			if err != nil {
+				logging.V(5).Infof("GetRequiredPlugins: ignoring compat entry for %s: %v", pkg, err)
+			} else {
+				spec = parsed
+			}
+		}
+	}
+
+	if entry, ok := manifest[pkg]; ok {
+		if entry.Path != "" {
+			// Dev'd packages don't correspond to a published plugin release.
+			return ""
+		}
+		if version := pluginVersion(entry.Version); version != "" {
+			if v, err := semver.ParseTolerant(version); err == nil && spec != nil && !spec.contains(v) {
+				logging.V(5).Infof("GetRequiredPlugins: manifest version %s of %s does not satisfy compat %v; "+
+					"using the manifest version", version, pkg, project.Compat[pkg])
+			}
+			return version
+		}
+	}
+
+	if spec != nil {
+		if lower := spec.lowerBound(); !lower.Equals(semver.Version{}) {
+			return "v" + lower.String()
+		}
+	}
+	return ""
+}
+
+// pluginDownloadSchemes are the URL schemes the engine can download plugins
+// from.
+var pluginDownloadSchemes = []string{"https://", "http://", "github://", "gitlab://"}
+
+// packageKey identifies the package a dependency provides. Parameterized
+// packages sharing a base plugin are distinct packages.
+func packageKey(dep *pulumirpc.PackageDependency) string {
+	if p := dep.GetParameterization(); p != nil {
+		return dep.GetName() + "/" + p.GetName()
+	}
+	return dep.GetName()
+}
+
+// mergePackageDependencies merges dependencies on the same package, which
+// arise when several Julia packages require the same plugin. The highest
+// requested version wins; a version always wins over no version. Requests
+// for different major versions can't both be satisfied, so they are merged
+// the same way but with a warning. The order of first appearance is kept.
+func mergePackageDependencies(deps []*pulumirpc.PackageDependency) []*pulumirpc.PackageDependency {
+	merged := make([]*pulumirpc.PackageDependency, 0, len(deps))
+	index := map[string]int{}
+	for _, dep := range deps {
+		key := packageKey(dep)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(merged)
+			merged = append(merged, dep)
+			continue
+		}
+		merged[i] = mergePackageDependency(merged[i], dep)
+	}
+	return merged
+}
+
+func mergePackageDependency(a, b *pulumirpc.PackageDependency) *pulumirpc.PackageDependency {
+	va, errA := semver.ParseTolerant(a.GetVersion())
+	vb, errB := semver.ParseTolerant(b.GetVersion())
+
+	winner, loser := a, b
+	switch {
+	case errA != nil && errB != nil:
+	case errA != nil:
+		winner, loser = b, a
+	case errB != nil:
+	default:
+		if va.Major != vb.Major {
+			logging.Warningf("plugin %s is required at incompatible versions %s and %s; using the highest",
+				packageKey(a), a.GetVersion(), b.GetVersion())
+		}
+		if vb.GT(va) {
+			winner, loser = b, a
+		}
+	}
+	if winner.GetServer() == "" && loser.GetServer() != "" {
+		merged := proto.Clone(winner).(*pulumirpc.PackageDependency)
+		merged.Server = loser.GetServer()
+		return merged
+	}
+	return winner
+}
+
+// validatePluginDownloadURL checks that url is a plugin download URL the
+// engine understands. An empty URL selects the default plugin registry.
+func validatePluginDownloadURL(url string) error {
+	if url == "" {
+		return nil
+	}
+	for _, scheme := range pluginDownloadSchemes {
+		if strings.HasPrefix(url, scheme) && len(url) > len(scheme) {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported plugin download URL %q (expected one of %s)",
+		url, strings.Join(pluginDownloadSchemes, ", "))
+}
+
+// withExplicitPlugins merges the plugins declared in the runtime options with
+// the detected ones. Explicit plugins are reported verbatim and replace any
+// detected plugin of the same name.
+func withExplicitPlugins(explicit []pluginOption, detected []*pulumirpc.PackageDependency) []*pulumirpc.PackageDependency {
+	if len(explicit) == 0 {
+		return detected
+	}
+
+	plugins := make([]*pulumirpc.PackageDependency, 0, len(explicit)+len(detected))
+	declared := map[string]bool{}
+	for _, p := range explicit {
+		version := p.Version
+		if version != "" {
+			version = "v" + strings.TrimPrefix(version, "v")
+		}
+		plugins = append(plugins, &pulumirpc.PackageDependency{
+			Name:    p.Name,
+			Kind:    "resource",
+			Version: version,
+			Server:  p.PluginDownloadURL,
+		})
+		declared[p.Name] = true
+	}
+	for _, dep := range detected {
+		if declared[dep.GetName()] && dep.GetParameterization() == nil {
+			logging.V(5).Infof("GetRequiredPlugins: using explicitly declared %s plugin instead of detected %s",
+				dep.GetName(), dep.GetVersion())
+			continue
+		}
+		plugins = append(plugins, dep)
+	}
+	return plugins
+}
+
+// pluginDependencies converts package dependencies into the plugin
+// dependencies reported to engines that predate GetRequiredPackages. Those
+// engines only need the base plugin of parameterized packages, so packages
+// sharing the same plugin are reported once.
+func pluginDependencies(packages []*pulumirpc.PackageDependency) []*pulumirpc.PluginDependency {
+	plugins := make([]*pulumirpc.PluginDependency, 0, len(packages))
+	seen := map[string]bool{}
+	for _, pkg := range packages {
+		key := pkg.GetName() + "@" + pkg.GetVersion() + "@" + pkg.GetServer()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		plugins = append(plugins, &pulumirpc.PluginDependency{
+			Name:    pkg.GetName(),
+			Kind:    pkg.GetKind(),
+			Version: pkg.GetVersion(),
+			Server:  pkg.GetServer(),
+		})
+	}
+	return plugins
+}
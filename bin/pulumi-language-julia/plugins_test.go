@@ -0,0 +1,634 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func pluginNames[T interface{ GetName() string }](plugins []T) []string {
+	names := make([]string, 0, len(plugins))
+	for _, p := range plugins {
+		names = append(names, p.GetName())
+	}
+	return names
+}
+
+func newTestHost() *juliaLanguageHost {
+	return newJuliaLanguageHost("127.0.0.1:0", "", "", defaultMaxSourceScanBytes)
+}
+
+func TestGetRequiredPluginsFromProjectToml(t *testing.T) {
+	host := newTestHost()
+	resp, err := host.GetRequiredPlugins(context.Background(), &pulumirpc.GetRequiredPluginsRequest{
+		Program: "testdata/project",
+	})
+	if err != nil {
+		t.Fatalf("GetRequiredPlugins: %v", err)
+	}
+
+	names := pluginNames(resp.GetPlugins())
+	want := []string{"aws", "random"}
+	if len(names) != len(want) {
+		t.Fatalf("expected plugins %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("expected plugins %v, got %v", want, names)
+		}
+		if kind := resp.GetPlugins()[i].GetKind(); kind != "resource" {
+			t.Errorf("plugin %s: expected kind resource, got %s", names[i], kind)
+		}
+	}
+}
+
+func TestGetRequiredPluginsWithoutProjectToml(t *testing.T) {
+	host := newTestHost()
+	resp, err := host.GetRequiredPlugins(context.Background(), &pulumirpc.GetRequiredPluginsRequest{
+		Program: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("GetRequiredPlugins: %v", err)
+	}
+	if len(resp.GetPlugins()) != 0 {
+		t.Fatalf("expected no plugins, got %v", pluginNames(resp.GetPlugins()))
+	}
+}
+
+func TestGetRequiredPluginsPinsManifestVersions(t *testing.T) {
+	host := newTestHost()
+	resp, err := host.GetRequiredPlugins(context.Background(), &pulumirpc.GetRequiredPluginsRequest{
+		Program: "testdata/manifest",
+	})
+	if err != nil {
+		t.Fatalf("GetRequiredPlugins: %v", err)
+	}
+
+	versions := map[string]string{}
+	for _, p := range resp.GetPlugins() {
+		versions[p.GetName()] = p.GetVersion()
+	}
+	if v := versions["aws"]; v != "v6.40.0" {
+		t.Errorf("expected aws to be pinned to v6.40.0, got %q", v)
+	}
+	// PulumiRandom is dev'd from a local path and must not be pinned.
+	if v, ok := versions["random"]; !ok || v != "" {
+		t.Errorf("expected random to be reported without a version, got %q (present=%v)", v, ok)
+	}
+}
+
+func TestGetRequiredPluginsIgnoresMalformedManifest(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "Project.toml"), "[deps]\nPulumiAWS = \"5f3c2e7a-0b1d-4a6e-8d2c-1e4f9b7a3c21\"\n")
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), "[[deps.PulumiAWS\nversion = ")
+
+	detector := &pluginDetector{maxSourceBytes: defaultMaxSourceScanBytes}
+	plugins, err := detector.detect(dir)
+	if err != nil {
+		t.Fatalf("detectPlugins: %v", err)
+	}
+	if len(plugins) != 1 || plugins[0].GetName() != "aws" || plugins[0].GetVersion() != "" {
+		t.Fatalf("expected a single unversioned aws plugin, got %v", plugins)
+	}
+}
+
+func TestReadJuliaManifestLegacyFormat(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), `[[PulumiAWS]]
+uuid = "5f3c2e7a-0b1d-4a6e-8d2c-1e4f9b7a3c21"
+version = "6.38.1"
+`)
+
+	manifest, err := readJuliaManifest(dir)
+	if err != nil {
+		t.Fatalf("readJuliaManifest: %v", err)
+	}
+	if v := manifest["PulumiAWS"].Version; v != "6.38.1" {
+		t.Fatalf("expected version 6.38.1, got %q", v)
+	}
+}
+
+func TestPluginVersion(t *testing.T) {
+	tests := []struct {
+		packageVersion string
+		expected       string
+	}{
+		{"6.40.0", "v6.40.0"},
+		{"v6.40.0", "v6.40.0"},
+		{"6.40.1+6.40.0", "v6.40.0"},
+		{"6.40.1+build.3", "v6.40.1"},
+		{"1.0.0-alpha.1", "v1.0.0-alpha.1"},
+		{"6.40", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if actual := pluginVersion(tt.packageVersion); actual != tt.expected {
+			t.Errorf("pluginVersion(%q) = %q, expected %q", tt.packageVersion, actual, tt.expected)
+		}
+	}
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// installPackage creates an installed copy of a Julia package in depot and
+// returns the manifest entry describing it.
+func installPackage(t *testing.T, depot, name, uuid, treeHash, pluginJSON string) manifestEntry {
+	t.Helper()
+	slug, ok := versionSlug(uuid, treeHash)
+	if !ok {
+		t.Fatalf("invalid package identity %s/%s", uuid, treeHash)
+	}
+	dir := filepath.Join(depot, "packages", name, slug)
+	writeFile(t, filepath.Join(dir, "Project.toml"), "name = \""+name+"\"\n")
+	if pluginJSON != "" {
+		writeFile(t, filepath.Join(dir, "pulumi-plugin.json"), pluginJSON)
+	}
+	return manifestEntry{UUID: uuid, GitTreeSha1: treeHash}
+}
+
+func TestGetRequiredPluginsHonorsPulumiPluginJSON(t *testing.T) {
+	depot := t.TempDir()
+	t.Setenv("JULIA_DEPOT_PATH", depot)
+
+	installPackage(t, depot, "PulumiAWS",
+		"5f3c2e7a-0b1d-4a6e-8d2c-1e4f9b7a3c21", "2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c",
+		`{"resource": true, "name": "aws", "version": "6.41.0", "server": "https://example.com/plugins"}`)
+	installPackage(t, depot, "AcmeProvider",
+		"c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f", "3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d",
+		`{"resource": true, "name": "acme", "version": "0.3.0"}`)
+	installPackage(t, depot, "PulumiRandom",
+		"b2a4c6d8-1e3f-4a5b-9c7d-2f4e6a8b0c13", "4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e",
+		`{"resource": false}`)
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "Project.toml"), `[deps]
+AcmeProvider = "c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f"
+PulumiAWS = "5f3c2e7a-0b1d-4a6e-8d2c-1e4f9b7a3c21"
+PulumiRandom = "b2a4c6d8-1e3f-4a5b-9c7d-2f4e6a8b0c13"
+`)
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), `manifest_format = "2.0"
+
+[[deps.AcmeProvider]]
+git-tree-sha1 = "3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d"
+uuid = "c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f"
+version = "0.3.2"
+
+[[deps.PulumiAWS]]
+git-tree-sha1 = "2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c"
+uuid = "5f3c2e7a-0b1d-4a6e-8d2c-1e4f9b7a3c21"
+version = "6.40.0"
+
+[[deps.PulumiRandom]]
+git-tree-sha1 = "4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e"
+uuid = "b2a4c6d8-1e3f-4a5b-9c7d-2f4e6a8b0c13"
+version = "4.16.3"
+`)
+
+	host := newTestHost()
+	resp, err := host.GetRequiredPlugins(context.Background(), &pulumirpc.GetRequiredPluginsRequest{
+		Program: dir,
+	})
+	if err != nil {
+		t.Fatalf("GetRequiredPlugins: %v", err)
+	}
+
+	plugins := resp.GetPlugins()
+	if len(plugins) != 2 {
+		t.Fatalf("expected acme and aws plugins, got %v", pluginNames(plugins))
+	}
+	acme, aws := plugins[0], plugins[1]
+	if acme.GetName() != "acme" || acme.GetVersion() != "v0.3.0" || acme.GetServer() != "" {
+		t.Errorf("unexpected acme plugin: %v", acme)
+	}
+	if aws.GetName() != "aws" || aws.GetVersion() != "v6.41.0" || aws.GetServer() != "https://example.com/plugins" {
+		t.Errorf("unexpected aws plugin: %v", aws)
+	}
+}
+
+func TestGetRequiredPluginsSearchesDepotOption(t *testing.T) {
+	t.Setenv("JULIA_DEPOT_PATH", t.TempDir())
+	dir := t.TempDir()
+	installPackage(t, filepath.Join(dir, ".julia-depot"), "AcmeProvider",
+		"c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f", "3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d",
+		`{"resource": true, "name": "acme", "version": "0.3.0"}`)
+	writeFile(t, filepath.Join(dir, "Project.toml"), `[deps]
+AcmeProvider = "c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f"
+`)
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), `manifest_format = "2.0"
+
+[[deps.AcmeProvider]]
+git-tree-sha1 = "3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d"
+uuid = "c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f"
+version = "0.3.2"
+`)
+
+	resp, err := newTestHost().GetRequiredPlugins(context.Background(), &pulumirpc.GetRequiredPluginsRequest{
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory: dir, ProgramDirectory: dir, EntryPoint: ".",
+			Options: mustStruct(t, map[string]interface{}{"depot": ".julia-depot"}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("GetRequiredPlugins: %v", err)
+	}
+	if names := pluginNames(resp.GetPlugins()); len(names) != 1 || names[0] != "acme" {
+		t.Errorf("expected the acme plugin from the project depot, got %v", names)
+	}
+}
+
+func TestPluginMetadataCache(t *testing.T) {
+	depot := t.TempDir()
+	t.Setenv("JULIA_DEPOT_PATH", depot)
+
+	entry := installPackage(t, depot, "AcmeProvider",
+		"c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f", "3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d",
+		`{"resource": true, "name": "acme"}`)
+
+	cache := newPluginMetadataCache()
+	if info := cache.lookup(t.TempDir(), "AcmeProvider", entry, nil); info == nil || info.Name != "acme" {
+		t.Fatalf("expected to find the acme plugin, got %v", info)
+	}
+
+	// Installed packages are immutable, so the second lookup must not go back
+	// to the depot.
+	if err := os.RemoveAll(filepath.Join(depot, "packages")); err != nil {
+		t.Fatal(err)
+	}
+	if info := cache.lookup(t.TempDir(), "AcmeProvider", entry, nil); info == nil || info.Name != "acme" {
+		t.Fatalf("expected a cached lookup, got %v", info)
+	}
+}
+
+func TestGetRequiredPluginsWithExplicitPlugins(t *testing.T) {
+	host := newTestHost()
+	resp, err := host.GetRequiredPlugins(context.Background(), &pulumirpc.GetRequiredPluginsRequest{
+		Program: "testdata/manifest",
+		Info: &pulumirpc.ProgramInfo{
+			Options: mustStruct(t, map[string]interface{}{
+				"plugins": []interface{}{
+					map[string]interface{}{"name": "aws", "version": "6.45.0"},
+					map[string]interface{}{"name": "acme", "version": "v0.1.0"},
+				},
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("GetRequiredPlugins: %v", err)
+	}
+
+	var actual []string
+	for _, p := range resp.GetPlugins() {
+		actual = append(actual, p.GetName()+"@"+p.GetVersion())
+	}
+	expected := []string{"aws@v6.45.0", "acme@v0.1.0", "random@"}
+	if strings.Join(actual, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected plugins %v, got %v", expected, actual)
+	}
+}
+
+func TestGetRequiredPluginsUsesCompatBounds(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "Project.toml"), `[deps]
+PulumiAWS = "5f3c2e7a-0b1d-4a6e-8d2c-1e4f9b7a3c21"
+PulumiGCP = "a1b2c3d4-e5f6-4a7b-8c9d-0e1f2a3b4c5d"
+PulumiRandom = "b2a4c6d8-1e3f-4a5b-9c7d-2f4e6a8b0c13"
+PulumiTLS = "d4e5f6a7-b8c9-4d0e-9f1a-2b3c4d5e6f7a"
+
+[compat]
+PulumiAWS = "6.40"
+PulumiGCP = "~7.2.1"
+PulumiRandom = "4.16"
+PulumiTLS = "< 5"
+`)
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), `manifest_format = "2.0"
+
+[[deps.PulumiRandom]]
+uuid = "b2a4c6d8-1e3f-4a5b-9c7d-2f4e6a8b0c13"
+version = "4.16.3"
+`)
+
+	detector := &pluginDetector{}
+	plugins, err := detector.detect(dir)
+	if err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+
+	var actual []string
+	for _, p := range plugins {
+		actual = append(actual, p.GetName()+"@"+p.GetVersion())
+	}
+	// The manifest takes precedence over compat, and a compat entry without a
+	// meaningful lower bound doesn't pin anything.
+	expected := []string{"aws@v6.40.0", "gcp@v7.2.1", "random@v4.16.3", "tls@"}
+	if strings.Join(actual, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected plugins %v, got %v", expected, actual)
+	}
+}
+
+func TestPluginServerSurvivesMerge(t *testing.T) {
+	depot := t.TempDir()
+	t.Setenv("JULIA_DEPOT_PATH", depot)
+
+	installPackage(t, depot, "AcmeProvider",
+		"c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f", "3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d",
+		`{"resource": true, "name": "acme", "server": "github://api.github.com/acme"}`)
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "Project.toml"), `[deps]
+AcmeProvider = "c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f"
+Internal = "e5f6a7b8-c9d0-4e1f-8a2b-3c4d5e6f7a8b"
+PulumiAWS = "5f3c2e7a-0b1d-4a6e-8d2c-1e4f9b7a3c21"
+`)
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), `manifest_format = "2.0"
+
+[[deps.AcmeProvider]]
+git-tree-sha1 = "3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d"
+uuid = "c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f"
+version = "0.3.2"
+`)
+	// The mapping file only overrides the version of AcmeProvider, so the
+	// server from its pulumi-plugin.json must be kept.
+	writeFile(t, filepath.Join(dir, "pulumi-plugins.json"), `{
+  "AcmeProvider": {"version": "0.4.0"},
+  "Internal": {"name": "internal", "downloadURL": "https://artifacts.example.com/pulumi"}
+}`)
+
+	host := newTestHost()
+	resp, err := host.GetRequiredPlugins(context.Background(), &pulumirpc.GetRequiredPluginsRequest{
+		Program: dir,
+		Info: &pulumirpc.ProgramInfo{
+			Options: mustStruct(t, map[string]interface{}{
+				"plugins": []interface{}{
+					map[string]interface{}{"name": "gitlab", "pluginDownloadURL": "gitlab://gitlab.example.com/tools"},
+				},
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("GetRequiredPlugins: %v", err)
+	}
+
+	servers := map[string]string{}
+	for _, p := range resp.GetPlugins() {
+		servers[p.GetName()] = p.GetServer()
+	}
+	expected := map[string]string{
+		"gitlab":   "gitlab://gitlab.example.com/tools",
+		"acme":     "github://api.github.com/acme",
+		"internal": "https://artifacts.example.com/pulumi",
+		// No server means the engine uses the default plugin registry.
+		"aws": "",
+	}
+	if len(servers) != len(expected) {
+		t.Fatalf("expected plugins %v, got %v", expected, servers)
+	}
+	for name, server := range expected {
+		if actual, ok := servers[name]; !ok || actual != server {
+			t.Errorf("plugin %s: expected server %q, got %q", name, server, actual)
+		}
+	}
+}
+
+func TestValidatePluginDownloadURL(t *testing.T) {
+	valid := []string{"", "https://example.com/plugins", "http://localhost:8080", "github://api.github.com/acme",
+		"gitlab://gitlab.example.com/project"}
+	for _, url := range valid {
+		if err := validatePluginDownloadURL(url); err != nil {
+			t.Errorf("expected %q to be accepted, got %v", url, err)
+		}
+	}
+	invalid := []string{"example.com/plugins", "ftp://example.com", "https://"}
+	for _, url := range invalid {
+		if err := validatePluginDownloadURL(url); err == nil {
+			t.Errorf("expected %q to be rejected", url)
+		}
+	}
+}
+
+func TestGetRequiredPackagesAgreesWithGetRequiredPlugins(t *testing.T) {
+	host := newTestHost()
+	options := mustStruct(t, map[string]interface{}{
+		"plugins": []interface{}{
+			map[string]interface{}{"name": "acme", "version": "0.1.0", "pluginDownloadURL": "github://api.github.com/acme"},
+		},
+	})
+
+	pluginsResp, err := host.GetRequiredPlugins(context.Background(), &pulumirpc.GetRequiredPluginsRequest{
+		Program: "testdata/manifest",
+		Info:    &pulumirpc.ProgramInfo{ProgramDirectory: "testdata/manifest", Options: options},
+	})
+	if err != nil {
+		t.Fatalf("GetRequiredPlugins: %v", err)
+	}
+	packagesResp, err := host.GetRequiredPackages(context.Background(), &pulumirpc.GetRequiredPackagesRequest{
+		Info: &pulumirpc.ProgramInfo{ProgramDirectory: "testdata/manifest", Options: options},
+	})
+	if err != nil {
+		t.Fatalf("GetRequiredPackages: %v", err)
+	}
+
+	plugins, packages := pluginsResp.GetPlugins(), packagesResp.GetPackages()
+	if len(plugins) != 3 || len(packages) != len(plugins) {
+		t.Fatalf("expected three plugins and matching packages, got %v and %v", plugins, packages)
+	}
+	for i := range plugins {
+		plugin, pkg := plugins[i], packages[i]
+		if plugin.GetName() != pkg.GetName() || plugin.GetKind() != pkg.GetKind() ||
+			plugin.GetVersion() != pkg.GetVersion() || plugin.GetServer() != pkg.GetServer() {
+			t.Errorf("plugin %v and package %v disagree", plugin, pkg)
+		}
+	}
+	if packages[0].GetServer() != "github://api.github.com/acme" || packages[1].GetVersion() != "v6.40.0" {
+		t.Errorf("unexpected packages: %v", packages)
+	}
+}
+
+func TestGetRequiredPackagesReportsParameterization(t *testing.T) {
+	depot := t.TempDir()
+	t.Setenv("JULIA_DEPOT_PATH", depot)
+
+	entry := installPackage(t, depot, "PulumiNetlify",
+		"5f3c2e7a-0b1d-4a6e-8d2c-1e4f9b7a3c21", "2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c",
+		`{"resource": true, "name": "terraform-provider", "version": "0.8.0",
+  "parameterization": {"name": "netlify", "version": "0.1.0", "value": "bmV0bGlmeQ=="}}`)
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "Project.toml"),
+		"[deps]\nPulumiNetlify = \""+entry.UUID+"\"\n")
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), `manifest_format = "2.0"
+
+[[deps.PulumiNetlify]]
+uuid = "`+entry.UUID+`"
+git-tree-sha1 = "`+entry.GitTreeSha1+`"
+version = "0.1.0"
+`)
+
+	host := newTestHost()
+	resp, err := host.GetRequiredPackages(context.Background(), &pulumirpc.GetRequiredPackagesRequest{
+		Info: &pulumirpc.ProgramInfo{ProgramDirectory: dir},
+	})
+	if err != nil {
+		t.Fatalf("GetRequiredPackages: %v", err)
+	}
+	if len(resp.GetPackages()) != 1 {
+		t.Fatalf("expected one package, got %v", pluginNames(resp.GetPackages()))
+	}
+	pkg := resp.GetPackages()[0]
+	if pkg.GetName() != "terraform-provider" || pkg.GetVersion() != "v0.8.0" {
+		t.Errorf("expected the base plugin terraform-provider v0.8.0, got %v", pkg)
+	}
+	param := pkg.GetParameterization()
+	if param.GetName() != "netlify" || param.GetVersion() != "v0.1.0" || string(param.GetValue()) != "netlify" {
+		t.Errorf("unexpected parameterization: %v", param)
+	}
+}
+
+func TestMergePackageDependencies(t *testing.T) {
+	dep := func(name, version, server string) *pulumirpc.PackageDependency {
+		return &pulumirpc.PackageDependency{Name: name, Kind: "resource", Version: version, Server: server}
+	}
+	tests := []struct {
+		name     string
+		deps     []*pulumirpc.PackageDependency
+		expected []*pulumirpc.PackageDependency
+	}{
+		{
+			name:     "highest compatible version",
+			deps:     []*pulumirpc.PackageDependency{dep("aws", "v6.40.0", ""), dep("random", "v4.0.0", ""), dep("aws", "v6.50.0", "")},
+			expected: []*pulumirpc.PackageDependency{dep("aws", "v6.50.0", ""), dep("random", "v4.0.0", "")},
+		},
+		{
+			name:     "version wins over none",
+			deps:     []*pulumirpc.PackageDependency{dep("aws", "", ""), dep("aws", "v6.40.0", "")},
+			expected: []*pulumirpc.PackageDependency{dep("aws", "v6.40.0", "")},
+		},
+		{
+			name:     "none loses to version",
+			deps:     []*pulumirpc.PackageDependency{dep("aws", "v6.40.0", ""), dep("aws", "", "")},
+			expected: []*pulumirpc.PackageDependency{dep("aws", "v6.40.0", "")},
+		},
+		{
+			name:     "incompatible majors",
+			deps:     []*pulumirpc.PackageDependency{dep("aws", "v6.40.0", ""), dep("aws", "v5.43.0", "")},
+			expected: []*pulumirpc.PackageDependency{dep("aws", "v6.40.0", "")},
+		},
+		{
+			name:     "server kept",
+			deps:     []*pulumirpc.PackageDependency{dep("acme", "v1.0.0", "https://example.com"), dep("acme", "v1.1.0", "")},
+			expected: []*pulumirpc.PackageDependency{dep("acme", "v1.1.0", "https://example.com")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := mergePackageDependencies(tt.deps)
+			if len(merged) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", pluginNames(tt.expected), pluginNames(merged))
+			}
+			for i := range merged {
+				if !proto.Equal(merged[i], tt.expected[i]) {
+					t.Errorf("entry %d: expected %v, got %v", i, tt.expected[i], merged[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDetectMergesDuplicatePlugins(t *testing.T) {
+	depot := t.TempDir()
+	t.Setenv("JULIA_DEPOT_PATH", depot)
+
+	aws := installPackage(t, depot, "PulumiAWS",
+		"5f3c2e7a-0b1d-4a6e-8d2c-1e4f9b7a3c21", "2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c",
+		`{"resource": true, "name": "aws", "version": "6.40.0"}`)
+	components := installPackage(t, depot, "AcmeComponents",
+		"c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f", "3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d",
+		`{"resource": true, "name": "aws", "version": "6.45.0"}`)
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "Project.toml"), "[deps]\n"+
+		"AcmeComponents = \""+components.UUID+"\"\n"+
+		"PulumiAWS = \""+aws.UUID+"\"\n")
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), `manifest_format = "2.0"
+
+[[deps.AcmeComponents]]
+uuid = "`+components.UUID+`"
+git-tree-sha1 = "`+components.GitTreeSha1+`"
+version = "1.0.0"
+
+[[deps.PulumiAWS]]
+uuid = "`+aws.UUID+`"
+git-tree-sha1 = "`+aws.GitTreeSha1+`"
+version = "6.40.0"
+`)
+
+	detector := &pluginDetector{}
+	plugins, err := detector.detect(dir)
+	if err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+	if len(plugins) != 1 || plugins[0].GetName() != "aws" || plugins[0].GetVersion() != "v6.45.0" {
+		t.Errorf("expected a single aws v6.45.0 plugin, got %v", plugins)
+	}
+}
+
+func TestDevelopedProviderPackage(t *testing.T) {
+	t.Setenv("JULIA_DEPOT_PATH", t.TempDir())
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "Project.toml"), `[deps]
+PulumiFoo = "c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f"
+
+[compat]
+PulumiFoo = "0.3"
+`)
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), `manifest_format = "2.0"
+
+[[deps.PulumiFoo]]
+uuid = "c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f"
+path = "../pulumi-foo-jl"
+version = "0.3.0"
+`)
+	writeFile(t, filepath.Join(dir, "..", "pulumi-foo-jl", "pulumi-plugin.json"),
+		`{"resource": true, "name": "foo", "version": "0.3.0-dev"}`)
+
+	detect := func() []*pulumirpc.PackageDependency {
+		t.Helper()
+		detector := &pluginDetector{}
+		plugins, err := detector.detect(dir)
+		if err != nil {
+			t.Fatalf("detect: %v", err)
+		}
+		if len(plugins) != 1 || plugins[0].GetName() != "foo" {
+			t.Fatalf("expected the foo plugin, got %v", pluginNames(plugins))
+		}
+		return plugins
+	}
+
+	if version := detect()[0].GetVersion(); version != "" {
+		t.Errorf("expected no version for a dev'd package, got %q", version)
+	}
+
+	t.Setenv("PULUMI_DEV_PLUGINS", "bar=1.0.0, foo=0.3.1-alpha.1")
+	if version := detect()[0].GetVersion(); version != "v0.3.1-alpha.1" {
+		t.Errorf("expected the version from PULUMI_DEV_PLUGINS, got %q", version)
+	}
+
+	t.Setenv("PULUMI_DEV_PLUGINS", "foo")
+	detector := &pluginDetector{}
+	if _, err := detector.detect(dir); err == nil || !strings.Contains(err.Error(), "PULUMI_DEV_PLUGINS") {
+		t.Errorf("expected an error about PULUMI_DEV_PLUGINS, got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+// precompileScript precompiles the packages of the active project.
+const precompileScript = "using Pkg; Pkg.precompile()"
+
+// precompileLabel prefixes each line of precompilation output, telling it
+// apart from the output of the program.
+const precompileLabel = "[precompile] "
+
+// The project and manifest files that decide what precompiling a project
+// does. Projects without a manifest have nothing to precompile.
+var (
+	precompileProjects  = []string{"JuliaProject.toml", "Project.toml"}
+	precompileManifests = []string{"JuliaManifest.toml", "Manifest.toml"}
+)
+
+// precompileProject precompiles the packages of prog, whose environment is in
+// dir, with the precompile runtime option before it runs, streaming Pkg's progress to w, so that the
+// first run after dependencies change doesn't sit silently for minutes. It
+// is skipped while the marker left in the depot by the last precompilation
+// shows that neither the project, its manifest nor julia have changed since.
+func precompileProject(
+	ctx context.Context, julia juliaCommand, prog juliaProgram, dir string, depots []string, w io.Writer,
+	gracePeriod, lockTimeout time.Duration,
+) error {
+	fingerprint, ok := precompileFingerprint(julia, dir)
+	if !ok {
+		logging.V(5).Infof("skipping precompilation of %s, which has no manifest", dir)
+		return nil
+	}
+	marker := precompileMarker(depots, dir)
+	precompiled := func() bool {
+		data, err := os.ReadFile(marker)
+		return err == nil && string(data) == fingerprint
+	}
+	if precompiled() {
+		logging.V(5).Infof("skipping precompilation of %s, unchanged since %s", dir, marker)
+		return nil
+	}
+	// Stacks sharing a depot precompile into it one at a time, and needn't
+	// precompile what another stack just did.
+	if len(depots) > 0 {
+		unlock, err := lockDepot(ctx, depots[0], &labelWriter{w: w, label: precompileLabel}, lockTimeout)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		if precompiled() {
+			logging.V(5).Infof("skipping precompilation of %s, precompiled meanwhile", dir)
+			return nil
+		}
+	}
+
+	logging.V(5).Infof("precompiling %s", dir)
+	fmt.Fprintf(w, "%sprecompiling the packages of %s before running the program\n", precompileLabel, dir)
+	var mu sync.Mutex
+	output := newLineWriter(&mu, &labelWriter{w: w, label: precompileLabel})
+	cmd := julia.command(ctx, "--project="+prog.project(), "-e", precompileScript)
+	cmd.Dir = dir
+	cmd.Stdout = output
+	cmd.Stderr = output
+	_, err := runProcess("Julia precompilation", cmd, gracePeriod)
+	output.Flush()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to precompile the packages of %s, see the output above: %w", dir, err)
+	}
+
+	if marker == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(marker), 0o755); err != nil {
+		logging.V(5).Infof("failed to record the precompilation of %s: %v", dir, err)
+		return nil
+	}
+	if err := os.WriteFile(marker, []byte(fingerprint), 0o644); err != nil {
+		logging.V(5).Infof("failed to record the precompilation of %s: %v", dir, err)
+	}
+	return nil
+}
+
+// precompileFingerprint identifies what precompiling the project in dir with
+// julia does: the julia executable and switches, and the contents of the
+// project and manifest files. It returns false if dir has no manifest.
+func precompileFingerprint(julia juliaCommand, dir string) (string, bool) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", julia.Path, strings.Join(julia.Args, "\x00"))
+	hasManifest := false
+	for _, name := range slices.Concat(precompileProjects, precompileManifests) {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		hasManifest = hasManifest || slices.Contains(precompileManifests, name)
+		fmt.Fprintf(h, "%s\x00%d\x00", name, len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), hasManifest
+}
+
+// precompileMarker returns the file recording the last precompilation of
+// the project in dir, in the first of depots, or "" if there is no depot.
+func precompileMarker(depots []string, dir string) string {
+	if len(depots) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(absPath(dir)))
+	return filepath.Join(depots[0], "pulumi", "precompiled", hex.EncodeToString(sum[:8]))
+}
+
+// labelWriter passes whole lines through to w, prefixing each with label.
+type labelWriter struct {
+	w     io.Writer
+	label string
+}
+
+func (l *labelWriter) Write(p []byte) (int, error) {
+	var b bytes.Buffer
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) > 0 {
+			b.WriteString(l.label)
+			b.Write(line)
+		}
+	}
+	if _, err := l.w.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
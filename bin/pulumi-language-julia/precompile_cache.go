@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+// corruptCachePatterns match the errors julia reports for cache files left
+// invalid, typically by an interrupted run, capturing the cache file.
+var corruptCachePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)cache file "?([^"\n]+?\.ji)"? is (?:invalid|corrupt)`),
+	regexp.MustCompile(`(?i)invalid (?:header in )?cache file "?([^"\n]+?\.ji)"?`),
+	regexp.MustCompile(`Failed to precompile \S+ \[[0-9a-f-]+\] to "?([^"\n]+?\.ji)"?`),
+}
+
+// corruptCacheFiles returns the cache files that julia reports in stderr as
+// invalid or as failing to precompile.
+func corruptCacheFiles(stderr string) []string {
+	stderr = ansiEscape.ReplaceAllString(stderr, "")
+	var files []string
+	for _, pattern := range corruptCachePatterns {
+		for _, m := range pattern.FindAllStringSubmatch(stderr, -1) {
+			// Paths are printed as Julia string literals, or abbreviated with
+			// a ~ for the home directory.
+			file := strings.ReplaceAll(m[1], `\\`, `\`)
+			if rest, ok := strings.CutPrefix(file, "~"); ok {
+				if home, err := os.UserHomeDir(); err == nil {
+					file = home + rest
+				}
+			}
+			if !slices.Contains(files, file) {
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
+
+// removeCacheFiles removes the cache files, along with the package images
+// and lock files next to them, returning those removed. Only files in the
+// compiled directory of a depot, `compiled/v1.10/Package`, are touched.
+func removeCacheFiles(files []string) []string {
+	var removed []string
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		if !filepath.IsAbs(file) || filepath.Base(filepath.Dir(filepath.Dir(dir))) != "compiled" {
+			logging.V(5).Infof("not removing %s, which isn't in a depot's compiled directory", file)
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		prefix := strings.TrimSuffix(filepath.Base(file), ".ji") + "."
+		for _, entry := range entries {
+			if !strings.HasPrefix(entry.Name(), prefix) {
+				continue
+			}
+			sibling := filepath.Join(dir, entry.Name())
+			if err := os.Remove(sibling); err != nil {
+				logging.V(5).Infof("failed to remove %s: %v", sibling, err)
+				continue
+			}
+			removed = append(removed, sibling)
+		}
+	}
+	return removed
+}
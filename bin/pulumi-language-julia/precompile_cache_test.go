@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+func TestCorruptCacheFiles(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	stderr := `┌ Warning: The call to compilecache failed to create a usable precompiled cache file for AWS
+│   exception = Cache file "/depot/compiled/v1.10/AWS/abc_def.ji" is invalid
+└ @ Base loading.jl:1818
+ERROR: LoadError: Failed to precompile JSON3 [0f8b85d8-7281-11e9-16c2-39a750bddbf1] to "/depot/compiled/v1.10/JSON3/ghi_jkl.ji".
+ERROR: Invalid header in cache file ~/.julia/compiled/v1.10/HTTP/mno.ji.
+ERROR: Failed to precompile Foo [0f8b85d8-7281-11e9-16c2-39a750bddbf1] to "C:\\Users\\me\\.julia\\compiled\\v1.10\\Foo\\pqr.ji".
+Cache file "/depot/compiled/v1.10/AWS/abc_def.ji" is invalid
+`
+	expected := []string{
+		"/depot/compiled/v1.10/AWS/abc_def.ji",
+		home + "/.julia/compiled/v1.10/HTTP/mno.ji",
+		"/depot/compiled/v1.10/JSON3/ghi_jkl.ji",
+		`C:\Users\me\.julia\compiled\v1.10\Foo\pqr.ji`,
+	}
+	if files := corruptCacheFiles(stderr); !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %q, got %q", expected, files)
+	}
+	if files := corruptCacheFiles("ERROR: LoadError: UndefVarError: `x` not defined\n"); len(files) != 0 {
+		t.Errorf("expected no cache files, got %q", files)
+	}
+}
+
+func TestRemoveCacheFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "compiled", "v1.10", "AWS")
+	for _, name := range []string{"abc_def.ji", "abc_def.so", "abc_def.ji.pidfile", "abc_xyz.ji", "abc_xyz.so"} {
+		writeFile(t, filepath.Join(dir, name), "")
+	}
+	outside := filepath.Join(t.TempDir(), "abc_def.ji")
+	writeFile(t, outside, "")
+
+	removed := removeCacheFiles([]string{filepath.Join(dir, "abc_def.ji"), outside, "relative/compiled/v1.10/X/a.ji"})
+	expected := []string{
+		filepath.Join(dir, "abc_def.ji"), filepath.Join(dir, "abc_def.ji.pidfile"), filepath.Join(dir, "abc_def.so"),
+	}
+	if !reflect.DeepEqual(removed, expected) {
+		t.Errorf("expected %q to be removed, got %q", expected, removed)
+	}
+	for _, kept := range []string{filepath.Join(dir, "abc_xyz.ji"), filepath.Join(dir, "abc_xyz.so"), outside} {
+		if _, err := os.Stat(kept); err != nil {
+			t.Errorf("expected %s to be kept, got %v", kept, err)
+		}
+	}
+}
+
+func TestRunRetriesCorruptCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake julia is a shell script")
+	}
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	cache := filepath.Join(t.TempDir(), "compiled", "v1.10", "AWS", "abc_def.ji")
+	runs := filepath.Join(t.TempDir(), "runs")
+	t.Setenv("PULUMI_JULIA_EXE", "")
+	run := func(options map[string]interface{}) *pulumirpc.RunResponse {
+		t.Helper()
+		resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".", Options: mustStruct(t, options),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		return resp
+	}
+	countRuns := func() int {
+		data, _ := os.ReadFile(runs)
+		defer os.Remove(runs)
+		return strings.Count(string(data), "run\n")
+	}
+
+	// Fails for as long as the cache file exists.
+	fakeJulia(t, `echo run >> "`+runs+`"
+if [ -e "`+cache+`" ]; then echo 'ERROR: Cache file "`+cache+`" is invalid' >&2; exit 1; fi`)
+
+	writeFile(t, cache, "corrupt")
+	if resp := run(nil); resp.GetError() != "" {
+		t.Errorf("expected the retried run to succeed, got %q", resp.GetError())
+	}
+	if n := countRuns(); n != 2 {
+		t.Errorf("expected the program to run twice, ran %d times", n)
+	}
+
+	writeFile(t, cache, "corrupt")
+	if resp := run(map[string]interface{}{"retryCorruptCache": false}); !strings.Contains(resp.GetError(), "is invalid") {
+		t.Errorf("expected the run to fail, got %q", resp.GetError())
+	}
+	if n := countRuns(); n != 1 {
+		t.Errorf("expected the program to run once, ran %d times", n)
+	}
+
+	// Programs failing for good are retried only once.
+	fakeJulia(t, `echo run >> "`+runs+`"
+mkdir -p "`+filepath.Dir(cache)+`"; touch "`+cache+`"
+echo 'ERROR: Cache file "`+cache+`" is invalid' >&2; exit 1`)
+	if resp := run(nil); !strings.Contains(resp.GetError(), "is invalid") {
+		t.Errorf("expected the run to fail, got %q", resp.GetError())
+	}
+	if n := countRuns(); n != 2 {
+		t.Errorf("expected the program to run twice, ran %d times", n)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+func TestPrecompileFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	julia := juliaCommand{Path: "/usr/bin/julia", Args: []string{"--startup-file=no"}}
+	writeFile(t, filepath.Join(dir, "Project.toml"), "[deps]\n")
+	if _, ok := precompileFingerprint(julia, dir); ok {
+		t.Errorf("expected a project without a manifest to have nothing to precompile")
+	}
+
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), "julia_version = \"1.10.4\"\n")
+	base, ok := precompileFingerprint(julia, dir)
+	if !ok {
+		t.Fatalf("expected a project with a manifest to be precompiled")
+	}
+	if again, _ := precompileFingerprint(julia, dir); again != base {
+		t.Errorf("expected an unchanged project to keep its fingerprint")
+	}
+
+	changed := map[string]func(){
+		"manifest": func() { writeFile(t, filepath.Join(dir, "Manifest.toml"), "julia_version = \"1.11.1\"\n") },
+		"project":  func() { writeFile(t, filepath.Join(dir, "Project.toml"), "[deps]\nJSON3 = \"0f8b85d8\"\n") },
+		"julia":    func() { julia.Path = "/opt/julia-1.11/bin/julia" },
+		"switches": func() { julia.Args = append([]string{"+1.11"}, julia.Args...) },
+	}
+	for what, change := range changed {
+		change()
+		fingerprint, _ := precompileFingerprint(julia, dir)
+		if fingerprint == base {
+			t.Errorf("expected a changed %s to change the fingerprint", what)
+		}
+		base = fingerprint
+	}
+}
+
+func TestPrecompileMarker(t *testing.T) {
+	if marker := precompileMarker(nil, "/work/infra"); marker != "" {
+		t.Errorf("expected no marker without a depot, got %s", marker)
+	}
+	depot := t.TempDir()
+	marker := precompileMarker([]string{depot, "/usr/share/julia"}, "/work/infra")
+	if filepath.Dir(marker) != filepath.Join(depot, "pulumi", "precompiled") {
+		t.Errorf("expected the marker in the first depot, got %s", marker)
+	}
+	if other := precompileMarker([]string{depot}, "/work/web"); other == marker {
+		t.Errorf("expected projects to have markers of their own, got %s for both", marker)
+	}
+}
+
+func TestPrecompileProject(t *testing.T) {
+	dir, depot := t.TempDir(), t.TempDir()
+	calls := filepath.Join(t.TempDir(), "calls")
+	writeFile(t, filepath.Join(dir, "Project.toml"), "[deps]\n")
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), "julia_version = \"1.10.4\"\n")
+	fakeJulia(t, `echo "$@" >> `+calls+`
+echo "Precompiling project..." >&2
+printf '  ✓ JSON3\n  1 dependency successfully precompiled in 3 seconds'`)
+	julia := juliaCommand{Path: "julia"}
+	prog := juliaProgram{ProjectDir: dir, EntryPoint: filepath.Join(dir, "main.jl")}
+	precompile := func() string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := precompileProject(context.Background(), julia, prog, dir, []string{depot}, &out, 0, 0); err != nil {
+			t.Fatalf("precompileProject: %v", err)
+		}
+		return out.String()
+	}
+
+	out := precompile()
+	want := precompileLabel + "precompiling the packages of " + dir + " before running the program\n" +
+		precompileLabel + "Precompiling project...\n" +
+		precompileLabel + "  ✓ JSON3\n" +
+		precompileLabel + "  1 dependency successfully precompiled in 3 seconds"
+	if out != want {
+		t.Errorf("expected the labeled output of Pkg.precompile, got %q", out)
+	}
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args := strings.TrimSpace(string(data)); args != "--project="+dir+" -e "+precompileScript {
+		t.Errorf("expected julia to run Pkg.precompile in the project, got %q", args)
+	}
+
+	if out := precompile(); out != "" {
+		t.Errorf("expected an unchanged project not to be precompiled again, got %q", out)
+	}
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), "julia_version = \"1.10.5\"\n")
+	if out := precompile(); out == "" {
+		t.Errorf("expected a changed manifest to be precompiled again")
+	}
+	if data, _ := os.ReadFile(calls); strings.Count(string(data), "\n") != 2 {
+		t.Errorf("expected julia to run twice, got %q", data)
+	}
+}
+
+func TestPrecompileProjectFailure(t *testing.T) {
+	dir, depot := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), "")
+	fakeJulia(t, "echo 'ERROR: The following 1 direct dependency failed to precompile:' >&2\nexit 1")
+	prog := juliaProgram{ProjectDir: dir}
+
+	var out bytes.Buffer
+	err := precompileProject(context.Background(), juliaCommand{Path: "julia"}, prog, dir, []string{depot}, &out, 0, 0)
+	if err == nil || !strings.Contains(err.Error(), "failed to precompile the packages of "+dir) {
+		t.Errorf("expected precompilation to fail, got %v", err)
+	}
+	if !strings.Contains(out.String(), precompileLabel+"ERROR: The following 1 direct dependency") {
+		t.Errorf("expected the labeled error of Pkg.precompile, got %q", out.String())
+	}
+	if _, err := os.Stat(precompileMarker([]string{depot}, dir)); !os.IsNotExist(err) {
+		t.Errorf("expected a failed precompilation not to be recorded, got %v", err)
+	}
+}
+
+func TestPrecompileProjectCancelled(t *testing.T) {
+	dir, depot := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), "")
+	fakeJulia(t, "echo 'Precompiling project...' >&2\nexec sleep 30")
+	prog := juliaProgram{ProjectDir: dir}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := precompileProject(ctx, juliaCommand{Path: "julia"}, prog, dir, []string{depot}, &bytes.Buffer{}, time.Second, 0)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected precompilation to stop with its context, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected precompilation to stop promptly, took %s", elapsed)
+	}
+}
+
+func TestRunPrecompilesFirst(t *testing.T) {
+	root, depot := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	writeFile(t, filepath.Join(root, "Manifest.toml"), "")
+	t.Setenv("JULIA_DEPOT_PATH", depot)
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeJulia(t, `echo "$@" >> `+calls)
+	for i := 0; i < 2; i++ {
+		resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+				Options: mustStruct(t, map[string]interface{}{"precompile": true}),
+			},
+		})
+		if err != nil || resp.GetError() != "" {
+			t.Fatalf("Run: %v %v", err, resp.GetError())
+		}
+	}
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(runs) != 3 || !strings.HasSuffix(runs[0], precompileScript) ||
+		strings.Contains(runs[1], precompileScript) || strings.Contains(runs[2], precompileScript) {
+		t.Errorf("expected the project to be precompiled before the first run only, got %q", runs)
+	}
+}
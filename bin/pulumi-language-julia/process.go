@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+// defaultCancelGracePeriod is how long cancelled programs get to shut down
+// after being interrupted, before they are killed.
+const defaultCancelGracePeriod = 15 * time.Second
+
+// statusControlCExit is the exit code of Windows programs stopped by Ctrl-C.
+const statusControlCExit = 0xC000013A
+
+// interruptExitCodes are the exit codes with which shells report programs
+// stopped by SIGINT or SIGTERM, and programs exit when they stop on being
+// interrupted.
+var interruptExitCodes = []int{128 + int(syscall.SIGINT), 128 + int(syscall.SIGTERM)}
+
+// interruptedExit reports whether err is the exit of a program stopped, or
+// that stopped itself, on being interrupted or terminated, including being
+// killed at the end of its grace period.
+func interruptedExit(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	switch sig, _ := exitSignal(exitErr); sig {
+	case syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL:
+		return true
+	}
+	return slices.Contains(interruptExitCodes, exitErr.ExitCode()) || uint32(exitErr.ExitCode()) == statusControlCExit
+}
+
+// exitSignal returns the signal that killed the process of exitErr, if it
+// was killed by one.
+func exitSignal(exitErr *exec.ExitError) (syscall.Signal, bool) {
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return status.Signal(), true
+	}
+	return 0, false
+}
+
+// killedExit reports whether the process of exitErr was killed by SIGKILL,
+// or exited as shells do for processes killed by it.
+func killedExit(exitErr *exec.ExitError) bool {
+	sig, ok := exitSignal(exitErr)
+	return (ok && sig == syscall.SIGKILL) || exitErr.ExitCode() == 128+int(syscall.SIGKILL)
+}
+
+// rerunCommand returns a command running cmd again, which has run already.
+func rerunCommand(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+	rerun := exec.CommandContext(ctx, cmd.Path)
+	rerun.Args, rerun.Env, rerun.Dir, rerun.Stdin = cmd.Args, cmd.Env, cmd.Dir, cmd.Stdin
+	return rerun
+}
+
+// processTiming is where the time of a process went. Julia processes only
+// write output once julia has started and loaded the packages they use, so
+// the time to their first output tells slow startup from slow work.
+type processTiming struct {
+	// FirstOutput is how long the process took to first write output, or 0
+	// if it wrote none.
+	FirstOutput time.Duration
+	// Total is how long the process ran.
+	Total time.Duration
+	// ExitCode is the exit code of the process, or -1 if it was killed by a
+	// signal or didn't start.
+	ExitCode int
+}
+
+func (t processTiming) String() string {
+	first := "no output"
+	if t.FirstOutput > 0 {
+		first = fmt.Sprintf("first output after %s", t.FirstOutput.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("%s, exited with code %d after %s", first, t.ExitCode, t.Total.Round(time.Millisecond))
+}
+
+// processTimer measures the processTiming of a process from its start,
+// noting when it first writes to the writers it wraps.
+type processTimer struct {
+	start time.Time
+
+	mu          sync.Mutex
+	firstOutput time.Duration
+}
+
+func newProcessTimer() *processTimer {
+	return &processTimer{start: time.Now()}
+}
+
+// wrap returns w, noting the first write to it. Files are written to by the
+// process directly, so writes to them go unnoticed.
+func (t *processTimer) wrap(w io.Writer) io.Writer {
+	if _, ok := w.(*os.File); ok || w == nil {
+		return w
+	}
+	return &timedWriter{timer: t, w: w}
+}
+
+// stop returns the timing of the process name, which exited with exitCode,
+// logging it.
+func (t *processTimer) stop(name string, exitCode int) processTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timing := processTiming{FirstOutput: t.firstOutput, Total: time.Since(t.start), ExitCode: exitCode}
+	logging.V(3).Infof("%s: %s", name, timing)
+	return timing
+}
+
+type timedWriter struct {
+	timer *processTimer
+	w     io.Writer
+}
+
+func (w *timedWriter) Write(p []byte) (int, error) {
+	w.timer.mu.Lock()
+	if w.timer.firstOutput == 0 && len(p) > 0 {
+		w.timer.firstOutput = time.Since(w.timer.start)
+	}
+	w.timer.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// runProcess starts cmd, created with a context, and waits for it to exit.
+// It runs in a process group of its own, so that the processes it starts,
+// such as helper tools and Distributed workers, are stopped along with it
+// when it fails or is cancelled rather than left holding locks.
+//
+// When the context is cancelled, as when the user hits Ctrl-C, the group is
+// interrupted rather than killed, so that programs get to run their finally
+// blocks and finish the resource registrations in flight. It is killed if
+// it hasn't exited after gracePeriod.
+//
+// The timing of the process is logged, as that of name, and returned.
+func runProcess(name string, cmd *exec.Cmd, gracePeriod time.Duration) (processTiming, error) {
+	timer := newProcessTimer()
+	if cmd.Stdout == cmd.Stderr {
+		cmd.Stdout = timer.wrap(cmd.Stdout)
+		cmd.Stderr = cmd.Stdout
+	} else {
+		cmd.Stdout, cmd.Stderr = timer.wrap(cmd.Stdout), timer.wrap(cmd.Stderr)
+	}
+	group := newProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		if gracePeriod == 0 {
+			return group.kill()
+		}
+		logging.V(5).Infof("interrupting %s, which has %s to exit", cmd.Path, gracePeriod)
+		return group.interrupt()
+	}
+	cmd.WaitDelay = gracePeriod
+
+	if err := cmd.Start(); err != nil {
+		return timer.stop(name, -1), err
+	}
+	defer group.release()
+	if err := group.started(); err != nil {
+		logging.V(5).Infof("failed to set up the process group of %s: %v", cmd.Path, err)
+	}
+
+	err := cmd.Wait()
+	if err != nil {
+		if killErr := group.kill(); killErr != nil {
+			logging.V(5).Infof("failed to stop the processes started by %s: %v", cmd.Path, killErr)
+		}
+	}
+	return timer.stop(name, cmd.ProcessState.ExitCode()), err
+}
@@ -0,0 +1,81 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// processGroup is the unix process group led by a command.
+type processGroup struct {
+	cmd *exec.Cmd
+	// own is set if the command leads a process group of its own, rather
+	// than staying in the host's.
+	own bool
+}
+
+// newProcessGroup makes cmd start a process group of its own, unless it reads
+// a terminal: only the terminal's foreground process group may, the others
+// being stopped by SIGTTIN, so such a command stays in the host's group and
+// only it is signalled.
+func newProcessGroup(cmd *exec.Cmd) *processGroup {
+	if isTerminal(cmd.Stdin) {
+		return &processGroup{cmd: cmd}
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	return &processGroup{cmd: cmd, own: true}
+}
+
+// started is called once the command has started.
+func (g *processGroup) started() error { return nil }
+
+// interrupt sends SIGINT to every process of the group.
+func (g *processGroup) interrupt() error { return g.signal(syscall.SIGINT) }
+
+// kill sends SIGKILL to every process of the group.
+func (g *processGroup) kill() error { return g.signal(syscall.SIGKILL) }
+
+// release frees the resources held for the group.
+func (g *processGroup) release() {}
+
+func (g *processGroup) signal(sig syscall.Signal) error {
+	// The group is named after its leader; a negative pid signals all of it.
+	pid := g.cmd.Process.Pid
+	if g.own {
+		pid = -pid
+	}
+	if err := syscall.Kill(pid, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return err
+	}
+	return nil
+}
+
+// processAlive reports whether the process pid is running.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// exitCrash describes the signal that killed the process of exitErr, such as
+// "signal SIGSEGV (segmentation fault)", and whether it may have dumped core,
+// or returns "" if it wasn't killed by a signal.
+func exitCrash(exitErr *exec.ExitError) (string, bool) {
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return "", false
+	}
+	sig := status.Signal()
+	name := unix.SignalName(sig)
+	if name == "" {
+		name = fmt.Sprint(int(sig))
+	}
+	return fmt.Sprintf("signal %s (%s)", name, sig), true
+}
@@ -0,0 +1,123 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+// grandchildScript starts a sleeping grandchild, like a helper tool or a
+// worker process, recording its pid in pidFile.
+func grandchildScript(pidFile string) string {
+	return `sleep 60 > /dev/null 2>&1 &
+echo $! > "` + pidFile + `"
+`
+}
+
+// assertProcessGone fails unless the process recorded in pidFile exits, or
+// is left a zombie for lack of a parent reaping it.
+func assertProcessGone(t *testing.T, pidFile string) {
+	t.Helper()
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if err := syscall.Kill(pid, 0); errors.Is(err, syscall.ESRCH) {
+			return
+		}
+		if stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat")); err == nil &&
+			strings.Contains(string(stat), ") Z ") {
+			return
+		}
+	}
+	_ = syscall.Kill(pid, syscall.SIGKILL)
+	t.Errorf("expected process %d started by the program to be stopped", pid)
+}
+
+func TestRunStopsProcessGroupOnCancel(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	runUntilCancelled(t, grandchildScript(pidFile)+`trap 'exit 130' INT
+while :; do sleep 0.05; done`, nil)
+	assertProcessGone(t, pidFile)
+}
+
+func TestRunStopsProcessGroupOnError(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	fakeJulia(t, grandchildScript(pidFile)+"exit 1")
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+	})
+	if err != nil || resp.GetError() == "" {
+		t.Fatalf("expected the run to fail, got %v, %v", resp, err)
+	}
+	assertProcessGone(t, pidFile)
+}
+
+func TestRunReportsCrashSignal(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	for _, tt := range []struct{ signal, stderr, want string }{
+		{"SEGV", "signal (11): Segmentation fault", "signal SIGSEGV (segmentation fault)"},
+		{"ABRT", "signal (6): Aborted", "signal SIGABRT (aborted)"},
+		{"BUS", "signal (7): Bus error", "signal SIGBUS (bus error)"},
+	} {
+		fakeJulia(t, "ulimit -c 0; echo '"+tt.stderr+"' >&2; kill -"+tt.signal+" $$")
+		resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+			Info: &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+		})
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		msg := resp.GetError()
+		for _, part := range []string{tt.want, "native library", root, tt.stderr} {
+			if !strings.Contains(msg, part) {
+				t.Errorf("expected %q in %q", part, msg)
+			}
+		}
+	}
+}
+
+func TestRunProcessTiming(t *testing.T) {
+	var out strings.Builder
+	cmd := exec.CommandContext(context.Background(), "sh", "-c", "sleep 0.2; echo ready; sleep 0.1; exit 3")
+	cmd.Stdout = &out
+	timing, err := runProcess("test", cmd, 0)
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || out.String() != "ready\n" {
+		t.Fatalf("expected the process to exit with an error, got %v and output %q", err, out.String())
+	}
+	if timing.FirstOutput < 200*time.Millisecond || timing.Total < timing.FirstOutput+100*time.Millisecond ||
+		timing.ExitCode != 3 {
+		t.Errorf("expected first output after 200ms and exit code 3 after 300ms, got %s", timing)
+	}
+
+	timing, err = runProcess("test", exec.CommandContext(context.Background(), "sh", "-c", "sleep 0.1"), 0)
+	if err != nil || timing.FirstOutput != 0 || timing.ExitCode != 0 || timing.Total < 100*time.Millisecond {
+		t.Errorf("expected no output and exit code 0 after 100ms, got %s, %v", timing, err)
+	}
+	if s := timing.String(); !strings.HasPrefix(s, "no output, exited with code 0 after ") {
+		t.Errorf("unexpected timing summary %q", s)
+	}
+}
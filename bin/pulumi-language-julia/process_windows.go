@@ -0,0 +1,180 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// processGroup is the job object holding a command and the processes it
+// starts.
+type processGroup struct {
+	cmd *exec.Cmd
+
+	mu  sync.Mutex
+	job windows.Handle
+}
+
+// newProcessGroup makes cmd start suspended, so that it is in its job object
+// before it gets to start any process of its own.
+func newProcessGroup(cmd *exec.Cmd) *processGroup {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= windows.CREATE_SUSPENDED
+	return &processGroup{cmd: cmd}
+}
+
+// started puts the command in a job object of its own, which the processes
+// it starts join too, then resumes it. It is resumed even if the job can't be
+// set up, and killed if it can't be resumed, so that it never hangs.
+func (g *processGroup) started() error {
+	err := g.assignJob()
+	if resumeErr := resumeProcess(uint32(g.cmd.Process.Pid)); resumeErr != nil {
+		g.cmd.Process.Kill()
+		return fmt.Errorf("failed to resume process %d: %w", g.cmd.Process.Pid, resumeErr)
+	}
+	return err
+}
+
+// assignJob puts the command in a job object of its own.
+func (g *processGroup) assignJob() error {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return err
+	}
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{
+		BasicLimitInformation: windows.JOBOBJECT_BASIC_LIMIT_INFORMATION{
+			LimitFlags: windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE,
+		},
+	}
+	if _, err := windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info))); err != nil {
+		windows.CloseHandle(job)
+		return err
+	}
+	process, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE,
+		false, uint32(g.cmd.Process.Pid))
+	if err != nil {
+		windows.CloseHandle(job)
+		return err
+	}
+	defer windows.CloseHandle(process)
+	if err := windows.AssignProcessToJobObject(job, process); err != nil {
+		windows.CloseHandle(job)
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.job = job
+	return nil
+}
+
+// resumeProcess resumes the threads of the suspended process pid. os/exec
+// closes the handle of its main thread, so the threads are found in a
+// snapshot of the system's.
+func resumeProcess(pid uint32) error {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPTHREAD, 0)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(snapshot)
+
+	resumed := false
+	entry := windows.ThreadEntry32{Size: uint32(unsafe.Sizeof(windows.ThreadEntry32{}))}
+	for err = windows.Thread32First(snapshot, &entry); err == nil; err = windows.Thread32Next(snapshot, &entry) {
+		if entry.OwnerProcessID != pid {
+			continue
+		}
+		thread, err := windows.OpenThread(windows.THREAD_SUSPEND_RESUME, false, entry.ThreadID)
+		if err != nil {
+			return err
+		}
+		_, err = windows.ResumeThread(thread)
+		windows.CloseHandle(thread)
+		if err != nil {
+			return err
+		}
+		resumed = true
+	}
+	if !errors.Is(err, windows.ERROR_NO_MORE_FILES) {
+		return err
+	}
+	if !resumed {
+		return errors.New("no thread found")
+	}
+	return nil
+}
+
+// interrupt kills the group, as Windows has no way to interrupt another
+// process.
+func (g *processGroup) interrupt() error { return g.kill() }
+
+// kill terminates every process of the group, which exit with the status of
+// processes stopped by Ctrl-C.
+func (g *processGroup) kill() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.job == 0 {
+		return g.cmd.Process.Kill()
+	}
+	return windows.TerminateJobObject(g.job, statusControlCExit)
+}
+
+// release closes the job object.
+func (g *processGroup) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.job != 0 {
+		windows.CloseHandle(g.job)
+		g.job = 0
+	}
+}
+
+// processAlive reports whether the process pid is running.
+func processAlive(pid int) bool {
+	process, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return errors.Is(err, windows.ERROR_ACCESS_DENIED)
+	}
+	defer windows.CloseHandle(process)
+	var code uint32
+	if err := windows.GetExitCodeProcess(process, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
+
+// stillActive is the exit code of processes that haven't exited.
+const stillActive = 259
+
+// crashExceptions name the exceptions that Windows processes die of, by the
+// NTSTATUS code they exit with.
+var crashExceptions = map[uint32]string{
+	0x80000003: "STATUS_BREAKPOINT",
+	0xC0000005: "STATUS_ACCESS_VIOLATION",
+	0xC000001D: "STATUS_ILLEGAL_INSTRUCTION",
+	0xC0000094: "STATUS_INTEGER_DIVIDE_BY_ZERO",
+	0xC00000FD: "STATUS_STACK_OVERFLOW",
+	0xC0000374: "STATUS_HEAP_CORRUPTION",
+	0xC0000409: "STATUS_STACK_BUFFER_OVERRUN",
+}
+
+// exitCrash describes the exception that killed the process of exitErr, such
+// as "exception STATUS_ACCESS_VIOLATION (0xC0000005)", or returns "" if it
+// exited normally. Windows processes don't dump core.
+func exitCrash(exitErr *exec.ExitError) (string, bool) {
+	code := uint32(exitErr.ExitCode())
+	if name, ok := crashExceptions[code]; ok {
+		return fmt.Sprintf("exception %s (0x%08X)", name, code), false
+	}
+	return "", false
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// defaultEntryPoint is the file run when a program directory is given.
+const defaultEntryPoint = "main.jl"
+
+// juliaProgram locates a Julia Pulumi program.
+type juliaProgram struct {
+	// ProjectDir is the directory holding the program's Project.toml.
+	ProjectDir string
+	// Environment is set for programs running in a shared named environment,
+	// such as "@pulumi", which julia resolves through its depots rather than
+	// a directory. ProjectDir is then where the environment is expected.
+	Environment string
+	// EntryPoint is the Julia file to run.
+	EntryPoint string
+	// Package is set for package-style programs, which are run by calling
+	// the main function of the named package rather than as a script. Their
+	// EntryPoint is the package's module file.
+	Package string
+	// Candidates are the locations considered while resolving the program,
+	// in order, for reporting a program that can't be found.
+	Candidates []programCandidate
+}
+
+// programCandidate is a location considered while resolving a program.
+type programCandidate struct {
+	Path string
+	// Source says where the location came from.
+	Source string
+}
+
+// project returns the environment the program runs in, as passed to julia's
+// --project and exported as JULIA_PROJECT.
+func (prog juliaProgram) project() string {
+	if prog.Environment != "" {
+		return prog.Environment
+	}
+	return absPath(prog.ProjectDir)
+}
+
+// programDirectory returns the directory containing the Julia program.
+func programDirectory(program string) string {
+	if program == "" {
+		return "."
+	}
+	if strings.HasSuffix(program, ".jl") {
+		return filepath.Dir(program)
+	}
+	return program
+}
+
+// resolveProgram locates the program of a request. A relative legacy program
+// path is resolved against the working directory reported by the engine or,
+// failing that, the project root the host was started with, which also bounds
+// the search for the program's Julia environment when info has no root.
+//
+// Symlinks are resolved up front, so that the Julia environment, the program
+// file and the working directory all agree on the real location of the
+// program even when it is reached through a link.
+func (host *juliaLanguageHost) resolveProgram(
+	info *pulumirpc.ProgramInfo, program, pwd string, opts runtimeOptions,
+) (juliaProgram, error) {
+	if base := orDefault(pwd, host.root); base != "" && !filepath.IsAbs(program) {
+		program = filepath.Join(base, program)
+	}
+	if program != "" {
+		program = realPath(program)
+	}
+
+	resolved := &pulumirpc.ProgramInfo{}
+	if info != nil {
+		resolved = proto.Clone(info).(*pulumirpc.ProgramInfo)
+	}
+	resolved.RootDirectory = orDefault(resolved.GetRootDirectory(), host.root)
+	if resolved.RootDirectory != "" {
+		resolved.RootDirectory = realPath(resolved.RootDirectory)
+	}
+	if resolved.ProgramDirectory != "" {
+		resolved.ProgramDirectory = realPath(resolved.ProgramDirectory)
+	}
+
+	prog, err := resolveProgram(resolved, program, opts)
+	if err != nil {
+		return juliaProgram{}, err
+	}
+	if prog.Environment != "" {
+		prog.ProjectDir = namedEnvironmentDir(prog.Environment, host.depots(info, opts))
+	}
+	prog.ProjectDir = realPath(prog.ProjectDir)
+	prog.EntryPoint = realPath(prog.EntryPoint)
+	return prog, nil
+}
+
+// realPath returns path with any symlinks resolved, or path itself if that
+// fails, e.g. because it doesn't exist.
+func realPath(path string) string {
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		return real
+	}
+	return path
+}
+
+// resolveProgram locates the program described by info, falling back to the
+// legacy program path for engines that don't send program info. The
+// `entrypoint` runtime option takes precedence over Pulumi.yaml's `main`,
+// and relative entry points are resolved against the project root. An entry
+// point naming a directory runs the entry point set in the `[pulumi]` table of
+// its Project.toml or, without one, the main.jl inside it or, failing that,
+// the package that directory holds.
+//
+// The program runs in the Julia environment named by the `project` runtime
+// option or, by default, the nearest Project.toml found walking up from the
+// program directory to the project root. A `project` starting with `@` names
+// a shared environment, which is left for the caller to locate.
+func resolveProgram(info *pulumirpc.ProgramInfo, program string, opts runtimeOptions) (juliaProgram, error) {
+	programDir := info.GetProgramDirectory()
+	if programDir == "" {
+		programDir = programDirectory(program)
+	}
+	root := info.GetRootDirectory()
+
+	var projectDir, environment string
+	switch {
+	case strings.HasPrefix(opts.Project, "@"):
+		environment = opts.Project
+		logging.V(5).Infof("using the shared Julia environment %s from the project runtime option", environment)
+	case opts.Project != "":
+		projectDir = resolveAgainst(orDefault(root, programDir), opts.Project)
+		logging.V(5).Infof("using the Julia environment %s from the project runtime option", projectDir)
+	case root != "" && !within(root, programDir):
+		// A program living outside the project, such as a shared template,
+		// still runs in the project's environment.
+		logging.V(5).Infof("program directory %s is outside the project root %s", programDir, root)
+		projectDir = findJuliaProject(root, root)
+	default:
+		projectDir = findJuliaProject(programDir, root)
+	}
+
+	var candidates []programCandidate
+	consider := func(path, source string) string {
+		candidates = append(candidates, programCandidate{Path: path, Source: source})
+		return path
+	}
+
+	var entryPoint string
+	switch {
+	case opts.EntryPoint != "":
+		entryPoint = consider(resolveAgainst(orDefault(root, programDir), opts.EntryPoint), "entrypoint runtime option")
+	case info.GetProgramDirectory() != "":
+		entryPoint = consider(resolveAgainst(info.GetProgramDirectory(), info.GetEntryPoint()), "Pulumi.yaml main")
+	case program != "":
+		entryPoint = consider(program, "program path")
+	default:
+		entryPoint = consider(".", "current directory")
+	}
+
+	if isProgramDirectory(entryPoint) && projectDir != "" {
+		project, err := readJuliaProject(projectDir)
+		if err != nil {
+			return juliaProgram{}, err
+		}
+		if project != nil && project.Pulumi.EntryPoint != "" {
+			projectToml := filepath.Join(projectDir, "Project.toml")
+			entryPoint = consider(resolveAgainst(projectDir, project.Pulumi.EntryPoint),
+				"[pulumi] entrypoint in "+projectToml)
+			logging.V(5).Infof("using the entry point %s from %s", entryPoint, projectToml)
+		}
+	}
+	if isProgramDirectory(entryPoint) {
+		dir := entryPoint
+		entryPoint = consider(filepath.Join(dir, defaultEntryPoint), "default entry point")
+		if pkg, module, ok := packageProgram(dir); ok {
+			pkg.Candidates = append(candidates, programCandidate{Path: module, Source: "package module"})
+			return pkg, nil
+		} else if module != "" {
+			consider(module, "package module")
+		}
+	}
+	return juliaProgram{
+		ProjectDir: projectDir, Environment: environment, EntryPoint: entryPoint, Candidates: candidates,
+	}, nil
+}
+
+// missingProgramError describes a program that doesn't exist, listing every
+// location considered while resolving it.
+func missingProgramError(prog juliaProgram, pwd string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "could not find Julia program: %s\n", prog.EntryPoint)
+	if len(prog.Candidates) > 1 {
+		b.WriteString("locations considered:\n")
+		for _, candidate := range prog.Candidates {
+			fmt.Fprintf(&b, "  - %s (%s)\n", candidate.Path, candidate.Source)
+		}
+	}
+	fmt.Fprintf(&b, "working directory: %s\n", pwd)
+	b.WriteString("a program directory must contain either a main.jl script or a Julia package " +
+		"(a Project.toml with a name, and src/<Name>.jl defining <Name>.main()); " +
+		"set `main` in Pulumi.yaml to run another file")
+	return b.String()
+}
+
+// isProgramDirectory reports whether an entry point names a directory rather
+// than a Julia file.
+func isProgramDirectory(entryPoint string) bool {
+	stat, err := os.Stat(entryPoint)
+	return (err == nil && stat.IsDir()) || !strings.HasSuffix(entryPoint, ".jl")
+}
+
+// findJuliaProject returns the directory of the nearest Project.toml found
+// walking up from dir, without leaving root. Without a root only dir itself
+// is considered. If there is no Project.toml, dir is returned.
+func findJuliaProject(dir, root string) string {
+	if root != "" {
+		root = filepath.Clean(root)
+		for candidate := dir; ; candidate = filepath.Dir(candidate) {
+			if _, err := os.Stat(filepath.Join(candidate, "Project.toml")); err == nil {
+				if candidate != dir {
+					logging.V(5).Infof("using the Julia environment %s found above %s", candidate, dir)
+				}
+				return candidate
+			}
+			if candidate == root || !within(root, candidate) || filepath.Dir(candidate) == candidate {
+				break
+			}
+		}
+	}
+	logging.V(5).Infof("using the Julia environment %s", dir)
+	return dir
+}
+
+// within reports whether path is root or lies below it.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolveAgainst resolves a path from the project configuration against base.
+func resolveAgainst(base, path string) string {
+	path = portablePath(path)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(base, path)
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// packageProgram returns the package-style program in dir: a Julia package
+// without a main.jl, whose Project.toml names the package and whose module
+// lives in src/<Name>.jl. It also returns the module path whenever the
+// Project.toml names a package, even if the module is missing.
+func packageProgram(dir string) (juliaProgram, string, bool) {
+	project, err := readJuliaProject(dir)
+	if err != nil || project == nil || !isJuliaIdentifier(project.Name) {
+		return juliaProgram{}, "", false
+	}
+	module := filepath.Join(dir, "src", project.Name+".jl")
+	if _, err := os.Stat(filepath.Join(dir, defaultEntryPoint)); err == nil {
+		return juliaProgram{}, module, false
+	}
+	if _, err := os.Stat(module); err != nil {
+		return juliaProgram{}, module, false
+	}
+	logging.V(5).Infof("no %s in %s; using package %s as the program", defaultEntryPoint, dir, project.Name)
+	return juliaProgram{ProjectDir: dir, EntryPoint: module, Package: project.Name}, module, true
+}
+
+// isJuliaIdentifier reports whether s is a valid Julia identifier, and so
+// can be spliced into code as is.
+func isJuliaIdentifier(s string) bool {
+	for i, r := range s {
+		if !(isIdentChar(r) && (i > 0 || isIdentStart(r))) || r == '!' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// portablePath converts a relative path from the project configuration, which
+// may have been written on either Windows or a Unix system, to use the host's
+// path separator. Absolute paths are left alone.
+func portablePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.FromSlash(strings.ReplaceAll(path, `\`, "/"))
+}
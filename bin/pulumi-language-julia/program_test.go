@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+func mustResolveProgram(
+	t *testing.T, info *pulumirpc.ProgramInfo, program string, opts runtimeOptions,
+) juliaProgram {
+	t.Helper()
+	prog, err := resolveProgram(info, program, opts)
+	if err != nil {
+		t.Fatalf("resolveProgram: %v", err)
+	}
+	return prog
+}
+
+func mustResolveHostProgram(
+	t *testing.T, host *juliaLanguageHost, info *pulumirpc.ProgramInfo, program, pwd string, opts runtimeOptions,
+) juliaProgram {
+	t.Helper()
+	prog, err := host.resolveProgram(info, program, pwd, opts)
+	if err != nil {
+		t.Fatalf("resolveProgram: %v", err)
+	}
+	return prog
+}
+
+func TestResolveProgram(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	writeFile(t, filepath.Join(root, "infra", "stacks", "prod.jl"), "")
+	writeFile(t, filepath.Join(root, "app", "main.jl"), "")
+
+	tests := []struct {
+		name       string
+		info       *pulumirpc.ProgramInfo
+		program    string
+		opts       runtimeOptions
+		projectDir string
+		entryPoint string
+	}{
+		{
+			name:       "legacy directory",
+			program:    root,
+			projectDir: root,
+			entryPoint: filepath.Join(root, "main.jl"),
+		},
+		{
+			name:       "legacy file",
+			program:    filepath.Join(root, "infra", "stacks", "prod.jl"),
+			projectDir: filepath.Join(root, "infra", "stacks"),
+			entryPoint: filepath.Join(root, "infra", "stacks", "prod.jl"),
+		},
+		{
+			name:       "default main",
+			info:       &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+			projectDir: root,
+			entryPoint: filepath.Join(root, "main.jl"),
+		},
+		{
+			name: "main file",
+			info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: "infra/stacks/prod.jl",
+			},
+			projectDir: root,
+			entryPoint: filepath.Join(root, "infra", "stacks", "prod.jl"),
+		},
+		{
+			name: "main directory",
+			info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: filepath.Join(root, "app"), EntryPoint: ".",
+			},
+			projectDir: filepath.Join(root, "app"),
+			entryPoint: filepath.Join(root, "app", "main.jl"),
+		},
+		{
+			name: "entrypoint option",
+			info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: filepath.Join(root, "app"), EntryPoint: ".",
+			},
+			opts:       runtimeOptions{EntryPoint: "infra/stacks/prod.jl"},
+			projectDir: filepath.Join(root, "app"),
+			entryPoint: filepath.Join(root, "infra", "stacks", "prod.jl"),
+		},
+		{
+			name: "windows main file",
+			info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: `infra\stacks\prod.jl`,
+			},
+			projectDir: root,
+			entryPoint: filepath.Join(root, "infra", "stacks", "prod.jl"),
+		},
+		{
+			name:       "windows entrypoint option",
+			info:       &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+			opts:       runtimeOptions{EntryPoint: `infra\stacks\prod.jl`},
+			projectDir: root,
+			entryPoint: filepath.Join(root, "infra", "stacks", "prod.jl"),
+		},
+		{
+			name:       "entrypoint option directory",
+			info:       &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."},
+			opts:       runtimeOptions{EntryPoint: "app"},
+			projectDir: root,
+			entryPoint: filepath.Join(root, "app", "main.jl"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog := mustResolveProgram(t, tt.info, tt.program, tt.opts)
+			if prog.ProjectDir != tt.projectDir || prog.EntryPoint != tt.entryPoint {
+				t.Errorf("expected %s in %s, got %s in %s",
+					tt.entryPoint, tt.projectDir, prog.EntryPoint, prog.ProjectDir)
+			}
+		})
+	}
+}
+
+func TestRunMissingEntryPoint(t *testing.T) {
+	root := t.TempDir()
+	host := newTestHost()
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory:    root,
+			ProgramDirectory: root,
+			EntryPoint:       ".",
+			Options:          mustStruct(t, map[string]interface{}{"entrypoint": "infra/stacks/prod.jl"}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	expected := "could not find Julia program: " + filepath.Join(root, "infra", "stacks", "prod.jl") + "\n"
+	if !strings.HasPrefix(resp.GetError(), expected) {
+		t.Errorf("expected %q, got %q", expected, resp.GetError())
+	}
+}
+
+func TestRequiredPackagesScansEntryPoint(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "using PulumiAWS\n")
+	writeFile(t, filepath.Join(root, "infra", "stacks", "prod.jl"), "using PulumiRandom\n")
+
+	host := newTestHost()
+	resp, err := host.GetRequiredPackages(context.Background(), &pulumirpc.GetRequiredPackagesRequest{
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory:    root,
+			ProgramDirectory: root,
+			EntryPoint:       "infra/stacks/prod.jl",
+		},
+	})
+	if err != nil {
+		t.Fatalf("GetRequiredPackages: %v", err)
+	}
+	if names := strings.Join(pluginNames(resp.GetPackages()), ","); names != "random" {
+		t.Errorf("expected only the plugins used by the entry point, got %s", names)
+	}
+}
+
+func TestResolvePackageProgram(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "name = \"Infra\"\nuuid = \"c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f\"\n")
+	writeFile(t, filepath.Join(root, "src", "Infra.jl"), "module Infra\nmain() = nothing\nend\n")
+
+	prog := mustResolveProgram(t, &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."}, "", runtimeOptions{})
+	if prog.Package != "Infra" || prog.ProjectDir != root || prog.EntryPoint != filepath.Join(root, "src", "Infra.jl") {
+		t.Fatalf("expected the Infra package, got %+v", prog)
+	}
+	args := juliaRunArgs(prog, nil, nil)
+	if len(args) != 3 || args[0] != "--project="+root || args[1] != "-e" || args[2] != "using Infra; Infra.main()" {
+		t.Errorf("unexpected arguments %q", args)
+	}
+
+	// A main.jl takes precedence over the package.
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	if prog := mustResolveProgram(t, nil, root, runtimeOptions{}); prog.Package != "" {
+		t.Errorf("expected main.jl to be run, got %+v", prog)
+	}
+}
+
+func TestRunMissingProgramExplainsLayouts(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+
+	host := newTestHost()
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{Program: root})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !strings.Contains(resp.GetError(), "main.jl script") || !strings.Contains(resp.GetError(), "src/<Name>.jl") {
+		t.Errorf("expected both program layouts to be explained, got %q", resp.GetError())
+	}
+}
+
+func TestIsJuliaIdentifier(t *testing.T) {
+	for s, expected := range map[string]bool{
+		"Infra": true, "My_Infra2": true, "Σ": true,
+		"": false, "2Infra": false, "Infra!": false, "Infra; rm": false, "Infra.jl": false,
+	} {
+		if actual := isJuliaIdentifier(s); actual != expected {
+			t.Errorf("isJuliaIdentifier(%q): expected %v, got %v", s, expected, actual)
+		}
+	}
+}
+
+func TestResolveProgramPrefersInfo(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	writeFile(t, filepath.Join(root, "legacy", "main.jl"), "")
+
+	for _, entryPoint := range []string{".", ""} {
+		info := &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: entryPoint}
+		prog := mustResolveProgram(t, info, filepath.Join(root, "legacy"), runtimeOptions{})
+		if prog.ProjectDir != root || prog.EntryPoint != filepath.Join(root, "main.jl") {
+			t.Errorf("entry point %q: expected main.jl from the program info, got %+v", entryPoint, prog)
+		}
+	}
+}
+
+func TestResolveProgramFindsEnclosingProject(t *testing.T) {
+	repo := t.TempDir()
+	writeFile(t, filepath.Join(repo, "Project.toml"), "[deps]\n")
+	root := filepath.Join(repo, "infra")
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	writeFile(t, filepath.Join(root, "stacks", "dev", "main.jl"), "")
+	writeFile(t, filepath.Join(root, "shared", "Project.toml"), "[deps]\n")
+	stack := filepath.Join(root, "stacks", "dev")
+
+	tests := []struct {
+		name       string
+		info       *pulumirpc.ProgramInfo
+		opts       runtimeOptions
+		projectDir string
+	}{
+		{
+			name:       "nearest project",
+			info:       &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: stack, EntryPoint: "."},
+			projectDir: root,
+		},
+		{
+			name:       "no root",
+			info:       &pulumirpc.ProgramInfo{ProgramDirectory: stack, EntryPoint: "."},
+			projectDir: stack,
+		},
+		{
+			name:       "project option",
+			info:       &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: stack, EntryPoint: "."},
+			opts:       runtimeOptions{Project: "shared"},
+			projectDir: filepath.Join(root, "shared"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog := mustResolveProgram(t, tt.info, "", tt.opts)
+			if prog.ProjectDir != tt.projectDir {
+				t.Errorf("expected the environment in %s, got %s", tt.projectDir, prog.ProjectDir)
+			}
+			if prog.EntryPoint != filepath.Join(stack, "main.jl") {
+				t.Errorf("unexpected entry point %s", prog.EntryPoint)
+			}
+		})
+	}
+
+	// The search never leaves the project root.
+	os.Remove(filepath.Join(root, "Project.toml"))
+	prog := mustResolveProgram(t, &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: stack, EntryPoint: "."},
+		"", runtimeOptions{})
+	if prog.ProjectDir != stack {
+		t.Errorf("expected the search to stop at %s, got %s", root, prog.ProjectDir)
+	}
+}
+
+func TestHostRootResolvesRelativePrograms(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	writeFile(t, filepath.Join(root, "infra", "main.jl"), "using PulumiRandom\n")
+
+	host := newJuliaLanguageHost("127.0.0.1:0", "", root, defaultMaxSourceScanBytes)
+	prog := mustResolveHostProgram(t, host, nil, "infra", "", runtimeOptions{})
+	if prog.EntryPoint != filepath.Join(root, "infra", "main.jl") {
+		t.Errorf("expected the program to resolve against the root, got %s", prog.EntryPoint)
+	}
+	if prog.ProjectDir != root {
+		t.Errorf("expected the environment search to reach the root, got %s", prog.ProjectDir)
+	}
+
+	// The engine's working directory takes precedence over the root.
+	pwd := t.TempDir()
+	if prog := mustResolveHostProgram(t, host, nil, "infra", pwd, runtimeOptions{}); prog.EntryPoint != filepath.Join(pwd, "infra", "main.jl") {
+		t.Errorf("expected the program to resolve against the working directory, got %s", prog.EntryPoint)
+	}
+
+	resp, err := host.GetRequiredPlugins(context.Background(), &pulumirpc.GetRequiredPluginsRequest{Program: "infra"})
+	if err != nil {
+		t.Fatalf("GetRequiredPlugins: %v", err)
+	}
+	if names := strings.Join(pluginNames(resp.GetPlugins()), ","); names != "random" {
+		t.Errorf("expected the random plugin, got %s", names)
+	}
+}
+
+func TestGetProgramDependencies(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "Project.toml"), `[deps]
+PulumiAWS = "5f3c2e7a-0b1d-4a6e-8d2c-1e4f9b7a3c21"
+`)
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), `manifest_format = "2.0"
+
+[[deps.Pulumi]]
+uuid = "90af1f71-c6d8-4a0a-9f87-1292e80e7fff"
+version = "0.1.0"
+
+[[deps.PulumiAWS]]
+deps = ["Pulumi"]
+uuid = "5f3c2e7a-0b1d-4a6e-8d2c-1e4f9b7a3c21"
+version = "6.40.0"
+`)
+
+	host := newTestHost()
+	for _, tt := range []struct {
+		transitive bool
+		expected   string
+	}{
+		{false, "PulumiAWS@6.40.0"},
+		{true, "Pulumi@0.1.0,PulumiAWS@6.40.0"},
+	} {
+		resp, err := host.GetProgramDependencies(context.Background(), &pulumirpc.GetProgramDependenciesRequest{
+			Program:                dir,
+			TransitiveDependencies: tt.transitive,
+		})
+		if err != nil {
+			t.Fatalf("GetProgramDependencies: %v", err)
+		}
+		var deps []string
+		for _, dep := range resp.GetDependencies() {
+			deps = append(deps, dep.GetName()+"@"+dep.GetVersion())
+		}
+		if actual := strings.Join(deps, ","); actual != tt.expected {
+			t.Errorf("transitive=%v: expected %s, got %s", tt.transitive, tt.expected, actual)
+		}
+	}
+}
+
+func TestResolveProgramFromProjectToml(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[pulumi]\nentrypoint = \"src/run.jl\"\n")
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	writeFile(t, filepath.Join(root, "src", "run.jl"), "")
+	writeFile(t, filepath.Join(root, "other.jl"), "")
+	info := &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."}
+
+	if prog := mustResolveProgram(t, info, "", runtimeOptions{}); prog.EntryPoint != filepath.Join(root, "src", "run.jl") {
+		t.Errorf("expected the [pulumi] entry point, got %s", prog.EntryPoint)
+	}
+	if prog := mustResolveProgram(t, info, "", runtimeOptions{EntryPoint: "other.jl"}); prog.EntryPoint != filepath.Join(root, "other.jl") {
+		t.Errorf("expected the runtime option to take precedence, got %s", prog.EntryPoint)
+	}
+	main := &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "other.jl"}
+	if prog := mustResolveProgram(t, main, "", runtimeOptions{}); prog.EntryPoint != filepath.Join(root, "other.jl") {
+		t.Errorf("expected Pulumi.yaml's main to take precedence, got %s", prog.EntryPoint)
+	}
+}
+
+func TestRunMalformedProjectToml(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[pulumi]\nentrypoint = 3\n")
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+
+	host := newTestHost()
+	resp, err := host.Run(context.Background(), &pulumirpc.RunRequest{Program: root})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !strings.Contains(resp.GetError(), filepath.Join(root, "Project.toml")) {
+		t.Errorf("expected an error naming the Project.toml, got %q", resp.GetError())
+	}
+}
+
+func TestMissingProgramCandidates(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "name = \"Infra\"\n\n[pulumi]\nentrypoint = \"stacks\"\n")
+	info := &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."}
+
+	prog := mustResolveProgram(t, info, "", runtimeOptions{})
+	var tried []string
+	for _, candidate := range prog.Candidates {
+		tried = append(tried, candidate.Source+": "+candidate.Path)
+	}
+	expected := []string{
+		"Pulumi.yaml main: " + root,
+		"[pulumi] entrypoint in " + filepath.Join(root, "Project.toml") + ": " + filepath.Join(root, "stacks"),
+		"default entry point: " + filepath.Join(root, "stacks", "main.jl"),
+	}
+	if strings.Join(tried, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected candidates %q, got %q", expected, tried)
+	}
+
+	msg := missingProgramError(prog, "/work")
+	mentions := []string{"working directory: /work\n", "`main` in Pulumi.yaml"}
+	for _, candidate := range prog.Candidates {
+		mentions = append(mentions, "  - "+candidate.Path+" ("+candidate.Source+")\n")
+	}
+	for _, s := range mentions {
+		if !strings.Contains(msg, s) {
+			t.Errorf("expected the error to mention %q, got %q", s, msg)
+		}
+	}
+}
+
+func TestMissingPackageModuleCandidate(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "name = \"Infra\"\n")
+
+	prog := mustResolveProgram(t, nil, root, runtimeOptions{})
+	last := prog.Candidates[len(prog.Candidates)-1]
+	if last.Path != filepath.Join(root, "src", "Infra.jl") || last.Source != "package module" {
+		t.Errorf("expected the package module to be considered, got %+v", prog.Candidates)
+	}
+}
+
+func TestResolveProgramOutsideProject(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	templates := t.TempDir()
+	writeFile(t, filepath.Join(templates, "Project.toml"), "[deps]\n")
+	writeFile(t, filepath.Join(templates, "vpc.jl"), "")
+	vpc := filepath.Join(templates, "vpc.jl")
+
+	for _, info := range []*pulumirpc.ProgramInfo{
+		{RootDirectory: root, ProgramDirectory: templates, EntryPoint: "vpc.jl"},
+		{RootDirectory: root, ProgramDirectory: root, EntryPoint: vpc},
+	} {
+		prog := mustResolveProgram(t, info, "", runtimeOptions{})
+		if prog.ProjectDir != root || prog.EntryPoint != vpc {
+			t.Errorf("expected %s to run in the environment in %s, got %s in %s",
+				vpc, root, prog.EntryPoint, prog.ProjectDir)
+		}
+	}
+
+	// The upward search for the environment starts from the root.
+	stacks := filepath.Join(root, "stacks")
+	writeFile(t, filepath.Join(stacks, "Project.toml"), "[deps]\n")
+	info := &pulumirpc.ProgramInfo{RootDirectory: stacks, ProgramDirectory: templates, EntryPoint: "vpc.jl"}
+	if prog := mustResolveProgram(t, info, "", runtimeOptions{}); prog.ProjectDir != stacks {
+		t.Errorf("expected the environment in %s, got %s", stacks, prog.ProjectDir)
+	}
+}
+
+func TestWithin(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "work", "project")
+	for path, expected := range map[string]bool{
+		root:                                  true,
+		filepath.Join(root, "stacks"):         true,
+		filepath.Join(root, "..project-data"): true,
+		filepath.Dir(root):                    false,
+		filepath.Join(root, "..", "other"):    false,
+	} {
+		if actual := within(root, path); actual != expected {
+			t.Errorf("within(%s, %s): expected %v, got %v", root, path, expected, actual)
+		}
+	}
+}
+
+func TestResolveProgramNamedEnvironment(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	empty, depot := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(depot, "environments", "pulumi", "Project.toml"), "[deps]\n")
+	t.Setenv("JULIA_DEPOT_PATH", empty+string(os.PathListSeparator)+depot)
+
+	info := &pulumirpc.ProgramInfo{RootDirectory: root, ProgramDirectory: root, EntryPoint: "."}
+	prog := mustResolveHostProgram(t, newTestHost(), info, "", "", runtimeOptions{Project: "@pulumi"})
+	if prog.Environment != "@pulumi" || prog.ProjectDir != filepath.Join(depot, "environments", "pulumi") {
+		t.Errorf("expected the shared environment in %s, got %+v", depot, prog)
+	}
+	if args := juliaRunArgs(prog, nil, nil); args[0] != "--project=@pulumi" {
+		t.Errorf("expected the environment name to be passed verbatim, got %q", args)
+	}
+
+	// A missing environment is expected where Pkg creates it.
+	prog = mustResolveHostProgram(t, newTestHost(), info, "", "", runtimeOptions{Project: "@infra"})
+	if prog.ProjectDir != filepath.Join(empty, "environments", "infra") {
+		t.Errorf("expected the environment in the first depot, got %s", prog.ProjectDir)
+	}
+}
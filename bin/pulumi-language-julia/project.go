@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+// juliaProject is the subset of a Julia Project.toml used by the language host.
+type juliaProject struct {
+	Name    string             `toml:"name"`
+	UUID    string             `toml:"uuid"`
+	Version string             `toml:"version"`
+	Deps    map[string]string  `toml:"deps"`
+	Compat  map[string]string  `toml:"compat"`
+	Pulumi  juliaProjectPulumi `toml:"pulumi"`
+}
+
+// juliaProjectPulumi is the optional `[pulumi]` table of a Project.toml,
+// keeping Pulumi settings with the Julia project:
+//
+//	[pulumi]
+//	entrypoint = "src/run.jl"
+type juliaProjectPulumi struct {
+	// EntryPoint is the program to run, relative to the Project.toml. It is
+	// used unless a runtime option or Pulumi.yaml's `main` names a file.
+	EntryPoint string `toml:"entrypoint"`
+}
+
+// readJuliaProject parses the Project.toml in dir. It returns nil without an
+// error if the file does not exist.
+func readJuliaProject(dir string) (*juliaProject, error) {
+	path := filepath.Join(dir, "Project.toml")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var project juliaProject
+	if _, err := toml.DecodeFile(path, &project); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &project, nil
+}
+
+// manifestEntry is a single resolved package in a Julia Manifest.toml.
+type manifestEntry struct {
+	UUID        string
+	Version     string
+	Path        string
+	GitTreeSha1 string
+}
+
+// juliaManifest maps package names to their resolved Manifest.toml entries.
+type juliaManifest map[string]manifestEntry
+
+// readJuliaManifest parses the Manifest.toml in dir, supporting both the
+// current (`[[deps.Name]]`) and the legacy (`[[Name]]`) manifest formats. It
+// returns nil without an error if the file does not exist.
+func readJuliaManifest(dir string) (juliaManifest, error) {
+	path := filepath.Join(dir, "Manifest.toml")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var raw map[string]interface{}
+	if _, err := toml.DecodeFile(path, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	packages := raw
+	if _, ok := raw["manifest_format"]; ok {
+		deps, _ := raw["deps"].(map[string]interface{})
+		packages = deps
+	}
+
+	manifest := juliaManifest{}
+	for name, value := range packages {
+		entries, ok := value.([]map[string]interface{})
+		if !ok || len(entries) == 0 {
+			continue
+		}
+		entry := entries[0]
+		manifest[name] = manifestEntry{
+			UUID:        tomlString(entry, "uuid"),
+			Version:     tomlString(entry, "version"),
+			Path:        tomlString(entry, "path"),
+			GitTreeSha1: tomlString(entry, "git-tree-sha1"),
+		}
+	}
+	return manifest, nil
+}
+
+// tomlString returns the string value stored under key, or "" if it is absent
+// or not a string.
+func tomlString(table map[string]interface{}, key string) string {
+	s, _ := table[key].(string)
+	return s
+}
+
+// programDependencies lists the Julia packages of the environment in dir with
+// their resolved versions: the direct dependencies from Project.toml or, if
+// transitive is set, every package in Manifest.toml.
+func programDependencies(dir string, transitive bool) ([]*pulumirpc.DependencyInfo, error) {
+	project, err := readJuliaProject(dir)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readJuliaManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	if transitive {
+		for name := range manifest {
+			names = append(names, name)
+		}
+	} else if project != nil {
+		for name := range project.Deps {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	dependencies := make([]*pulumirpc.DependencyInfo, 0, len(names))
+	for _, name := range names {
+		dependencies = append(dependencies, &pulumirpc.DependencyInfo{
+			Name:    name,
+			Version: manifest[name].Version,
+		})
+	}
+	return dependencies, nil
+}
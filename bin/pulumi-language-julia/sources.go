@@ -0,0 +1,283 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// juliaTokenKind classifies the tokens produced by juliaTokenizer.
+type juliaTokenKind int
+
+const (
+	tokenIdent juliaTokenKind = iota
+	tokenPunct
+	tokenNewline
+	tokenString
+)
+
+// juliaToken is a single token of Julia source code.
+type juliaToken struct {
+	kind juliaTokenKind
+	text string
+	// literal is set for string tokens without interpolation, whose text is
+	// then the value of the string.
+	literal bool
+}
+
+// juliaTokenizer splits Julia source into the coarse tokens needed to find
+// `using` and `import` statements. It is deliberately not a full Julia lexer:
+// comments are dropped, string and character literals are collapsed into a
+// single token (carrying the value of plain string literals), and everything
+// else is either an identifier or punctuation.
+type juliaTokenizer struct {
+	src []byte
+	pos int
+	// prev is the kind of the last token produced, used to tell character
+	// literals apart from the adjoint operator.
+	prev juliaToken
+}
+
+func newJuliaTokenizer(src []byte) *juliaTokenizer {
+	return &juliaTokenizer{src: src, prev: juliaToken{kind: tokenNewline}}
+}
+
+// next returns the next token, or false once the input is exhausted.
+func (t *juliaTokenizer) next() (juliaToken, bool) {
+	for t.pos < len(t.src) {
+		c := t.src[t.pos]
+		switch {
+		case c == '\n':
+			t.pos++
+			return t.emit(juliaToken{kind: tokenNewline, text: "\n"}), true
+		case c == ' ' || c == '\t' || c == '\r':
+			t.pos++
+		case c == '#':
+			if t.pos+1 < len(t.src) && t.src[t.pos+1] == '=' {
+				t.skipBlockComment()
+			} else {
+				t.skipLineComment()
+			}
+		case c == '"' || c == '`':
+			value, literal := t.readString(c)
+			return t.emit(juliaToken{kind: tokenString, text: value, literal: literal}), true
+		case c == '\'' && !t.isAdjoint():
+			t.skipCharLiteral()
+			return t.emit(juliaToken{kind: tokenString}), true
+		default:
+			r, size := utf8.DecodeRune(t.src[t.pos:])
+			if isIdentStart(r) {
+				start := t.pos
+				for t.pos < len(t.src) {
+					r, size := utf8.DecodeRune(t.src[t.pos:])
+					if !isIdentChar(r) {
+						break
+					}
+					t.pos += size
+				}
+				return t.emit(juliaToken{kind: tokenIdent, text: string(t.src[start:t.pos])}), true
+			}
+			t.pos += size
+			return t.emit(juliaToken{kind: tokenPunct, text: string(r)}), true
+		}
+	}
+	return juliaToken{}, false
+}
+
+func (t *juliaTokenizer) emit(tok juliaToken) juliaToken {
+	t.prev = tok
+	return tok
+}
+
+// isAdjoint reports whether a `'` at the current position is the adjoint
+// operator (as in `A'`) rather than the start of a character literal.
+func (t *juliaTokenizer) isAdjoint() bool {
+	switch t.prev.kind {
+	case tokenIdent, tokenString:
+		return t.pos > 0 && t.src[t.pos-1] != ' ' && t.src[t.pos-1] != '\t'
+	case tokenPunct:
+		return t.prev.text == ")" || t.prev.text == "]" || t.prev.text == "}" || t.prev.text == "'"
+	}
+	return false
+}
+
+func (t *juliaTokenizer) skipLineComment() {
+	for t.pos < len(t.src) && t.src[t.pos] != '\n' {
+		t.pos++
+	}
+}
+
+// skipBlockComment skips a possibly nested `#= ... =#` comment.
+func (t *juliaTokenizer) skipBlockComment() {
+	depth := 0
+	for t.pos < len(t.src) {
+		switch {
+		case t.hasPrefix("#="):
+			depth++
+			t.pos += 2
+		case t.hasPrefix("=#"):
+			depth--
+			t.pos += 2
+			if depth == 0 {
+				return
+			}
+		default:
+			t.pos++
+		}
+	}
+}
+
+// readString consumes a string or command literal delimited by quote,
+// including the triple-quoted form. It returns the value of the literal and
+// whether it is a plain literal, i.e. one without interpolation.
+func (t *juliaTokenizer) readString(quote byte) (string, bool) {
+	delim := string([]byte{quote})
+	if t.hasPrefix(delim + delim + delim) {
+		delim += delim + delim
+	}
+	t.pos += len(delim)
+
+	var value strings.Builder
+	literal := true
+	for t.pos < len(t.src) {
+		switch c := t.src[t.pos]; {
+		case c == '\\':
+			if t.pos+1 < len(t.src) {
+				value.WriteByte(unescapeByte(t.src[t.pos+1]))
+			}
+			t.pos += 2
+		case t.hasPrefix(delim):
+			t.pos += len(delim)
+			return value.String(), literal
+		default:
+			if c == '$' {
+				literal = false
+			}
+			value.WriteByte(c)
+			t.pos++
+		}
+	}
+	return value.String(), literal
+}
+
+// unescapeByte returns the byte denoted by the escape sequence `\c`.
+func unescapeByte(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	case 'r':
+		return '\r'
+	case '0':
+		return 0
+	}
+	return c
+}
+
+func (t *juliaTokenizer) skipCharLiteral() {
+	t.pos++
+	for t.pos < len(t.src) && t.src[t.pos] != '\n' {
+		switch t.src[t.pos] {
+		case '\\':
+			t.pos += 2
+		case '\'':
+			t.pos++
+			return
+		default:
+			t.pos++
+		}
+	}
+}
+
+func (t *juliaTokenizer) hasPrefix(s string) bool {
+	return len(t.src)-t.pos >= len(s) && string(t.src[t.pos:t.pos+len(s)]) == s
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentChar(r rune) bool {
+	return isIdentStart(r) || r == '!' || unicode.IsDigit(r)
+}
+
+// juliaSourceInfo summarizes what a Julia source file loads.
+type juliaSourceInfo struct {
+	// Imports are the top-level packages loaded by `using` and `import`
+	// statements, in order of first appearance. Relative imports
+	// (`using .Local`) are not packages and are skipped.
+	Imports []string
+	// Includes are the paths passed to `include` as plain string literals.
+	Includes []string
+	// DynamicIncludes counts the `include` calls whose argument isn't a plain
+	// string literal and so can't be followed.
+	DynamicIncludes int
+}
+
+// scanJuliaSource finds the packages loaded and the files included by src.
+func scanJuliaSource(src []byte) juliaSourceInfo {
+	t := newJuliaTokenizer(src)
+	var info juliaSourceInfo
+	seen := map[string]bool{}
+
+	tok, ok := t.next()
+	for ok {
+		if tok.kind == tokenIdent && tok.text == "include" {
+			tok, ok = t.next()
+			if !ok || tok.kind != tokenPunct || tok.text != "(" {
+				continue
+			}
+			arg, more := t.next()
+			closing, closed := t.next()
+			if more && arg.kind == tokenString && arg.literal && closed && closing.text == ")" {
+				info.Includes = append(info.Includes, arg.text)
+			} else {
+				info.DynamicIncludes++
+			}
+			tok, ok = t.next()
+			continue
+		}
+		if tok.kind != tokenIdent || (tok.text != "using" && tok.text != "import") {
+			tok, ok = t.next()
+			continue
+		}
+
+		// Parse a comma separated list of module paths, stopping at the end of
+		// the statement or at a `:` that introduces the imported names.
+		for {
+			tok, ok = t.next()
+			relative := false
+			for ok && tok.kind == tokenPunct && tok.text == "." {
+				relative = true
+				tok, ok = t.next()
+			}
+			if !ok || tok.kind != tokenIdent {
+				break
+			}
+			if !relative && !seen[tok.text] {
+				seen[tok.text] = true
+				info.Imports = append(info.Imports, tok.text)
+			}
+
+			// Skip the rest of the path and any `as` rename.
+			tok, ok = t.next()
+			for ok && (tok.kind == tokenIdent || (tok.kind == tokenPunct && tok.text == ".")) {
+				tok, ok = t.next()
+			}
+			if !ok || tok.kind != tokenPunct || tok.text != "," {
+				break
+			}
+			// A trailing comma continues the statement onto the next line.
+			for {
+				next := *t
+				peek, more := next.next()
+				if !more || peek.kind != tokenNewline {
+					break
+				}
+				*t = next
+			}
+		}
+	}
+	return info
+}
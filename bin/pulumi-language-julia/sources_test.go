@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+func TestScanJuliaImports(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		expected []string
+	}{
+		{"using", "using PulumiAWS\n", []string{"PulumiAWS"}},
+		{"import", "import PulumiAWS\n", []string{"PulumiAWS"}},
+		{"multiple", "using Pulumi, PulumiAWS,PulumiRandom\n", []string{"Pulumi", "PulumiAWS", "PulumiRandom"}},
+		{"continued", "using PulumiAWS,\n    PulumiRandom\n", []string{"PulumiAWS", "PulumiRandom"}},
+		{"names", "using PulumiAWS: S3, EC2\n", []string{"PulumiAWS"}},
+		{"submodule", "import PulumiAWS.S3\n", []string{"PulumiAWS"}},
+		{"rename", "import PulumiAWS as AWS, PulumiRandom\n", []string{"PulumiAWS", "PulumiRandom"}},
+		{"relative", "using .Network, ..Shared\n", nil},
+		{"semicolon", "using Pulumi; using PulumiAWS\n", []string{"Pulumi", "PulumiAWS"}},
+		{"indented", "begin\n    using PulumiAWS\nend\n", []string{"PulumiAWS"}},
+		{"line comment", "# using PulumiAWS\nx = 1 # import PulumiRandom\n", nil},
+		{"block comment", "#= using PulumiAWS\n#= nested =# import PulumiGCP =#\n", nil},
+		{"string", `s = "using PulumiAWS"` + "\n", nil},
+		{"triple string", "s = \"\"\"\nusing PulumiAWS\n\"\"\"\n", nil},
+		{"escaped quote", `s = "\"using PulumiAWS"` + "\n", nil},
+		{"command", "run(`using PulumiAWS`)\n", nil},
+		{"char literal", "c = '\"'\nusing PulumiAWS\n", []string{"PulumiAWS"}},
+		{"adjoint", "y = x'\nusing PulumiAWS\n", []string{"PulumiAWS"}},
+		{"duplicates", "using PulumiAWS\nimport PulumiAWS\n", []string{"PulumiAWS"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := scanJuliaSource([]byte(tt.src)).Imports
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestGetRequiredPluginsFromSources(t *testing.T) {
+	host := newTestHost()
+	resp, err := host.GetRequiredPlugins(context.Background(), &pulumirpc.GetRequiredPluginsRequest{
+		Program: "testdata/sources",
+	})
+	if err != nil {
+		t.Fatalf("GetRequiredPlugins: %v", err)
+	}
+
+	names := pluginNames(resp.GetPlugins())
+	expected := []string{"aws", "random"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected plugins %v, got %v", expected, names)
+	}
+}
+
+func TestSourceScanLimit(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "main.jl"), "using PulumiAWS\n")
+
+	for _, limit := range []int64{0, 4} {
+		detector := &pluginDetector{maxSourceBytes: limit}
+		plugins, err := detector.detect(dir)
+		if err != nil {
+			t.Fatalf("detect: %v", err)
+		}
+		if len(plugins) != 0 {
+			t.Errorf("limit %d: expected source scanning to be skipped, got %v", limit, pluginNames(plugins))
+		}
+	}
+}
+
+func TestScanJuliaSourceIncludes(t *testing.T) {
+	src := `include("a.jl")
+include( "sub/b.jl" )
+include("$(name).jl")
+include(joinpath(@__DIR__, "c.jl"))
+# include("commented.jl")
+Base.include(Main, "d.jl")
+`
+	info := scanJuliaSource([]byte(src))
+	expected := []string{"a.jl", "sub/b.jl"}
+	if !reflect.DeepEqual(info.Includes, expected) {
+		t.Errorf("expected includes %v, got %v", expected, info.Includes)
+	}
+	if info.DynamicIncludes != 3 {
+		t.Errorf("expected 3 dynamic includes, got %d", info.DynamicIncludes)
+	}
+}
+
+func TestGetRequiredPluginsFollowsIncludes(t *testing.T) {
+	detector := &pluginDetector{maxSourceBytes: defaultMaxSourceScanBytes}
+	plugins, err := detector.detect("testdata/includes")
+	if err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+
+	names := pluginNames(plugins)
+	expected := []string{"aws", "random"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected plugins %v, got %v", expected, names)
+	}
+	// Each file is scanned once, even though compute.jl and instances.jl
+	// include each other.
+	if len(detector.sources) != 4 {
+		t.Errorf("expected 4 scanned sources, got %v", detector.sources)
+	}
+}
+
+func TestIncludeDepthLimit(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "main.jl"), `include("f0.jl")`)
+	for i := 0; i < maxIncludeDepth+1; i++ {
+		writeFile(t, filepath.Join(dir, fmt.Sprintf("f%d.jl", i)), fmt.Sprintf(`include("f%d.jl")`, i+1))
+	}
+	writeFile(t, filepath.Join(dir, fmt.Sprintf("f%d.jl", maxIncludeDepth+1)), "using PulumiAWS\n")
+
+	detector := &pluginDetector{maxSourceBytes: defaultMaxSourceScanBytes}
+	plugins, err := detector.detect(dir)
+	if err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+	if len(plugins) != 0 {
+		t.Errorf("expected includes beyond the depth limit to be ignored, got %v", pluginNames(plugins))
+	}
+}
+
+// writeMonorepo writes a program whose main.jl includes n files, each loading
+// PulumiAWS, next to n files it doesn't include, loading PulumiRandom.
+func writeMonorepo(tb testing.TB, n int) string {
+	tb.Helper()
+	dir := tb.TempDir()
+	var main strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&main, "include(\"stacks/s%d.jl\")\n", i)
+		for name, src := range map[string]string{
+			fmt.Sprintf("stacks/s%d.jl", i): "using PulumiAWS\n",
+			fmt.Sprintf("other/o%d.jl", i):  "using PulumiRandom\n",
+		} {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				tb.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.jl"), []byte(main.String()), 0o600); err != nil {
+		tb.Fatal(err)
+	}
+	return dir
+}
+
+func TestScanSourceManyFiles(t *testing.T) {
+	dir := writeMonorepo(t, 2000)
+	detector := &pluginDetector{maxSourceBytes: defaultMaxSourceScanBytes, scanTimeout: time.Minute}
+	plugins, err := detector.detect(dir)
+	if err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+	if names := pluginNames(plugins); !reflect.DeepEqual(names, []string{"aws"}) {
+		t.Errorf("expected only the plugins of included files, got %v", names)
+	}
+	if len(detector.sources) != 2001 || detector.truncated {
+		t.Errorf("expected the entry point and the files it includes to be scanned, got %d sources (truncated %v)",
+			len(detector.sources), detector.truncated)
+	}
+}
+
+func TestScanSourceTimeout(t *testing.T) {
+	dir := writeMonorepo(t, 100)
+	detector := &pluginDetector{maxSourceBytes: defaultMaxSourceScanBytes, scanTimeout: time.Nanosecond}
+	if _, err := detector.detect(dir); err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+	if !detector.truncated {
+		t.Errorf("expected the scan to run out of time")
+	}
+
+	// Truncated results are not cached.
+	cache := newPluginDetectionCache()
+	if _, err := cache.detect(detector, dir); err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+	if len(cache.entries) != 0 {
+		t.Errorf("expected a truncated result not to be cached, got %v", cache.entries)
+	}
+}
+
+func TestGetRequiredPluginsDetectionModes(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "Project.toml"), "[deps]\nPulumiGCP = \"00000000-0000-0000-0000-000000000000\"\n")
+	writeFile(t, filepath.Join(dir, "main.jl"), "using PulumiAWS\n")
+	host := newTestHost()
+	for mode, expected := range map[string][]string{
+		"sources":      {"aws", "gcp"},
+		"project-only": {"gcp"},
+		"off":          {"random"},
+	} {
+		resp, err := host.GetRequiredPlugins(context.Background(), &pulumirpc.GetRequiredPluginsRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: dir, ProgramDirectory: dir, EntryPoint: ".",
+				Options: mustStruct(t, map[string]interface{}{
+					"pluginDetection": mode,
+					"plugins":         []interface{}{map[string]interface{}{"name": "random"}},
+				}),
+			},
+		})
+		if err != nil {
+			t.Fatalf("GetRequiredPlugins: %v", err)
+		}
+		names := pluginNames(resp.GetPlugins())
+		if mode != "off" {
+			expected = append(expected, "random")
+		}
+		sort.Strings(names)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(names, expected) {
+			t.Errorf("pluginDetection %s: expected plugins %v, got %v", mode, expected, names)
+		}
+	}
+}
+
+func BenchmarkScanSource(b *testing.B) {
+	dir := writeMonorepo(b, 2000)
+	for i := 0; i < b.N; i++ {
+		detector := &pluginDetector{maxSourceBytes: defaultMaxSourceScanBytes}
+		detector.scanSource(filepath.Join(dir, "main.jl"))
+	}
+}
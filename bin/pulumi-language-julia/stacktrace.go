@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxUserFrames is how many of the frames of each exception in a program's
+// stack trace that are in user code are kept in its summary.
+const maxUserFrames = 3
+
+var (
+	// ansiEscape matches the escape sequences coloring julia's output.
+	ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	// stackFrame matches the first line of a stack frame, `[1] f(x::Int64)`,
+	// which before Julia 1.6 is followed by ` at path:line` rather than by a
+	// location line.
+	stackFrame = regexp.MustCompile(`^\[\d+\] (.+?)(?: at (.+):(\d+))?$`)
+	// frameLocation matches the location line of a stack frame,
+	// `@ Module path:line`, where the module is missing for some frames.
+	frameLocation = regexp.MustCompile(`^@ (?:[A-Za-z_][\w.]* )?(.+):(\d+)(?: \[inlined\])?$`)
+	// expressionStart matches the line locating the top-level expression an
+	// error was thrown while including, one for each file being included.
+	expressionStart = regexp.MustCompile(`^in expression starting at (.+):(\d+)$`)
+)
+
+// juliaError is an uncaught exception reported by julia, with the exceptions
+// of its `caused by:` chain.
+type juliaError struct {
+	exceptions []*juliaException
+	// location is where in user code the error was thrown, `path:line`
+	// relative to the user directory: the innermost user frame of its stack
+	// trace or, for errors without one such as syntax errors, the innermost
+	// user file being included.
+	location string
+}
+
+// juliaException is an exception reported in a julia stack trace.
+type juliaException struct {
+	message []string
+	frames  []string
+	// function is that of the frame whose location is on the next line.
+	function string
+}
+
+// parseJuliaError parses the uncaught exception reported by julia in stderr,
+// keeping the first few frames of each exception in its stack trace that are
+// in user code, those in files under userDir. It returns nil if stderr
+// reports no exception.
+func parseJuliaError(stderr, userDir string) *juliaError {
+	lines := strings.Split(ansiEscape.ReplaceAllString(stderr, ""), "\n")
+	start := -1
+	for i, line := range lines {
+		if strings.HasPrefix(line, "ERROR: ") {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+
+	e := &juliaError{}
+	var current *juliaException
+	var included string
+	inTrace := false
+	for i, line := range lines[start:] {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if m := expressionStart.FindStringSubmatch(trimmed); m != nil && included == "" {
+			if rel, ok := userPath(m[1], userDir); ok {
+				included = rel + ":" + m[2]
+			}
+		}
+		switch {
+		case i == 0:
+			current = &juliaException{message: []string{strings.TrimPrefix(line, "ERROR: ")}}
+		case strings.HasPrefix(trimmed, "caused by: "), strings.HasPrefix(trimmed, "nested task error: "):
+			current = &juliaException{message: []string{trimmed}}
+			inTrace = false
+		case !inTrace && trimmed == "Stacktrace:":
+			inTrace = true
+			continue
+		case !inTrace:
+			current.message = append(current.message, line)
+			continue
+		default:
+			var location string
+			if m := stackFrame.FindStringSubmatch(trimmed); m != nil {
+				current.function = m[1]
+				if m[2] != "" {
+					location = current.addFrame(m[2], m[3], userDir)
+				}
+			} else if m := frameLocation.FindStringSubmatch(trimmed); m != nil {
+				location = current.addFrame(m[1], m[2], userDir)
+			}
+			if e.location == "" {
+				e.location = location
+			}
+			continue
+		}
+		e.exceptions = append(e.exceptions, current)
+	}
+	if e.location == "" {
+		e.location = included
+	}
+	return e
+}
+
+// summary reduces the error, with its whole stack trace, to its message and
+// the frames kept of each exception in its `caused by:` chain.
+func (e *juliaError) summary() string {
+	var summary []string
+	for i, exc := range e.exceptions {
+		message := strings.TrimRight(strings.Join(exc.message, "\n"), "\n ")
+		if i == 0 {
+			// Errors thrown while including the program are wrapped in a
+			// LoadError, which says no more than the frames do.
+			for {
+				trimmed, ok := strings.CutPrefix(message, "LoadError: ")
+				if !ok {
+					break
+				}
+				message = trimmed
+			}
+			message = "ERROR: " + message
+		}
+		summary = append(summary, message)
+		summary = append(summary, exc.frames...)
+	}
+	return strings.Join(summary, "\n")
+}
+
+// addFrame adds the frame of the last function seen, at path:line, if it is
+// in user code, returning its location relative to userDir.
+func (e *juliaException) addFrame(path, line, userDir string) string {
+	function := e.function
+	e.function = ""
+	if function == "" {
+		return ""
+	}
+	rel, ok := userPath(path, userDir)
+	if !ok {
+		return ""
+	}
+	if len(e.frames) < maxUserFrames {
+		e.frames = append(e.frames, fmt.Sprintf("  at %s (%s:%s)", function, rel, line))
+	}
+	return rel + ":" + line
+}
+
+// userPath returns path relative to userDir, and whether it is in userDir.
+// Julia abbreviates paths in the user's home directory with a `~`.
+func userPath(path, userDir string) (string, bool) {
+	if userDir == "" {
+		return "", false
+	}
+	if rest, ok := strings.CutPrefix(path, "~"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", false
+		}
+		path = home + rest
+	}
+	if !filepath.IsAbs(path) {
+		return "", false
+	}
+	for _, dir := range []string{userDir, realPath(userDir)} {
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+func TestParseJuliaError(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	dir := filepath.Join(home, "infra")
+	main := filepath.Join(dir, "main.jl")
+
+	tests := []struct {
+		name     string
+		stderr   string
+		want     string
+		location string
+	}{
+		{
+			name: "load error",
+			stderr: "┌ Info: creating bucket\n└ @ Main " + main + ":2\n" +
+				"ERROR: LoadError: bucket name is taken\n" +
+				"Stacktrace:\n" +
+				" [1] error(s::String)\n" +
+				"   @ Base ./error.jl:35\n" +
+				" [2] create_bucket(name::String)\n" +
+				"   @ Main " + filepath.Join(dir, "lib", "buckets.jl") + ":12\n" +
+				" [3] macro expansion\n" +
+				"   @ ~/infra/main.jl:7 [inlined]\n" +
+				" [4] top-level scope\n" +
+				"   @ " + main + ":9\n" +
+				" [5] main()\n" +
+				"   @ Main " + main + ":20\n" +
+				" [6] include(mod::Module, _path::String)\n" +
+				"   @ Base ./Base.jl:495\n" +
+				"in expression starting at " + main + ":9\n",
+			want: "ERROR: bucket name is taken\n" +
+				"  at create_bucket(name::String) (lib/buckets.jl:12)\n" +
+				"  at macro expansion (main.jl:7)\n" +
+				"  at top-level scope (main.jl:9)",
+			location: "lib/buckets.jl:12",
+		},
+		{
+			name: "caused by",
+			stderr: "\x1b[91m\x1b[1mERROR: \x1b[22m\x1b[39mLoadError: deployment failed\n" +
+				"with details\n" +
+				"Stacktrace:\n" +
+				" [1] deploy()\n" +
+				"   @ Main " + main + ":4\n" +
+				"\n" +
+				"caused by: KeyError: key \"region\" not found\n" +
+				"Stacktrace:\n" +
+				" [1] getindex(h::Dict{String, String}, key::String)\n" +
+				"   @ Base ./dict.jl:498\n" +
+				" [2] settings()\n" +
+				"   @ Main " + main + ":2\n" +
+				"\n" +
+				"caused by: nothing useful\n" +
+				"Stacktrace:\n" +
+				" [1] throw_it()\n" +
+				"   @ Base ./somewhere.jl:1\n",
+			want: "ERROR: deployment failed\nwith details\n" +
+				"  at deploy() (main.jl:4)\n" +
+				"caused by: KeyError: key \"region\" not found\n" +
+				"  at settings() (main.jl:2)\n" +
+				"caused by: nothing useful",
+			location: "main.jl:4",
+		},
+		{
+			name: "julia 1.5",
+			stderr: "ERROR: LoadError: boom\n" +
+				"Stacktrace:\n" +
+				" [1] error(::String) at ./error.jl:33\n" +
+				" [2] top-level scope at " + main + ":3\n" +
+				" [3] include(::Function, ::Module, ::String) at ./Base.jl:380\n",
+			want:     "ERROR: boom\n  at top-level scope (main.jl:3)",
+			location: "main.jl:3",
+		},
+		{
+			name: "nested include",
+			stderr: "ERROR: LoadError: LoadError: boom\n" +
+				"Stacktrace:\n" +
+				" [1] error(s::String)\n" +
+				"   @ Base ./error.jl:35\n" +
+				" [2] top-level scope\n" +
+				"   @ " + filepath.Join(dir, "network.jl") + ":5\n" +
+				" [3] include(fname::String)\n" +
+				"   @ Base.MainInclude ./client.jl:489\n" +
+				" [4] top-level scope\n" +
+				"   @ " + main + ":3\n" +
+				"in expression starting at " + filepath.Join(dir, "network.jl") + ":5\n" +
+				"in expression starting at " + main + ":3\n",
+			want:     "ERROR: boom\n  at top-level scope (network.jl:5)\n  at top-level scope (main.jl:3)",
+			location: "network.jl:5",
+		},
+		{
+			name: "syntax error",
+			stderr: "ERROR: LoadError: ParseError:\n" +
+				"# Error @ " + filepath.Join(dir, "network.jl") + ":2:8\n" +
+				"in expression starting at " + filepath.Join(dir, "network.jl") + ":2\n" +
+				"in expression starting at " + main + ":3\n",
+			want: "ERROR: ParseError:\n" +
+				"# Error @ " + filepath.Join(dir, "network.jl") + ":2:8\n" +
+				"in expression starting at " + filepath.Join(dir, "network.jl") + ":2\n" +
+				"in expression starting at " + main + ":3",
+			location: "network.jl:2",
+		},
+		{
+			name:   "no stack trace",
+			stderr: "ERROR: SystemError: opening file \"missing.jl\": No such file or directory\n",
+			want:   "ERROR: SystemError: opening file \"missing.jl\": No such file or directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			juliaErr := parseJuliaError(tt.stderr, dir)
+			if juliaErr == nil {
+				t.Fatal("expected an error to be parsed")
+			}
+			if got := juliaErr.summary(); got != tt.want {
+				t.Errorf("expected\n%s\ngot\n%s", tt.want, got)
+			}
+			if juliaErr.location != tt.location {
+				t.Errorf("expected location %q, got %q", tt.location, juliaErr.location)
+			}
+		})
+	}
+
+	if juliaErr := parseJuliaError("┌ Warning: deprecated\n└ @ Main main.jl:1\n", dir); juliaErr != nil {
+		t.Errorf("expected no error, got %+v", juliaErr)
+	}
+}
+
+func TestRunSummarizesStackTrace(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	trace := "ERROR: LoadError: boom\nStacktrace:\n [1] error(s::String)\n   @ Base ./error.jl:35\n" +
+		" [2] top-level scope\n   @ " + filepath.Join(realPath(root), "main.jl") + ":1\n"
+	fakeJulia(t, "printf '%s' '"+trace+"' >&2\nexit 1")
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	for verbose, want := range map[bool]string{
+		false: "main.jl:1: ERROR: boom\n  at top-level scope (main.jl:1)",
+		true:  "main.jl:1: " + strings.TrimSpace(trace),
+	} {
+		resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+				Options: mustStruct(t, map[string]interface{}{"verboseErrors": verbose}),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		if resp.GetError() != want {
+			t.Errorf("verboseErrors %v: expected %q, got %q", verbose, want, resp.GetError())
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+// autoSysimage is the sysimage runtime option building a sysimage of the
+// project's dependencies with PackageCompiler.jl as they are installed.
+const autoSysimage = "auto"
+
+// sysimageCacheDir is where automatic sysimages are kept, relative to the
+// project root.
+var sysimageCacheDir = filepath.Join(".pulumi", "julia-cache")
+
+// packageCompilerMissingExitCode is the exit code of sysimageBuildScript if
+// PackageCompiler.jl isn't installed.
+const packageCompilerMissingExitCode = 2
+
+// sysimageBuildScript builds a sysimage of the dependencies of the project
+// passed as its second argument at the path passed as its first.
+var sysimageBuildScript = fmt.Sprintf(`Base.find_package("PackageCompiler") === nothing && exit(%d)
+using PackageCompiler
+PackageCompiler.create_sysimage(; sysimage_path=ARGS[1], project=ARGS[2])`, packageCompilerMissingExitCode)
+
+// sysimageMagics start the shared libraries sysimages are built as: ELF,
+// 64-bit Mach-O and PE files.
+var sysimageMagics = [][]byte{[]byte("\x7fELF"), []byte("\xcf\xfa\xed\xfe"), []byte("MZ")}
+
+// autoSysimagePath returns where the automatic sysimage of the project in
+// dir is cached under cacheDir. It is keyed on the project's dependencies, so
+// that each set of dependencies gets a sysimage of its own.
+func autoSysimagePath(ctx context.Context, julia juliaCommand, cacheDir, dir string) (string, error) {
+	key, err := dependencyKey(ctx, julia, dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "sysimage-"+key+sharedLibraryExt()), nil
+}
+
+// dependencyKey identifies the dependencies of the project in dir, for the
+// files cached for them: a hash of its manifest and of julia's version.
+func dependencyKey(ctx context.Context, julia juliaCommand, dir string) (string, error) {
+	var manifest []byte
+	for _, name := range precompileManifests {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+			manifest = data
+			break
+		}
+	}
+	if manifest == nil {
+		return "", fmt.Errorf("%s has no Manifest.toml", dir)
+	}
+	version, err := julia.version(ctx)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00", version)
+	h.Write(manifest)
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// sharedLibraryExt is the extension of shared libraries on this platform.
+func sharedLibraryExt() string {
+	switch runtime.GOOS {
+	case "windows":
+		return ".dll"
+	case "darwin":
+		return ".dylib"
+	}
+	return ".so"
+}
+
+// checkSysimage checks that path holds a sysimage julia can load, as far as
+// can be told without loading it.
+func checkSysimage(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return fmt.Errorf("sysimage %s is truncated", path)
+	}
+	for _, magic := range sysimageMagics {
+		if bytes.HasPrefix(header, magic) {
+			return nil
+		}
+	}
+	return fmt.Errorf("sysimage %s is not a shared library", path)
+}
+
+// buildAutoSysimage builds the automatic sysimage of the project in dir with
+// PackageCompiler.jl, streaming the build's output to stdout and stderr,
+// unless one is cached for its dependencies already. Sysimages built for
+// earlier dependencies are removed once it is built.
+func buildAutoSysimage(
+	ctx context.Context, julia juliaCommand, cacheDir string, prog juliaProgram, dir string,
+	stdout, stderr io.Writer, gracePeriod time.Duration,
+) error {
+	path, err := autoSysimagePath(ctx, julia, cacheDir, dir)
+	if err != nil {
+		return fmt.Errorf("failed to build a sysimage: %w", err)
+	}
+	if err := checkSysimage(path); err == nil {
+		fmt.Fprintf(stdout, "Using the sysimage %s built for the current dependencies\n", path)
+		return nil
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create sysimage cache %s: %w", cacheDir, err)
+	}
+
+	// The sysimage is built aside and moved into place once complete, so
+	// that an interrupted build never leaves a partial one to run with.
+	partial := filepath.Join(cacheDir, "partial-"+filepath.Base(path))
+	defer os.Remove(partial)
+	fmt.Fprintf(stdout, "Building a sysimage of the project's dependencies at %s, which may take several minutes\n", path)
+	cmd := julia.command(ctx, "--project="+prog.project(), "-e", sysimageBuildScript, "--", partial, dir)
+	cmd.Dir = dir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	_, err = runProcess("Julia sysimage build", cmd, gracePeriod)
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == packageCompilerMissingExitCode:
+		return fmt.Errorf("the sysimage runtime option auto requires PackageCompiler.jl; install it with " +
+			"`julia -e 'using Pkg; Pkg.add(\"PackageCompiler\")'` or remove the option")
+	case err != nil:
+		return fmt.Errorf("failed to build a sysimage: %w", err)
+	}
+	if err := checkSysimage(partial); err != nil {
+		return fmt.Errorf("failed to build a sysimage: %w", err)
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return fmt.Errorf("failed to build a sysimage: %w", err)
+	}
+	removeStaleCacheFiles(cacheDir, "sysimage-", path)
+	return nil
+}
+
+// removeStaleCacheFiles removes the files in cacheDir named with prefix,
+// cached for earlier dependencies, keeping current and the files named after
+// it.
+func removeStaleCacheFiles(cacheDir, prefix, current string) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return
+	}
+	stem := strings.TrimSuffix(filepath.Base(current), filepath.Ext(current))
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), prefix) || strings.HasPrefix(entry.Name(), stem) {
+			continue
+		}
+		path := filepath.Join(cacheDir, entry.Name())
+		if err := os.Remove(path); err != nil {
+			logging.V(5).Infof("failed to remove stale cache file %s: %v", path, err)
+		}
+	}
+}
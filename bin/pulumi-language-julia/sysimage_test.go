@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+// sysimageJulia is a fake julia reporting version 1.10.4, building sysimages
+// as PackageCompiler.jl would and recording the arguments of other runs in
+// the file calls.
+func sysimageJulia(calls string) string {
+	return `case "$*" in
+*--version) echo "julia version 1.10.4" ;;
+*create_sysimage*)
+	echo "PackageCompiler: creating compiler .ji image" >&2
+	for arg; do [ "$prev" = -- ] && printf '\177ELF' > "$arg"; prev=$arg; done ;;
+*) echo "$@" >> "` + calls + `" ;;
+esac`
+}
+
+func TestCheckSysimage(t *testing.T) {
+	dir := t.TempDir()
+	usable := map[string]string{"elf.so": "\x7fELF\x02\x01", "macho.dylib": "\xcf\xfa\xed\xfe\x07", "pe.dll": "MZ\x90\x00"}
+	for name, content := range usable {
+		writeFile(t, filepath.Join(dir, name), content)
+		if err := checkSysimage(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to be a usable sysimage, got %v", name, err)
+		}
+	}
+	if err := checkSysimage(filepath.Join(dir, "missing.so")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected a missing sysimage to be reported as such, got %v", err)
+	}
+	for name, content := range map[string]string{"truncated.so": "\x7fE", "text.so": "not a library"} {
+		writeFile(t, filepath.Join(dir, name), content)
+		if err := checkSysimage(filepath.Join(dir, name)); err == nil || errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected %s to be an unusable sysimage, got %v", name, err)
+		}
+	}
+}
+
+func TestAutoSysimagePath(t *testing.T) {
+	dir, cache := t.TempDir(), t.TempDir()
+	fakeJulia(t, `echo "julia version $VERSION"`)
+	julia := juliaCommand{Path: "julia"}
+	if _, err := autoSysimagePath(context.Background(), julia, cache, dir); err == nil {
+		t.Errorf("expected a project without a manifest to have no sysimage")
+	}
+
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), "julia_version = \"1.10.4\"\n")
+	t.Setenv("VERSION", "1.10.4")
+	path, err := autoSysimagePath(context.Background(), julia, cache, dir)
+	if err != nil {
+		t.Fatalf("autoSysimagePath: %v", err)
+	}
+	if filepath.Dir(path) != cache || !strings.HasPrefix(filepath.Base(path), "sysimage-") {
+		t.Errorf("expected a sysimage in %s, got %s", cache, path)
+	}
+	if again, _ := autoSysimagePath(context.Background(), julia, cache, dir); again != path {
+		t.Errorf("expected unchanged dependencies to keep their sysimage, got %s and %s", path, again)
+	}
+
+	t.Setenv("VERSION", "1.11.1")
+	upgraded, _ := autoSysimagePath(context.Background(), julia, cache, dir)
+	writeFile(t, filepath.Join(dir, "Manifest.toml"), "julia_version = \"1.11.1\"\n")
+	updated, _ := autoSysimagePath(context.Background(), julia, cache, dir)
+	if upgraded == path || updated == upgraded {
+		t.Errorf("expected a new sysimage for a new julia and new dependencies, got %s, %s and %s",
+			path, upgraded, updated)
+	}
+}
+
+func TestInstallDependenciesBuildsAutoSysimage(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	writeFile(t, filepath.Join(root, "Manifest.toml"), "julia_version = \"1.10.4\"\n")
+	fakeJulia(t, sysimageJulia(filepath.Join(t.TempDir(), "calls")))
+	install := func() *installDependenciesServer {
+		t.Helper()
+		server := &installDependenciesServer{}
+		err := newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+			Info: &pulumirpc.ProgramInfo{
+				RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+				Options: mustStruct(t, map[string]interface{}{"sysimage": "auto"}),
+			},
+		}, server)
+		if err != nil {
+			t.Fatalf("InstallDependencies: %v", err)
+		}
+		return server
+	}
+	sysimages := func() []string {
+		t.Helper()
+		paths, _ := filepath.Glob(filepath.Join(root, ".pulumi", "julia-cache", "*"))
+		return paths
+	}
+
+	server := install()
+	built := sysimages()
+	if len(built) != 1 || checkSysimage(built[0]) != nil {
+		t.Fatalf("expected a sysimage to be built, got %q", built)
+	}
+	if !strings.Contains(server.stdout.String(), "Building a sysimage") ||
+		!strings.Contains(server.stderr.String(), "PackageCompiler: creating compiler") {
+		t.Errorf("expected the build to be streamed, got stdout %q and stderr %q",
+			server.stdout.String(), server.stderr.String())
+	}
+
+	if server := install(); !strings.Contains(server.stdout.String(), "Using the sysimage "+built[0]) {
+		t.Errorf("expected the cached sysimage to be reused, got %q", server.stdout.String())
+	}
+
+	writeFile(t, filepath.Join(root, "Manifest.toml"), "julia_version = \"1.10.5\"\n")
+	install()
+	if rebuilt := sysimages(); len(rebuilt) != 1 || rebuilt[0] == built[0] {
+		t.Errorf("expected changed dependencies to replace the sysimage, got %q", rebuilt)
+	}
+}
+
+func TestInstallDependenciesRequiresPackageCompiler(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	writeFile(t, filepath.Join(root, "Manifest.toml"), "")
+	fakeJulia(t, `case "$*" in *--version) echo "julia version 1.10.4" ;; *create_sysimage*) exit 2 ;; esac`)
+	err := newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+			Options: mustStruct(t, map[string]interface{}{"sysimage": "auto"}),
+		},
+	}, &installDependenciesServer{})
+	if err == nil || !strings.Contains(err.Error(), "requires PackageCompiler.jl") {
+		t.Errorf("expected an error asking for PackageCompiler.jl, got %v", err)
+	}
+	if paths, _ := filepath.Glob(filepath.Join(root, ".pulumi", "julia-cache", "*")); len(paths) != 0 {
+		t.Errorf("expected a failed build to leave nothing behind, got %q", paths)
+	}
+}
+
+func TestRunUsesAutoSysimage(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	writeFile(t, filepath.Join(root, "Manifest.toml"), "")
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeJulia(t, sysimageJulia(calls))
+	info := &pulumirpc.ProgramInfo{
+		RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+		Options: mustStruct(t, map[string]interface{}{"sysimage": "auto"}),
+	}
+	run := func() string {
+		t.Helper()
+		os.Remove(calls)
+		resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{Info: info})
+		if err != nil || resp.GetError() != "" {
+			t.Fatalf("Run: %v %v", err, resp.GetError())
+		}
+		data, err := os.ReadFile(calls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if args := run(); strings.Contains(args, "--sysimage") {
+		t.Errorf("expected the program to run with the default sysimage before one is built, got %q", args)
+	}
+	err := newTestHost().InstallDependencies(&pulumirpc.InstallDependenciesRequest{Info: info}, &installDependenciesServer{})
+	if err != nil {
+		t.Fatalf("InstallDependencies: %v", err)
+	}
+	cache := filepath.Join(root, sysimageCacheDir)
+	path, err := autoSysimagePath(context.Background(), juliaCommand{Path: "julia"}, cache, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args := run(); !strings.Contains(args, "--sysimage="+path) {
+		t.Errorf("expected the program to run with %s, got %q", path, args)
+	}
+
+	writeFile(t, path, "corrupted")
+	if args := run(); strings.Contains(args, "--sysimage") {
+		t.Errorf("expected the program to run with the default sysimage rather than a corrupted one, got %q", args)
+	}
+}
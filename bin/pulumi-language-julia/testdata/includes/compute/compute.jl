@@ -0,0 +1,3 @@
+# Included files resolve relative to the including file.
+include("instances.jl")
+include("../network.jl")
@@ -0,0 +1,2 @@
+import PulumiRandom
+include("compute.jl")
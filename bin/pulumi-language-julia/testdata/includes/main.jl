@@ -0,0 +1,5 @@
+using Pulumi
+
+include("network.jl")
+include("compute/compute.jl")
+include(joinpath(@__DIR__, "generated.jl"))
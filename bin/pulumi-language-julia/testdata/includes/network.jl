@@ -0,0 +1,3 @@
+using PulumiAWS
+
+vpc = PulumiAWS.EC2.Vpc("vpc"; cidrBlock="10.0.0.0/16")
@@ -0,0 +1,3 @@
+using Pulumi
+using PulumiAWS
+using PulumiRandom
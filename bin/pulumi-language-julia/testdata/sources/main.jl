@@ -0,0 +1,10 @@
+# A program run against a shared environment, without a Project.toml.
+using Pulumi
+using PulumiAWS, PulumiRandom
+# using PulumiGCP
+#=
+import PulumiAzure
+=#
+const banner = "using PulumiKubernetes"
+
+bucket = PulumiAWS.S3.Bucket("bucket")
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+// traceCompilePrefix starts the names of the files of precompile statements
+// traced from program runs with the traceCompile runtime option, and of the
+// scripts replaying them, in the cache directory.
+const traceCompilePrefix = "trace-"
+
+// traceReplayScript precompiles the statements in the file whose path it is
+// formatted with before the program runs. The project's packages are loaded
+// first, and every loaded module is made reachable by name, so that the
+// statements can name the types they were traced with. Statements that no
+// longer apply, or that a truncated file cut short, are skipped, and nothing
+// the script does can fail the run.
+const traceReplayScript = `# Precompiles the methods an earlier run compiled; written by pulumi-language-julia.
+try
+    for name in keys(get(Base.parsed_toml(Base.active_project()), "deps", Dict()))
+        try
+            Base.require(Main, Symbol(name))
+        catch
+        end
+    end
+    let replay = Module()
+        for mod in values(Base.loaded_modules)
+            isdefined(replay, nameof(mod)) || Core.eval(replay, :(const $(nameof(mod)) = $mod))
+        end
+        for statement in eachline(%s)
+            try
+                Core.eval(replay, Meta.parse(statement))
+            catch
+            end
+        end
+    end
+catch err
+    @debug "failed to replay precompile statements" exception = err
+end
+`
+
+// traceCompilePath returns where the precompile statements traced from runs
+// of the project in dir are cached under cacheDir, keyed on its dependencies
+// like the automatic sysimage.
+func traceCompilePath(ctx context.Context, julia juliaCommand, cacheDir, dir string) (string, error) {
+	key, err := dependencyKey(ctx, julia, dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, traceCompilePrefix+key+".jl"), nil
+}
+
+// traceCompileSwitches returns the julia switches of the traceCompile runtime
+// option for a run of the project in dir: replaying the precompile statements
+// traced from an earlier run with the same dependencies, or, if there are
+// none yet or they are corrupted, tracing them from this run.
+func traceCompileSwitches(ctx context.Context, julia juliaCommand, cacheDir, dir string) []string {
+	path, err := traceCompilePath(ctx, julia, cacheDir, dir)
+	if err != nil {
+		logging.V(5).Infof("not tracing compilation: %v", err)
+		return nil
+	}
+	err = checkTraceCompile(path)
+	if err == nil {
+		replay := strings.TrimSuffix(path, ".jl") + "-replay.jl"
+		script := []byte(fmt.Sprintf(traceReplayScript, juliaString(path)))
+		if data, err := os.ReadFile(replay); err != nil || !bytes.Equal(data, script) {
+			if err := os.WriteFile(replay, script, 0o644); err != nil {
+				logging.Warningf("failed to write %s, running without precompile statements: %v", replay, err)
+				return nil
+			}
+		}
+		logging.V(5).Infof("replaying the precompile statements in %s", path)
+		return []string{"--load=" + replay}
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		logging.Warningf("%v; tracing them again", err)
+	}
+
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		logging.Warningf("failed to create cache %s, not tracing compilation: %v", cacheDir, err)
+		return nil
+	}
+	removeStaleCacheFiles(cacheDir, traceCompilePrefix, path)
+	logging.V(5).Infof("tracing the methods the program compiles to %s", path)
+	return []string{"--trace-compile=" + path}
+}
+
+// checkTraceCompile checks that path holds precompile statements, as julia's
+// --trace-compile writes them: a precompile call, or a comment, per line.
+func checkTraceCompile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 || !utf8.Valid(data) {
+		return fmt.Errorf("precompile statements %s are corrupted", path)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "precompile(") && !strings.HasPrefix(line, "#") {
+			return fmt.Errorf("precompile statements %s are corrupted", path)
+		}
+	}
+	return nil
+}
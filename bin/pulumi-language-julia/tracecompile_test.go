@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+func TestCheckTraceCompile(t *testing.T) {
+	dir := t.TempDir()
+	if err := checkTraceCompile(filepath.Join(dir, "missing.jl")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected missing statements to be reported as such, got %v", err)
+	}
+	usable := "precompile(Tuple{typeof(PulumiAWS.S3.bucket), String})\n#= 3.2 ms =# precompile(Tuple{typeof(Main.f)})\n"
+	writeFile(t, filepath.Join(dir, "usable.jl"), usable)
+	if err := checkTraceCompile(filepath.Join(dir, "usable.jl")); err != nil {
+		t.Errorf("expected usable statements, got %v", err)
+	}
+	for name, content := range map[string]string{"empty.jl": "", "binary.jl": "\x00\xff\xfe", "other.jl": "rm(\"/\")\n"} {
+		writeFile(t, filepath.Join(dir, name), content)
+		if err := checkTraceCompile(filepath.Join(dir, name)); err == nil || errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected %s to be corrupted, got %v", name, err)
+		}
+	}
+}
+
+func TestRunTracesThenReplaysCompilation(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	writeFile(t, filepath.Join(root, "Project.toml"), "[deps]\n")
+	writeFile(t, filepath.Join(root, "Manifest.toml"), "julia_version = \"1.10.4\"\n")
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeJulia(t, `case "$*" in
+*--version) echo "julia version 1.10.4" ;;
+*)
+	echo "$@" > "`+calls+`"
+	for arg; do
+		case "$arg" in --trace-compile=*) echo 'precompile(Tuple{typeof(Main.f)})' > "${arg#--trace-compile=}" ;; esac
+	done ;;
+esac`)
+	info := &pulumirpc.ProgramInfo{
+		RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+		Options: mustStruct(t, map[string]interface{}{"traceCompile": true}),
+	}
+	run := func() string {
+		t.Helper()
+		resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{Info: info})
+		if err != nil || resp.GetError() != "" {
+			t.Fatalf("Run: %v %v", err, resp.GetError())
+		}
+		data, err := os.ReadFile(calls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	cache := filepath.Join(root, sysimageCacheDir)
+	path, err := traceCompilePath(context.Background(), juliaCommand{Path: "julia"}, cache, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := strings.TrimSuffix(path, ".jl") + "-replay.jl"
+
+	if args := run(); !strings.Contains(args, "--trace-compile="+path) {
+		t.Errorf("expected the first run to trace compilation to %s, got %q", path, args)
+	}
+	if args := run(); !strings.Contains(args, "--load="+replay) || strings.Contains(args, "--trace-compile") {
+		t.Errorf("expected the next run to replay the statements with %s, got %q", replay, args)
+	}
+	if script, err := os.ReadFile(replay); err != nil || !strings.Contains(string(script), juliaString(path)) {
+		t.Errorf("expected the replay script to load %s, got %q, %v", path, script, err)
+	}
+
+	// Corrupted statements are traced again.
+	writeFile(t, path, "\x00\x00garbage")
+	if args := run(); !strings.Contains(args, "--trace-compile="+path) {
+		t.Errorf("expected corrupted statements to be traced again, got %q", args)
+	}
+
+	// Changed dependencies get statements of their own.
+	writeFile(t, filepath.Join(root, "Manifest.toml"), "julia_version = \"1.10.5\"\n")
+	if args := run(); strings.Contains(args, path) || !strings.Contains(args, "--trace-compile=") {
+		t.Errorf("expected changed dependencies to be traced anew, got %q", args)
+	}
+	for _, stale := range []string{path, replay} {
+		if _, err := os.Stat(stale); !os.IsNotExist(err) {
+			t.Errorf("expected %s, traced for earlier dependencies, to be removed: %v", stale, err)
+		}
+	}
+}
+
+func TestRunWithoutManifestDoesNotTrace(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeJulia(t, `echo "$@" > "`+calls+`"`)
+	resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+		Info: &pulumirpc.ProgramInfo{
+			RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+			Options: mustStruct(t, map[string]interface{}{"traceCompile": true}),
+		},
+	})
+	if err != nil || resp.GetError() != "" {
+		t.Fatalf("Run: %v %v", err, resp.GetError())
+	}
+	if data, _ := os.ReadFile(calls); strings.Contains(string(data), "--trace-compile") {
+		t.Errorf("expected a project without a manifest not to be traced, got %q", data)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+// Exit codes of jetScript.
+const (
+	jetReportsExitCode = 1
+	jetMissingExitCode = 2
+)
+
+// jetScript checks the file passed as its argument with JET.jl, printing the
+// report and exiting with jetReportsExitCode if JET finds problems, or with
+// jetMissingExitCode if JET isn't installed.
+var jetScript = fmt.Sprintf(`Base.find_package("JET") === nothing && exit(%d)
+using JET
+result = JET.report_file(ARGS[1])
+isempty(JET.get_reports(result)) && exit(0)
+show(stdout, result)
+println()
+exit(%d)`, jetMissingExitCode, jetReportsExitCode)
+
+// typecheck checks prog with the typechecker runtime option before it runs,
+// streaming the report to stderr. Problems fail the run unless the option is
+// set to only warn about them.
+func typecheck(ctx context.Context, julia juliaCommand, prog juliaProgram, opts runtimeOptions) error {
+	if opts.Typechecker == "" {
+		return nil
+	}
+	logging.V(5).Infof("checking %s with JET", prog.EntryPoint)
+
+	cmd := julia.command(ctx, "--project="+prog.project(), "-e", jetScript, "--", absPath(prog.EntryPoint))
+	cmd.Dir = filepath.Dir(prog.EntryPoint)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == jetMissingExitCode:
+		return fmt.Errorf("the typechecker runtime option requires JET.jl in the program's environment; " +
+			"add it with `julia --project -e 'using Pkg; Pkg.add(\"JET\")'` or remove the option")
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == jetReportsExitCode:
+		if opts.TypecheckerWarnOnly {
+			logging.Warningf("JET found problems in %s", prog.EntryPoint)
+			return nil
+		}
+		return fmt.Errorf("JET found problems in %s; see the report above, "+
+			"or set typecheckerLevel to warn to run the program anyway", prog.EntryPoint)
+	default:
+		return fmt.Errorf("failed to check %s with JET: %w", prog.EntryPoint, err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pulumirpc "github.com/pulumi/pulumi/sdk/v3/proto/go"
+)
+
+func TestRunTypechecker(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.jl"), "")
+	out := filepath.Join(t.TempDir(), "out")
+	fakeJulia(t, `case "$*" in
+*report_file*) echo "$@" > "`+out+`.jet"; exit "$JET_EXIT_CODE" ;;
+esac
+echo ran > "`+out+`"`)
+	t.Setenv("PULUMI_JULIA_EXE", "")
+
+	tests := []struct {
+		name     string
+		exitCode string
+		level    string
+		message  string
+		ran      bool
+	}{
+		{"clean", "0", "error", "", true},
+		{"reports", "1", "error", "JET found problems", false},
+		{"reports with warn", "1", "warn", "", true},
+		{"missing JET", "2", "warn", "requires JET.jl", false},
+		{"crash", "3", "error", "failed to check", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(out)
+			t.Setenv("JET_EXIT_CODE", tt.exitCode)
+			resp, err := newTestHost().Run(context.Background(), &pulumirpc.RunRequest{
+				Info: &pulumirpc.ProgramInfo{
+					RootDirectory: root, ProgramDirectory: root, EntryPoint: ".",
+					Options: mustStruct(t, map[string]interface{}{
+						"typechecker": "jet", "typecheckerLevel": tt.level,
+					}),
+				},
+			})
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if tt.message == "" && resp.GetError() != "" || !strings.Contains(resp.GetError(), tt.message) {
+				t.Errorf("expected an error containing %q, got %q", tt.message, resp.GetError())
+			}
+			if _, err := os.Stat(out); (err == nil) != tt.ran {
+				t.Errorf("expected the program to run: %v", tt.ran)
+			}
+
+			data, err := os.ReadFile(out + ".jet")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(strings.TrimSpace(string(data)), "-- "+filepath.Join(root, "main.jl")) {
+				t.Errorf("expected JET to check the entry point, got %q", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+)
+
+// versionRange is the half-open interval of versions [lower, upper). An
+// unbounded range has no upper limit.
+type versionRange struct {
+	lower     semver.Version
+	upper     semver.Version
+	unbounded bool
+}
+
+func (r versionRange) contains(v semver.Version) bool {
+	return v.GTE(r.lower) && (r.unbounded || v.LT(r.upper))
+}
+
+// juliaVersionSpec is a parsed Julia version specifier, as used by the
+// `[compat]` section of a Project.toml. It is the union of its ranges.
+type juliaVersionSpec []versionRange
+
+// contains reports whether v satisfies the specifier.
+func (s juliaVersionSpec) contains(v semver.Version) bool {
+	// Compat bounds apply to release versions; ignore pre-release and build
+	// metadata when matching.
+	v = semver.Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+	for _, r := range s {
+		if r.contains(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// lowerBound returns the smallest version allowed by the specifier.
+func (s juliaVersionSpec) lowerBound() semver.Version {
+	lower := s[0].lower
+	for _, r := range s[1:] {
+		if r.lower.LT(lower) {
+			lower = r.lower
+		}
+	}
+	return lower
+}
+
+// parseJuliaVersionSpec parses a Julia version specifier such as "1.2",
+// "^0.3, 1", "~1.2.3", "=1.0.0", ">= 1.6", "< 2" or "1.2 - 1.5". See
+// https://pkgdocs.julialang.org/v1/compatibility/ for the semantics.
+func parseJuliaVersionSpec(spec string) (juliaVersionSpec, error) {
+	var ranges juliaVersionSpec
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("invalid version specifier %q: empty entry", spec)
+		}
+		r, err := parseJuliaVersionRange(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version specifier %q: %w", spec, err)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func parseJuliaVersionRange(spec string) (versionRange, error) {
+	switch {
+	case strings.HasPrefix(spec, ">="), strings.HasPrefix(spec, "≥"):
+		spec = strings.TrimPrefix(strings.TrimPrefix(spec, ">="), "≥")
+		parts, err := parseVersionParts(spec)
+		if err != nil {
+			return versionRange{}, err
+		}
+		return versionRange{lower: partsVersion(parts), unbounded: true}, nil
+	case strings.HasPrefix(spec, "<"):
+		parts, err := parseVersionParts(strings.TrimPrefix(spec, "<"))
+		if err != nil {
+			return versionRange{}, err
+		}
+		return versionRange{upper: partsVersion(parts)}, nil
+	case strings.HasPrefix(spec, "="):
+		parts, err := parseVersionParts(strings.TrimPrefix(spec, "="))
+		if err != nil {
+			return versionRange{}, err
+		}
+		return versionRange{lower: partsVersion(parts), upper: bumpLast(parts)}, nil
+	case strings.HasPrefix(spec, "~"):
+		parts, err := parseVersionParts(strings.TrimPrefix(spec, "~"))
+		if err != nil {
+			return versionRange{}, err
+		}
+		var upper semver.Version
+		switch {
+		case len(parts) == 3 && parts[0] == 0 && parts[1] == 0:
+			upper = semver.Version{Patch: parts[2] + 1}
+		case len(parts) >= 2:
+			upper = semver.Version{Major: parts[0], Minor: parts[1] + 1}
+		default:
+			upper = semver.Version{Major: parts[0] + 1}
+		}
+		return versionRange{lower: partsVersion(parts), upper: upper}, nil
+	case strings.Contains(spec, " - "):
+		bounds := strings.SplitN(spec, " - ", 2)
+		lower, err := parseVersionParts(bounds[0])
+		if err != nil {
+			return versionRange{}, err
+		}
+		upper, err := parseVersionParts(bounds[1])
+		if err != nil {
+			return versionRange{}, err
+		}
+		return versionRange{lower: partsVersion(lower), upper: bumpLast(upper)}, nil
+	default:
+		// Caret specifiers are the default.
+		parts, err := parseVersionParts(strings.TrimPrefix(spec, "^"))
+		if err != nil {
+			return versionRange{}, err
+		}
+		// The upper bound bumps the first non-zero component, or the last
+		// given one if they are all zero.
+		i := 0
+		for i < len(parts)-1 && parts[i] == 0 {
+			i++
+		}
+		bumped := append([]uint64{}, parts[:i+1]...)
+		return versionRange{lower: partsVersion(parts), upper: bumpLast(bumped)}, nil
+	}
+}
+
+// parseVersionParts parses a version with one to three numeric components.
+func parseVersionParts(s string) ([]uint64, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	fields := strings.Split(s, ".")
+	if s == "" || len(fields) > 3 {
+		return nil, fmt.Errorf("malformed version %q", s)
+	}
+	parts := make([]uint64, len(fields))
+	for i, field := range fields {
+		n, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed version %q", s)
+		}
+		parts[i] = n
+	}
+	return parts, nil
+}
+
+// partsVersion pads parts with zeros to form a full version.
+func partsVersion(parts []uint64) semver.Version {
+	var padded [3]uint64
+	copy(padded[:], parts)
+	return semver.Version{Major: padded[0], Minor: padded[1], Patch: padded[2]}
+}
+
+// bumpLast returns the smallest version greater than every version matching
+// the given prefix, e.g. 1.2 -> 1.3.0.
+func bumpLast(parts []uint64) semver.Version {
+	bumped := append([]uint64{}, parts...)
+	bumped[len(bumped)-1]++
+	return partsVersion(bumped)
+}
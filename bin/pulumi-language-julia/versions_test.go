@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/blang/semver"
+)
+
+func TestParseJuliaVersionSpec(t *testing.T) {
+	tests := []struct {
+		spec     string
+		lower    string
+		included []string
+		excluded []string
+	}{
+		{"1.2", "1.2.0", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0"}},
+		{"1.2.3", "1.2.3", []string{"1.2.3", "1.99.0"}, []string{"1.2.2", "2.0.0"}},
+		{"^1.2.3", "1.2.3", []string{"1.5.0"}, []string{"2.0.0"}},
+		{"0.2.3", "0.2.3", []string{"0.2.9"}, []string{"0.3.0"}},
+		{"0.0.3", "0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"0", "0.0.0", []string{"0.9.0"}, []string{"1.0.0"}},
+		{"~1.2.3", "1.2.3", []string{"1.2.9"}, []string{"1.3.0", "1.2.2"}},
+		{"~1.2", "1.2.0", []string{"1.2.5"}, []string{"1.3.0"}},
+		{"~1", "1.0.0", []string{"1.9.0"}, []string{"2.0.0"}},
+		{"~0.0.3", "0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"=1.2.3", "1.2.3", []string{"1.2.3"}, []string{"1.2.4"}},
+		{">= 1.6", "1.6.0", []string{"1.6.0", "7.0.0"}, []string{"1.5.9"}},
+		{"≥1.6", "1.6.0", []string{"1.6.0"}, []string{"1.5.0"}},
+		{"< 2", "0.0.0", []string{"1.9.9"}, []string{"2.0.0"}},
+		{"1.2 - 1.5", "1.2.0", []string{"1.5.9"}, []string{"1.6.0", "1.1.0"}},
+		{"0.9, 1", "0.9.0", []string{"0.9.5", "1.4.0"}, []string{"0.8.0", "2.0.0"}},
+		{"6.40", "6.40.0", []string{"6.41.0"}, []string{"7.0.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			spec, err := parseJuliaVersionSpec(tt.spec)
+			if err != nil {
+				t.Fatalf("parseJuliaVersionSpec: %v", err)
+			}
+			if lower := spec.lowerBound().String(); lower != tt.lower {
+				t.Errorf("expected lower bound %s, got %s", tt.lower, lower)
+			}
+			for _, v := range tt.included {
+				if !spec.contains(semver.MustParse(v)) {
+					t.Errorf("expected %s to satisfy %q", v, tt.spec)
+				}
+			}
+			for _, v := range tt.excluded {
+				if spec.contains(semver.MustParse(v)) {
+					t.Errorf("expected %s not to satisfy %q", v, tt.spec)
+				}
+			}
+		})
+	}
+}
+
+func TestParseJuliaVersionSpecErrors(t *testing.T) {
+	for _, spec := range []string{"", "1.2,", "latest", "1.2.3.4", "~", ">= x"} {
+		if _, err := parseJuliaVersionSpec(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
@@ -20,6 +20,7 @@ makedocs(
             "Configuration" => "guides/configuration.md",
             "Components" => "guides/components.md",
             "Stack Exports" => "guides/exports.md",
+            "Runtime Options" => "guides/runtime-options.md",
         ],
         "API Reference" => "api.md",
     ],
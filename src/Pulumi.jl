@@ -23,7 +23,11 @@ cloud infrastructure using the Pulumi platform.
 - `get_stack`, `get_project`: Access stack/project information
 
 ## Error Types
-- `PulumiError`, `ResourceError`, `GRPCError`, `ConfigMissingError`
+- `PulumiError`, `ResourceError`, `GRPCError`, `ConfigMissingError`, `BailError`
+
+## Running Programs
+- `Pulumi.run`: Run a program, reporting its errors to the engine
+- `Pulumi.serve_runs`: Run programs in a warm process, for the language host's daemon mode
 
 ## Logging
 - `log_debug`, `log_info`, `log_warn`, `log_error`
@@ -47,9 +51,11 @@ include("context.jl")
 include("resource.jl")
 include("config.jl")
 include("logging.jl")
+include("run.jl")
 include("invoke.jl")
 include("export.jl")
 include("dependency.jl")
+include("daemon.jl")
 
 # Core types
 export Output, Unknown
@@ -64,7 +70,8 @@ export register_resources_parallel, with_parallelism
 export apply, all
 export invoke, call
 export export_value, export_secret, get_exports, clear_exports!
-export get_stack, get_project, get_organization, is_dry_run
+export get_stack, get_project, get_organization, is_dry_run, is_query_mode
+export get_root_directory, get_program_directory
 export get_context, set_context!, reset_context!
 export get_urn, get_name, get_type
 
@@ -73,7 +80,8 @@ export require, is_secret, get_secret, require_secret
 export get_int, get_bool, get_float, get_object
 
 # Error types
-export PulumiError, ResourceError, GRPCError, ConfigMissingError, DependencyError
+export PulumiError, ResourceError, GRPCError, ConfigMissingError, DependencyError, BailError
+export BAIL_EXIT_CODE
 
 # gRPC status codes and mappings
 export GRPCStatusCode, GRPCLogSeverity
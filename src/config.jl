@@ -3,7 +3,8 @@ Configuration access for Pulumi programs.
 
 Per data-model.md:
 - Config: Type-safe access to stack configuration
-- Values from PULUMI_CONFIG environment variable (JSON)
+- Values from PULUMI_CONFIG environment variable (JSON), keeping the types of
+  structured values
 - Secret keys from PULUMI_CONFIG_SECRET_KEYS
 """
 
@@ -43,6 +44,17 @@ Get a configuration value by key.
 - `nothing`: If key is not set
 """
 function Base.get(config::Config, key::String)::Union{String, Nothing}
+    value = raw_value(config, key)
+    value === nothing || value isa AbstractString ? value : JSON3.write(value)
+end
+
+"""
+    raw_value(config::Config, key::String)
+
+Get a configuration value as passed by the language host: a string, or a
+structured value such as a number, boolean, object or array.
+"""
+function raw_value(config::Config, key::String)
     ctx = get_context()
     full_key = "$(config.namespace):$key"
     get(ctx.config, full_key, nothing)
@@ -165,8 +177,9 @@ Get a configuration value as an integer.
 - `ArgumentError`: If value cannot be parsed as integer
 """
 function get_int(config::Config, key::String)::Union{Int, Nothing}
-    value = get(config, key)
-    value === nothing ? nothing : parse(Int, value)
+    value = raw_value(config, key)
+    value isa Integer && return Int(value)
+    value === nothing ? nothing : parse(Int, get(config, key))
 end
 
 """
@@ -183,11 +196,12 @@ Get a configuration value as a boolean.
 - `nothing`: If key is not set
 """
 function get_bool(config::Config, key::String)::Union{Bool, Nothing}
-    value = get(config, key)
+    value = raw_value(config, key)
+    value isa Bool && return value
     if value === nothing
         return nothing
     end
-    lowercase(value) in ("true", "1", "yes")
+    lowercase(get(config, key)) in ("true", "1", "yes")
 end
 
 """
@@ -207,8 +221,9 @@ Get a configuration value as a float.
 - `ArgumentError`: If value cannot be parsed as float
 """
 function get_float(config::Config, key::String)::Union{Float64, Nothing}
-    value = get(config, key)
-    value === nothing ? nothing : parse(Float64, value)
+    value = raw_value(config, key)
+    value isa Real && !(value isa Bool) && return Float64(value)
+    value === nothing ? nothing : parse(Float64, get(config, key))
 end
 
 """
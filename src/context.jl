@@ -19,24 +19,67 @@ Execution context holding engine connection and stack information.
 - `stack::String`: Current stack name
 - `organization::String`: Organization name
 - `is_dry_run::Bool`: Preview mode (true) or deploy (false)
+- `is_query_mode::Bool`: Query mode, in which resources can't be registered
 - `parallel::Int`: Max parallel resource operations
 - `monitor_address::String`: gRPC address for ResourceMonitor
 - `engine_address::String`: gRPC address for Engine
+- `root_directory::String`: Absolute path of the Pulumi project root
+- `program_directory::String`: Absolute path of the program directory
+- `config::Dict{String, Any}`: Configuration values by fully-qualified key
+- `config_aliases::Dict{String, String}`: Fully-qualified keys of the
+  project's configuration by key without the project namespace
 """
 struct Context
     project::String
     stack::String
     organization::String
     is_dry_run::Bool
+    is_query_mode::Bool
     parallel::Int
     monitor_address::String
     engine_address::String
+    root_directory::String
+    program_directory::String
     config::Dict{String, Any}
+    config_aliases::Dict{String, String}
     config_secret_keys::Set{String}
     _monitor::MonitorClient
     _engine::EngineClient
 end
 
+"""
+    parse_config_document(json::AbstractString) -> Dict{String, Any}
+
+Parse the configuration passed by the language host. Documents of the form
+`{"version": 2, "config": {...}}` keep the types of structured values, such as
+objects and booleans set with `pulumi config set --path`; older hosts pass a
+flat object of strings.
+"""
+function parse_config_document(json::AbstractString)::Dict{String, Any}
+    document = JSON3.read(json, Dict{String, Any})
+    if get(document, "version", nothing) == 2
+        return Dict{String, Any}(String(key) => value for (key, value) in pairs(document["config"]))
+    end
+    document
+end
+
+"""
+    parse_config_aliases(json::AbstractString) -> Dict{String, String}
+
+Parse the aliases of a configuration document from the language host, which
+map keys of the project's configuration without their namespace, such as
+`name`, to fully-qualified keys, such as `project:name`. A key of another
+namespace that looks the same, such as `aws:region` for `project:aws:region`,
+takes precedence over an alias and so has none.
+"""
+function parse_config_aliases(json::AbstractString)::Dict{String, String}
+    document = JSON3.read(json, Dict{String, Any})
+    if get(document, "version", nothing) == 2 && haskey(document, "aliases")
+        return Dict{String, String}(String(alias) => String(key) for (alias, key) in pairs(document["aliases"]))
+    end
+    Dict{String, String}()
+end
+
 # Global context singleton
 const _CONTEXT = Ref{Union{Context, Nothing}}(nothing)
 
@@ -50,10 +93,21 @@ Environment variables:
 - `PULUMI_STACK`: Stack name
 - `PULUMI_ORGANIZATION`: Organization name
 - `PULUMI_DRY_RUN`: Preview mode flag
+- `PULUMI_QUERY_MODE`: Query mode flag
 - `PULUMI_PARALLEL`: Max parallelism
 - `PULUMI_MONITOR`: ResourceMonitor gRPC address
 - `PULUMI_ENGINE`: Engine gRPC address
+
+  Addresses are either `host:port`, with IPv6 hosts in brackets as in
+  `[::1]:52341`, or `unix:` followed by the absolute path of a unix socket.
+- `PULUMI_ROOT_DIRECTORY`: Pulumi project root, the working directory by default
+- `PULUMI_PROGRAM_DIRECTORY`: Program directory, the working directory by default
 - `PULUMI_CONFIG`: JSON-encoded configuration
+- `PULUMI_CONFIG_FILE`: Path of a file holding the JSON-encoded configuration,
+  set instead of `PULUMI_CONFIG` when the configuration is too large for the
+  environment
+- `PULUMI_CONFIG_SECRETS_FILE`: Path of a file holding the JSON-encoded secret
+  configuration, which the language host keeps out of the environment
 - `PULUMI_CONFIG_SECRET_KEYS`: Secret key names
 """
 function Context()
@@ -61,17 +115,33 @@ function Context()
     stack = get(ENV, "PULUMI_STACK", "")
     organization = get(ENV, "PULUMI_ORGANIZATION", "")
     is_dry_run = lowercase(get(ENV, "PULUMI_DRY_RUN", "false")) in ("true", "1", "yes")
+    is_query_mode = lowercase(get(ENV, "PULUMI_QUERY_MODE", "false")) in ("true", "1", "yes")
     parallel = parse(Int, get(ENV, "PULUMI_PARALLEL", "16"))
     monitor_address = get(ENV, "PULUMI_MONITOR", "")
     engine_address = get(ENV, "PULUMI_ENGINE", "")
+    root_directory = get(ENV, "PULUMI_ROOT_DIRECTORY", pwd())
+    program_directory = get(ENV, "PULUMI_PROGRAM_DIRECTORY", pwd())
 
-    # Parse configuration
-    config_json = get(ENV, "PULUMI_CONFIG", "{}")
+    # Parse configuration, which the language host passes in a file when it
+    # is too large for the environment
+    config_file = get(ENV, "PULUMI_CONFIG_FILE", "")
+    config_json = isempty(config_file) ? get(ENV, "PULUMI_CONFIG", "{}") : read(config_file, String)
     config = try
-        JSON3.read(config_json, Dict{String, Any})
+        parse_config_document(config_json)
     catch
         Dict{String, Any}()
     end
+    config_aliases = try
+        parse_config_aliases(config_json)
+    catch
+        Dict{String, String}()
+    end
+
+    # Secret values are passed in a file of their own
+    secrets_file = get(ENV, "PULUMI_CONFIG_SECRETS_FILE", "")
+    if !isempty(secrets_file)
+        merge!(config, parse_config_document(read(secrets_file, String)))
+    end
 
     # Parse secret keys
     secret_keys_json = get(ENV, "PULUMI_CONFIG_SECRET_KEYS", "[]")
@@ -88,6 +158,10 @@ function Context()
     # Connect if addresses are available
     if !isempty(monitor_address)
         connect!(monitor)
+        # The language host interrupts cancelled programs before killing
+        # them; throw InterruptException, rather than exiting outright, so
+        # that finally blocks get to run.
+        Base.exit_on_sigint(false)
     end
     if !isempty(engine_address)
         connect!(engine)
@@ -98,10 +172,14 @@ function Context()
         stack,
         organization,
         is_dry_run,
+        is_query_mode,
         parallel,
         monitor_address,
         engine_address,
+        root_directory,
+        program_directory,
         config,
+        config_aliases,
         secret_keys,
         monitor,
         engine
@@ -171,6 +249,25 @@ function get_organization()::String
     get_context().organization
 end
 
+"""
+    get_root_directory() -> String
+
+Get the absolute path of the Pulumi project root, the directory holding
+Pulumi.yaml, for locating files relative to the project.
+"""
+function get_root_directory()::String
+    get_context().root_directory
+end
+
+"""
+    get_program_directory() -> String
+
+Get the absolute path of the directory holding the program.
+"""
+function get_program_directory()::String
+    get_context().program_directory
+end
+
 """
     is_dry_run() -> Bool
 
@@ -180,6 +277,16 @@ function is_dry_run()::Bool
     get_context().is_dry_run
 end
 
+"""
+    is_query_mode() -> Bool
+
+Check if running in query mode, as with `pulumi query`, where existing
+resources can be read but none can be registered.
+"""
+function is_query_mode()::Bool
+    get_context().is_query_mode
+end
+
 # Show method
 function Base.show(io::IO, ctx::Context)
     print(io, "Context(project=\"", ctx.project, "\", stack=\"", ctx.stack, "\")")
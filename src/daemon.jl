@@ -0,0 +1,154 @@
+"""
+Running programs in a warm Julia process, for the language host's daemon mode.
+
+With the `daemon` runtime option, the language host starts Julia once, as
+`julia -e 'using Pulumi; Pulumi.serve_runs()'` with the switches and
+environment of the program, and has it run the program of each preview and
+update, so that they don't pay for Julia's startup and package loading again.
+"""
+
+import Logging
+import Sockets
+import TOML
+
+# Prefix of the protocol messages written to the language host, telling them
+# apart from the output of packages as they are loaded.
+const DAEMON_MESSAGE_PREFIX = "pulumi-julia-daemon: "
+
+"""
+    serve_runs(requests::IO=stdin, responses::IO=stdout; revise=nothing)
+
+Serve program runs for the language host: load the packages of the active
+project, then run the program of each request read from `requests`, one JSON
+object per line, writing its exit code to `responses`. Program output is sent
+to the language host over connections to the address in the request, rather
+than mixed with the responses. Returns once `requests` is closed, as the
+language host shuts down.
+
+With `daemon: revise`, the language host passes a `revise` function applying
+the code changes Revise.jl tracked, called before each run. When it throws, as
+for changes Revise can't apply such as struct redefinitions, the program isn't
+run and the language host is told to restart the daemon instead.
+"""
+function serve_runs(requests::IO=stdin, responses::IO=stdout; revise=nothing)
+    _preload_project()
+    _send_daemon_message(responses, Dict("ready" => true))
+    for line in eachline(requests)
+        isempty(strip(line)) && continue
+        request = JSON3.read(line, Dict{String, Any})
+        if revise !== nothing && !_revise(revise)
+            _send_daemon_message(responses, Dict("restart" => true))
+            continue
+        end
+        _send_daemon_message(responses, Dict("exitCode" => _serve_run(request)))
+    end
+end
+
+# Apply the code changes tracked by Revise, returning false if it fails to.
+function _revise(revise)
+    try
+        Base.invokelatest(revise)
+        return true
+    catch e
+        @error "Revise failed to apply code changes; the daemon will be restarted" exception = (e, catch_backtrace())
+        return false
+    end
+end
+
+function _send_daemon_message(io::IO, message::Dict)
+    println(io, DAEMON_MESSAGE_PREFIX, JSON3.write(message))
+    flush(io)
+end
+
+# Load the packages the active project depends on, so that programs find them
+# loaded already. Packages that fail to load are left for programs to report.
+function _preload_project()
+    project = Base.active_project()
+    (project === nothing || !isfile(project)) && return
+    for (name, uuid) in get(TOML.parsefile(project), "deps", Dict{String, Any}())
+        try
+            Base.require(Base.PkgId(Base.UUID(uuid), name))
+        catch e
+            @debug "Failed to preload $name" exception = e
+        end
+    end
+end
+
+# Run the program of request, as julia would run it as a script, returning the
+# exit code julia would exit with.
+function _serve_run(request::Dict{String, Any})::Int
+    host, port = rsplit(request["output"], ':'; limit=2)
+    out = _connect_output(host, port, request["token"], "stdout")
+    err = _connect_output(host, port, request["token"], "stderr")
+    old_stdin, old_stdout, old_stderr = stdin, stdout, stderr
+    redirect_stdin(devnull)
+    redirect_stdout(out)
+    redirect_stderr(err)
+    try
+        Logging.with_logger(Logging.ConsoleLogger(err)) do
+            _with_run_settings(request) do
+                _include_program(request["program"])
+            end
+        end
+        return 0
+    catch e
+        (e isa BailError || (e isa LoadError && e.error isa BailError)) && return BAIL_EXIT_CODE
+        Base.display_error(err, e, catch_backtrace())
+        return 1
+    finally
+        flush(out)
+        flush(err)
+        redirect_stdin(old_stdin)
+        redirect_stdout(old_stdout)
+        redirect_stderr(old_stderr)
+        close(out)
+        close(err)
+        _reset_program_state()
+    end
+end
+
+function _connect_output(host::AbstractString, port::AbstractString, token::AbstractString, name::String)
+    socket = Sockets.connect(String(host), parse(Int, port))
+    println(socket, token, " ", name)
+    return socket
+end
+
+# Run f with the PULUMI_* environment variables, arguments and working
+# directory of request, nothing being left over from earlier runs.
+function _with_run_settings(f, request::Dict{String, Any})
+    for name in collect(keys(ENV))
+        startswith(name, "PULUMI_") && delete!(ENV, name)
+    end
+    for (name, value) in request["env"]
+        ENV[name] = value
+    end
+    empty!(ARGS)
+    append!(ARGS, request["args"])
+    Core.eval(Base, :(global PROGRAM_FILE = $(request["program"])))
+    _IN_PROCESS_RUN[] = true
+    try
+        cd(f, request["dir"])
+    finally
+        _IN_PROCESS_RUN[] = false
+    end
+end
+
+# Include the program in a fresh module of its own, as the Main module of a
+# run of its own would be.
+function _include_program(path::AbstractString)
+    mod = Module(:Main)
+    Core.eval(mod, :(include(path::AbstractString) = Base.include($mod, path)))
+    Core.eval(mod, :(include(mapexpr::Function, path::AbstractString) = Base.include(mapexpr, $mod, path)))
+    Core.eval(mod, :(eval(x) = Core.eval($mod, x)))
+    Base.include(mod, path)
+end
+
+function _reset_program_state()
+    for reset in (reset_context!, clear_exports!, reset_dependency_graph!)
+        try
+            reset()
+        catch e
+            @debug "Failed to reset program state with $reset" exception = e
+        end
+    end
+end
@@ -8,6 +8,7 @@ Per constitution's gRPC Protocol Fidelity principle:
 
 using gRPCClient
 using ProtoBuf: OneOf
+import Sockets
 
 # Import proto types (included at Pulumi module level)
 using .pulumirpc: RegisterResourceRequest, RegisterResourceResponse
@@ -155,8 +156,8 @@ function connect!(client::MonitorClient)
     # Initialize gRPC (safe to call multiple times)
     gRPCClient.grpc_init()
 
-    # Parse address (format: "host:port")
-    host, port = _parse_address(client.address)
+    # Resolve address (format: "host:port" or "unix:/path")
+    host, port = _resolve_address(client.address)
 
     # Create gRPC service clients for each RPC method
     # Using gRPCServiceClient with proper type parameters
@@ -478,8 +479,8 @@ function connect!(client::EngineClient)
     # Initialize gRPC (safe to call multiple times)
     gRPCClient.grpc_init()
 
-    # Parse address
-    host, port = _parse_address(client.address)
+    # Resolve address
+    host, port = _resolve_address(client.address)
 
     # Create gRPC service clients
     client._log_client = gRPCClient.gRPCServiceClient{
@@ -586,11 +587,23 @@ end
     _parse_address(address::String) -> Tuple{String, Int}
 
 Parse a gRPC address string (host:port) into host and port components.
+IPv6 hosts, which the language host passes in brackets as in `[::1]:50051`,
+keep their brackets. Unix socket addresses (`unix:/path`) have no port, and
+are resolved by `_resolve_address` instead.
 """
 function _parse_address(address::String)
+    if startswith(address, "unix:")
+        throw(ArgumentError("unix socket addresses have no host and port: $address"))
+    end
+
     # Remove any protocol prefix if present
     addr = replace(address, r"^(http://|https://|grpc://)" => "")
 
+    m = match(r"^(\[[^\]]+\]):(\d+)$", addr)
+    if m !== nothing
+        return (String(m.captures[1]), parse(Int, m.captures[2]))
+    end
+
     parts = split(addr, ":")
     if length(parts) >= 2
         host = parts[1]
@@ -601,3 +614,80 @@ function _parse_address(address::String)
         return (addr, 50051)
     end
 end
+
+"""
+    _resolve_address(address::String) -> Tuple{String, Int}
+
+Return the host and port to dial for a gRPC address from the language host.
+gRPCClient only dials TCP, so a `unix:` address is reached through a loopback
+port bridged to its socket by `_bridge_unix_socket`.
+"""
+function _resolve_address(address::String)
+    if startswith(address, "unix:")
+        return ("127.0.0.1", _bridge_unix_socket(String(chopprefix(address, "unix:"))))
+    end
+    return _parse_address(address)
+end
+
+# Loopback ports bridged to unix sockets, by socket path. Bridges are kept for
+# the life of the process, shared by the monitor and engine clients.
+const _UNIX_SOCKET_BRIDGES = Dict{String, Int}()
+const _UNIX_SOCKET_BRIDGES_LOCK = ReentrantLock()
+
+"""
+    _bridge_unix_socket(path::String) -> Int
+
+Listen on a loopback TCP port, forwarding each connection to the unix socket at
+`path` byte for byte, and return the port.
+"""
+function _bridge_unix_socket(path::String)
+    lock(_UNIX_SOCKET_BRIDGES_LOCK) do
+        get!(_UNIX_SOCKET_BRIDGES, path) do
+            server = Sockets.listen(Sockets.localhost, 0)
+            _, port = Sockets.getsockname(server)
+            errormonitor(@async _serve_unix_socket_bridge(server, path))
+            Int(port)
+        end
+    end
+end
+
+function _serve_unix_socket_bridge(server::Sockets.TCPServer, path::String)
+    while isopen(server)
+        tcp = try
+            Sockets.accept(server)
+        catch err
+            isopen(server) && @warn "Bridge to unix socket $path stopped accepting connections" exception = err
+            return
+        end
+        errormonitor(@async _bridge_connection(tcp, path))
+    end
+end
+
+function _bridge_connection(tcp::Sockets.TCPSocket, path::String)
+    unix = try
+        Sockets.connect(path)
+    catch err
+        @warn "Failed to connect to unix socket $path" exception = err
+        close(tcp)
+        return
+    end
+    @sync begin
+        @async _forward(tcp, unix)
+        @async _forward(unix, tcp)
+    end
+end
+
+# Copy `from` to `to` until either end closes, then close both, ending the
+# bridged connection.
+function _forward(from::IO, to::IO)
+    try
+        while !eof(from)
+            write(to, readavailable(from))
+        end
+    catch err
+        err isa Base.IOError || err isa EOFError || rethrow()
+    finally
+        close(from)
+        close(to)
+    end
+end
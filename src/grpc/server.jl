@@ -136,6 +136,7 @@ function handle_run(runtime::JuliaLanguageRuntime, ctx::ServerContext, req::RunR
             ENV["PULUMI_MONITOR"] = req.monitor_address
             ENV["PULUMI_ENGINE"] = runtime.engine_address
             ENV["PULUMI_DRY_RUN"] = req.dryRun ? "true" : "false"
+            ENV["PULUMI_QUERY_MODE"] = req.queryMode ? "true" : "false"
             ENV["PULUMI_PARALLEL"] = string(req.parallel)
 
             # Set config as JSON if provided
@@ -162,8 +163,13 @@ function handle_run(runtime::JuliaLanguageRuntime, ctx::ServerContext, req::RunR
         # 7. Execute the program
         # Note: Using include() executes in the current module scope
         # A more robust approach would use a sandbox module
-        cd(pwd) do
-            include(program_path)
+        _IN_PROCESS_RUN[] = true
+        try
+            cd(pwd) do
+                include(program_path)
+            end
+        finally
+            _IN_PROCESS_RUN[] = false
         end
 
         # 8. Cleanup gRPC clients
@@ -184,6 +190,11 @@ function handle_run(runtime::JuliaLanguageRuntime, ctx::ServerContext, req::RunR
             # Ignore cleanup errors
         end
 
+        # Programs that reported their error to the engine bail out
+        if e isa BailError || (e isa LoadError && e.error isa BailError)
+            return RunResponse("", true)
+        end
+
         # Return error message
         error_msg = sprint(showerror, e, catch_backtrace())
         return RunResponse(error_msg, false)
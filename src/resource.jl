@@ -223,6 +223,11 @@ function register_resource(
     # Get context
     ctx = get_context()
 
+    # The monitor rejects registrations in query mode
+    if ctx.is_query_mode
+        throw(ResourceError("Cannot register resource $name of type $type in query mode"))
+    end
+
     # Serialize inputs
     serialized_inputs = serialize_struct(inputs)
 
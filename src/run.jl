@@ -0,0 +1,84 @@
+"""
+Running Pulumi programs and reporting their errors.
+
+A program that throws is reported once: [`run`](@ref) sends the error to the
+engine as a diagnostic and exits with [`BAIL_EXIT_CODE`](@ref), which tells
+the language host that the error was reported already.
+"""
+
+"""
+    BAIL_EXIT_CODE
+
+Exit code of a program that failed after reporting its error to the engine,
+for which the language host reports no error of its own. It is the code the
+other Pulumi language SDKs bail out with.
+"""
+const BAIL_EXIT_CODE = 32
+
+# Exit code of programs stopped by an interrupt, as the update is cancelled.
+const INTERRUPT_EXIT_CODE = 130
+
+"""
+    BailError <: PulumiError
+
+Thrown by [`run`](@ref) in place of exiting with [`BAIL_EXIT_CODE`](@ref)
+when the program runs inside the language runtime server's own process.
+"""
+struct BailError <: PulumiError end
+
+function Base.showerror(io::IO, ::BailError)
+    print(io, "BailError: the program failed after reporting its error to the engine")
+end
+
+# Set while the language runtime server runs a program in its own process,
+# which bailing out must not exit.
+const _IN_PROCESS_RUN = Ref(false)
+
+"""
+    run(f::Function)
+
+Run the Pulumi program `f`, returning its result. An exception thrown by `f`
+is reported to the engine as an error diagnostic, with its backtrace logged
+at debug level, and the program exits with [`BAIL_EXIT_CODE`](@ref) rather
+than printing the whole stack trace. Without an engine to report to, as when
+the program is run by hand, the exception is rethrown. A program interrupted as
+the update is cancelled exits with code 130, once its `finally` blocks ran.
+
+# Example
+```julia
+Pulumi.run() do
+    bucket = register_resource("aws:s3:Bucket", "my-bucket", Dict{String,Any}())
+    export_value("bucketUrn", bucket.urn)
+end
+```
+"""
+function run(f::Function)
+    try
+        return f()
+    catch e
+        e isa BailError && rethrow()
+        if e isa InterruptException
+            _IN_PROCESS_RUN[] && rethrow()
+            # Exit as shells report programs stopped by SIGINT, which the
+            # language host takes for a cancellation rather than a failure
+            exit(INTERRUPT_EXIT_CODE)
+        end
+        report_error(e, catch_backtrace()) || rethrow()
+        _IN_PROCESS_RUN[] && throw(BailError())
+        exit(BAIL_EXIT_CODE)
+    end
+end
+
+"""
+    report_error(e, backtrace) -> Bool
+
+Report the exception `e` to the engine, returning whether there is an engine
+to report it to.
+"""
+function report_error(e, backtrace)::Bool
+    ctx = get_context()
+    ctx._engine.connected || return false
+    log_error(sprint(showerror, e))
+    log_debug(sprint(showerror, e, backtrace))
+    return true
+end
@@ -148,6 +148,32 @@
             @test_throws ConfigMissingError config["nonExistent"]
         end
 
+        @testset "Config structured values" begin
+            ENV["PULUMI_CONFIG"] = """{
+                "version": 2,
+                "config": {
+                    "test-project:stringKey": "string-value",
+                    "test-project:intKey": 42,
+                    "test-project:boolKey": false,
+                    "test-project:floatKey": 2.5,
+                    "test-project:objectKey": {"nested": {"list": [1, 2]}, "flag": true}
+                }
+            }"""
+            reset_context!()
+            config = Config()
+
+            @test get(config, "stringKey") == "string-value"
+            @test get_int(config, "intKey") == 42
+            @test get_bool(config, "boolKey") === false
+            @test get_float(config, "floatKey") == 2.5
+            @test get(config, "intKey") == "42"
+
+            object = get_object(config, "objectKey")
+            @test object["flag"] === true
+            @test object["nested"]["list"] == [1, 2]
+            @test contains(get(config, "objectKey"), "\"flag\":true")
+        end
+
     finally
         # Restore original environment
         for key in env_keys
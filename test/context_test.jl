@@ -4,7 +4,9 @@
     env_keys = [
         "PULUMI_PROJECT", "PULUMI_STACK", "PULUMI_ORGANIZATION",
         "PULUMI_DRY_RUN", "PULUMI_PARALLEL", "PULUMI_MONITOR",
-        "PULUMI_ENGINE", "PULUMI_CONFIG", "PULUMI_CONFIG_SECRET_KEYS"
+        "PULUMI_ENGINE", "PULUMI_CONFIG", "PULUMI_CONFIG_FILE", "PULUMI_CONFIG_SECRETS_FILE",
+        "PULUMI_CONFIG_SECRET_KEYS", "PULUMI_ROOT_DIRECTORY", "PULUMI_PROGRAM_DIRECTORY",
+        "PULUMI_QUERY_MODE"
     ]
     for key in env_keys
         if haskey(ENV, key)
@@ -39,6 +41,69 @@
             @test "test-project:secret1" in ctx.config_secret_keys
         end
 
+        @testset "Context from config file" begin
+            reset_context!()
+            ENV["PULUMI_PROJECT"] = "file-project"
+            ENV["PULUMI_STACK"] = "dev"
+            ENV["PULUMI_CONFIG"] = "{}"
+            ENV["PULUMI_CONFIG_SECRET_KEYS"] = "[]"
+            path, io = mktemp()
+            write(io, """{"file-project:key": "from-file"}""")
+            close(io)
+            ENV["PULUMI_CONFIG_FILE"] = path
+
+            try
+                ctx = get_context()
+                @test ctx.config["file-project:key"] == "from-file"
+            finally
+                delete!(ENV, "PULUMI_CONFIG_FILE")
+                rm(path)
+            end
+        end
+
+        @testset "Context config aliases" begin
+            reset_context!()
+            ENV["PULUMI_PROJECT"] = "alias-project"
+            ENV["PULUMI_STACK"] = "dev"
+            ENV["PULUMI_CONFIG"] = """{
+                "version": 2,
+                "config": {"alias-project:name": "web", "aws:region": "us-west-2"},
+                "aliases": {"name": "alias-project:name"}
+            }"""
+            ENV["PULUMI_CONFIG_SECRET_KEYS"] = "[]"
+
+            ctx = get_context()
+            @test ctx.config_aliases == Dict("name" => "alias-project:name")
+            @test ctx.config[ctx.config_aliases["name"]] == "web"
+
+            # Flat documents from older hosts have no aliases
+            reset_context!()
+            ENV["PULUMI_CONFIG"] = """{"alias-project:name": "web"}"""
+            @test isempty(get_context().config_aliases)
+        end
+
+        @testset "Context with secrets file" begin
+            reset_context!()
+            ENV["PULUMI_PROJECT"] = "secrets-project"
+            ENV["PULUMI_STACK"] = "dev"
+            ENV["PULUMI_CONFIG"] = """{"secrets-project:plain": "visible"}"""
+            ENV["PULUMI_CONFIG_SECRET_KEYS"] = """["secrets-project:password"]"""
+            path, io = mktemp()
+            write(io, """{"secrets-project:password": "hunter2"}""")
+            close(io)
+            ENV["PULUMI_CONFIG_SECRETS_FILE"] = path
+
+            try
+                ctx = get_context()
+                @test ctx.config["secrets-project:plain"] == "visible"
+                @test ctx.config["secrets-project:password"] == "hunter2"
+                @test "secrets-project:password" in ctx.config_secret_keys
+            finally
+                delete!(ENV, "PULUMI_CONFIG_SECRETS_FILE")
+                rm(path)
+            end
+        end
+
         @testset "Context accessors" begin
             reset_context!()
             ENV["PULUMI_PROJECT"] = "accessor-project"
@@ -52,6 +117,33 @@
             @test get_stack() == "staging"
             @test get_organization() == "my-org"
             @test is_dry_run() == false
+            @test is_query_mode() == false
+        end
+
+        @testset "Context query mode" begin
+            reset_context!()
+            ENV["PULUMI_MONITOR"] = ""
+            ENV["PULUMI_QUERY_MODE"] = "true"
+
+            @test is_query_mode() == true
+            @test_throws ResourceError register_resource("test:index:Resource", "res", Dict{String, Any}())
+            delete!(ENV, "PULUMI_QUERY_MODE")
+        end
+
+        @testset "Context directories" begin
+            reset_context!()
+            ENV["PULUMI_ROOT_DIRECTORY"] = "/work/project"
+            ENV["PULUMI_PROGRAM_DIRECTORY"] = "/work/project/infra"
+
+            @test get_root_directory() == "/work/project"
+            @test get_program_directory() == "/work/project/infra"
+
+            # Programs run by hand default to the working directory
+            reset_context!()
+            delete!(ENV, "PULUMI_ROOT_DIRECTORY")
+            delete!(ENV, "PULUMI_PROGRAM_DIRECTORY")
+            @test get_root_directory() == pwd()
+            @test get_program_directory() == pwd()
         end
 
         @testset "Context singleton" begin
@@ -114,6 +114,39 @@
         host, port = Pulumi._parse_address("http://localhost:50051")
         @test host == "localhost"
         @test port == 50051
+
+        # IPv6, in brackets
+        host, port = Pulumi._parse_address("[::1]:52341")
+        @test host == "[::1]"
+        @test port == 52341
+
+        @test_throws ArgumentError Pulumi._parse_address("unix:/run/pulumi.sock")
+    end
+
+    if !Sys.iswindows()
+        @testset "Unix socket addresses" begin
+            # unix: addresses are dialed through a loopback port bridged to
+            # the socket
+            path = joinpath(mktempdir(), "monitor.sock")
+            server = Pulumi.Sockets.listen(path)
+            echo = @async begin
+                socket = Pulumi.Sockets.accept(server)
+                println(socket, readline(socket))
+                close(socket)
+            end
+
+            host, port = Pulumi._resolve_address("unix:" * path)
+            @test host == "127.0.0.1"
+            @test Pulumi._resolve_address("unix:" * path) == (host, port)
+            @test Pulumi._resolve_address("localhost:50051") == ("localhost", 50051)
+
+            client = Pulumi.Sockets.connect(host, port)
+            println(client, "ping")
+            @test readline(client) == "ping"
+            wait(echo)
+            close(client)
+            close(server)
+        end
     end
 
     @testset "Function signatures" begin
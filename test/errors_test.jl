@@ -64,3 +64,20 @@ end
     @test contains(output, "DependencyError")
     @test contains(output, "Cycle detected")
 end
+
+@testset "BailError" begin
+    @test BailError() isa PulumiError
+    @test BAIL_EXIT_CODE == 32
+    @test contains(sprint(showerror, BailError()), "BailError")
+end
+
+@testset "run" begin
+    reset_context!()
+    withenv("PULUMI_ENGINE" => nothing, "PULUMI_MONITOR" => nothing) do
+        @test Pulumi.run(() -> 42) == 42
+        # Without an engine to report to, errors are thrown as usual
+        @test_throws ErrorException Pulumi.run(() -> error("boom"))
+        @test_throws BailError Pulumi.run(() -> throw(BailError()))
+    end
+    reset_context!()
+end